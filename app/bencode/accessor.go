@@ -1,67 +1,309 @@
 package bencode
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
-// Returns the string value associated with the data node.
-// If the underlying type of the node is not StringType, the
-// function would panic with an error message conveying the same.
-func (bd *BencodeData) GetString() *BencodeString {
-	if bd.Type != StringType {
-		panic(fmt.Sprintf("called GetString on the BencodeData node %v with type %s", *bd, bd.Type))
+// ErrNotFound is wrapped by the errors of lookups of missing values.
+var ErrNotFound = errors.New("value not found")
+
+// AccessError is returned when a value is missing, or doesn't have the
+// expected type. The path is empty for the value that was accessed itself.
+type AccessError struct {
+	Path     string
+	Expected DataType
+	Actual   DataType // Empty if the value is missing
+}
+
+func (e *AccessError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "value"
+	}
+	if e.Actual == "" {
+		return fmt.Sprintf("bencode: %s not found", path)
+	}
+	return fmt.Sprintf("bencode: expected %s to be a %s, got %s", path, e.Expected, e.Actual)
+}
+
+func (e *AccessError) Unwrap() error {
+	if e.Actual == "" {
+		return ErrNotFound
 	}
+	return nil
+}
 
+// AsString returns the string value of the node, or an AccessError if
+// the node is not a string.
+func (bd *BencodeData) AsString() (*BencodeString, error) {
 	v, ok := bd.Value.(*BencodeString)
-	if !ok {
-		panic(fmt.Sprintf("%v has type String, but conversion to Bencode string failed", *bd))
+	if bd.Type != StringType || !ok {
+		return nil, &AccessError{Expected: StringType, Actual: bd.Type}
 	}
+	return v, nil
+}
 
+// AsInteger returns the integer value of the node, or an AccessError if
+// the node is not an integer.
+func (bd *BencodeData) AsInteger() (*BencodeInteger, error) {
+	v, ok := bd.Value.(*BencodeInteger)
+	if bd.Type != IntegerType || !ok {
+		return nil, &AccessError{Expected: IntegerType, Actual: bd.Type}
+	}
+	return v, nil
+}
+
+// AsList returns the list value of the node, or an AccessError if
+// the node is not a list.
+func (bd *BencodeData) AsList() (*BencodeList, error) {
+	v, ok := bd.Value.(*BencodeList)
+	if bd.Type != ListType || !ok {
+		return nil, &AccessError{Expected: ListType, Actual: bd.Type}
+	}
+	return v, nil
+}
+
+// AsDictionary returns the dictionary value of the node, or an AccessError
+// if the node is not a dictionary.
+func (bd *BencodeData) AsDictionary() (*BencodeDictionary, error) {
+	v, ok := bd.Value.(*BencodeDictionary)
+	if bd.Type != DictionaryType || !ok {
+		return nil, &AccessError{Expected: DictionaryType, Actual: bd.Type}
+	}
+	return v, nil
+}
+
+// Returns the string value associated with the data node.
+// If the underlying type of the node is not StringType, the function
+// panics. Use AsString or LookupString for values that are not trusted.
+func (bd *BencodeData) GetString() *BencodeString {
+	v, err := bd.AsString()
+	if err != nil {
+		panic(fmt.Sprintf("called GetString on the BencodeData node %v: %v", *bd, err))
+	}
 	return v
 }
 
 // Returns the integer value associated with the data node.
-// If the underlying type of the node is not IntegerType, the
-// function would panic with an error message conveying the same.
+// If the underlying type of the node is not IntegerType, the function
+// panics. Use AsInteger or LookupInteger for values that are not trusted.
 func (bd *BencodeData) GetInteger() *BencodeInteger {
-	if bd.Type != IntegerType {
-		panic(fmt.Sprintf("called GetInteger on the BencodeData node %v with type %s", *bd, bd.Type))
+	v, err := bd.AsInteger()
+	if err != nil {
+		panic(fmt.Sprintf("called GetInteger on the BencodeData node %v: %v", *bd, err))
 	}
+	return v
+}
 
-	v, ok := bd.Value.(*BencodeInteger)
-	if !ok {
-		panic(fmt.Sprintf("%v has type Integer, but conversion to Bencode integer failed", *bd))
+// Returns the list value associated with the data node.
+// If the underlying type of the node is not ListType, the function
+// panics. Use AsList or LookupList for values that are not trusted.
+func (bd *BencodeData) GetList() *BencodeList {
+	v, err := bd.AsList()
+	if err != nil {
+		panic(fmt.Sprintf("called GetList on the BencodeData node %v: %v", *bd, err))
 	}
+	return v
+}
 
+// Returns the dictionary value associated with the data node.
+// If the underlying type of the node is not DictionaryType, the function
+// panics. Use AsDictionary or LookupDictionary for values that are not trusted.
+func (bd *BencodeData) GetDictionary() *BencodeDictionary {
+	v, err := bd.AsDictionary()
+	if err != nil {
+		panic(fmt.Sprintf("called GetDictionary on the BencodeData node %v: %v", *bd, err))
+	}
 	return v
 }
 
-// Returns the list value associated with the data node.
-// If the underlying type of the node is not ListType, the
-// function would panic with an error message conveying the same.
-func (bd *BencodeData) GetList() *BencodeList {
-	if bd.Type != ListType {
-		panic(fmt.Sprintf("called GetList on the BencodeData node %v with type %s", *bd, bd.Type))
+// pathSegment is a dictionary key or a list index of a path.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+	all     bool // Set for "[*]", which matches all the items of a list or a dictionary
+	end     int  // The offset in the path after the segment
+}
+
+// parsePath splits a path like "info.files[2].length" into its segments.
+// Keys that contain '.' or '[' can be quoted, like `["added.f"]`, and "[*]"
+// is a wildcard for all the items of a list or a dictionary.
+func parsePath(path string) ([]pathSegment, error) {
+	segments := make([]pathSegment, 0)
+	i := 0
+	for i < len(path) {
+		switch {
+		case path[i] == '[':
+			end := strings.IndexByte(path[i:], ']')
+			if path[i+1:min(i+2, len(path))] == `"` {
+				// Find the end of the quoted key, which can contain ']'
+				key, err := strconv.QuotedPrefix(path[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("bencode: invalid quoted key at offset %d of path %q", i, path)
+				}
+				end = 1 + len(key)
+				if i+end >= len(path) || path[i+end] != ']' {
+					return nil, fmt.Errorf("bencode: expected ']' at offset %d of path %q", i+end, path)
+				}
+				key, _ = strconv.Unquote(key)
+				segments = append(segments, pathSegment{key: key, end: i + end + 1})
+				i += end + 1
+				continue
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("bencode: unterminated '[' at offset %d of path %q", i, path)
+			}
+			if path[i+1:i+end] == "*" {
+				segments = append(segments, pathSegment{all: true, end: i + end + 1})
+				i += end + 1
+				continue
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("bencode: invalid index %q in path %q", path[i+1:i+end], path)
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true, end: i + end + 1})
+			i += end + 1
+
+		case path[i] == '.' && len(segments) > 0:
+			i++
+			if i == len(path) {
+				return nil, fmt.Errorf("bencode: path %q ends with '.'", path)
+			}
+			fallthrough
+
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, pathSegment{key: path[i : i+end], end: i + end})
+			i += end
+		}
 	}
+	return segments, nil
+}
 
-	v, ok := bd.Value.(*BencodeList)
-	if !ok {
-		panic(fmt.Sprintf("%v has type List, but conversion to Bencode list failed", *bd))
+// Lookup returns the value at the path in the node. A path is a list of
+// dictionary keys separated by '.', and list indices in brackets, eg.
+// "info.files[2].length". An empty path returns the node itself.
+// Missing values and values of the wrong type return an AccessError.
+// Wildcards are not supported, use LookupAll for paths with them.
+func (bd *BencodeData) Lookup(path string) (*BencodeData, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segments {
+		if s.all {
+			return nil, fmt.Errorf("bencode: wildcard in path %q, use LookupAll", path)
+		}
 	}
 
-	return v
+	v := bd
+	for i, s := range segments {
+		parent := path[:0]
+		if i > 0 {
+			parent = path[:segments[i-1].end]
+		}
+
+		if s.isIndex {
+			l, ok := v.Value.(*BencodeList)
+			if v.Type != ListType || !ok {
+				return nil, &AccessError{Path: parent, Expected: ListType, Actual: v.Type}
+			}
+			if s.index >= len(l.Array) {
+				return nil, &AccessError{Path: path[:s.end]}
+			}
+			v = l.Array[s.index]
+			continue
+		}
+
+		d, ok := v.Value.(*BencodeDictionary)
+		if v.Type != DictionaryType || !ok {
+			return nil, &AccessError{Path: parent, Expected: DictionaryType, Actual: v.Type}
+		}
+		item, ok := d.Map[s.key]
+		if !ok {
+			return nil, &AccessError{Path: path[:s.end]}
+		}
+		v = item
+	}
+	return v, nil
 }
 
-// Returns the dictionary value associated with the data node.
-// If the underlying type of the node is not DictionaryType, the
-// function would panic with an error message conveying the same.
-func (bd *BencodeData) GetDictionary() *BencodeDictionary {
-	if bd.Type != DictionaryType {
-		panic(fmt.Sprintf("called GetDictionary on the BencodeData node %v with type %s", *bd, bd.Type))
+// atPath sets the path of the AccessError returned by the As methods.
+func atPath(err error, path string) error {
+	var accessErr *AccessError
+	if errors.As(err, &accessErr) {
+		accessErr.Path = path
+	}
+	return err
+}
+
+// LookupBytes returns the string at the path as bytes.
+func (bd *BencodeData) LookupBytes(path string) ([]byte, error) {
+	v, err := bd.Lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.AsString()
+	if err != nil {
+		return nil, atPath(err, path)
 	}
+	return s.Value, nil
+}
 
-	v, ok := bd.Value.(*BencodeDictionary)
-	if !ok {
-		panic(fmt.Sprintf("%v has type Dictionary, but conversion to Bencode dictionary failed", *bd))
+// LookupString returns the string at the path.
+func (bd *BencodeData) LookupString(path string) (string, error) {
+	b, err := bd.LookupBytes(path)
+	return string(b), err
+}
+
+// LookupInteger returns the integer at the path. Integers that don't fit in
+// an int return an error wrapping ErrIntegerOverflow.
+func (bd *BencodeData) LookupInteger(path string) (int, error) {
+	v, err := bd.Lookup(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := v.AsInteger()
+	if err != nil {
+		return 0, atPath(err, path)
+	}
+	i, err := n.Int()
+	if err != nil {
+		return 0, fmt.Errorf("bencode: %s: %w", path, err)
 	}
+	return i, nil
+}
 
-	return v
+// LookupList returns the items of the list at the path.
+func (bd *BencodeData) LookupList(path string) ([]*BencodeData, error) {
+	v, err := bd.Lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := v.AsList()
+	if err != nil {
+		return nil, atPath(err, path)
+	}
+	return l.Array, nil
+}
+
+// LookupDictionary returns the dictionary at the path.
+func (bd *BencodeData) LookupDictionary(path string) (*BencodeDictionary, error) {
+	v, err := bd.Lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := v.AsDictionary()
+	if err != nil {
+		return nil, atPath(err, path)
+	}
+	return d, nil
 }
@@ -0,0 +1,139 @@
+package bencode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testAccessorInput = "d8:announce3:url4:infod3:a.bi7e3:bigi99999999999999999999e" +
+	"5:filesld6:lengthi1e4:pathl1:aeed6:lengthi2e4:pathl1:beed6:lengthi3e4:pathl1:ceee" +
+	"4:name3:diree"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string // The encoded value, if there is no error
+		err     string
+		missing bool
+	}{
+		{path: "info.files[2].length", want: "i3e"},
+		{path: "info.files[2].path[0]", want: "1:c"},
+		{path: "info.name", want: "3:dir"},
+		{path: `info["a.b"]`, want: "i7e"},
+		{path: "", want: testAccessorInput},
+
+		{path: "info.files[3]", err: "bencode: info.files[3] not found", missing: true},
+		{path: "info.files[3].length", err: "bencode: info.files[3] not found", missing: true},
+		{path: "info.missing", err: "bencode: info.missing not found", missing: true},
+		{path: "info.a.b", err: "bencode: info.a not found", missing: true},
+		{path: "announce[0]", err: "bencode: expected announce to be a List, got String"},
+		{path: "info.files.length", err: "bencode: expected info.files to be a Dictionary, got List"},
+		{path: "info.files[0][0]", err: "bencode: expected info.files[0] to be a List, got Dictionary"},
+		{path: "info.name.first", err: "bencode: expected info.name to be a Dictionary, got String"},
+
+		{path: "info.files[x]", err: `bencode: invalid index "x" in path "info.files[x]"`},
+		{path: "info.files[-1]", err: `bencode: invalid index "-1" in path "info.files[-1]"`},
+		{path: "info.files[]", err: `bencode: invalid index "" in path "info.files[]"`},
+		{path: "info.files[1", err: `bencode: unterminated '[' at offset 10 of path "info.files[1"`},
+		{path: "info.", err: `bencode: path "info." ends with '.'`},
+		{path: `info["a.b`, err: `bencode: invalid quoted key at offset 4 of path "info[\"a.b"`},
+		{path: "info.files[*]", err: `bencode: wildcard in path "info.files[*]", use LookupAll`},
+	}
+
+	bd, err := NewBencodeDataBigInt([]byte(testAccessorInput))
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	v, err := ParseValue([]byte(testAccessorInput))
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := bd.Lookup(tt.path)
+			lazy, lazyErr := v.Lookup(tt.path)
+			if tt.err == "" {
+				if err != nil || lazyErr != nil {
+					t.Fatalf("error looking up the path: %v, %v", err, lazyErr)
+				}
+				if string(got.Value.Encode()) != tt.want || string(lazy.Raw()) != tt.want {
+					t.Errorf("expected %s, got %s and %s", tt.want, got.Value.Encode(), lazy.Raw())
+				}
+				return
+			}
+
+			// The lazy values report the same errors
+			for _, err := range []error{err, lazyErr} {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected the error %q, got %v", tt.err, err)
+				}
+				if errors.Is(err, ErrNotFound) != tt.missing {
+					t.Errorf("expected errors.Is(err, ErrNotFound) to be %v for %v", tt.missing, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTypedLookups(t *testing.T) {
+	bd, err := NewBencodeDataBigInt([]byte(testAccessorInput))
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+
+	n, err := bd.LookupInteger("info.files[1].length")
+	if err != nil || n != 2 {
+		t.Errorf("expected 2, got %d, %v", n, err)
+	}
+	s, err := bd.LookupString("info.files[1].path[0]")
+	if err != nil || s != "b" {
+		t.Errorf("expected b, got %q, %v", s, err)
+	}
+	l, err := bd.LookupList("info.files")
+	if err != nil || len(l) != 3 {
+		t.Errorf("expected 3 files, got %d, %v", len(l), err)
+	}
+	d, err := bd.LookupDictionary("info")
+	if err != nil {
+		t.Errorf("error looking up info: %v", err)
+	} else if d.Length != 4 {
+		t.Errorf("expected 4 keys, got %d", d.Length)
+	}
+
+	tests := []struct {
+		name   string
+		lookup func() error
+		err    string
+	}{
+		{"integer", func() error { _, err := bd.LookupInteger("info.name"); return err },
+			"bencode: expected info.name to be a Integer, got String"},
+		{"string", func() error { _, err := bd.LookupString("info.files[0]"); return err },
+			"bencode: expected info.files[0] to be a String, got Dictionary"},
+		{"bytes", func() error { _, err := bd.LookupBytes("info.files"); return err },
+			"bencode: expected info.files to be a String, got List"},
+		{"list", func() error { _, err := bd.LookupList("info"); return err },
+			"bencode: expected info to be a List, got Dictionary"},
+		{"dictionary", func() error { _, err := bd.LookupDictionary("announce"); return err },
+			"bencode: expected announce to be a Dictionary, got String"},
+		{"missing", func() error { _, err := bd.LookupInteger("info.length"); return err },
+			"bencode: info.length not found"},
+		{"As", func() error { _, err := bd.AsList(); return err },
+			"bencode: expected value to be a List, got Dictionary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.lookup()
+			var accessErr *AccessError
+			if !errors.As(err, &accessErr) || err.Error() != tt.err {
+				t.Errorf("expected the AccessError %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	_, err = bd.LookupInteger("info.big")
+	if !errors.Is(err, ErrIntegerOverflow) || !strings.Contains(err.Error(), "info.big") {
+		t.Errorf("expected an overflow error naming the path, got %v", err)
+	}
+}
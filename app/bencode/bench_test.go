@@ -0,0 +1,134 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+const BENCH_PIECES = 100_000
+const BENCH_PIECE_LENGTH = 256 * 1024
+
+// benchTorrent generates a torrent with BENCH_PIECES pieces. It has a single
+// file if files is 0, and the files of a multi-file torrent otherwise.
+func benchTorrent(files int) []byte {
+	var b bytes.Buffer
+	b.WriteString("d8:announce31:http://tracker.example/announce4:infod")
+	if files > 0 {
+		b.WriteString("5:filesl")
+		for i := range files {
+			name := fmt.Sprintf("file-%06d.bin", i)
+			fmt.Fprintf(&b, "d6:lengthi%de4:pathl3:dir%d:%see", int64(BENCH_PIECES)*BENCH_PIECE_LENGTH/int64(files), len(name), name)
+		}
+		b.WriteString("e")
+	} else {
+		fmt.Fprintf(&b, "6:lengthi%de", int64(BENCH_PIECES)*BENCH_PIECE_LENGTH)
+	}
+	fmt.Fprintf(&b, "4:name5:bench12:piece lengthi%de", BENCH_PIECE_LENGTH)
+	fmt.Fprintf(&b, "6:pieces%d:", BENCH_PIECES*20)
+	b.Write(bytes.Repeat([]byte{0xab}, BENCH_PIECES*20))
+	b.WriteString("ee")
+	return b.Bytes()
+}
+
+var benchSingleFile = benchTorrent(0)
+var benchMultiFile = benchTorrent(BENCH_PIECES)
+
+func benchmarkDecode(b *testing.B, data []byte, decode func([]byte) error) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for range b.N {
+		err := decode(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func decodeLegacy(data []byte) error {
+	_, err := newLegacyBencodeData(data)
+	return err
+}
+
+func decodeTree(data []byte) error {
+	_, err := NewBencodeData(data)
+	return err
+}
+
+func decodeStream(data []byte) error {
+	_, err := NewDecoder(bytes.NewReader(data)).Decode()
+	return err
+}
+
+// parseValuePieces validates the torrent, and reads the piece hashes.
+func parseValuePieces(data []byte) error {
+	v, err := ParseValue(data)
+	if err != nil {
+		return err
+	}
+	pieces, err := v.Lookup("info.pieces")
+	if err != nil {
+		return err
+	}
+	b, err := pieces.Bytes()
+	if err != nil {
+		return err
+	}
+	if len(b) != BENCH_PIECES*20 {
+		return fmt.Errorf("expected %d bytes of pieces, got %d", BENCH_PIECES*20, len(b))
+	}
+	return nil
+}
+
+// TestDecodeMatchesLegacy checks that the decoders being compared return the
+// same values for the benchmark torrents.
+func TestDecodeMatchesLegacy(t *testing.T) {
+	for _, data := range [][]byte{benchSingleFile, benchMultiFile} {
+		legacy, err := newLegacyBencodeData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := NewBencodeData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(legacy.Value.Encode(), tree.Value.Encode()) {
+			t.Fatal("the decoders returned different values")
+		}
+		if err := parseValuePieces(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeLegacySingleFile(b *testing.B) {
+	benchmarkDecode(b, benchSingleFile, decodeLegacy)
+}
+
+func BenchmarkDecodeLegacyMultiFile(b *testing.B) {
+	benchmarkDecode(b, benchMultiFile, decodeLegacy)
+}
+
+func BenchmarkDecodeStreamSingleFile(b *testing.B) {
+	benchmarkDecode(b, benchSingleFile, decodeStream)
+}
+
+func BenchmarkDecodeStreamMultiFile(b *testing.B) {
+	benchmarkDecode(b, benchMultiFile, decodeStream)
+}
+
+func BenchmarkDecodeTreeSingleFile(b *testing.B) {
+	benchmarkDecode(b, benchSingleFile, decodeTree)
+}
+
+func BenchmarkDecodeTreeMultiFile(b *testing.B) {
+	benchmarkDecode(b, benchMultiFile, decodeTree)
+}
+
+func BenchmarkParseValueSingleFile(b *testing.B) {
+	benchmarkDecode(b, benchSingleFile, parseValuePieces)
+}
+
+func BenchmarkParseValueMultiFile(b *testing.B) {
+	benchmarkDecode(b, benchMultiFile, parseValuePieces)
+}
@@ -1,6 +1,9 @@
 package bencode
 
-import "fmt"
+import (
+	"fmt"
+	"math/big"
+)
 
 type DataType string
 
@@ -19,11 +22,32 @@ type ValueInterface interface {
 type BencodeData struct {
 	Type  DataType
 	Value ValueInterface // Stores the pointers to the underlying values
+
+	// The span of the value in the decoded input, and its bytes as they were
+	// in the input. Raw is nil for values that were not decoded, or that were
+	// decoded by a Decoder without KeepRaw, and is only cleared if the value
+	// is modified with Set or Delete. It differs from the encoding of the
+	// value for input that is not canonical, eg. with unsorted keys.
+	Start int
+	End   int
+	Raw   []byte
 }
 
 func NewBencodeData(s []byte) (*BencodeData, error) {
+	return decodeAll(newDecoder(s))
+}
+
+// NewBencodeDataBigInt is like NewBencodeData, but decodes the integers that
+// don't fit in 64 bits as big integers instead of failing, so that they can
+// be re-encoded without loss.
+func NewBencodeDataBigInt(s []byte) (*BencodeData, error) {
 	d := newDecoder(s)
+	d.bigInts = true
+	return decodeAll(d)
+}
 
+// decodeAll decodes a single value, which must span all the input.
+func decodeAll(d *decoder) (*BencodeData, error) {
 	v, err := d.parse()
 	if err != nil {
 		return nil, err
@@ -63,6 +87,10 @@ func NewDataString(s string) *BencodeData {
 }
 
 func NewDataInteger(i int) *BencodeData {
+	return NewDataInteger64(int64(i))
+}
+
+func NewDataInteger64(i int64) *BencodeData {
 	return &BencodeData{
 		Type: IntegerType,
 		Value: &BencodeInteger{
@@ -71,6 +99,20 @@ func NewDataInteger(i int) *BencodeData {
 	}
 }
 
+// NewDataBigInt returns an integer, which is only stored as a big integer
+// if it doesn't fit in 64 bits.
+func NewDataBigInt(i *big.Int) *BencodeData {
+	if i.IsInt64() {
+		return NewDataInteger64(i.Int64())
+	}
+	return &BencodeData{
+		Type: IntegerType,
+		Value: &BencodeInteger{
+			Big: new(big.Int).Set(i),
+		},
+	}
+}
+
 func NewDataList(l []*BencodeData) *BencodeData {
 	return &BencodeData{
 		Type: ListType,
@@ -5,10 +5,20 @@ import (
 	"fmt"
 )
 
+// Lists and dictionaries nested deeper than this are rejected by the
+// decoders, as they are decoded recursively and could exhaust the stack.
+const MAX_NESTING_DEPTH = 512
+
+var ErrNestingTooDeep = fmt.Errorf("values nested deeper than %d", MAX_NESTING_DEPTH)
+
 type decoder struct {
 	data    []byte
 	idx     int
 	dataLen int
+	depth   int // The number of lists and dictionaries being parsed
+
+	violations []Violation // Deviations from the canonical form
+	bigInts    bool        // Whether to decode integers that don't fit in 64 bits
 }
 
 func newDecoder(data []byte) *decoder {
@@ -19,36 +29,42 @@ func newDecoder(data []byte) *decoder {
 	}
 }
 
-func (d *decoder) peek() *byte {
-	if d.idx >= len(d.data) {
-		return nil
+// peek returns the next byte without consuming it, and false at the end of
+// the data. The bytes are returned by value, so that reading the input does
+// not allocate.
+func (d *decoder) peek() (byte, bool) {
+	if d.idx >= d.dataLen {
+		return 0, false
 	}
-	b := d.data[d.idx]
-	return &b
+	return d.data[d.idx], true
 }
 
-func (d *decoder) next() *byte {
-	if d.idx >= d.dataLen {
-		return nil
-	}
-	b := d.data[d.idx]
-	d.idx++
-	return &b
+// peekIs reports whether the next byte is b.
+func (d *decoder) peekIs(b byte) bool {
+	return d.idx < d.dataLen && d.data[d.idx] == b
+}
+
+// peekDigit reports whether the next byte is a digit.
+func (d *decoder) peekDigit() bool {
+	return d.idx < d.dataLen && isDigit(d.data[d.idx])
 }
 
 func (d *decoder) expect(b byte) error {
-	ch := d.peek()
-	if ch == nil || *ch != b {
-		return fmt.Errorf("expected character at index %d to be '%q': got '%q'", d.idx, b, *ch)
+	ch, ok := d.peek()
+	if !ok {
+		return fmt.Errorf("expected character at index %d to be %q: reached end of data", d.idx, b)
+	}
+	if ch != b {
+		return fmt.Errorf("expected character at index %d to be %q: got %q", d.idx, b, ch)
 	}
 
 	// Consume the character
-	d.next()
+	d.idx++
 	return nil
 }
 
 func (d *decoder) getNBytes(l int) []byte {
-	if d.idx+l-1 >= d.dataLen {
+	if l < 0 || l > d.dataLen-d.idx {
 		return nil
 	}
 
@@ -57,82 +73,131 @@ func (d *decoder) getNBytes(l int) []byte {
 	return v
 }
 
-func (d *decoder) readInteger() (int, error) {
-	nxt := d.peek()
-	if nxt == nil {
-		return 0, errors.New("not enough bytes")
+// readDigits reads the digits of a number, and returns them as a slice of
+// the input.
+func (d *decoder) readDigits() ([]byte, error) {
+	ch, ok := d.peek()
+	if !ok {
+		return nil, errors.New("not enough bytes")
 	}
-	if !isDigit(nxt) {
-		return 0, fmt.Errorf("expected a digit, recieved '%q'", *nxt)
+	if !isDigit(ch) {
+		return nil, fmt.Errorf("expected a digit, recieved '%q'", ch)
 	}
 
 	// Consume the first byte to read the number
-	v := int(*nxt) - int('0')
-	d.next()
+	start := d.idx
+	d.idx++
+	if ch == '0' && d.peekDigit() {
+		d.violation(start, "number with leading zeros")
+	}
 
 	// Continue parsing
-	for isDigit(d.peek()) {
-		n := int(*d.next()) - int('0')
-		v = v*10 + n
+	for d.peekDigit() {
+		d.idx++
 	}
 
-	return v, nil
+	return d.data[start:d.idx], nil
 }
 
+// parse parses the next value, and records its span in the input.
 func (d *decoder) parse() (*BencodeData, error) {
-	ch := d.peek()
+	start := d.idx
+	v, err := d.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	v.Start, v.End = start, d.idx
+	v.Raw = d.data[start:d.idx:d.idx]
+	return v, nil
+}
+
+// The nodes of the decoded values, which are allocated together with their
+// values to halve the allocations of the decoder.
+type stringNode struct {
+	data  BencodeData
+	value BencodeString
+}
+
+type integerNode struct {
+	data  BencodeData
+	value BencodeInteger
+}
+
+type listNode struct {
+	data  BencodeData
+	value BencodeList
+}
+
+type dictionaryNode struct {
+	data  BencodeData
+	value BencodeDictionary
+}
 
-	if ch == nil {
+// enter is called when a list or dictionary starts, and fails if it is
+// nested deeper than MAX_NESTING_DEPTH.
+func (d *decoder) enter() error {
+	d.depth++
+	if d.depth > MAX_NESTING_DEPTH {
+		return fmt.Errorf("%w at index %d", ErrNestingTooDeep, d.idx)
+	}
+	return nil
+}
+
+func (d *decoder) parseValue() (*BencodeData, error) {
+	ch, ok := d.peek()
+	if !ok {
 		return nil, fmt.Errorf("unexpected end of data")
 	}
 
+	switch {
 	// Parse string if it starts with a numeric character
-	if isDigit(ch) {
-		s, err := d.parseString()
+	case isDigit(ch):
+		n := &stringNode{}
+		err := d.parseStringInto(&n.value)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing string: %w", err)
 		}
-		return &BencodeData{
-			Type:  StringType,
-			Value: s,
-		}, nil
-	}
+		n.data = BencodeData{Type: StringType, Value: &n.value}
+		return &n.data, nil
 
 	// Parse integer if it starts with byte 'i'
-	if *ch == 'i' {
-		n, err := d.parseInteger()
+	case ch == 'i':
+		n := &integerNode{}
+		err := d.parseIntegerInto(&n.value)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing integer: %w", err)
 		}
-		return &BencodeData{
-			Type:  IntegerType,
-			Value: n,
-		}, nil
-	}
+		n.data = BencodeData{Type: IntegerType, Value: &n.value}
+		return &n.data, nil
 
 	// Parse list if it starts with byte 'l'
-	if *ch == 'l' {
-		l, err := d.parseList()
+	case ch == 'l':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		n := &listNode{}
+		err := d.parseListInto(&n.value)
+		d.depth--
 		if err != nil {
 			return nil, fmt.Errorf("error parsing list: %w", err)
 		}
-		return &BencodeData{
-			Type:  ListType,
-			Value: l,
-		}, nil
-	}
+		n.data = BencodeData{Type: ListType, Value: &n.value}
+		return &n.data, nil
 
 	// Parse dictionary if it starts with byte 'd'
-	if *ch == 'd' {
-		d, err := d.parseDictionary()
+	case ch == 'd':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		n := &dictionaryNode{}
+		err := d.parseDictionaryInto(&n.value)
+		d.depth--
 		if err != nil {
 			return nil, fmt.Errorf("error parsing dictionary: %w", err)
 		}
-		return &BencodeData{
-			Type:  DictionaryType,
-			Value: d,
-		}, nil
+		n.data = BencodeData{Type: DictionaryType, Value: &n.value}
+		return &n.data, nil
 	}
 
-	return nil, fmt.Errorf("unrecognized character to start parsing: %q", *ch)
+	return nil, fmt.Errorf("unrecognized character to start parsing: %q", ch)
 }
@@ -1,6 +1,7 @@
 package bencode
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"strings"
@@ -26,7 +27,7 @@ func (d *BencodeDictionary) Add(key string, value *BencodeData) {
 func (d *BencodeDictionary) GetInteger(key string) (int, error) {
 	if value, ok := d.Map[key]; ok {
 		if value.Type == IntegerType {
-			return value.GetInteger().Value, nil
+			return value.GetInteger().Int()
 		}
 		return -1, fmt.Errorf("value for key %s is not an integer", key)
 	}
@@ -48,66 +49,94 @@ func getSortedKeys(m map[string]*BencodeData) []string {
 
 // Parses a dictionary encoded in the bencode format.
 // Format: d<key1><item1>...e
-func (d *decoder) parseDictionary() (*BencodeDictionary, error) {
+func (d *decoder) parseDictionaryInto(dict *BencodeDictionary) error {
 	err := d.expect('d')
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	itemsMap := make(map[string]*BencodeData)
-	for {
-		ch := d.peek()
-		if ch != nil && *ch == 'e' {
-			break
+	var prevKey []byte
+	for !d.peekIs('e') {
+		keyStart := d.idx
+		if _, ok := d.peek(); ok && !d.peekDigit() {
+			return fmt.Errorf("parsing dictionary key: expected string key in dictionary at index %d", d.idx)
 		}
-
-		key, err := d.parse()
+		key, err := d.parseStringBytes()
 		if err != nil {
-			return nil, fmt.Errorf("parsing dictionary key: %w", err)
-		}
-		if key.Type != StringType {
-			return nil, fmt.Errorf("expected string key in dictionary, got %s", key.Type)
+			return fmt.Errorf("parsing dictionary key: %w", err)
 		}
 
 		item, err := d.parse()
 		if err != nil {
-			return nil, fmt.Errorf("parsing dictionary item: %w", err)
+			return fmt.Errorf("parsing dictionary item: %w", err)
 		}
 
-		keyStr := string(key.GetString().Value)
-		itemsMap[keyStr] = item
+		// Keys must be unique, and sorted as raw strings
+		if prevKey != nil {
+			switch bytes.Compare(key, prevKey) {
+			case 0:
+				d.violation(keyStart, "duplicate dictionary key %q", key)
+			case -1:
+				d.violation(keyStart, "dictionary key %q is not sorted after %q", key, prevKey)
+			}
+		}
+		prevKey = key
+		itemsMap[string(key)] = item
 	}
 
 	err = d.expect('e')
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &BencodeDictionary{
-		Map:    itemsMap,
-		Length: len(itemsMap),
-	}, nil
+	dict.Map, dict.Length = itemsMap, len(itemsMap)
+	return nil
 }
 
 func (s *BencodeDictionary) String() string {
-	elements := make([]string, 0)
-	keys := getSortedKeys(s.Map)
-
-	for _, key := range keys {
-		elements = append(elements, fmt.Sprintf("\"%s\":%s", key, s.Map[key].Value.String()))
-	}
-	return fmt.Sprintf("{%s}", strings.Join(elements, ","))
+	var b strings.Builder
+	writeText(&b, s)
+	return b.String()
 }
 
-func (s *BencodeDictionary) Encode() []byte {
-	res := []byte{'d'}
-	keys := getSortedKeys(s.Map)
+// writeText writes the text representation of the value, which is JSON-like
+// but prints the bytes of strings as they are.
+func writeText(b *strings.Builder, v ValueInterface) {
+	switch v := v.(type) {
+	case *BencodeList:
+		b.WriteByte('[')
+		for i, item := range v.Array {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeText(b, item.Value)
+		}
+		b.WriteByte(']')
+
+	case *BencodeDictionary:
+		b.WriteByte('{')
+		for i, key := range getSortedKeys(v.Map) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteByte('"')
+			b.WriteString(key)
+			b.WriteString(`":`)
+			writeText(b, v.Map[key].Value)
+		}
+		b.WriteByte('}')
 
-	for _, key := range keys {
-		res = append(res, fmt.Sprintf("%d:%s", len(key), key)...) // Encode key as string
-		res = append(res, s.Map[key].Value.Encode()...)
+	case *BencodeString:
+		b.WriteByte('"')
+		b.Write(v.Value)
+		b.WriteByte('"')
+
+	default:
+		b.WriteString(v.String())
 	}
-	res = append(res, 'e')
+}
 
-	return res
+func (s *BencodeDictionary) Encode() []byte {
+	return encodeValue(s)
 }
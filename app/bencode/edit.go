@@ -0,0 +1,294 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathMatch is a value matched by a path with wildcards, with the path of
+// the value itself, eg. "info.files[2].length" for "info.files[*].length".
+type PathMatch struct {
+	Path  string
+	Value *BencodeData
+
+	parents []*BencodeData // The values containing the value, from the root
+}
+
+// appendPathKey returns the path of the key of the dictionary at the path.
+// Keys that can't be parsed back unquoted are quoted.
+func appendPathKey(path string, key string) string {
+	if key == "" || strings.ContainsAny(key, ".[]\"") {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func appendPathIndex(path string, index int) string {
+	return fmt.Sprintf("%s[%d]", path, index)
+}
+
+// child returns the match for the item at the index or the key of the value.
+func (m PathMatch) child(path string, v *BencodeData) PathMatch {
+	parents := make([]*BencodeData, len(m.parents), len(m.parents)+1)
+	copy(parents, m.parents)
+	return PathMatch{Path: path, Value: v, parents: append(parents, m.Value)}
+}
+
+// children returns the values of the match for the segment.
+func (m PathMatch) children(s pathSegment) ([]PathMatch, error) {
+	v := m.Value
+	switch {
+	case s.all:
+		if l, err := v.AsList(); err == nil {
+			matches := make([]PathMatch, 0, len(l.Array))
+			for i, item := range l.Array {
+				matches = append(matches, m.child(appendPathIndex(m.Path, i), item))
+			}
+			return matches, nil
+		}
+		d, err := v.AsDictionary()
+		if err != nil {
+			return nil, &AccessError{Path: m.Path, Expected: ListType, Actual: v.Type}
+		}
+		matches := make([]PathMatch, 0, len(d.Map))
+		for _, key := range getSortedKeys(d.Map) {
+			matches = append(matches, m.child(appendPathKey(m.Path, key), d.Map[key]))
+		}
+		return matches, nil
+
+	case s.isIndex:
+		l, err := v.AsList()
+		if err != nil {
+			return nil, atPath(err, m.Path)
+		}
+		path := appendPathIndex(m.Path, s.index)
+		if s.index >= len(l.Array) {
+			return nil, &AccessError{Path: path}
+		}
+		return []PathMatch{m.child(path, l.Array[s.index])}, nil
+
+	default:
+		d, err := v.AsDictionary()
+		if err != nil {
+			return nil, atPath(err, m.Path)
+		}
+		path := appendPathKey(m.Path, s.key)
+		item, ok := d.Map[s.key]
+		if !ok {
+			return nil, &AccessError{Path: path}
+		}
+		return []PathMatch{m.child(path, item)}, nil
+	}
+}
+
+// lookupSegments returns the values matched by the segments. Values that
+// are missing below a wildcard are skipped, and missing values without
+// wildcards return an AccessError.
+func (bd *BencodeData) lookupSegments(segments []pathSegment) ([]PathMatch, error) {
+	matches := []PathMatch{{Value: bd}}
+	wildcard := false
+	for _, s := range segments {
+		next := make([]PathMatch, 0, len(matches))
+		for _, m := range matches {
+			children, err := m.children(s)
+			if err != nil {
+				if wildcard && errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			next = append(next, children...)
+		}
+		matches = next
+		wildcard = wildcard || s.all
+	}
+	return matches, nil
+}
+
+// LookupAll returns all the values at the path, which can have wildcards
+// like "info.files[*].path". Values missing below a wildcard are skipped.
+func (bd *BencodeData) LookupAll(path string) ([]PathMatch, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return bd.lookupSegments(segments)
+}
+
+// modified clears the raw bytes of the values containing the modified value,
+// as they no longer match the values.
+func (m PathMatch) modified() {
+	for _, p := range m.parents {
+		p.Raw = nil
+	}
+	m.Value.Raw = nil
+}
+
+// splitLastSegment returns the matches for the parents of the values at
+// the path, the last segment of the path, and if the parents are matched
+// by a wildcard.
+func (bd *BencodeData) splitLastSegment(path string) ([]PathMatch, pathSegment, bool, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, pathSegment{}, false, err
+	}
+	if len(segments) == 0 {
+		return nil, pathSegment{}, false, fmt.Errorf("bencode: empty path")
+	}
+
+	parentSegments := segments[:len(segments)-1]
+	parents, err := bd.lookupSegments(parentSegments)
+	if err != nil {
+		return nil, pathSegment{}, false, err
+	}
+
+	wildcard := false
+	for _, s := range parentSegments {
+		wildcard = wildcard || s.all
+	}
+	return parents, segments[len(segments)-1], wildcard, nil
+}
+
+// Set sets the values at the path to a copy of v, and returns the number of
+// values that were set. Missing dictionary keys are added, and an index one
+// past the end of a list appends to it. The raw bytes of the values
+// containing the modified values are cleared.
+func (bd *BencodeData) Set(path string, v *BencodeData) (int, error) {
+	parents, last, _, err := bd.splitLastSegment(path)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, parent := range parents {
+		switch {
+		case last.all:
+			children, err := parent.children(last)
+			if err != nil {
+				return count, err
+			}
+			for _, c := range children {
+				*c.Value = *v.clone()
+			}
+			count += len(children)
+
+		case last.isIndex:
+			l, err := parent.Value.AsList()
+			if err != nil {
+				return count, atPath(err, parent.Path)
+			}
+			if last.index > len(l.Array) {
+				return count, &AccessError{Path: appendPathIndex(parent.Path, last.index)}
+			}
+			if last.index == len(l.Array) {
+				l.Array = append(l.Array, v.clone())
+				l.Length = len(l.Array)
+			} else {
+				l.Array[last.index] = v.clone()
+			}
+			count++
+
+		default:
+			d, err := parent.Value.AsDictionary()
+			if err != nil {
+				return count, atPath(err, parent.Path)
+			}
+			d.Map[last.key] = v.clone()
+			d.Length = len(d.Map)
+			count++
+		}
+		parent.modified()
+	}
+	return count, nil
+}
+
+// Delete removes the values at the path, and returns the number of values
+// that were removed. Values missing below a wildcard are skipped. The raw
+// bytes of the values containing the removed values are cleared.
+func (bd *BencodeData) Delete(path string) (int, error) {
+	parents, last, wildcard, err := bd.splitLastSegment(path)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, parent := range parents {
+		switch {
+		case last.all:
+			if l, err := parent.Value.AsList(); err == nil {
+				count += len(l.Array)
+				l.Array = make([]*BencodeData, 0)
+				l.Length = 0
+			} else if d, err := parent.Value.AsDictionary(); err == nil {
+				count += len(d.Map)
+				d.Map = make(map[string]*BencodeData)
+				d.Length = 0
+			} else {
+				return count, &AccessError{Path: parent.Path, Expected: ListType, Actual: parent.Value.Type}
+			}
+
+		case last.isIndex:
+			l, err := parent.Value.AsList()
+			if err != nil {
+				return count, atPath(err, parent.Path)
+			}
+			if last.index >= len(l.Array) {
+				if wildcard {
+					continue
+				}
+				return count, &AccessError{Path: appendPathIndex(parent.Path, last.index)}
+			}
+			l.Array = append(l.Array[:last.index], l.Array[last.index+1:]...)
+			l.Length = len(l.Array)
+			count++
+
+		default:
+			d, err := parent.Value.AsDictionary()
+			if err != nil {
+				return count, atPath(err, parent.Path)
+			}
+			if _, ok := d.Map[last.key]; !ok {
+				if wildcard {
+					continue
+				}
+				return count, &AccessError{Path: appendPathKey(parent.Path, last.key)}
+			}
+			delete(d.Map, last.key)
+			d.Length = len(d.Map)
+			count++
+		}
+		parent.modified()
+	}
+	return count, nil
+}
+
+// clone returns a deep copy of the value, without its span in the input.
+func (bd *BencodeData) clone() *BencodeData {
+	c := &BencodeData{Type: bd.Type, Raw: bd.Raw}
+	switch v := bd.Value.(type) {
+	case *BencodeString:
+		c.Value = &BencodeString{Value: append([]byte{}, v.Value...), Length: v.Length}
+	case *BencodeInteger:
+		c.Value = &BencodeInteger{Value: v.Value, Big: v.Big}
+	case *BencodeList:
+		items := make([]*BencodeData, 0, len(v.Array))
+		for _, item := range v.Array {
+			items = append(items, item.clone())
+		}
+		c.Value = &BencodeList{Array: items, Length: len(items)}
+	case *BencodeDictionary:
+		m := make(map[string]*BencodeData, len(v.Map))
+		for key, item := range v.Map {
+			m[key] = item.clone()
+		}
+		c.Value = &BencodeDictionary{Map: m, Length: len(m)}
+	default:
+		c.Value = bd.Value
+	}
+	return c
+}
@@ -0,0 +1,163 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// The keys of info are not sorted, so that re-encoding it would change it.
+const testEditInfo = "d5:filesld6:lengthi1e4:pathl1:aeed4:attr1:p6:lengthi2e4:pathl1:beed6:lengthi3e4:pathl1:ceee" +
+	"4:name3:dir12:piece lengthi16384e6:pieces0:" + "4:apexi1ee"
+
+const testEditInput = "d8:announce3:url13:announce-listll1:ael1:bee13:creation datei1e4:info" + testEditInfo + "e"
+
+func TestEditOutsideInfoKeepsInfo(t *testing.T) {
+	bd, err := NewBencodeData([]byte(testEditInput))
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if _, err := bd.Set("announce", NewDataString("http://other")); err != nil {
+		t.Fatalf("error setting: %v", err)
+	}
+	if _, err := bd.Set("comment", NewDataString("edited")); err != nil {
+		t.Fatalf("error setting: %v", err)
+	}
+	if _, err := bd.Delete("creation date"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+
+	// The info dictionary is written as it was, so its hash is unchanged
+	want := "d8:announce12:http://other13:announce-listll1:ael1:bee7:comment6:edited4:info" + testEditInfo + "e"
+	if got := bd.EncodeRaw(); string(got) != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	edited, err := NewBencodeData(bd.EncodeRaw())
+	if err != nil {
+		t.Fatalf("error decoding the edited value: %v", err)
+	}
+	info, err := edited.Lookup("info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(info.Raw) != testEditInfo {
+		t.Errorf("expected the info dictionary to be unchanged, got %s", info.Raw)
+	}
+
+	// Editing info re-encodes it
+	if _, err := bd.Set("info.name", NewDataString("other")); err != nil {
+		t.Fatalf("error setting: %v", err)
+	}
+	info, _ = bd.Lookup("info")
+	if info.Raw != nil {
+		t.Error("expected the raw bytes of info to be cleared")
+	}
+	if bytes.Contains(bd.EncodeRaw(), []byte(testEditInfo[:20])) {
+		t.Error("expected info to be re-encoded with sorted keys")
+	}
+}
+
+func TestEditWildcards(t *testing.T) {
+	bd, err := NewBencodeData([]byte(testEditInput))
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+
+	matches, err := bd.LookupAll("info.files[*].attr")
+	if err != nil {
+		t.Fatalf("error looking up: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Path != "info.files[1].attr" {
+		t.Errorf("expected only the attr of the second file, got %+v", matches)
+	}
+
+	n, err := bd.Set("info.files[*].length", NewDataInteger(0))
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 values to be set, got %d, %v", n, err)
+	}
+	lengths := make([]string, 0)
+	matches, _ = bd.LookupAll("info.files[*].length")
+	for _, m := range matches {
+		lengths = append(lengths, m.Path+"="+string(m.Value.Value.Encode()))
+	}
+	want := []string{"info.files[0].length=i0e", "info.files[1].length=i0e", "info.files[2].length=i0e"}
+	if !reflect.DeepEqual(lengths, want) {
+		t.Errorf("expected %v, got %v", want, lengths)
+	}
+
+	// Missing values below a wildcard are skipped
+	n, err = bd.Delete("info.files[*].attr")
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 value to be deleted, got %d, %v", n, err)
+	}
+	n, err = bd.Delete("info.files[*].attr")
+	if err != nil || n != 0 {
+		t.Errorf("expected no value to be deleted, got %d, %v", n, err)
+	}
+
+	// Wildcards match the items of dictionaries too, in the order of their keys
+	matches, err = bd.LookupAll("announce-list[*][0]")
+	if err != nil || len(matches) != 2 || matches[1].Path != "announce-list[1][0]" {
+		t.Errorf("unexpected matches %+v, %v", matches, err)
+	}
+	matches, err = bd.LookupAll("info[*]")
+	if err != nil || len(matches) != 5 || matches[0].Path != "info.apex" {
+		t.Errorf("unexpected matches %+v, %v", matches, err)
+	}
+
+	n, err = bd.Delete("announce-list[*]")
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 values to be deleted, got %d, %v", n, err)
+	}
+	if got := string(bd.EncodeRaw()); got[:40] != "d8:announce3:url13:announce-listle13:cre" {
+		t.Errorf("expected an empty announce list, got %s", got)
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	bd, err := NewBencodeData([]byte(testEditInput))
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+
+	// An index one past the end appends, and further ones are missing
+	n, err := bd.Set("info.files[3]", NewDataString("d"))
+	if err != nil || n != 1 {
+		t.Errorf("expected the value to be appended, got %d, %v", n, err)
+	}
+	_, err = bd.Set("info.files[5]", NewDataString("f"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an error for an index past the end, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func() (int, error)
+		err  string
+	}{
+		{"empty path", func() (int, error) { return bd.Set("", NewDataInteger(1)) }, "bencode: empty path"},
+		{"set in a string", func() (int, error) { return bd.Set("announce.x", NewDataInteger(1)) },
+			"bencode: expected announce to be a Dictionary, got String"},
+		{"delete a missing key", func() (int, error) { return bd.Delete("info.missing") }, "bencode: info.missing not found"},
+		{"delete past the end", func() (int, error) { return bd.Delete("info.files[9]") }, "bencode: info.files[9] not found"},
+		{"wildcard on an integer", func() (int, error) { return bd.Delete("info.piece length[*]") },
+			"bencode: expected info.piece length to be a List, got Integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.edit()
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected the error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// The value is copied, so changing it later doesn't change the data
+	v := NewDataString("copied")
+	bd.Set("comment", v)
+	v.GetString().Value[0] = 'X'
+	if s, _ := bd.LookupString("comment"); s != "copied" {
+		t.Errorf("expected the set value to be copied, got %q", s)
+	}
+}
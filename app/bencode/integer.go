@@ -1,51 +1,128 @@
 package bencode
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
 )
 
+// ErrIntegerOverflow is returned for integers that don't fit in 64 bits,
+// unless they are decoded as big integers.
+var ErrIntegerOverflow = errors.New("integer overflow")
+
 type BencodeInteger struct {
-	Value int
+	Value int64
+
+	// Set instead of Value for integers that don't fit in 64 bits, which are
+	// only decoded by NewBencodeDataBigInt and Decoder.UseBigInt.
+	Big *big.Int
+}
+
+// parseDecimal converts the digits of a non-negative number, and reports
+// false if it doesn't fit in a uint64.
+func parseDecimal(digits []byte) (uint64, bool) {
+	var n uint64
+	for _, c := range digits {
+		digit := uint64(c - '0')
+		if n > (math.MaxUint64-digit)/10 {
+			return 0, false
+		}
+		n = n*10 + digit
+	}
+	return n, true
+}
+
+// newInteger converts the digits of an integer, with an optional '-' sign.
+func newInteger(digits []byte, bigInts bool) (BencodeInteger, error) {
+	isNeg := len(digits) > 0 && digits[0] == '-'
+	magnitude := digits
+	if isNeg {
+		magnitude = digits[1:]
+	}
+
+	n, ok := parseDecimal(magnitude)
+	if ok && !isNeg && n <= math.MaxInt64 {
+		return BencodeInteger{Value: int64(n)}, nil
+	}
+	if ok && isNeg && n <= -math.MinInt64 {
+		return BencodeInteger{Value: -int64(n)}, nil
+	}
+	if !bigInts {
+		return BencodeInteger{}, fmt.Errorf("%w: %s", ErrIntegerOverflow, digits)
+	}
+
+	b, ok := new(big.Int).SetString(string(digits), 10)
+	if !ok {
+		return BencodeInteger{}, fmt.Errorf("invalid integer: %s", digits)
+	}
+	return BencodeInteger{Big: b}, nil
+}
+
+// parseLength converts the digits of the length of a string.
+func parseLength(digits []byte) (int, error) {
+	n, ok := parseDecimal(digits)
+	if !ok || n > math.MaxInt {
+		return 0, fmt.Errorf("%w: string length %s", ErrIntegerOverflow, digits)
+	}
+	return int(n), nil
 }
 
 // Parses a integer encoded in the bencode format.
 // Format: i<number>e
 // Examples: i52e, i-52e
-func (d *decoder) parseInteger() (*BencodeInteger, error) {
+func (d *decoder) parseIntegerInto(i *BencodeInteger) error {
 	err := d.expect('i')
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	isNeg := false
-	nxt := d.peek()
-	if nxt != nil && *nxt == '-' {
-		isNeg = true
-		d.next()
+	start := d.idx
+	if d.peekIs('-') {
+		d.idx++
 	}
 
-	n, err := d.readInteger()
+	digits, err := d.readDigits()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if d.idx-start > len(digits) && len(digits) == 1 && digits[0] == '0' {
+		d.violation(start, "negative zero")
 	}
 
 	err = d.expect('e')
 	if err != nil {
-		return nil, fmt.Errorf("expected 'e' to terminate integer: %w", err)
+		return fmt.Errorf("expected 'e' to terminate integer: %w", err)
 	}
 
-	if isNeg {
-		n = n * -1
+	*i, err = newInteger(d.data[start:d.idx-1], d.bigInts)
+	return err
+}
+
+// Int returns the integer as an int, or an error if it doesn't fit in one.
+func (i *BencodeInteger) Int() (int, error) {
+	if i.Big != nil || int64(int(i.Value)) != i.Value {
+		return 0, fmt.Errorf("%w: %s does not fit in an int", ErrIntegerOverflow, i.String())
+	}
+	return int(i.Value), nil
+}
+
+// BigInt returns a copy of the integer as a big integer.
+func (i *BencodeInteger) BigInt() *big.Int {
+	if i.Big != nil {
+		return new(big.Int).Set(i.Big)
 	}
-	return &BencodeInteger{
-		Value: n,
-	}, nil
+	return big.NewInt(i.Value)
 }
 
 func (i *BencodeInteger) String() string {
-	return fmt.Sprintf("%d", i.Value)
+	if i.Big != nil {
+		return i.Big.String()
+	}
+	return strconv.FormatInt(i.Value, 10)
 }
 
 func (i *BencodeInteger) Encode() []byte {
-	return fmt.Appendf([]byte{}, "i%de", i.Value)
+	return encodeValue(i)
 }
@@ -0,0 +1,289 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"unicode/utf8"
+)
+
+// BinaryEncoding is the encoding of the strings that are not valid UTF-8,
+// like the piece hashes, when they are converted to JSON.
+type BinaryEncoding string
+
+const (
+	BINARY_HEX    BinaryEncoding = "hex"
+	BINARY_BASE64 BinaryEncoding = "base64"
+)
+
+// The keys of the JSON objects that mark values which can't be represented
+// with plain JSON. Binary strings are written as {"$hex": "<hex>"} or
+// {"$base64": "<base64>"}, and dictionaries with binary keys or which could
+// be mistaken for a marker are written as {"$dict": [[key, value], ...]}.
+const (
+	JSON_HEX_KEY    = "$hex"
+	JSON_BASE64_KEY = "$base64"
+	JSON_DICT_KEY   = "$dict"
+)
+
+// JSONOptions configures the conversion of bencode to JSON.
+type JSONOptions struct {
+	Binary BinaryEncoding // BINARY_HEX if empty
+	Indent string         // The indent of the pretty-printed output, compact if empty
+}
+
+// ToJSON converts the value to JSON. The conversion is lossless, and FromJSON
+// returns the same value for the output, though the keys of dictionaries
+// which were not sorted in the input are sorted.
+func ToJSON(bd *BencodeData, opts JSONOptions) ([]byte, error) {
+	switch opts.Binary {
+	case "":
+		opts.Binary = BINARY_HEX
+	case BINARY_HEX, BINARY_BASE64:
+	default:
+		return nil, fmt.Errorf("bencode: unknown binary encoding %q", opts.Binary)
+	}
+
+	v, err := toJSONValue(bd, opts.Binary)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", opts.Indent)
+	err = enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+func toJSONString(s []byte, binary BinaryEncoding) any {
+	if utf8.Valid(s) {
+		return string(s)
+	}
+	if binary == BINARY_BASE64 {
+		return map[string]string{JSON_BASE64_KEY: base64.StdEncoding.EncodeToString(s)}
+	}
+	return map[string]string{JSON_HEX_KEY: hex.EncodeToString(s)}
+}
+
+func toJSONValue(bd *BencodeData, binary BinaryEncoding) (any, error) {
+	switch v := bd.Value.(type) {
+	case *BencodeString:
+		return toJSONString(v.Value, binary), nil
+
+	case *BencodeInteger:
+		if v.Big != nil {
+			return json.Number(v.Big.String()), nil
+		}
+		return v.Value, nil
+
+	case *BencodeList:
+		items := make([]any, 0, len(v.Array))
+		for _, item := range v.Array {
+			jv, err := toJSONValue(item, binary)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, jv)
+		}
+		return items, nil
+
+	case *BencodeDictionary:
+		if !isPlainJSONObject(v) {
+			pairs := make([]any, 0, len(v.Map))
+			for _, key := range getSortedKeys(v.Map) {
+				jv, err := toJSONValue(v.Map[key], binary)
+				if err != nil {
+					return nil, err
+				}
+				pairs = append(pairs, []any{toJSONString([]byte(key), binary), jv})
+			}
+			return map[string]any{JSON_DICT_KEY: pairs}, nil
+		}
+
+		m := make(map[string]any, len(v.Map))
+		for key, item := range v.Map {
+			jv, err := toJSONValue(item, binary)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = jv
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("bencode: can't convert %s value to JSON", bd.Type)
+}
+
+// isPlainJSONObject reports if the dictionary can be written as a JSON
+// object, without being read back as a marked value.
+func isPlainJSONObject(d *BencodeDictionary) bool {
+	for key := range d.Map {
+		if !utf8.ValidString(key) {
+			return false
+		}
+		if len(d.Map) == 1 && (key == JSON_HEX_KEY || key == JSON_BASE64_KEY || key == JSON_DICT_KEY) {
+			return false
+		}
+	}
+	return true
+}
+
+// FromJSON converts JSON, in the format written by ToJSON, to bencode.
+// Both hex and base64 binary strings are accepted. Numbers must be
+// integers, and booleans and nulls are rejected as bencode has no
+// equivalent for them.
+func FromJSON(data []byte) (*BencodeData, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("bencode: invalid JSON: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("bencode: invalid JSON: data left after the value at offset %d", dec.InputOffset())
+	}
+
+	return fromJSONValue(v, "")
+}
+
+// fromJSONString converts a JSON string, or a marked binary string.
+func fromJSONString(v any, path string) ([]byte, bool, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), true, nil
+
+	case map[string]any:
+		if len(v) != 1 {
+			return nil, false, nil
+		}
+		if s, ok := v[JSON_HEX_KEY].(string); ok {
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				return nil, false, fmt.Errorf("bencode: invalid hex string%s: %w", atJSONPath(path), err)
+			}
+			return b, true, nil
+		}
+		if s, ok := v[JSON_BASE64_KEY].(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, false, fmt.Errorf("bencode: invalid base64 string%s: %w", atJSONPath(path), err)
+			}
+			return b, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func fromJSONValue(v any, path string) (*BencodeData, error) {
+	s, ok, err := fromJSONString(v, path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return NewDataString(string(s)), nil
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		// Integers that don't fit in 64 bits are kept as big integers
+		n, ok := new(big.Int).SetString(string(v), 10)
+		if !ok {
+			return nil, fmt.Errorf("bencode: number %s%s is not an integer", v, atJSONPath(path))
+		}
+		return NewDataBigInt(n), nil
+
+	case []any:
+		items := make([]*BencodeData, 0, len(v))
+		for i, item := range v {
+			bd, err := fromJSONValue(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, bd)
+		}
+		return NewDataList(items), nil
+
+	case map[string]any:
+		if pairs, ok := v[JSON_DICT_KEY]; ok && len(v) == 1 {
+			return fromJSONPairs(pairs, path)
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		m := make(map[string]*BencodeData, len(v))
+		for _, key := range keys {
+			bd, err := fromJSONValue(v[key], joinJSONPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			m[key] = bd
+		}
+		return NewDataDictionary(m), nil
+	}
+
+	return nil, fmt.Errorf("bencode: JSON value %v%s has no bencode equivalent", v, atJSONPath(path))
+}
+
+// fromJSONPairs converts the [key, value] pairs of a marked dictionary.
+func fromJSONPairs(v any, path string) (*BencodeData, error) {
+	pairs, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("bencode: expected a list of [key, value] pairs%s", atJSONPath(path))
+	}
+
+	m := make(map[string]*BencodeData, len(pairs))
+	for i, p := range pairs {
+		pair, ok := p.([]any)
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("bencode: expected a [key, value] pair%s", atJSONPath(fmt.Sprintf("%s[%d]", path, i)))
+		}
+		key, ok, err := fromJSONString(pair[0], path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("bencode: expected a string key%s", atJSONPath(fmt.Sprintf("%s[%d]", path, i)))
+		}
+
+		bd, err := fromJSONValue(pair[1], joinJSONPath(path, string(key)))
+		if err != nil {
+			return nil, err
+		}
+		m[string(key)] = bd
+	}
+	return NewDataDictionary(m), nil
+}
+
+// joinJSONPath returns the path of the key in the dictionary at the path,
+// in the format used by Lookup.
+func joinJSONPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// atJSONPath describes the location of a value in the errors of FromJSON.
+func atJSONPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf(" at %q", path)
+}
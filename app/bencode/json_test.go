@@ -0,0 +1,101 @@
+package bencode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"binary string", "4:\xff\x00\x01\xfe"},
+		{"UTF-8 string", "3:a\xc2\xb5"},
+		{"empty values", "l0:ledee"},
+		{"big integers", "li123456789012345678901234567890ei-123456789012345678901234567890ee"},
+		{"piece hashes", "d4:infod4:name1:a6:pieces20:" + strings.Repeat("\x00\xff", 10) + "ee"},
+		{"binary key", "d1:ai2e2:\xff\xfei1ee"},
+		{"hex marker key", "d4:$hex2:abe"},
+		{"base64 marker key", "d7:$base644:AAAAe"},
+		{"dict marker key", "d5:$dictlee"},
+		{"marker key of a binary string", "d4:$hex2:\xff\xfee"},
+		{"marker key with other keys", "d4:$hex2:ab1:xi1ee"},
+		{"marker strings", "l4:$hex7:$base645:$dicte"},
+		{"nested markers", "ld5:$dictd4:$hexd7:$base640:eeee"},
+	}
+
+	for _, tt := range tests {
+		for _, binary := range []BinaryEncoding{BINARY_HEX, BINARY_BASE64} {
+			t.Run(tt.name+" "+string(binary), func(t *testing.T) {
+				bd, err := NewBencodeDataBigInt([]byte(tt.input))
+				if err != nil {
+					t.Fatalf("error decoding: %v", err)
+				}
+				j, err := ToJSON(bd, JSONOptions{Binary: binary})
+				if err != nil {
+					t.Fatalf("error converting to JSON: %v", err)
+				}
+				got, err := FromJSON(j)
+				if err != nil {
+					t.Fatalf("error converting %s from JSON: %v", j, err)
+				}
+				if encoded := string(got.Value.Encode()); encoded != tt.input {
+					t.Errorf("expected %q, got %q from %s", tt.input, encoded, j)
+				}
+			})
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	tests := []struct {
+		input  string
+		binary BinaryEncoding
+		want   string
+	}{
+		{"4:\xff\x00\x01\xfe", BINARY_HEX, `{"$hex":"ff0001fe"}`},
+		{"4:\xff\x00\x01\xfe", BINARY_BASE64, `{"$base64":"/wAB/g=="}`},
+		{"d4:$hex2:abe", BINARY_HEX, `{"$dict":[["$hex","ab"]]}`},
+		{"d2:\xff\xfei1e1:ai2ee", BINARY_HEX, `{"$dict":[["a",2],[{"$hex":"fffe"},1]]}`},
+		{"d4:$hex2:ab1:xi1ee", BINARY_HEX, `{"$hex":"ab","x":1}`},
+		{"d1:<3:a&be", BINARY_HEX, `{"<":"a&b"}`},
+	}
+
+	for _, tt := range tests {
+		bd, err := NewBencodeData([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("error decoding: %v", err)
+		}
+		j, err := ToJSON(bd, JSONOptions{Binary: tt.binary})
+		if err != nil {
+			t.Fatalf("error converting to JSON: %v", err)
+		}
+		if string(j) != tt.want {
+			t.Errorf("expected %s for %q, got %s", tt.want, tt.input, j)
+		}
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string
+	}{
+		{`1.5`, "bencode: number 1.5 is not an integer"},
+		{`{"a":[true]}`, `bencode: JSON value true at "a[0]" has no bencode equivalent`},
+		{`null`, "bencode: JSON value <nil> has no bencode equivalent"},
+		{`{"$hex":"zz"}`, "bencode: invalid hex string"},
+		{`{"$dict":{}}`, "bencode: expected a list of [key, value] pairs"},
+		{`{"$dict":[["a"]]}`, `bencode: expected a [key, value] pair at "[0]"`},
+		{`{"$dict":[[1,2]]}`, `bencode: expected a string key at "[0]"`},
+		{`{} {}`, "bencode: invalid JSON: data left after the value"},
+	}
+
+	for _, tt := range tests {
+		_, err := FromJSON([]byte(tt.json))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("expected an error starting with %q for %s, got %v", tt.err, tt.json, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package bencode
+
+import (
+	"fmt"
+	"math"
+)
+
+// Value is a bencoded value that is decoded lazily. It is a slice of the
+// input, and its strings and items are slices of the input too, so reading
+// it does not copy or allocate. The input must not be modified while the
+// value is in use.
+//
+// The input is validated once by ParseValue, so the accessors only fail if
+// the value has a different type, or an integer overflows. The zero Value,
+// Dict and List are empty, as returned for missing items.
+type Value struct {
+	raw []byte
+}
+
+// Dict is a lazily decoded dictionary. Its items are located by scanning
+// the dictionary when they are looked up.
+type Dict struct {
+	raw []byte
+}
+
+// List is a lazily decoded list.
+type List struct {
+	raw []byte
+}
+
+// ParseValue validates that the data is a single bencoded value, and
+// returns it without decoding it.
+func ParseValue(data []byte) (Value, error) {
+	v, rest, err := ParseValuePrefix(data)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(rest) > 0 {
+		return Value{}, fmt.Errorf("data bytes left unprocessed from index %d even after parsing", len(data)-len(rest))
+	}
+	return v, nil
+}
+
+// ParseValuePrefix is like ParseValue, but the value can be followed by
+// other data, which is returned.
+func ParseValuePrefix(data []byte) (Value, []byte, error) {
+	end, err := skipValue(data, 0, 0)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	return Value{raw: data[:end:end]}, data[end:], nil
+}
+
+// ParseDict validates that the data is a single bencoded dictionary, and
+// returns it without decoding it.
+func ParseDict(data []byte) (Dict, error) {
+	v, err := ParseValue(data)
+	if err != nil {
+		return Dict{}, err
+	}
+	return v.Dict()
+}
+
+// skipValue validates the value at the offset, nested in depth lists and
+// dictionaries, and returns the offset after it.
+func skipValue(data []byte, i, depth int) (int, error) {
+	if i >= len(data) {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+
+	switch ch := data[i]; {
+	case isDigit(ch):
+		_, end, err := stringBounds(data, i)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing string: %w", err)
+		}
+		return end, nil
+
+	case ch == 'i':
+		i++
+		if i < len(data) && data[i] == '-' {
+			i++
+		}
+		start := i
+		for i < len(data) && isDigit(data[i]) {
+			i++
+		}
+		if i == start || i >= len(data) || data[i] != 'e' {
+			return 0, fmt.Errorf("error parsing integer: invalid integer at index %d", start)
+		}
+		return i + 1, nil
+
+	case ch == 'l':
+		if depth >= MAX_NESTING_DEPTH {
+			return 0, fmt.Errorf("%w at index %d", ErrNestingTooDeep, i)
+		}
+		i++
+		for i < len(data) && data[i] != 'e' {
+			end, err := skipValue(data, i, depth+1)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing list: parsing list item: %w", err)
+			}
+			i = end
+		}
+		if i >= len(data) {
+			return 0, fmt.Errorf("error parsing list: unexpected end of data")
+		}
+		return i + 1, nil
+
+	case ch == 'd':
+		if depth >= MAX_NESTING_DEPTH {
+			return 0, fmt.Errorf("%w at index %d", ErrNestingTooDeep, i)
+		}
+		i++
+		for i < len(data) && data[i] != 'e' {
+			if !isDigit(data[i]) {
+				return 0, fmt.Errorf("error parsing dictionary: expected string key in dictionary at index %d", i)
+			}
+			_, end, err := stringBounds(data, i)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing dictionary: parsing dictionary key: %w", err)
+			}
+			end, err = skipValue(data, end, depth+1)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing dictionary: parsing dictionary item: %w", err)
+			}
+			i = end
+		}
+		if i >= len(data) {
+			return 0, fmt.Errorf("error parsing dictionary: unexpected end of data")
+		}
+		return i + 1, nil
+
+	default:
+		return 0, fmt.Errorf("unrecognized character to start parsing: %q", ch)
+	}
+}
+
+// stringBounds returns the offsets of the bytes of the string at the offset.
+func stringBounds(data []byte, i int) (int, int, error) {
+	start := i
+	for i < len(data) && isDigit(data[i]) {
+		i++
+	}
+	if i == start || i >= len(data) || data[i] != ':' {
+		return 0, 0, fmt.Errorf("invalid length of string at index %d", start)
+	}
+
+	l, ok := parseDecimal(data[start:i])
+	if !ok || l > uint64(len(data)-i-1) {
+		return 0, 0, fmt.Errorf("not enough bytes: expected to read %s bytes", data[start:i])
+	}
+	return i + 1, i + 1 + int(l), nil
+}
+
+// Raw returns the bytes of the value.
+func (v Value) Raw() []byte {
+	return v.raw
+}
+
+// Type returns the type of the value, or an empty type for the zero Value.
+func (v Value) Type() DataType {
+	if len(v.raw) == 0 {
+		return ""
+	}
+	switch ch := v.raw[0]; {
+	case ch == 'i':
+		return IntegerType
+	case ch == 'l':
+		return ListType
+	case ch == 'd':
+		return DictionaryType
+	default:
+		return StringType
+	}
+}
+
+// Bytes returns the bytes of the string, or an AccessError if the value is
+// not a string.
+func (v Value) Bytes() ([]byte, error) {
+	if v.Type() != StringType {
+		return nil, &AccessError{Expected: StringType, Actual: v.Type()}
+	}
+	start, end, _ := stringBounds(v.raw, 0)
+	return v.raw[start:end:end], nil
+}
+
+// Int returns the integer, or an AccessError if the value is not an integer.
+// Integers that don't fit in 64 bits return an error wrapping ErrIntegerOverflow.
+func (v Value) Int() (int64, error) {
+	if v.Type() != IntegerType {
+		return 0, &AccessError{Expected: IntegerType, Actual: v.Type()}
+	}
+	digits := v.raw[1 : len(v.raw)-1]
+	isNeg := digits[0] == '-'
+	if isNeg {
+		digits = digits[1:]
+	}
+
+	n, ok := parseDecimal(digits)
+	switch {
+	case ok && !isNeg && n <= math.MaxInt64:
+		return int64(n), nil
+	case ok && isNeg && n <= -math.MinInt64:
+		return -int64(n), nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrIntegerOverflow, v.raw[1:len(v.raw)-1])
+}
+
+// List returns the list, or an AccessError if the value is not a list.
+func (v Value) List() (List, error) {
+	if v.Type() != ListType {
+		return List{}, &AccessError{Expected: ListType, Actual: v.Type()}
+	}
+	return List{raw: v.raw}, nil
+}
+
+// Dict returns the dictionary, or an AccessError if the value is not a
+// dictionary.
+func (v Value) Dict() (Dict, error) {
+	if v.Type() != DictionaryType {
+		return Dict{}, &AccessError{Expected: DictionaryType, Actual: v.Type()}
+	}
+	return Dict{raw: v.raw}, nil
+}
+
+// Data decodes the value into a tree of BencodeData, whose strings share
+// the bytes of the input.
+func (v Value) Data() (*BencodeData, error) {
+	return NewBencodeData(v.raw)
+}
+
+// Lookup returns the value at the path, in the format used by
+// BencodeData.Lookup, eg. "info.files[2].length".
+func (v Value) Lookup(path string) (Value, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return Value{}, err
+	}
+
+	for i, s := range segments {
+		parent := path[:0]
+		if i > 0 {
+			parent = path[:segments[i-1].end]
+		}
+
+		switch {
+		case s.all:
+			return Value{}, fmt.Errorf("bencode: wildcard in path %q, use LookupAll", path)
+
+		case s.isIndex:
+			l, err := v.List()
+			if err != nil {
+				return Value{}, atPath(err, parent)
+			}
+			item, ok := l.Index(s.index)
+			if !ok {
+				return Value{}, &AccessError{Path: path[:s.end]}
+			}
+			v = item
+
+		default:
+			d, err := v.Dict()
+			if err != nil {
+				return Value{}, atPath(err, parent)
+			}
+			item, ok := d.Get(s.key)
+			if !ok {
+				return Value{}, &AccessError{Path: path[:s.end]}
+			}
+			v = item
+		}
+	}
+	return v, nil
+}
+
+// Raw returns the bytes of the dictionary.
+func (d Dict) Raw() []byte {
+	return d.raw
+}
+
+// Range calls f for the items of the dictionary in the order of the input,
+// until f returns false. The keys are slices of the input.
+func (d Dict) Range(f func(key []byte, v Value) bool) {
+	if len(d.raw) == 0 {
+		return
+	}
+	i := 1
+	for d.raw[i] != 'e' {
+		start, end, _ := stringBounds(d.raw, i)
+		valueEnd, _ := skipValue(d.raw, end, 0)
+		if !f(d.raw[start:end:end], Value{raw: d.raw[end:valueEnd:valueEnd]}) {
+			return
+		}
+		i = valueEnd
+	}
+}
+
+// Get returns the value of the key. The dictionary is scanned for the key,
+// and the last value is returned for duplicate keys, like the decoder does.
+func (d Dict) Get(key string) (Value, bool) {
+	var found Value
+	ok := false
+	d.Range(func(k []byte, v Value) bool {
+		if string(k) == key {
+			found, ok = v, true
+		}
+		return true
+	})
+	return found, ok
+}
+
+// Len returns the number of items in the dictionary, counting each
+// duplicate key.
+func (d Dict) Len() int {
+	n := 0
+	d.Range(func([]byte, Value) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Raw returns the bytes of the list.
+func (l List) Raw() []byte {
+	return l.raw
+}
+
+// Range calls f for the items of the list in order, until f returns false.
+func (l List) Range(f func(index int, v Value) bool) {
+	if len(l.raw) == 0 {
+		return
+	}
+	i, index := 1, 0
+	for l.raw[i] != 'e' {
+		end, _ := skipValue(l.raw, i, 0)
+		if !f(index, Value{raw: l.raw[i:end:end]}) {
+			return
+		}
+		i, index = end, index+1
+	}
+}
+
+// Index returns the item at the index of the list.
+func (l List) Index(index int) (Value, bool) {
+	var found Value
+	ok := false
+	l.Range(func(i int, v Value) bool {
+		if i == index {
+			found, ok = v, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// Len returns the number of items in the list.
+func (l List) Len() int {
+	n := 0
+	l.Range(func(int, Value) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -0,0 +1,66 @@
+package bencode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLazyZeroValues(t *testing.T) {
+	// The zero values are returned for missing items, and must not panic
+	var v Value
+	if typ := v.Type(); typ != "" {
+		t.Errorf("expected no type, got %s", typ)
+	}
+	var accessErr *AccessError
+	if _, err := v.Bytes(); !errors.As(err, &accessErr) {
+		t.Errorf("expected an AccessError, got %v", err)
+	}
+	if _, err := v.Int(); !errors.As(err, &accessErr) {
+		t.Errorf("expected an AccessError, got %v", err)
+	}
+	if _, err := v.Dict(); !errors.As(err, &accessErr) {
+		t.Errorf("expected an AccessError, got %v", err)
+	}
+	if _, err := v.Lookup("info.name"); err == nil {
+		t.Error("expected an error looking up a path in the zero Value")
+	}
+
+	var d Dict
+	if d.Len() != 0 {
+		t.Errorf("expected an empty dictionary, got %d items", d.Len())
+	}
+	if _, ok := d.Get("a"); ok {
+		t.Error("expected no item in the zero Dict")
+	}
+
+	var l List
+	if l.Len() != 0 {
+		t.Errorf("expected an empty list, got %d items", l.Len())
+	}
+	if _, ok := l.Index(0); ok {
+		t.Error("expected no item in the zero List")
+	}
+}
+
+func TestLazyValue(t *testing.T) {
+	v, err := ParseValue([]byte("d4:infod5:filesld6:lengthi5eed6:lengthi-7eee4:name1:xee"))
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+
+	n, err := v.Lookup("info.files[1].length")
+	if err != nil {
+		t.Fatalf("error looking up: %v", err)
+	}
+	if i, err := n.Int(); err != nil || i != -7 {
+		t.Errorf("expected -7, got %d, %v", i, err)
+	}
+
+	// A missing item is the zero Value
+	info, _ := v.Lookup("info")
+	d, _ := info.Dict()
+	missing, ok := d.Get("missing")
+	if ok || missing.Type() != "" {
+		t.Errorf("expected the zero Value for a missing key, got %s", missing.Type())
+	}
+}
@@ -0,0 +1,299 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The tree decoder of the baseline, before it was rewritten to avoid
+// allocations, kept as the baseline of the benchmarks. It is copied
+// verbatim from decoder.go, string.go, integer.go, list.go, dictionary.go,
+// bencode.go and utils.go, with only the names prefixed with legacy so that
+// it compiles next to the current decoder, and the integers converted to
+// the int64 of BencodeInteger.
+
+type legacyDecoder struct {
+	data    []byte
+	idx     int
+	dataLen int
+}
+
+func newLegacyDecoder(data []byte) *legacyDecoder {
+	return &legacyDecoder{
+		data:    data,
+		dataLen: len(data),
+		idx:     0,
+	}
+}
+
+func newLegacyBencodeData(s []byte) (*BencodeData, error) {
+	d := newLegacyDecoder(s)
+
+	v, err := d.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that we are at the last byte of the decoder
+	if (d.idx) != d.dataLen {
+		return nil, fmt.Errorf("data bytes left unprocessed from index %d even after parsing: '%s'", d.idx, d.data[d.idx:])
+	}
+
+	return v, nil
+}
+
+func legacyIsDigit(b *byte) bool {
+	return *b >= '0' && *b <= '9'
+}
+
+func (d *legacyDecoder) peek() *byte {
+	if d.idx >= len(d.data) {
+		return nil
+	}
+	b := d.data[d.idx]
+	return &b
+}
+
+func (d *legacyDecoder) next() *byte {
+	if d.idx >= d.dataLen {
+		return nil
+	}
+	b := d.data[d.idx]
+	d.idx++
+	return &b
+}
+
+func (d *legacyDecoder) expect(b byte) error {
+	ch := d.peek()
+	if ch == nil || *ch != b {
+		return fmt.Errorf("expected character at index %d to be '%q': got '%q'", d.idx, b, *ch)
+	}
+
+	// Consume the character
+	d.next()
+	return nil
+}
+
+func (d *legacyDecoder) getNBytes(l int) []byte {
+	if d.idx+l-1 >= d.dataLen {
+		return nil
+	}
+
+	v := d.data[d.idx : d.idx+l]
+	d.idx += l
+	return v
+}
+
+func (d *legacyDecoder) readInteger() (int, error) {
+	nxt := d.peek()
+	if nxt == nil {
+		return 0, errors.New("not enough bytes")
+	}
+	if !legacyIsDigit(nxt) {
+		return 0, fmt.Errorf("expected a digit, recieved '%q'", *nxt)
+	}
+
+	// Consume the first byte to read the number
+	v := int(*nxt) - int('0')
+	d.next()
+
+	// Continue parsing
+	for legacyIsDigit(d.peek()) {
+		n := int(*d.next()) - int('0')
+		v = v*10 + n
+	}
+
+	return v, nil
+}
+
+func (d *legacyDecoder) parse() (*BencodeData, error) {
+	ch := d.peek()
+
+	if ch == nil {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+
+	// Parse string if it starts with a numeric character
+	if legacyIsDigit(ch) {
+		s, err := d.parseString()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing string: %w", err)
+		}
+		return &BencodeData{
+			Type:  StringType,
+			Value: s,
+		}, nil
+	}
+
+	// Parse integer if it starts with byte 'i'
+	if *ch == 'i' {
+		n, err := d.parseInteger()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing integer: %w", err)
+		}
+		return &BencodeData{
+			Type:  IntegerType,
+			Value: n,
+		}, nil
+	}
+
+	// Parse list if it starts with byte 'l'
+	if *ch == 'l' {
+		l, err := d.parseList()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing list: %w", err)
+		}
+		return &BencodeData{
+			Type:  ListType,
+			Value: l,
+		}, nil
+	}
+
+	// Parse dictionary if it starts with byte 'd'
+	if *ch == 'd' {
+		d, err := d.parseDictionary()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dictionary: %w", err)
+		}
+		return &BencodeData{
+			Type:  DictionaryType,
+			Value: d,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized character to start parsing: %q", *ch)
+}
+
+// Parses a string encoded in the bencode format.
+// Format: <length>:<bytes>
+func (d *legacyDecoder) parseString() (*BencodeString, error) {
+	l, err := d.readInteger()
+	if err != nil {
+		return nil, fmt.Errorf("length of string: %w", err)
+	}
+
+	err = d.expect(':')
+	if err != nil {
+		return nil, err
+	}
+
+	b := d.getNBytes(l)
+	if b == nil {
+		return nil, fmt.Errorf("not enough bytes: expected to read %d bytes", l)
+	}
+
+	return &BencodeString{
+		Value:  b,
+		Length: len(b),
+	}, nil
+}
+
+// Parses a integer encoded in the bencode format.
+// Format: i<number>e
+// Examples: i52e, i-52e
+func (d *legacyDecoder) parseInteger() (*BencodeInteger, error) {
+	err := d.expect('i')
+	if err != nil {
+		return nil, err
+	}
+
+	isNeg := false
+	nxt := d.peek()
+	if nxt != nil && *nxt == '-' {
+		isNeg = true
+		d.next()
+	}
+
+	n, err := d.readInteger()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.expect('e')
+	if err != nil {
+		return nil, fmt.Errorf("expected 'e' to terminate integer: %w", err)
+	}
+
+	if isNeg {
+		n = n * -1
+	}
+	return &BencodeInteger{
+		Value: int64(n),
+	}, nil
+}
+
+// Parses a list encoded in the bencode format.
+// Format: l<item><item>...e
+func (d *legacyDecoder) parseList() (*BencodeList, error) {
+	err := d.expect('l')
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*BencodeData
+	for {
+		ch := d.peek()
+		if ch != nil && *ch == 'e' {
+			break
+		}
+
+		item, err := d.parse()
+		if err != nil {
+			return nil, fmt.Errorf("parsing list item: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	err = d.expect('e')
+	if err != nil {
+		return nil, err
+	}
+
+	return &BencodeList{
+		Array:  items,
+		Length: len(items),
+	}, nil
+}
+
+// Parses a dictionary encoded in the bencode format.
+// Format: d<key1><item1>...e
+func (d *legacyDecoder) parseDictionary() (*BencodeDictionary, error) {
+	err := d.expect('d')
+	if err != nil {
+		return nil, err
+	}
+
+	itemsMap := make(map[string]*BencodeData)
+	for {
+		ch := d.peek()
+		if ch != nil && *ch == 'e' {
+			break
+		}
+
+		key, err := d.parse()
+		if err != nil {
+			return nil, fmt.Errorf("parsing dictionary key: %w", err)
+		}
+		if key.Type != StringType {
+			return nil, fmt.Errorf("expected string key in dictionary, got %s", key.Type)
+		}
+
+		item, err := d.parse()
+		if err != nil {
+			return nil, fmt.Errorf("parsing dictionary item: %w", err)
+		}
+
+		keyStr := string(key.GetString().Value)
+		itemsMap[keyStr] = item
+	}
+
+	err = d.expect('e')
+	if err != nil {
+		return nil, err
+	}
+
+	return &BencodeDictionary{
+		Map:    itemsMap,
+		Length: len(itemsMap),
+	}, nil
+}
@@ -12,51 +12,36 @@ type BencodeList struct {
 
 // Parses a list encoded in the bencode format.
 // Format: l<item><item>...e
-func (d *decoder) parseList() (*BencodeList, error) {
+func (d *decoder) parseListInto(l *BencodeList) error {
 	err := d.expect('l')
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	var items []*BencodeData
-	for {
-		ch := d.peek()
-		if ch != nil && *ch == 'e' {
-			break
-		}
-
+	for !d.peekIs('e') {
 		item, err := d.parse()
 		if err != nil {
-			return nil, fmt.Errorf("parsing list item: %w", err)
+			return fmt.Errorf("parsing list item: %w", err)
 		}
 		items = append(items, item)
 	}
 
 	err = d.expect('e')
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &BencodeList{
-		Array:  items,
-		Length: len(items),
-	}, nil
+	l.Array, l.Length = items, len(items)
+	return nil
 }
 
 func (s *BencodeList) String() string {
-	elements := make([]string, 0)
-	for _, item := range s.Array {
-		elements = append(elements, item.Value.String())
-	}
-
-	return fmt.Sprintf("[%s]", strings.Join(elements, ","))
+	var b strings.Builder
+	writeText(&b, s)
+	return b.String()
 }
 
 func (s *BencodeList) Encode() []byte {
-	d := []byte{'l'}
-	for _, item := range s.Array {
-		d = append(d, item.Value.Encode()...)
-	}
-	d = append(d, 'e')
-	return d
+	return encodeValue(s)
 }
@@ -0,0 +1,549 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Marshaler is implemented by types that encode themselves to bencode.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves from bencode.
+// The passed data is a single encoded value.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+// RawMessage is an encoded bencode value. It is used to delay decoding a
+// value, or to include a value that is already encoded.
+type RawMessage []byte
+
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("bencode: empty RawMessage")
+	}
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// UnmarshalTypeError describes a value that can't be stored in a Go value
+// of the given type.
+type UnmarshalTypeError struct {
+	Value DataType     // The type of the bencode value
+	Type  reflect.Type // The type of the Go value
+	Field string       // The path of the value, eg. "info.files"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %s", e.Value, e.Type)
+	}
+	return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %s at %q", e.Value, e.Type, e.Field)
+}
+
+var (
+	marshalerType   = reflect.TypeFor[Marshaler]()
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+	dataType        = reflect.TypeFor[BencodeData]()
+	bigIntType      = reflect.TypeFor[big.Int]()
+)
+
+// structField is an exported field of a struct, with its options from the
+// "bencode" struct tag. Example: `bencode:"piece length,omitempty"`.
+type structField struct {
+	name      string
+	index     []int
+	tagged    bool // Whether the name is from the tag
+	omitEmpty bool
+}
+
+var structFieldsCache sync.Map // map[reflect.Type][]structField
+
+// structFields returns the fields of the struct, including the fields
+// of embedded structs without a tag, sorted by their name. Like in
+// encoding/json, a field hides the fields with the same name that are
+// nested deeper, and fields with the same name at the same depth are
+// left out, unless only one of them is tagged.
+func structFields(t reflect.Type) []structField {
+	if f, ok := structFieldsCache.Load(t); ok {
+		return f.([]structField)
+	}
+
+	fields := make([]structField, 0)
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for _, f := range structFields(ft) {
+					f.index = append([]int{i}, f.index...)
+					fields = append(fields, f)
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     []int{i},
+			tagged:    tagged,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		return a.tagged && !b.tagged
+	})
+
+	visible := make([]structField, 0, len(fields))
+	for i := 0; i < len(fields); {
+		// The fields with the same name are sorted by depth, and then with
+		// the tagged ones first
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name && len(fields[j].index) == len(fields[i].index) {
+			j++
+		}
+		if j == i+1 || (fields[i].tagged && !fields[i+1].tagged) {
+			visible = append(visible, fields[i])
+		}
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		i = j
+	}
+
+	structFieldsCache.Store(t, visible)
+	return visible
+}
+
+// Marshal returns the bencode encoding of v. Structs are encoded as
+// dictionaries using the "bencode" struct tags of their fields, maps must
+// have string keys, and byte slices and arrays are encoded as strings.
+// Nil pointers, interfaces, maps and slices in structs are left out.
+// The output of a Marshaler, like a RawMessage, is included as it is,
+// even if its dictionary keys are not sorted.
+func Marshal(v any) ([]byte, error) {
+	bd, err := MarshalData(v)
+	if err != nil {
+		return nil, err
+	}
+	return bd.EncodeRaw(), nil
+}
+
+// MarshalData is like Marshal, but returns the decoded representation.
+func MarshalData(v any) (*BencodeData, error) {
+	bd, err := marshalValue(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	if bd == nil {
+		return nil, fmt.Errorf("bencode: cannot marshal nil value of type %T", v)
+	}
+	return bd, nil
+}
+
+// marshalValue returns nil for values that have no representation,
+// like nil pointers.
+func marshalValue(v reflect.Value) (*BencodeData, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if v.Kind() != reflect.Pointer && v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		v = v.Addr()
+	}
+	if v.Type().Implements(marshalerType) {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			if v.IsNil() {
+				return nil, nil
+			}
+		}
+		b, err := v.Interface().(Marshaler).MarshalBencode()
+		if err != nil {
+			return nil, fmt.Errorf("bencode: error marshaling %s: %w", v.Type(), err)
+		}
+		return NewBencodeDataBigInt(b)
+	}
+	if v.Type() == dataType {
+		bd := v.Interface().(BencodeData)
+		return &bd, nil
+	}
+	if v.Type() == bigIntType {
+		n := v.Interface().(big.Int)
+		return NewDataBigInt(&n), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(v.Elem())
+
+	case reflect.String:
+		return NewDataString(v.String()), nil
+
+	case reflect.Bool:
+		if v.Bool() {
+			return NewDataInteger(1), nil
+		}
+		return NewDataInteger(0), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewDataInteger64(v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return NewDataBigInt(new(big.Int).SetUint64(v.Uint())), nil
+		}
+		return NewDataInteger64(int64(v.Uint())), nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice && v.IsNil() {
+				return nil, nil
+			}
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return &BencodeData{Type: StringType, Value: &BencodeString{Value: b, Length: len(b)}}, nil
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+
+		items := make([]*BencodeData, 0, v.Len())
+		for i := range v.Len() {
+			item, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			if item == nil {
+				return nil, fmt.Errorf("bencode: cannot marshal nil item %d of %s", i, v.Type())
+			}
+			items = append(items, item)
+		}
+		return NewDataList(items), nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("bencode: unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		m := make(map[string]*BencodeData, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			item, err := marshalValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				m[iter.Key().String()] = item
+			}
+		}
+		return NewDataDictionary(m), nil
+
+	case reflect.Struct:
+		m := make(map[string]*BencodeData)
+		for _, f := range structFields(v.Type()) {
+			fv, err := v.FieldByIndexErr(f.index)
+			if err != nil {
+				// The field is in a nil embedded struct
+				continue
+			}
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			item, err := marshalValue(fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name, err)
+			}
+			if item != nil {
+				m[f.name] = item
+			}
+		}
+		return NewDataDictionary(m), nil
+	}
+
+	return nil, fmt.Errorf("bencode: unsupported type %s", v.Type())
+}
+
+// Unmarshal decodes the bencoded data into the value pointed to by v.
+// Strings can be stored in strings and byte slices, integers in integer
+// kinds and bools, lists in slices and arrays, and dictionaries in structs
+// and maps with string keys. Keys without a matching field are ignored.
+// Decoding into an interface stores string, int64, []any and map[string]any.
+// Integers that don't fit in an int64 are an error, unless the data is
+// decoded with NewBencodeDataBigInt and passed to UnmarshalData, which
+// stores them as *big.Int.
+func Unmarshal(data []byte, v any) error {
+	bd, err := NewBencodeData(data)
+	if err != nil {
+		return err
+	}
+	return UnmarshalData(bd, v)
+}
+
+// UnmarshalData is like Unmarshal, but for a value that is already decoded.
+func UnmarshalData(bd *BencodeData, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("bencode: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	return unmarshalValue(bd, rv.Elem(), "")
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func unmarshalValue(bd *BencodeData, v reflect.Value, path string) error {
+	typeError := func() error {
+		return &UnmarshalTypeError{Value: bd.Type, Type: v.Type(), Field: path}
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		// Pass the value as it was in the input, if it was decoded
+		raw := bd.Raw
+		if raw == nil {
+			raw = bd.Value.Encode()
+		}
+		err := v.Addr().Interface().(Unmarshaler).UnmarshalBencode(raw)
+		if err != nil {
+			return fmt.Errorf("bencode: error unmarshaling %q: %w", path, err)
+		}
+		return nil
+	}
+	if v.Type() == dataType {
+		v.Set(reflect.ValueOf(*bd))
+		return nil
+	}
+	if v.Type() == bigIntType && v.CanAddr() {
+		if bd.Type != IntegerType {
+			return typeError()
+		}
+		v.Addr().Interface().(*big.Int).Set(bd.GetInteger().BigInt())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(bd, v.Elem(), path)
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return typeError()
+		}
+		v.Set(reflect.ValueOf(toInterface(bd)))
+		return nil
+	}
+
+	switch bd.Type {
+	case StringType:
+		s := bd.GetString().Value
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(s))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte{}, s...))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if len(s) != v.Len() {
+				return fmt.Errorf("bencode: expected %d bytes at %q, got %d", v.Len(), path, len(s))
+			}
+			reflect.Copy(v, reflect.ValueOf(s))
+		default:
+			return typeError()
+		}
+
+	case IntegerType:
+		i := bd.GetInteger()
+		n := i.Value
+		overflowError := func() error {
+			return fmt.Errorf("bencode: integer %s overflows %s at %q: %w", i, v.Type(), path, ErrIntegerOverflow)
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if i.Big != nil || v.OverflowInt(n) {
+				return overflowError()
+			}
+			v.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if i.Big != nil && i.Big.IsUint64() && !v.OverflowUint(i.Big.Uint64()) {
+				v.SetUint(i.Big.Uint64())
+				break
+			}
+			if i.Big != nil || n < 0 || v.OverflowUint(uint64(n)) {
+				return overflowError()
+			}
+			v.SetUint(uint64(n))
+		case reflect.Bool:
+			v.SetBool(n != 0 || i.Big != nil)
+		default:
+			return typeError()
+		}
+
+	case ListType:
+		items := bd.GetList().Array
+		switch v.Kind() {
+		case reflect.Slice:
+			s := reflect.MakeSlice(v.Type(), len(items), len(items))
+			for i, item := range items {
+				err := unmarshalValue(item, s.Index(i), fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+			v.Set(s)
+		case reflect.Array:
+			if len(items) != v.Len() {
+				return fmt.Errorf("bencode: expected %d items at %q, got %d", v.Len(), path, len(items))
+			}
+			for i, item := range items {
+				err := unmarshalValue(item, v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return typeError()
+		}
+
+	case DictionaryType:
+		m := bd.GetDictionary().Map
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return typeError()
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMapWithSize(v.Type(), len(m)))
+			}
+			for k, item := range m {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				err := unmarshalValue(item, elem, joinPath(path, k))
+				if err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+			}
+		case reflect.Struct:
+			for _, f := range structFields(v.Type()) {
+				item, ok := m[f.name]
+				if !ok {
+					continue
+				}
+				fv, err := fieldByIndex(v, f.index)
+				if err != nil {
+					return fmt.Errorf("bencode: cannot unmarshal %q: %w", joinPath(path, f.name), err)
+				}
+				err = unmarshalValue(item, fv, joinPath(path, f.name))
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return typeError()
+		}
+	}
+	return nil
+}
+
+// isEmptyValue reports whether the value is left out with "omitempty".
+// Like in encoding/json, empty strings, slices and maps are also empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// fieldByIndex returns the nested field, allocating nil embedded structs.
+// Like in encoding/json, a nil pointer to an unexported struct can't be
+// allocated, and is an error.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// toInterface converts the value to string, int64, *big.Int, []any or
+// map[string]any. Big integers are only used for values out of range of int64.
+func toInterface(bd *BencodeData) any {
+	switch bd.Type {
+	case StringType:
+		return string(bd.GetString().Value)
+	case IntegerType:
+		if i := bd.GetInteger(); i.Big != nil {
+			return i.BigInt()
+		}
+		return bd.GetInteger().Value
+	case ListType:
+		items := make([]any, 0, len(bd.GetList().Array))
+		for _, item := range bd.GetList().Array {
+			items = append(items, toInterface(item))
+		}
+		return items
+	case DictionaryType:
+		m := make(map[string]any, len(bd.GetDictionary().Map))
+		for k, item := range bd.GetDictionary().Map {
+			m[k] = toInterface(item)
+		}
+		return m
+	}
+	return nil
+}
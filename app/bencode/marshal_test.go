@@ -0,0 +1,293 @@
+package bencode
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalInterface(t *testing.T) {
+	var v any
+	err := Unmarshal([]byte("d1:ai42e1:bl3:fooee"), &v)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+
+	want := map[string]any{"a": int64(42), "b": []any{"foo"}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("expected %#v, got %#v", want, v)
+	}
+}
+
+func TestUnmarshalInterfaceBigInt(t *testing.T) {
+	data := []byte("i123456789012345678901234567890e")
+	var v any
+	if err := Unmarshal(data, &v); err == nil {
+		t.Fatalf("expected an error for an integer out of range of int64, got %v", v)
+	}
+
+	bd, err := NewBencodeDataBigInt(data)
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	err = UnmarshalData(bd, &v)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	n, ok := v.(*big.Int)
+	if !ok || n.String() != "123456789012345678901234567890" {
+		t.Errorf("expected a *big.Int, got %#v", v)
+	}
+}
+
+type testFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string     `bencode:"name"`
+	PieceLength int        `bencode:"piece length"`
+	Files       []testFile `bencode:"files,omitempty"`
+	Private     bool       `bencode:"private,omitempty"`
+	Ignored     string     `bencode:"-"`
+}
+
+type testMetainfo struct {
+	Announce string   `bencode:"announce,omitempty"`
+	Info     testInfo `bencode:"info"`
+}
+
+func TestMarshalNestedStructs(t *testing.T) {
+	m := testMetainfo{
+		Announce: "http://tracker",
+		Info: testInfo{
+			Name:        "dir",
+			PieceLength: 16384,
+			Files:       []testFile{{Length: 5, Path: []string{"a", "b"}}},
+			Ignored:     "ignored",
+		},
+	}
+	data, err := Marshal(m)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	want := "d8:announce14:http://tracker4:infod5:filesld6:lengthi5e4:pathl1:a1:beee4:name3:dir12:piece lengthi16384eee"
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	var got testMetainfo
+	err = Unmarshal(data, &got)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	m.Info.Ignored = ""
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("expected %+v, got %+v", m, got)
+	}
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	data, err := Marshal(testMetainfo{Info: testInfo{Name: "file"}})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	want := "d4:infod4:name4:file12:piece lengthi0eee"
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	var m testMetainfo
+	err := Unmarshal([]byte("d4:infod5:filesld6:length1:xeeee"), &m)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected an UnmarshalTypeError, got %v", err)
+	}
+	if typeErr.Field != "info.files[0].length" || typeErr.Value != StringType {
+		t.Errorf("expected a string at info.files[0].length, got %s at %s", typeErr.Value, typeErr.Field)
+	}
+}
+
+type Base struct {
+	X int `bencode:"x"`
+	Y int `bencode:"y"`
+}
+
+type inner struct {
+	X int `bencode:"x"`
+}
+
+type Tagged struct {
+	Z int `bencode:"z"`
+}
+
+type Untagged struct {
+	Z int
+}
+
+type TaggedZ struct {
+	Z int `bencode:"Z"`
+}
+
+func TestMarshalEmbeddedStructs(t *testing.T) {
+	type T struct {
+		Base
+		*Tagged
+		Y int `bencode:"y"` // Hides Base.Y
+	}
+	v := T{Base: Base{X: 1, Y: 2}, Y: 3}
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	// The nil embedded pointer is left out
+	if want := "d1:xi1e1:yi3ee"; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	var got T
+	err = Unmarshal([]byte("d1:xi1e1:yi3e1:zi4ee"), &got)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if got.X != 1 || got.Y != 3 || got.Base.Y != 0 || got.Tagged == nil || got.Z != 4 {
+		t.Errorf("unexpected value %+v", got)
+	}
+}
+
+func TestMarshalEmbeddedConflicts(t *testing.T) {
+	// Fields with the same name at the same depth are left out, unless
+	// only one of them is tagged
+	type Untagged2 struct{ Z int }
+	type Conflict struct {
+		Untagged
+		Untagged2
+	}
+	type Resolved struct {
+		Untagged
+		TaggedZ
+	}
+
+	data, err := Marshal(Conflict{Untagged{1}, Untagged2{2}})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if want := "de"; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	data, err = Marshal(Resolved{Untagged{1}, TaggedZ{2}})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if want := "d1:Zi2ee"; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestUnmarshalUnexportedEmbeddedPointer(t *testing.T) {
+	type T struct {
+		*inner
+		Y int `bencode:"y"`
+	}
+
+	var v T
+	err := Unmarshal([]byte("d1:xi1e1:yi2ee"), &v)
+	if err == nil {
+		t.Fatal("expected an error for a nil pointer to an unexported struct")
+	}
+
+	// It is only an error if it has to be allocated
+	v = T{inner: &inner{}}
+	err = Unmarshal([]byte("d1:xi1e1:yi2ee"), &v)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if v.X != 1 || v.Y != 2 {
+		t.Errorf("unexpected value %+v", v)
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	type T struct {
+		R RawMessage `bencode:"r"`
+	}
+
+	// The raw bytes are kept as they are, even with unsorted keys
+	data, err := Marshal(T{R: RawMessage("d1:bi1e1:ai2ee")})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if want := "d1:rd1:bi1e1:ai2eee"; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	var v T
+	err = Unmarshal(data, &v)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if want := "d1:bi1e1:ai2ee"; string(v.R) != want {
+		t.Errorf("expected %s, got %s", want, v.R)
+	}
+
+	_, err = Marshal(T{R: RawMessage("d1:a")})
+	if err == nil {
+		t.Error("expected an error for an invalid RawMessage")
+	}
+}
+
+// hexValue encodes itself as a hex string, to test the Marshaler and
+// Unmarshaler interfaces.
+type hexValue []byte
+
+func (h hexValue) MarshalBencode() ([]byte, error) {
+	return NewDataString(hex.EncodeToString(h)).Value.Encode(), nil
+}
+
+func (h *hexValue) UnmarshalBencode(data []byte) error {
+	var s string
+	err := Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	*h, err = hex.DecodeString(s)
+	return err
+}
+
+func TestMarshalerInterfaces(t *testing.T) {
+	type T struct {
+		H  hexValue  `bencode:"h"`
+		P  *hexValue `bencode:"p"`
+		Hs []hexValue
+	}
+	p := hexValue{0xff}
+	v := T{H: hexValue{0xab, 0xcd}, P: &p, Hs: []hexValue{{0x01}}}
+
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if want := "d2:Hsl2:01e1:h4:abcd1:p2:ffe"; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	var got T
+	err = Unmarshal(data, &got)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("expected %+v, got %+v", v, got)
+	}
+
+	err = Unmarshal([]byte("d1:h2:zze"), &got)
+	if err == nil {
+		t.Error("expected the error of UnmarshalBencode to be returned")
+	}
+}
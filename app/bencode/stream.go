@@ -0,0 +1,524 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// Strings longer than this are read in chunks, so that a bogus length
+// prefix can't make us allocate more than the input actually contains.
+const MAX_PREALLOCATED_STRING_LENGTH = 64 * 1024
+
+// Decoder reads bencoded values one at a time from an input stream.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64
+	depth  int    // The number of lists and dictionaries being decoded
+	digits []byte // The digits of the number being decoded
+
+	// The raw bytes are only recorded for the values asked for with KeepRaw
+	keepAll   bool
+	keepKeys  map[string]bool
+	recording bool
+	raw       []byte // The bytes of the value being recorded
+	base      int64  // The offset of the value being recorded
+
+	bigInts bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next value from the input. It returns io.EOF if the
+// input ends before the value starts, and io.ErrUnexpectedEOF if it ends
+// in the middle of the value.
+func (d *Decoder) Decode() (*BencodeData, error) {
+	_, err := d.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	d.depth = 0
+	if d.keepAll {
+		return d.decodeRecorded()
+	}
+	return d.decodeValue()
+}
+
+// KeepRaw makes the decoder set the Raw bytes of the values of the keys of
+// a top level dictionary, eg. "info" so that it can be hashed as it was in
+// the input, or of all the values if no keys are passed. The Raw bytes of
+// the other values are nil, so that the input isn't held twice.
+func (d *Decoder) KeepRaw(keys ...string) {
+	if len(keys) == 0 {
+		d.keepAll = true
+		return
+	}
+	d.keepKeys = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		d.keepKeys[key] = true
+	}
+}
+
+// decodeRecorded decodes the next value, and sets its raw bytes.
+func (d *Decoder) decodeRecorded() (*BencodeData, error) {
+	// The values reference the bytes of the previous value, so they are
+	// collected into a new buffer
+	d.raw = nil
+	d.base = d.offset
+	d.recording = true
+	v, err := d.decodeValue()
+	d.recording = false
+	if err != nil {
+		return nil, err
+	}
+	d.setRaw(v)
+	return v, nil
+}
+
+// setRaw sets the raw bytes of the value and its children from their spans,
+// once all the bytes of the value have been read.
+func (d *Decoder) setRaw(v *BencodeData) {
+	start, end := v.Start-int(d.base), v.End-int(d.base)
+	v.Raw = d.raw[start:end:end]
+
+	switch v.Type {
+	case StringType:
+		// Share the bytes of the string with the raw bytes
+		s := v.GetString()
+		s.Value = v.Raw[len(v.Raw)-len(s.Value):]
+	case ListType:
+		for _, item := range v.GetList().Array {
+			d.setRaw(item)
+		}
+	case DictionaryType:
+		for _, item := range v.GetDictionary().Map {
+			d.setRaw(item)
+		}
+	}
+}
+
+// UseBigInt makes the decoder decode the integers that don't fit in 64 bits
+// as big integers, instead of failing with ErrIntegerOverflow.
+func (d *Decoder) UseBigInt() {
+	d.bigInts = true
+}
+
+// InputOffset returns the number of bytes of the input consumed so far.
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
+
+// Remaining returns a reader for the rest of the input, after the values
+// that were decoded. This is used to read raw bytes that follow a value.
+func (d *Decoder) Remaining() io.Reader {
+	return d.r
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	d.offset++
+	if d.recording {
+		d.raw = append(d.raw, b)
+	}
+	return b, nil
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return b[0], nil
+}
+
+func (d *Decoder) expect(b byte) error {
+	ch, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if ch != b {
+		return fmt.Errorf("expected character at index %d to be %q: got %q", d.offset-1, b, ch)
+	}
+	return nil
+}
+
+// readDigits reads the digits of a number, until the terminator.
+// The digits are only valid until the next call.
+func (d *Decoder) readDigits(terminator byte) ([]byte, error) {
+	ch, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if !isDigit(ch) {
+		return nil, fmt.Errorf("expected a digit, recieved %q", ch)
+	}
+
+	d.digits = append(d.digits[:0], ch)
+	for {
+		ch, err = d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if ch == terminator {
+			return d.digits, nil
+		}
+		if !isDigit(ch) {
+			return nil, fmt.Errorf("expected a digit or %q at index %d, recieved %q", terminator, d.offset-1, ch)
+		}
+		d.digits = append(d.digits, ch)
+	}
+}
+
+// readBytes reads exactly n bytes of the input. The bytes are read into the
+// raw bytes if they are being recorded, and shared with them.
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if d.recording {
+		start := len(d.raw)
+		raw, err := d.readAppend(d.raw, n)
+		if err != nil {
+			return nil, err
+		}
+		d.raw = raw
+		return d.raw[start:], nil
+	}
+	return d.readAppend(make([]byte, 0, min(n, MAX_PREALLOCATED_STRING_LENGTH)), n)
+}
+
+// readAppend reads exactly n bytes of the input, and appends them to b.
+func (d *Decoder) readAppend(b []byte, n int) ([]byte, error) {
+	for remaining := n; remaining > 0; {
+		chunk := min(remaining, MAX_PREALLOCATED_STRING_LENGTH)
+		start := len(b)
+		b = slices.Grow(b, chunk)[:start+chunk]
+		read, err := io.ReadFull(d.r, b[start:])
+		d.offset += int64(read)
+		if err != nil {
+			return nil, fmt.Errorf("not enough bytes: expected to read %d bytes: %w", n, unexpectedEOF(err))
+		}
+		remaining -= chunk
+	}
+	return b, nil
+}
+
+// decodeValue decodes the next value, and records its span in the input.
+func (d *Decoder) decodeValue() (*BencodeData, error) {
+	start := d.offset
+	v, err := d.decodeNext()
+	if err != nil {
+		return nil, err
+	}
+	v.Start, v.End = int(start), int(d.offset)
+	return v, nil
+}
+
+func (d *Decoder) decodeNext() (*BencodeData, error) {
+	ch, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isDigit(ch):
+		s, err := d.decodeString()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing string: %w", err)
+		}
+		return &BencodeData{Type: StringType, Value: s}, nil
+
+	case ch == 'i':
+		n, err := d.decodeInteger()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing integer: %w", err)
+		}
+		return &BencodeData{Type: IntegerType, Value: n}, nil
+
+	case ch == 'l':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		l, err := d.decodeList()
+		d.depth--
+		if err != nil {
+			return nil, fmt.Errorf("error parsing list: %w", err)
+		}
+		return &BencodeData{Type: ListType, Value: l}, nil
+
+	case ch == 'd':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		dict, err := d.decodeDictionary()
+		d.depth--
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dictionary: %w", err)
+		}
+		return &BencodeData{Type: DictionaryType, Value: dict}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized character to start parsing at index %d: %q", d.offset, ch)
+}
+
+// enter is called when a list or dictionary starts, and fails if it is
+// nested deeper than MAX_NESTING_DEPTH.
+func (d *Decoder) enter() error {
+	d.depth++
+	if d.depth > MAX_NESTING_DEPTH {
+		return fmt.Errorf("%w at index %d", ErrNestingTooDeep, d.offset)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeString() (*BencodeString, error) {
+	digits, err := d.readDigits(':')
+	if err != nil {
+		return nil, fmt.Errorf("length of string: %w", err)
+	}
+	l, err := parseLength(digits)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := d.readBytes(l)
+	if err != nil {
+		return nil, err
+	}
+	return &BencodeString{Value: b, Length: len(b)}, nil
+}
+
+func (d *Decoder) decodeInteger() (*BencodeInteger, error) {
+	err := d.expect('i')
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	isNeg := ch == '-'
+	if isNeg {
+		d.readByte()
+	}
+
+	digits, err := d.readDigits('e')
+	if err != nil {
+		return nil, err
+	}
+	if isNeg {
+		digits = append([]byte{'-'}, digits...)
+	}
+	i, err := newInteger(digits, d.bigInts)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (d *Decoder) decodeList() (*BencodeList, error) {
+	err := d.expect('l')
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*BencodeData
+	for {
+		ch, err := d.peekByte()
+		if err != nil {
+			return nil, err
+		}
+		if ch == 'e' {
+			d.readByte()
+			return &BencodeList{Array: items, Length: len(items)}, nil
+		}
+
+		item, err := d.decodeValue()
+		if err != nil {
+			return nil, fmt.Errorf("parsing list item: %w", err)
+		}
+		items = append(items, item)
+	}
+}
+
+func (d *Decoder) decodeDictionary() (*BencodeDictionary, error) {
+	err := d.expect('d')
+	if err != nil {
+		return nil, err
+	}
+
+	itemsMap := make(map[string]*BencodeData)
+	for {
+		ch, err := d.peekByte()
+		if err != nil {
+			return nil, err
+		}
+		if ch == 'e' {
+			d.readByte()
+			return &BencodeDictionary{Map: itemsMap, Length: len(itemsMap)}, nil
+		}
+		if !isDigit(ch) {
+			return nil, fmt.Errorf("expected string key in dictionary at index %d, got %q", d.offset, ch)
+		}
+
+		key, err := d.decodeString()
+		if err != nil {
+			return nil, fmt.Errorf("parsing dictionary key: %w", err)
+		}
+		var item *BencodeData
+		if d.depth == 1 && !d.recording && d.keepKeys[string(key.Value)] {
+			item, err = d.decodeRecorded()
+		} else {
+			item, err = d.decodeValue()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing dictionary item: %w", err)
+		}
+		itemsMap[string(key.Value)] = item
+	}
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for when the input
+// ends in the middle of a value.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encodeWriter is implemented by both bufio.Writer and bytes.Buffer.
+type encodeWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// Encoder writes bencoded values to an output stream.
+type Encoder struct {
+	w       encodeWriter
+	buf     *bufio.Writer // Set if the output is buffered by the encoder
+	scratch []byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	buf := bufio.NewWriter(w)
+	return &Encoder{w: buf, buf: buf}
+}
+
+// Encode writes the value to the output.
+func (e *Encoder) Encode(v *BencodeData) error {
+	e.writeValue(v.Value)
+
+	// The errors of the buffered writer are sticky, so they surface here
+	if e.buf != nil {
+		return e.buf.Flush()
+	}
+	return nil
+}
+
+func (e *Encoder) writeInt(n int) {
+	e.writeInt64(int64(n))
+}
+
+func (e *Encoder) writeInt64(n int64) {
+	e.scratch = strconv.AppendInt(e.scratch[:0], n, 10)
+	e.w.Write(e.scratch)
+}
+
+func (e *Encoder) writeString(s []byte) {
+	e.writeInt(len(s))
+	e.w.WriteByte(':')
+	e.w.Write(s)
+}
+
+func (e *Encoder) writeValue(v ValueInterface) {
+	switch v := v.(type) {
+	case *BencodeString:
+		e.writeString(v.Value)
+
+	case *BencodeInteger:
+		e.w.WriteByte('i')
+		if v.Big != nil {
+			e.w.WriteString(v.Big.String())
+		} else {
+			e.writeInt64(v.Value)
+		}
+		e.w.WriteByte('e')
+
+	case *BencodeList:
+		e.w.WriteByte('l')
+		for _, item := range v.Array {
+			e.writeValue(item.Value)
+		}
+		e.w.WriteByte('e')
+
+	case *BencodeDictionary:
+		e.w.WriteByte('d')
+		for _, key := range getSortedKeys(v.Map) {
+			e.writeInt(len(key))
+			e.w.WriteByte(':')
+			e.w.WriteString(key)
+			e.writeValue(v.Map[key].Value)
+		}
+		e.w.WriteByte('e')
+
+	default:
+		e.w.Write(v.Encode())
+	}
+}
+
+// writeData writes the value, using the raw bytes of the values that have them.
+func (e *Encoder) writeData(bd *BencodeData) {
+	if bd.Raw != nil {
+		e.w.Write(bd.Raw)
+		return
+	}
+
+	switch v := bd.Value.(type) {
+	case *BencodeList:
+		e.w.WriteByte('l')
+		for _, item := range v.Array {
+			e.writeData(item)
+		}
+		e.w.WriteByte('e')
+
+	case *BencodeDictionary:
+		e.w.WriteByte('d')
+		for _, key := range getSortedKeys(v.Map) {
+			e.writeString([]byte(key))
+			e.writeData(v.Map[key])
+		}
+		e.w.WriteByte('e')
+
+	default:
+		e.writeValue(bd.Value)
+	}
+}
+
+// EncodeRaw returns the encoded bytes of the value, keeping the bytes of the
+// values that were decoded and have not been modified with Set or Delete as
+// they were in the input. This keeps values that are not canonical, like an
+// info dictionary with unsorted keys, byte-identical when re-encoding.
+func (bd *BencodeData) EncodeRaw() []byte {
+	var b bytes.Buffer
+	e := &Encoder{w: &b}
+	e.writeData(bd)
+	return b.Bytes()
+}
+
+// encodeValue returns the encoded bytes of the value, without buffering.
+func encodeValue(v ValueInterface) []byte {
+	var b bytes.Buffer
+	e := &Encoder{w: &b}
+	e.writeValue(v)
+	return b.Bytes()
+}
@@ -0,0 +1,174 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecoderBackToBack(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1e3:abcld1:ai2eee"))
+	want := []struct {
+		encoded string
+		offset  int64
+	}{
+		{"i1e", 3},
+		{"3:abc", 8},
+		{"ld1:ai2eee", 18},
+	}
+
+	for _, w := range want {
+		v, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("error decoding %s: %v", w.encoded, err)
+		}
+		if got := string(v.Value.Encode()); got != w.encoded {
+			t.Errorf("expected %s, got %s", w.encoded, got)
+		}
+		if dec.InputOffset() != w.offset {
+			t.Errorf("expected offset %d after %s, got %d", w.offset, w.encoded, dec.InputOffset())
+		}
+		if int64(v.End) != w.offset {
+			t.Errorf("expected %s to end at %d, got %d", w.encoded, w.offset, v.End)
+		}
+	}
+
+	_, err := dec.Decode()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF after the last value, got %v", err)
+	}
+}
+
+func TestDecoderUnexpectedEOF(t *testing.T) {
+	for _, input := range []string{"i12", "5:abc", "l1:a", "d1:a", "100000:abc"} {
+		_, err := NewDecoder(strings.NewReader(input)).Decode()
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestDecoderRemaining(t *testing.T) {
+	// The bytes after the value are read from Remaining, including the
+	// ones the decoder has already buffered
+	payload := bytes.Repeat([]byte{0xff, 'e', 0}, 10000)
+	input := append([]byte("d8:msg_typei1e5:piecei0ee"), payload...)
+
+	dec := NewDecoder(bytes.NewReader(input))
+	v, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if n, err := v.LookupInteger("piece"); err != nil || n != 0 {
+		t.Errorf("expected piece 0, got %d, %v", n, err)
+	}
+
+	rest, err := io.ReadAll(dec.Remaining())
+	if err != nil {
+		t.Fatalf("error reading the remaining bytes: %v", err)
+	}
+	if !bytes.Equal(rest, payload) {
+		t.Errorf("expected the %d bytes of the payload, got %d bytes", len(payload), len(rest))
+	}
+}
+
+func TestDecoderKeepRaw(t *testing.T) {
+	// The keys of info are not sorted, so its raw bytes differ from its encoding
+	info := "d1:bi1e1:a" + "70000:" + strings.Repeat("x", 70000) + "e"
+	input := "d4:info" + info + "4:listl1:xee"
+
+	v, err := NewDecoder(strings.NewReader(input)).Decode()
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	for _, path := range []string{"info", "info.a", "list[0]"} {
+		bd, err := v.Lookup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bd.Raw != nil {
+			t.Errorf("expected no raw bytes at %s without KeepRaw", path)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(input))
+	dec.KeepRaw("info")
+	v, err = dec.Decode()
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	infoData, err := v.Lookup("info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(infoData.Raw) != info {
+		t.Errorf("expected the raw bytes of info to be kept, got %d bytes", len(infoData.Raw))
+	}
+	a, err := v.Lookup("info.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := a.GetString().Value; len(s) != 70000 || string(a.Raw) != "70000:"+string(s) {
+		t.Errorf("expected the raw bytes of info.a to be kept, got %d bytes", len(a.Raw))
+	}
+	list, err := v.Lookup("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Raw != nil || list.Raw != nil {
+		t.Error("expected no raw bytes outside of info")
+	}
+
+	dec = NewDecoder(strings.NewReader(input + input))
+	dec.KeepRaw()
+	for range 2 {
+		v, err = dec.Decode()
+		if err != nil {
+			t.Fatalf("error decoding: %v", err)
+		}
+		if string(v.Raw) != input {
+			t.Errorf("expected the raw bytes of the whole value, got %d bytes", len(v.Raw))
+		}
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		return []byte(strings.Repeat("l", depth) + strings.Repeat("e", depth))
+	}
+	decoders := []struct {
+		name   string
+		decode func([]byte) error
+	}{
+		{"tree", func(data []byte) error {
+			_, err := NewBencodeData(data)
+			return err
+		}},
+		{"lazy", func(data []byte) error {
+			_, err := ParseValue(data)
+			return err
+		}},
+		{"stream", func(data []byte) error {
+			_, err := NewDecoder(bytes.NewReader(data)).Decode()
+			return err
+		}},
+	}
+
+	for _, dec := range decoders {
+		t.Run(dec.name, func(t *testing.T) {
+			if err := dec.decode(nested(MAX_NESTING_DEPTH)); err != nil {
+				t.Errorf("expected %d nested lists to be decoded, got %v", MAX_NESTING_DEPTH, err)
+			}
+			err := dec.decode(nested(MAX_NESTING_DEPTH + 1))
+			if !errors.Is(err, ErrNestingTooDeep) {
+				t.Errorf("expected ErrNestingTooDeep, got %v", err)
+			}
+			err = dec.decode([]byte(strings.Repeat("d1:a", MAX_NESTING_DEPTH+1)))
+			if !errors.Is(err, ErrNestingTooDeep) {
+				t.Errorf("expected ErrNestingTooDeep for dictionaries, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package bencode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Violation is a deviation of the input from the canonical form of bencode.
+// Such input is accepted when decoding, but is changed when re-encoded,
+// eg. "i03e" becomes "i3e" and the later of duplicate keys is kept.
+type Violation struct {
+	Offset int // The offset of the value in the input
+	Reason string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("offset %d: %s", v.Offset, v.Reason)
+}
+
+// StrictError is returned by NewStrictBencodeData for input that is not
+// in the canonical form.
+type StrictError struct {
+	Violations []Violation
+}
+
+func (e *StrictError) Error() string {
+	reasons := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		reasons = append(reasons, v.String())
+	}
+	return fmt.Sprintf("bencode: input is not canonical: %s", strings.Join(reasons, "; "))
+}
+
+func (d *decoder) violation(offset int, format string, vals ...any) {
+	d.violations = append(d.violations, Violation{Offset: offset, Reason: fmt.Sprintf(format, vals...)})
+}
+
+// NewStrictBencodeData is like NewBencodeData, but also rejects input that is
+// valid but not in the canonical form, with a StrictError listing every
+// violation: integers with leading zeros or "-0", string lengths with leading
+// zeros, and dictionaries with unsorted or duplicate keys.
+func NewStrictBencodeData(s []byte) (*BencodeData, error) {
+	d := newDecoder(s)
+	v, err := d.parse()
+	if err != nil {
+		return nil, err
+	}
+	if d.idx != d.dataLen {
+		return nil, fmt.Errorf("data bytes left unprocessed from index %d even after parsing", d.idx)
+	}
+
+	if len(d.violations) > 0 {
+		// The violations of a dictionary are found after those of its values
+		sort.SliceStable(d.violations, func(i, j int) bool {
+			return d.violations[i].Offset < d.violations[j].Offset
+		})
+		return nil, &StrictError{Violations: d.violations}
+	}
+	return v, nil
+}
+
+// Validate decodes the input, and returns its violations of the canonical
+// form. The error is only set if the input can't be decoded at all.
+func Validate(s []byte) ([]Violation, error) {
+	_, err := NewStrictBencodeData(s)
+	if strictErr, ok := err.(*StrictError); ok {
+		return strictErr.Violations, nil
+	}
+	return nil, err
+}
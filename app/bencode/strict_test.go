@@ -0,0 +1,85 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStrictViolations(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		violations []string
+	}{
+		{"canonical integer", "i3e", nil},
+		{"zero", "i0e", nil},
+		{"leading zero", "i03e", []string{"offset 1: number with leading zeros"}},
+		{"negative leading zero", "i-03e", []string{"offset 2: number with leading zeros"}},
+		{"negative zero", "i-0e", []string{"offset 1: negative zero"}},
+		{"empty string", "0:", nil},
+		{"overlong string length", "03:abc", []string{"offset 0: number with leading zeros"}},
+		{"overlong empty string length", "00:", []string{"offset 0: number with leading zeros"}},
+		{"sorted keys", "d1:ai1e1:bi2ee", nil},
+		{"unsorted keys", "d1:bi1e1:ai2ee", []string{`offset 7: dictionary key "a" is not sorted after "b"`}},
+		{"duplicate keys", "d1:ai1e1:ai2ee", []string{`offset 7: duplicate dictionary key "a"`}},
+		{"keys sorted as bytes", "d1:B0:1:a0:e", nil},
+		{
+			"violations in order of their offsets",
+			"ld1:b0:1:a02:xyei-0ee",
+			[]string{
+				`offset 7: dictionary key "a" is not sorted after "b"`,
+				"offset 10: number with leading zeros",
+				"offset 17: negative zero",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStrictBencodeData([]byte(tt.input))
+			violations, validateErr := Validate([]byte(tt.input))
+			if validateErr != nil {
+				t.Fatalf("error validating: %v", validateErr)
+			}
+
+			got := make([]string, 0)
+			for _, v := range violations {
+				got = append(got, v.String())
+			}
+			if len(tt.violations) == 0 {
+				if err != nil || len(got) != 0 {
+					t.Errorf("expected no violations, got %v, %v", got, err)
+				}
+				return
+			}
+
+			var strictErr *StrictError
+			if !errors.As(err, &strictErr) || !reflect.DeepEqual(strictErr.Violations, violations) {
+				t.Errorf("expected a StrictError with the violations, got %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.violations) {
+				t.Errorf("expected the violations %q, got %q", tt.violations, got)
+			}
+
+			// The input is still accepted by the lenient decoder
+			if _, err := NewBencodeData([]byte(tt.input)); err != nil {
+				t.Errorf("error decoding leniently: %v", err)
+			}
+		})
+	}
+}
+
+func TestStrictInvalidInput(t *testing.T) {
+	// Input that can't be decoded at all is not a StrictError
+	for _, input := range []string{"5:abc", "i03", "d1:ai1e", "i1ei2e", "i e", "d1:ae"} {
+		_, err := NewStrictBencodeData([]byte(input))
+		var strictErr *StrictError
+		if err == nil || errors.As(err, &strictErr) {
+			t.Errorf("expected a decoding error for %q, got %v", input, err)
+		}
+		if _, err := Validate([]byte(input)); err == nil {
+			t.Errorf("expected Validate to fail for %q", input)
+		}
+	}
+}
@@ -9,13 +9,18 @@ type BencodeString struct {
 	Length int
 }
 
-// Parses a string encoded in the bencode format.
+// Parses a string encoded in the bencode format, and returns its bytes as
+// a slice of the input.
 // Format: <length>:<bytes>
-func (d *decoder) parseString() (*BencodeString, error) {
-	l, err := d.readInteger()
+func (d *decoder) parseStringBytes() ([]byte, error) {
+	digits, err := d.readDigits()
 	if err != nil {
 		return nil, fmt.Errorf("length of string: %w", err)
 	}
+	l, err := parseLength(digits)
+	if err != nil {
+		return nil, err
+	}
 
 	err = d.expect(':')
 	if err != nil {
@@ -26,17 +31,22 @@ func (d *decoder) parseString() (*BencodeString, error) {
 	if b == nil {
 		return nil, fmt.Errorf("not enough bytes: expected to read %d bytes", l)
 	}
+	return b, nil
+}
 
-	return &BencodeString{
-		Value:  b,
-		Length: len(b),
-	}, nil
+func (d *decoder) parseStringInto(s *BencodeString) error {
+	b, err := d.parseStringBytes()
+	if err != nil {
+		return err
+	}
+	s.Value, s.Length = b, len(b)
+	return nil
 }
 
 func (s *BencodeString) String() string {
-	return fmt.Sprintf("\"%s\"", s.Value)
+	return "\"" + string(s.Value) + "\""
 }
 
 func (s *BencodeString) Encode() []byte {
-	return append(fmt.Appendf([]byte{}, "%d:", s.Length), s.Value...)
+	return encodeValue(s)
 }
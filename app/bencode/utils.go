@@ -1,5 +1,5 @@
 package bencode
 
-func isDigit(b *byte) bool {
-	return *b >= '0' && *b <= '9'
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
 }
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+const createUsage = "usage: go-torrent create [-private] [-piece-length <bytes>] [-web-seeds <url,...>] -o <output-file> <path> <tracker-url>"
+
+func HandleCreate(args []string) {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	output := fs.String("o", "", "file to write the torrent to")
+	private := fs.Bool("private", false, "only allow peers from the tracker (BEP 27)")
+	pieceLength := fs.Int("piece-length", types.DEFAULT_PIECE_LENGTH, "piece length in bytes, a power of two")
+	webSeeds := fs.String("web-seeds", "", "comma separated list of web seed URLs")
+
+	if err := fs.Parse(args); err != nil || *output == "" || fs.NArg() != 2 {
+		fmt.Println(createUsage)
+		return
+	}
+
+	opts := types.TorrentCreateOptions{
+		Path:        fs.Arg(0),
+		TrackerURL:  fs.Arg(1),
+		PieceLength: *pieceLength,
+		Private:     *private,
+	}
+	if *webSeeds != "" {
+		opts.WebSeeds = strings.Split(*webSeeds, ",")
+	}
+
+	data, err := types.CreateTorrent(opts)
+	if err != nil {
+		fmt.Printf("error creating torrent: %v\n", err)
+		return
+	}
+	err = utils.MakeFileWithData(*output, data)
+	if err != nil {
+		fmt.Printf("error writing torrent file: %v\n", err)
+		return
+	}
+
+	fileInfo, err := types.NewTorrentFileInfo(*output)
+	if err != nil {
+		fmt.Printf("error reading created torrent: %v\n", err)
+		return
+	}
+	fmt.Printf("Torrent saved to '%s'\n", *output)
+	logInfo(fileInfo)
+}
@@ -1,24 +1,64 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
 )
 
+const decodeUsage = "usage: go-torrent decode [-pretty] [-binary hex|base64] [-f <file>] [<bytes-to-decode>|-]"
+
+// HandleDecode prints bencoded data as JSON. The data is read from the
+// argument, from a file with -f, or from STDIN if it is "-" or missing.
 func HandleDecode(args []string) {
-	if len(args) == 0 {
-		fmt.Println("no data passed to decode. usage: kafka decode <bytes-to-decode>")
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	pretty := fs.Bool("pretty", false, "pretty-print the JSON output")
+	binary := fs.String("binary", string(bencode.BINARY_HEX), "encoding of the strings that are not valid UTF-8: hex or base64")
+	file := fs.String("f", "", "file to decode")
+
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 || (*file != "" && fs.NArg() > 0) {
+		fmt.Println(decodeUsage)
+		return
+	}
+
+	data, err := readCommandInput(*file, fs.Arg(0))
+	if err != nil {
+		fmt.Printf("error reading the data: %v\n", err)
 		return
 	}
 
-	d := args[0]
-	bd, err := bencode.NewBencodeData([]byte(d))
+	bd, err := bencode.NewBencodeDataBigInt(data)
 	if err != nil {
-		fmt.Printf("error decoding the passed data: %v", err)
+		fmt.Printf("error decoding the passed data: %v\n", err)
 		return
 	}
 
-	// Print the string representation of the decoded data to STDOUT
-	fmt.Println(bd.Value.String())
+	opts := bencode.JSONOptions{Binary: bencode.BinaryEncoding(*binary)}
+	if *pretty {
+		opts.Indent = "  "
+	}
+	out, err := bencode.ToJSON(bd, opts)
+	if err != nil {
+		fmt.Printf("error converting to JSON: %v\n", err)
+		return
+	}
+
+	// Print the JSON representation of the decoded data to STDOUT
+	fmt.Println(string(out))
+}
+
+// readCommandInput returns the data passed to a command, which is read
+// from the file if it is set, from STDIN if the argument is "-" or empty,
+// and is the argument itself otherwise.
+func readCommandInput(file string, arg string) ([]byte, error) {
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	if arg == "" || arg == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return []byte(arg), nil
 }
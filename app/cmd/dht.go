@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/dht"
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+const dhtUsage = `incorrect arguments passed. usage:
+go-torrent [--dht-state <state-file>] dht status
+go-torrent dht keygen <key-file>
+go-torrent dht publish <key-file> <torrent-file> [<salt>]
+go-torrent dht resolve <magnet-link>`
+
+func HandleDHT(args []string) {
+	if len(args) == 0 {
+		fmt.Println(dhtUsage)
+		return
+	}
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		handleDHTStatus()
+	case args[0] == "keygen" && len(args) == 2:
+		handleDHTKeygen(args[1])
+	case args[0] == "publish" && (len(args) == 3 || len(args) == 4):
+		salt := ""
+		if len(args) == 4 {
+			salt = args[3]
+		}
+		handleDHTPublish(args[1], args[2], salt)
+	case args[0] == "resolve" && len(args) == 2:
+		handleDHTResolve(args[1])
+	default:
+		fmt.Println(dhtUsage)
+	}
+}
+
+func handleDHTStatus() {
+	if options.DHTStateFile == "" {
+		fmt.Println("no DHT state file configured")
+		return
+	}
+	state, err := dht.LoadState(options.DHTStateFile)
+	if err != nil {
+		fmt.Printf("error loading DHT state: %v\n", err)
+		return
+	}
+	if state == nil {
+		fmt.Printf("no DHT state found at '%s'\n", options.DHTStateFile)
+		return
+	}
+
+	// Rebuild the routing tables from the saved nodes to get the bucket occupancy
+	table4 := dht.NewRoutingTable(state.NodeID)
+	table6 := dht.NewRoutingTable(state.NodeID)
+	for _, n := range state.Nodes {
+		if n.Addr.IP.To4() != nil {
+			table4.Insert(n)
+		} else {
+			table6.Insert(n)
+		}
+	}
+
+	fmt.Printf("State File: %s\n", options.DHTStateFile)
+	fmt.Printf("Node ID: %s\n", state.NodeID)
+	if state.ExternalIP != nil {
+		fmt.Printf("External IP: %s (node ID secure: %t)\n", state.ExternalIP, dht.IsNodeIDSecure(state.NodeID, state.ExternalIP))
+	}
+	logRoutingTable("IPv4", table4)
+	logRoutingTable("IPv6", table6)
+}
+
+func logRoutingTable(name string, table *dht.RoutingTable) {
+	fmt.Printf("%s Nodes: %d (%d good)\n", name, table.Len(), table.GoodLen())
+	for _, b := range table.Stats() {
+		fmt.Printf("%3d: %d/%d nodes, %d good, last changed %s\n", b.Index, b.Nodes, dht.K_BUCKET_SIZE, b.GoodNodes, b.LastChanged.Format(time.RFC3339))
+	}
+}
+
+// handleDHTKeygen generates an ed25519 key used to publish mutable torrents
+// (BEP 46), and stores its hex encoded seed in the key file.
+func handleDHTKeygen(keyFile string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Printf("error generating key: %v\n", err)
+		return
+	}
+
+	err = utils.MakeFileWithData(keyFile, []byte(hex.EncodeToString(key.Seed())))
+	if err != nil {
+		fmt.Printf("error writing key file: %v\n", err)
+		return
+	}
+	fmt.Printf("Public Key: %x\n", key.Public())
+}
+
+func readKeyFile(keyFile string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key file should contain a hex encoded %d byte seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// handleDHTPublish publishes the info hash of the torrent file as the latest
+// version of the mutable torrent identified by the key and the salt.
+func handleDHTPublish(keyFile, torrentFile, salt string) {
+	key, err := readKeyFile(keyFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fileInfo, err := types.NewTorrentFileInfo(torrentFile)
+	if err != nil {
+		fmt.Printf("error creating TorrentFileInfo: %v\n", err)
+		return
+	}
+
+	node, err := startDHT()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer node.Close()
+
+	seq, err := node.PublishTorrentUpdate(key, []byte(salt), fileInfo.InfoHash)
+	if err != nil {
+		fmt.Printf("error publishing torrent update: %v\n", err)
+		return
+	}
+
+	magnet := fmt.Sprintf("magnet:?xs=urn:btpk:%x", key.Public())
+	if salt != "" {
+		magnet += fmt.Sprintf("&s=%x", salt)
+	}
+	fmt.Printf("Published info hash %s with sequence number %d\n", fileInfo.GetHexInfoHash(), seq)
+	fmt.Printf("Magnet Link: %s\n", magnet)
+}
+
+func handleDHTResolve(magnetLink string) {
+	m, err := types.NewMagnetURI(magnetLink)
+	if err != nil {
+		fmt.Printf("error creating MagnetURI: %v\n", err)
+		return
+	}
+	if !m.IsMutable() {
+		fmt.Println("magnet link does not point to a mutable torrent")
+		return
+	}
+
+	err = resolveMagnet(m)
+	if err != nil {
+		fmt.Printf("error resolving magnet link: %v\n", err)
+	}
+}
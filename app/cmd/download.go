@@ -7,30 +7,37 @@ import (
 )
 
 func HandleDownload(args []string) {
-	if len(args) != 3 || args[0] != "-o" {
-		println("incorrect arguments passed. usage: go-torrent download_file -o <output-file> <token-file>")
+	outputFile, torrentFile, err := parseDownloadArgs("download", args)
+	if err != nil {
+		fmt.Printf("error parsing arguments: %v\n", err)
+		fmt.Println("usage: go-torrent download [<rate-limit-flags>] -o <output-file> <torrent-file>")
 		return
 	}
 
 	// Create the torrent file info
-	torrentFile := args[2]
 	fileInfo, err := types.NewTorrentFileInfo(torrentFile)
 	if err != nil {
 		fmt.Printf("error creating TorrentFileInfo: %v\n", err)
 		return
 	}
 
-	// Get peers and prepare them to send piece data
-	peers, err := getPeersFromFile(fileInfo, true)
+	// Get peers and prepare them to send piece data. The file can
+	// still be downloaded without peers if it has web seeds.
+	webSeeds := getWebSeeds(fileInfo)
+	peers, px, err := getPeersFromFile(fileInfo, true)
 	if err != nil {
-		fmt.Printf("error getting peers: %v\n", err)
-		return
+		if len(webSeeds) == 0 {
+			fmt.Printf("error getting peers: %v\n", err)
+			return
+		}
+		fmt.Printf("error getting peers: %v, using web seeds only\n", err)
 	}
-	if len(peers) == 0 {
+	if len(peers) == 0 && len(webSeeds) == 0 {
 		fmt.Println("no peers found")
 		return
 	}
 	for _, peer := range peers {
+		peer.Metadata = fileInfo.InfoBytes
 		err = peer.PrepareToGetPieceData(fileInfo.InfoHash)
 		if err != nil {
 			fmt.Printf("error preparing peer: %v\n", err)
@@ -38,5 +45,5 @@ func HandleDownload(args []string) {
 		}
 	}
 
-	downloadPieces(peers, fileInfo, args[1])
+	downloadPieces(peers, px, webSeeds, fileInfo, outputFile)
 }
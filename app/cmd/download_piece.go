@@ -26,13 +26,13 @@ func HandleDownloadPiece(args []string) {
 		fmt.Printf("error converting piece index to int: %v\n", err)
 		return
 	}
-	if pieceIdx < 0 || pieceIdx >= len(fileInfo.InfoDict.Pieces) {
+	if pieceIdx < 0 || pieceIdx >= fileInfo.NumPieces() {
 		fmt.Printf("piece index out of range: %d\n", pieceIdx)
 		return
 	}
 
 	// Get the peers from the tracker
-	peers, err := getPeersFromFile(fileInfo, true)
+	peers, _, err := getPeersFromFile(fileInfo, true)
 	if err != nil {
 		fmt.Printf("error getting peers: %v\n", err)
 		return
@@ -52,14 +52,10 @@ func HandleDownloadPiece(args []string) {
 		return
 	}
 
-	// Register the piece with the peer
-	pieceLen := getPieceLength(fileInfo, pieceIdx)
-	pieceHash := fileInfo.InfoDict.Pieces[pieceIdx]
-
 	var sp *types.StoredPiece
 
 	for {
-		sp, err = peer.DownloadPiece(uint32(pieceIdx), pieceLen, pieceHash)
+		sp, err = downloadPiece(peer, fileInfo, pieceIdx)
 		if err != nil {
 			fmt.Printf("error downloading piece: %v\n", err)
 			continue
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+const encodeUsage = "usage: go-torrent encode [-o <output-file>] [-f <file>] [<json-to-encode>|-]"
+
+// HandleEncode converts JSON, in the format printed by the decode command,
+// back to bencode. The bencoded data is written to STDOUT, or to a file with -o.
+func HandleEncode(args []string) {
+	fs := flag.NewFlagSet("encode", flag.ContinueOnError)
+	output := fs.String("o", "", "file to write the bencoded data to")
+	file := fs.String("f", "", "file with the JSON to encode")
+
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 || (*file != "" && fs.NArg() > 0) {
+		fmt.Println(encodeUsage)
+		return
+	}
+
+	data, err := readCommandInput(*file, fs.Arg(0))
+	if err != nil {
+		fmt.Printf("error reading the data: %v\n", err)
+		return
+	}
+
+	bd, err := bencode.FromJSON(data)
+	if err != nil {
+		fmt.Printf("error converting from JSON: %v\n", err)
+		return
+	}
+	encoded := bd.Value.Encode()
+
+	if *output != "" {
+		err = utils.MakeFileWithData(*output, encoded)
+		if err != nil {
+			fmt.Printf("error writing the bencoded data: %v\n", err)
+		}
+		return
+	}
+	os.Stdout.Write(encoded)
+}
@@ -20,11 +20,18 @@ func HandleHandshake(args []string) {
 		return
 	}
 
-	peer, err := types.NewPeerFromAddr(args[1])
+	dialer, err := peerDialer()
+	if err != nil {
+		fmt.Printf("error creating dialer: %v\n", err)
+		return
+	}
+
+	peer, err := types.NewPeerFromAddrWithDialer(args[1], dialer)
 	if err != nil {
 		fmt.Printf("error creating peer: %v\n", err)
 		return
 	}
+	peer.Encryption = options.Encryption
 
 	handshake, err := peer.PerformHandshake(fileInfo.InfoHash)
 	if err != nil {
@@ -32,4 +39,7 @@ func HandleHandshake(args []string) {
 		return
 	}
 	fmt.Println("Peer ID:", utils.BytesToHex(handshake.PeerID))
+	if client, ok := peer.Client(); ok {
+		fmt.Println("Client:", client)
+	}
 }
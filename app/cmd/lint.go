@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+)
+
+func HandleLint(args []string) {
+	if len(args) != 1 {
+		fmt.Println("expected exactly one argument. usage: go-torrent lint <path-to-file>")
+		return
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Printf("error reading file: %v\n", err)
+		return
+	}
+
+	issues := types.LintTorrent(data)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	errorCount := 0
+	for _, issue := range issues {
+		if !issue.Warning {
+			errorCount++
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", errorCount, len(issues)-errorCount)
+}
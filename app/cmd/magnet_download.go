@@ -7,19 +7,26 @@ import (
 )
 
 func HandleMagnetDownload(args []string) {
-	if len(args) != 3 || args[0] != "-o" {
-		fmt.Println("usage: go-torrent magnet_download -o <output-file> <magnet-url>")
+	outputFile, magnetURL, err := parseDownloadArgs("magnet_download", args)
+	if err != nil {
+		fmt.Printf("error parsing arguments: %v\n", err)
+		fmt.Println("usage: go-torrent magnet_download [<rate-limit-flags>] -o <output-file> <magnet-url>")
 		return
 	}
 
-	m, err := types.NewMagnetURI(args[2])
+	m, err := types.NewMagnetURI(magnetURL)
 	if err != nil {
 		fmt.Printf("error creating MagnetURI: %v\n", err)
 		return
 	}
+	err = resolveMagnet(m)
+	if err != nil {
+		fmt.Printf("error resolving magnet link: %v\n", err)
+		return
+	}
 
 	// Get the peers from the tracker
-	peers, err := getPeers(m.TrackerURL, m.InfoHash, 999, true)
+	peers, px, err := getPeers(m.Trackers(), m.InfoHash, 999, false, true)
 	if err != nil {
 		fmt.Printf("error getting peers: %v\n", err)
 		return
@@ -40,5 +47,5 @@ func HandleMagnetDownload(args []string) {
 		}
 	}
 
-	downloadPieces(peers, fileInfo, args[1])
+	downloadPieces(peers, px, getWebSeeds(fileInfo), fileInfo, outputFile)
 }
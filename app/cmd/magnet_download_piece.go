@@ -21,9 +21,14 @@ func HandleMagnetDownloadPiece(args []string) {
 		fmt.Printf("error creating MagnetURI: %v\n", err)
 		return
 	}
+	err = resolveMagnet(m)
+	if err != nil {
+		fmt.Printf("error resolving magnet link: %v\n", err)
+		return
+	}
 
 	// Get the peers from the tracker
-	peers, err := getPeers(m.TrackerURL, m.InfoHash, 999, true)
+	peers, _, err := getPeers(m.Trackers(), m.InfoHash, 999, false, true)
 	if err != nil {
 		fmt.Printf("error getting peers: %v\n", err)
 		return
@@ -47,18 +52,15 @@ func HandleMagnetDownloadPiece(args []string) {
 		fmt.Printf("error converting piece index to int: %v\n", err)
 		return
 	}
-	if pieceIdx < 0 || pieceIdx >= len(fileInfo.InfoDict.Pieces) {
+	if pieceIdx < 0 || pieceIdx >= fileInfo.NumPieces() {
 		fmt.Printf("piece index out of range: %d\n", pieceIdx)
 		return
 	}
 
-	pieceLen := getPieceLength(fileInfo, pieceIdx)
-	pieceHash := fileInfo.InfoDict.Pieces[pieceIdx]
-
 	var sp *types.StoredPiece
 
 	for {
-		sp, err = peer.DownloadPiece(uint32(pieceIdx), pieceLen, pieceHash)
+		sp, err = downloadPiece(peer, fileInfo, pieceIdx)
 		if err != nil {
 			fmt.Printf("error downloading piece: %v\n", err)
 			continue
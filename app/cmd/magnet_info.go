@@ -16,8 +16,13 @@ func HandleMagnetInfo(args []string) {
 		println("error creating MagnetURI:", err)
 		return
 	}
+	err = resolveMagnet(m)
+	if err != nil {
+		println("error resolving magnet link:", err)
+		return
+	}
 
-	peers, err := getPeers(m.TrackerURL, m.InfoHash, 999, true)
+	peers, _, err := getPeers(m.Trackers(), m.InfoHash, 999, false, true)
 	if err != nil {
 		println("error getting peers:", err)
 		return
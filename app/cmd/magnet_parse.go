@@ -20,5 +20,9 @@ func HandleMagnetParse(args []string) {
 	}
 
 	fmt.Printf("Tracker URL: %s\n", m.TrackerURL)
+	if m.PublicKey != nil {
+		fmt.Printf("Public Key: %x\n", m.PublicKey)
+		fmt.Printf("Salt: %x\n", m.Salt)
+	}
 	fmt.Printf("Info Hash: %s\n", m.InfoHashHex)
 }
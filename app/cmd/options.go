@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+// Options holds the command line flags that are shared by all the
+// commands. They must be passed before the name of the command, eg.
+// go-torrent --dht magnet_download -o <output-file> <magnet-url>
+type Options struct {
+	Port         int    // The port we accept BitTorrent connections on, as announced to other peers
+	PeerIDPrefix string // The prefix of our peer ID, Azureus-style by default
+	Transport    string // The transport used to connect to peers: "tcp", "utp" or "both"
+	UTPAddr      string // The UDP address for the uTP socket to listen on
+
+	Encryption mse.Policy // Whether to encrypt the connections to peers (MSE/PE)
+
+	DHT               bool     // Whether to discover peers using the DHT
+	DHTAddr           string   // The UDP address for the DHT node to listen on
+	DHTBootstrapNodes []string // Overrides the default DHT bootstrap nodes
+	DHTStateFile      string   // The file used to persist the DHT routing table, empty to disable
+	DHTReadOnly       bool     // Whether the DHT node should never answer queries (BEP 43)
+	PEX               bool     // Whether to exchange peers with the connected peers (BEP 11)
+	LSD               bool     // Whether to discover peers on the local network (BEP 14)
+	LSDInterface      string   // The network interface used for local service discovery
+	LSDLoopback       bool     // Whether to discover the peers running on this host too
+}
+
+var options = Options{}
+
+// ParseOptions parses the global flags from the start of args, and
+// returns the remaining arguments starting with the command name.
+func ParseOptions(args []string) ([]string, error) {
+	fs := flag.NewFlagSet("go-torrent", flag.ContinueOnError)
+
+	fs.IntVar(&options.Port, "port", 6881, "port to announce for incoming BitTorrent connections")
+	fs.StringVar(&options.PeerIDPrefix, "peer-id-prefix", types.PEER_ID_PREFIX, "prefix of the peer ID, the rest is random")
+	fs.StringVar(&options.Transport, "transport", TRANSPORT_TCP, "transport to connect to peers with: tcp, utp, or both to prefer uTP and fall back to TCP")
+	fs.StringVar(&options.UTPAddr, "utp-addr", ":0", "UDP address for the uTP socket to listen on")
+	encryption := fs.String("encryption", string(mse.POLICY_DISABLE), "encryption of peer connections: disable, prefer or require")
+	fs.BoolVar(&options.DHT, "dht", false, "discover peers using the mainline DHT")
+	fs.StringVar(&options.DHTAddr, "dht-addr", ":6881", "UDP address for the DHT node to listen on")
+	fs.StringVar(&options.DHTStateFile, "dht-state", defaultDHTStateFile(), "file to persist the DHT node ID and routing table in, empty to disable")
+	fs.BoolVar(&options.DHTReadOnly, "dht-read-only", false, "run the DHT node in read-only mode, without answering queries")
+	fs.BoolVar(&options.PEX, "pex", false, "exchange peers with the connected peers, unless the torrent is private")
+	fs.BoolVar(&options.LSD, "lsd", false, "discover peers on the local network using multicast announces")
+	fs.StringVar(&options.LSDInterface, "lsd-interface", "", "network interface to use for local service discovery, the system default if empty")
+	fs.BoolVar(&options.LSDLoopback, "lsd-loopback", false, "discover the peers running on this host using multicast loopback")
+	bootstrap := fs.String("dht-bootstrap", "", "comma separated list of DHT bootstrap nodes (host:port)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	switch options.Transport {
+	case TRANSPORT_TCP, TRANSPORT_UTP, TRANSPORT_BOTH:
+	default:
+		return nil, fmt.Errorf("invalid transport %q", options.Transport)
+	}
+
+	if len(options.PeerIDPrefix) > 20 {
+		return nil, fmt.Errorf("peer ID prefix %q is longer than 20 bytes", options.PeerIDPrefix)
+	}
+	types.SERVER_PEER_ID = utils.GetRandomPeerID(options.PeerIDPrefix)
+
+	policy, err := mse.ParsePolicy(*encryption)
+	if err != nil {
+		return nil, err
+	}
+	options.Encryption = policy
+
+	if *bootstrap != "" {
+		options.DHTBootstrapNodes = strings.Split(*bootstrap, ",")
+	}
+	return fs.Args(), nil
+}
+
+// defaultDHTStateFile returns the path of the DHT state file in
+// the user's cache directory, or an empty string if there is none.
+func defaultDHTStateFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "toy-bittorrent", "dht.state")
+}
@@ -2,19 +2,32 @@ package cmd
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/types"
 )
 
+// The time given to every peer to complete the handshakes when identifying the peers
+const IDENTIFY_TIMEOUT = 10 * time.Second
+
+const peersUsage = "usage: go-torrent peers [-identify] <path-to-file>"
+
 func HandlePeers(args []string) {
+	// With -identify, we connect to the peers to identify their clients
+	identify := len(args) > 0 && args[0] == "-identify"
+	if identify {
+		args = args[1:]
+	}
+
 	// Validate the number of arguments passed to the info command
 	if len(args) == 0 {
-		fmt.Println("no data passed to info. usage: go-torrent peers <path-to-file>")
+		fmt.Println("no data passed to info. " + peersUsage)
 		return
 	}
 
 	if len(args) > 1 {
-		fmt.Println("too many arguments passed to info. usage: go-torrent peers <path-to-file>")
+		fmt.Println("too many arguments passed to info. " + peersUsage)
 		return
 	}
 
@@ -24,12 +37,46 @@ func HandlePeers(args []string) {
 		return
 	}
 
-	peers, err := getPeersFromFile(fileInfo, false)
+	peers, _, err := getPeersFromFile(fileInfo, identify)
 	if err != nil {
 		fmt.Printf("error getting peers: %v\n", err)
 		return
 	}
+	if identify {
+		identifyPeers(peers, fileInfo.InfoHash)
+	}
+
 	for _, peer := range peers {
+		if client, ok := peer.Client(); ok {
+			fmt.Printf("%s:%d %s\n", peer.IP, peer.Port, client)
+			continue
+		}
 		fmt.Printf("%s:%d\n", peer.IP, peer.Port)
 	}
 }
+
+// identifyPeers performs the handshakes with the connected peers, which
+// tell their peer IDs, and the client versions of the peers that support
+// the extension protocol.
+func identifyPeers(peers []*types.Peer, infoHash []byte) {
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer.SetDeadline(time.Now().Add(IDENTIFY_TIMEOUT))
+			handshake, err := peer.PerformHandshake(infoHash)
+			if err != nil {
+				peer.Log("error performing handshake: %v", err)
+				return
+			}
+			if handshake.SupportsExtensions {
+				_, err = peer.PerformExtensionHandshake()
+				if err != nil {
+					peer.Log("error performing extension handshake: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+)
+
+// HandleSeed serves a downloaded torrent to the peers that connect to us on
+// the port of the global options. Encrypted and plaintext connections are
+// accepted on the same listener, as allowed by the encryption policy, and
+// they are limited by the rate limit flags like the download commands.
+func HandleSeed(args []string) {
+	torrentFile, contentFile, err := parseSeedArgs(args)
+	if err != nil {
+		fmt.Printf("error parsing arguments: %v\n", err)
+		fmt.Println("usage: go-torrent seed [<rate-limit-flags>] <torrent-file> <file>")
+		return
+	}
+
+	fileInfo, err := types.NewTorrentFileInfo(torrentFile)
+	if err != nil {
+		fmt.Printf("error creating TorrentFileInfo: %v\n", err)
+		return
+	}
+
+	file, err := os.Open(contentFile)
+	if err != nil {
+		fmt.Printf("error opening file: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	err = verifyContent(fileInfo, file)
+	if err != nil {
+		fmt.Printf("error verifying file: %v\n", err)
+		return
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(options.Port)))
+	if err != nil {
+		fmt.Printf("error listening for peers: %v\n", err)
+		return
+	}
+	infoHashes := [][]byte{fileInfo.InfoHash}
+	if fileInfo.IsHybrid() {
+		infoHashes = append(infoHashes, fileInfo.InfoHashV2[:20])
+	}
+	listener := mse.NewListener(l, options.Encryption, func() [][]byte { return infoHashes })
+	defer listener.Close()
+
+	// Announce to the trackers that we have the whole torrent
+	for _, trackerURL := range fileInfo.Trackers() {
+		_, err := getTrackerPeers(trackerURL, fileInfo.InfoHash, 0)
+		if err == nil {
+			break
+		}
+		fmt.Printf("error announcing to tracker %s: %v\n", trackerURL, err)
+	}
+
+	fmt.Printf("Seeding '%s' on %s\n", contentFile, l.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("error accepting connection: %v\n", err)
+			return
+		}
+
+		go func() {
+			peer := types.NewPeerFromConn(conn)
+			peer.Metadata = fileInfo.InfoBytes
+			peer.Limits = ratelimit.NewLimits(rateLimits.peerDownload, rateLimits.peerUpload)
+			peer.ApplyRateLimits(ratelimit.GLOBAL_LIMITS, rateLimits.torrent)
+			defer peer.Close()
+
+			err := peer.Seed(fileInfo, file)
+			if err != nil {
+				fmt.Printf("error seeding to peer %s: %v\n", peer.Addr(), err)
+			}
+		}()
+	}
+}
+
+// verifyContent checks that all the pieces of the torrent can be read
+// from the content, and match their hashes.
+func verifyContent(fileInfo *types.TorrentFileInfo, content io.ReaderAt) error {
+	for i := range fileInfo.NumPieces() {
+		offset, err := fileInfo.PieceOffset(i)
+		if err != nil {
+			return err
+		}
+		data := make([]byte, fileInfo.PieceSize(i))
+		_, err = content.ReadAt(data, offset)
+		if err != nil {
+			return fmt.Errorf("error reading piece %d: %w", i, err)
+		}
+		err = fileInfo.VerifyPiece(i, data)
+		if err != nil {
+			return fmt.Errorf("error verifying piece %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+const torrentUsage = `usage: go-torrent torrent get <path> <file>...
+       go-torrent torrent set [-json] <path> <value> <file>...
+       go-torrent torrent del <path> <file>...
+paths are like "announce-list[0][0]" or "info.files[*].path"`
+
+// HandleTorrent reads and edits the values in torrent files, at paths in the
+// format used by bencode.Lookup, with "[*]" as a wildcard. Edits keep the
+// bytes of the info dictionary as they were unless it is edited, and warn
+// when the info hash of the torrent changes.
+func HandleTorrent(args []string) {
+	if len(args) == 0 {
+		fmt.Println(torrentUsage)
+		return
+	}
+
+	switch args[0] {
+	case "get":
+		handleTorrentGet(args[1:])
+	case "set":
+		handleTorrentSet(args[1:])
+	case "del":
+		handleTorrentDel(args[1:])
+	default:
+		fmt.Println(torrentUsage)
+	}
+}
+
+func readTorrentData(file string) (*bencode.BencodeData, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	bd, err := bencode.NewBencodeDataBigInt(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding file: %w", err)
+	}
+	return bd, nil
+}
+
+func handleTorrentGet(args []string) {
+	if len(args) < 2 {
+		fmt.Println(torrentUsage)
+		return
+	}
+	path, files := args[0], args[1:]
+
+	for _, file := range files {
+		prefix := ""
+		if len(files) > 1 {
+			prefix = file + ": "
+		}
+
+		bd, err := readTorrentData(file)
+		if err != nil {
+			fmt.Printf("%s%v\n", prefix, err)
+			continue
+		}
+		matches, err := bd.LookupAll(path)
+		if err != nil {
+			fmt.Printf("%s%v\n", prefix, err)
+			continue
+		}
+
+		for _, m := range matches {
+			out, err := bencode.ToJSON(m.Value, bencode.JSONOptions{})
+			if err != nil {
+				fmt.Printf("%s%v\n", prefix, err)
+				continue
+			}
+			// The paths of the values are printed for wildcards
+			if m.Path != path {
+				fmt.Printf("%s%s: %s\n", prefix, m.Path, out)
+			} else {
+				fmt.Printf("%s%s\n", prefix, out)
+			}
+		}
+	}
+}
+
+func handleTorrentSet(args []string) {
+	fs := flag.NewFlagSet("torrent set", flag.ContinueOnError)
+	isJSON := fs.Bool("json", false, "parse the value as JSON, in the format printed by decode")
+	if err := fs.Parse(args); err != nil || fs.NArg() < 3 {
+		fmt.Println(torrentUsage)
+		return
+	}
+	path, files := fs.Arg(0), fs.Args()[2:]
+
+	value := bencode.NewDataString(fs.Arg(1))
+	if *isJSON {
+		var err error
+		value, err = bencode.FromJSON([]byte(fs.Arg(1)))
+		if err != nil {
+			fmt.Printf("error parsing the value: %v\n", err)
+			return
+		}
+	}
+
+	for _, file := range files {
+		editTorrentFile(file, "set", func(bd *bencode.BencodeData) (int, error) {
+			return bd.Set(path, value)
+		})
+	}
+}
+
+func handleTorrentDel(args []string) {
+	if len(args) < 2 {
+		fmt.Println(torrentUsage)
+		return
+	}
+	path, files := args[0], args[1:]
+
+	for _, file := range files {
+		editTorrentFile(file, "deleted", func(bd *bencode.BencodeData) (int, error) {
+			return bd.Delete(path)
+		})
+	}
+}
+
+// editTorrentFile applies the edit to the torrent file, and writes it back
+// if any values were changed. Values that were not edited keep their bytes.
+func editTorrentFile(file string, action string, edit func(bd *bencode.BencodeData) (int, error)) {
+	bd, err := readTorrentData(file)
+	if err != nil {
+		fmt.Printf("%s: %v\n", file, err)
+		return
+	}
+	infoBefore := torrentInfoBytes(bd)
+
+	count, err := edit(bd)
+	if err != nil {
+		fmt.Printf("%s: %v\n", file, err)
+		return
+	}
+	if count == 0 {
+		fmt.Printf("%s: no values matched\n", file)
+		return
+	}
+
+	infoAfter := torrentInfoBytes(bd)
+	if !bytes.Equal(infoBefore, infoAfter) {
+		warnInfoHashChange(file, infoBefore, infoAfter)
+	}
+
+	err = utils.MakeFileWithData(file, bd.EncodeRaw())
+	if err != nil {
+		fmt.Printf("%s: error writing file: %v\n", file, err)
+		return
+	}
+	fmt.Printf("%s: %s %d values\n", file, action, count)
+}
+
+// torrentInfoBytes returns the bytes of the info dictionary of the torrent,
+// or nil if it has none.
+func torrentInfoBytes(bd *bencode.BencodeData) []byte {
+	info, err := bd.Lookup("info")
+	if err != nil {
+		return nil
+	}
+	return info.EncodeRaw()
+}
+
+func warnInfoHashChange(file string, before []byte, after []byte) {
+	if after == nil {
+		fmt.Printf("%s: warning: the edit removes the info dictionary\n", file)
+		return
+	}
+	hashBefore, _ := utils.SHA1Hash(before)
+	hashAfter, _ := utils.SHA1Hash(after)
+	fmt.Printf("%s: warning: the edit changes the info hash from %x to %x\n", file, hashBefore, hashAfter)
+}
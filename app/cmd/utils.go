@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/types"
 )
@@ -9,29 +10,116 @@ import (
 // getPieceLength calculates the length of a piece in a torrent file.
 // It takes into account the last piece which may be shorter than the others.
 func getPieceLength(fileInfo *types.TorrentFileInfo, pieceIdx int) uint32 {
-	pieceLength := fileInfo.InfoDict.PieceLength
-	fileLength := fileInfo.InfoDict.Length
-	if (pieceIdx+1)*pieceLength > fileLength {
-		pieceLength = fileLength - (pieceIdx * pieceLength)
+	return fileInfo.PieceSize(pieceIdx)
+}
+
+// downloadPiece downloads the piece from the peer, and verifies it with the
+// SHA-1 piece hash for v1 and hybrid torrents, or the merkle tree for v2 torrents.
+func downloadPiece(peer *types.Peer, fileInfo *types.TorrentFileInfo, pieceIdx int) (*types.StoredPiece, error) {
+	// The piece indices fit in 32 bits for the torrents that were parsed
+	if pieceIdx < 0 || pieceIdx >= fileInfo.NumPieces() {
+		return nil, fmt.Errorf("piece index out of range: %d", pieceIdx)
 	}
-	return uint32(pieceLength)
+	pieceLen := getPieceLength(fileInfo, pieceIdx)
+	if fileInfo.InfoDict.Pieces != nil {
+		return peer.DownloadPiece(uint32(pieceIdx), pieceLen, fileInfo.InfoDict.Pieces[pieceIdx])
+	}
+
+	root, leaves, err := fileInfo.PieceHashV2(pieceIdx)
+	if err != nil {
+		return nil, err
+	}
+	return peer.DownloadPieceV2(uint32(pieceIdx), pieceLen, root, leaves)
 }
 
-// getPeers makes a request to the tracker to get a list of peers.
+// getPeers collects the peers for the info hash from the trackers, and from
+// the DHT and the local network if they are enabled, into a single peer pool.
+// The trackers are tried in order, until one of them responds. If PEX is
+// enabled, the peers learnt from the connected peers are also added to the
+// pool, and queued in the returned peer exchange so that they can be
+// connected to while downloading. It is nil if PEX is disabled.
+// Peers of private torrents are only obtained from the trackers (BEP 27).
 // makeConnection is a boolean that indicates whether to make a connection to the
-// generated peers or not.
-func getPeers(trackerURL string, infoHash []byte, leftLength int, makeConnection bool) ([]*types.Peer, error) {
+// generated peers or not. Peers that cannot be connected to are skipped.
+func getPeers(trackerURLs []string, infoHash []byte, leftLength int, private bool, makeConnection bool) ([]*types.Peer, *types.PeerExchange, error) {
+	pool := types.NewPeerPool()
+	useDHT := options.DHT && !private
+	useLSD := options.LSD && !private
+
+	var trackerErr error
+	for _, trackerURL := range trackerURLs {
+		peers, err := getTrackerPeers(trackerURL, infoHash, leftLength)
+		if err == nil {
+			pool.AddPeers(peers, types.PEER_SOURCE_TRACKER)
+			trackerErr = nil
+			break
+		}
+		trackerErr = err
+		fmt.Printf("error getting peers from tracker %s: %v\n", trackerURL, err)
+	}
+	if trackerErr != nil && !useDHT && !useLSD {
+		return nil, nil, trackerErr
+	}
+
+	if useDHT {
+		addrs, err := getDHTPeers(infoHash)
+		if err != nil {
+			fmt.Printf("error getting peers from DHT: %v\n", err)
+		}
+		for _, addr := range addrs {
+			pool.Add(addr, types.PEER_SOURCE_DHT)
+		}
+	}
+
+	if useLSD {
+		addrs, err := getLSDPeers(infoHash)
+		if err != nil {
+			fmt.Printf("error getting peers from LSD: %v\n", err)
+		}
+		for _, addr := range addrs {
+			pool.Add(addr, types.PEER_SOURCE_LSD)
+		}
+	}
+
+	if !makeConnection {
+		return pool.Peers(), nil, nil
+	}
+
+	dialer, err := peerDialer()
+	if err != nil {
+		return nil, nil, err
+	}
+	pool.Dialer = dialer
+	pool.Encryption = options.Encryption
+	pool.ListenPort = options.Port
+	pool.TorrentLimits = rateLimits.torrent
+	pool.PeerDownloadLimit = rateLimits.peerDownload
+	pool.PeerUploadLimit = rateLimits.peerUpload
+
+	peers := pool.Connect()
+	if !options.PEX || private {
+		return peers, nil, nil
+	}
+	px := types.NewPeerExchange(pool)
+	for _, p := range peers {
+		p.EnablePex(px)
+	}
+	return peers, px, nil
+}
+
+// getTrackerPeers makes a request to the tracker to get a list of peers.
+func getTrackerPeers(trackerURL string, infoHash []byte, leftLength int) ([]*types.Peer, error) {
 	req := types.TrackerGetRequest{
 		TrackerURL: trackerURL,
 		InfoHash:   infoHash,
 		PeerID:     string(types.SERVER_PEER_ID),
-		Port:       6881,
+		Port:       options.Port,
 		Uploaded:   0,
 		Downloaded: 0,
 		Compact:    1,
 		Left:       leftLength,
 	}
-	resp, err := req.MakeRequest(makeConnection)
+	resp, err := req.MakeRequest(false)
 	if err != nil {
 		return nil, fmt.Errorf("error making request to tracker: %w", err)
 	}
@@ -40,12 +128,12 @@ func getPeers(trackerURL string, infoHash []byte, leftLength int, makeConnection
 }
 
 // getPeersFromFile is a wrapper function around getPeers.
-func getPeersFromFile(fileInfo *types.TorrentFileInfo, makeConnection bool) ([]*types.Peer, error) {
-	peers, err := getPeers(fileInfo.TrackerURL, fileInfo.InfoHash, fileInfo.InfoDict.Length, makeConnection)
+func getPeersFromFile(fileInfo *types.TorrentFileInfo, makeConnection bool) ([]*types.Peer, *types.PeerExchange, error) {
+	peers, px, err := getPeers(fileInfo.Trackers(), fileInfo.InfoHash, fileInfo.InfoDict.Length, fileInfo.InfoDict.Private, makeConnection)
 	if err != nil {
-		return nil, fmt.Errorf("error getting peers from tracker: %w", err)
+		return nil, nil, fmt.Errorf("error getting peers: %w", err)
 	}
-	return peers, nil
+	return peers, px, nil
 }
 
 func logInfo(fileInfo *types.TorrentFileInfo) {
@@ -54,9 +142,23 @@ func logInfo(fileInfo *types.TorrentFileInfo) {
 	fmt.Printf("Tracker URL: %s\n", fileInfo.TrackerURL)
 	fmt.Printf("Length: %d\n", infoDict.Length)
 	fmt.Printf("Info Hash: %s\n", fileInfo.GetHexInfoHash())
+	if fileInfo.IsV2() {
+		fmt.Printf("Info Hash v2: %s\n", fileInfo.GetHexInfoHashV2())
+	}
 	fmt.Printf("Piece Length: %d\n", infoDict.PieceLength)
-	fmt.Println("Piece Hashes:")
-	for _, p := range infoDict.Pieces {
-		fmt.Printf("%x\n", p)
+	if infoDict.Private {
+		fmt.Println("Private: true")
+	}
+	if infoDict.Pieces != nil {
+		fmt.Println("Piece Hashes:")
+		for _, p := range infoDict.Pieces {
+			fmt.Printf("%x\n", p)
+		}
+	}
+	if infoDict.Files != nil {
+		fmt.Println("Files:")
+		for _, f := range infoDict.Files {
+			fmt.Printf("%s (%d bytes, pieces root %x)\n", strings.Join(f.Path, "/"), f.Length, f.PiecesRoot)
+		}
 	}
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/dht"
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+)
+
+// startDHT creates a DHT node using the global options and bootstraps it.
+func startDHT() (*dht.DHT, error) {
+	node, err := dht.New(dht.Config{
+		Addr:           options.DHTAddr,
+		BootstrapNodes: options.DHTBootstrapNodes,
+		StateFile:      options.DHTStateFile,
+		ReadOnly:       options.DHTReadOnly,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating DHT node: %w", err)
+	}
+
+	err = node.Bootstrap()
+	if err != nil {
+		node.Close()
+		return nil, fmt.Errorf("error bootstrapping DHT node: %w", err)
+	}
+
+	return node, nil
+}
+
+// getDHTPeers looks up the peers for the info hash in the DHT.
+func getDHTPeers(infoHash []byte) ([]string, error) {
+	node, err := startDHT()
+	if err != nil {
+		return nil, err
+	}
+	defer node.Close()
+
+	addrs, err := node.GetPeers(infoHash)
+	if err != nil {
+		return nil, fmt.Errorf("error getting peers from DHT: %w", err)
+	}
+	return addrs, nil
+}
+
+// resolveMagnet resolves the info hash of magnet links that point to a
+// mutable torrent (BEP 46) using the DHT. Other magnet links are left as is.
+func resolveMagnet(m *types.MagnetURI) error {
+	if !m.IsMutable() {
+		return nil
+	}
+
+	node, err := startDHT()
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+
+	infoHash, seq, err := node.ResolveTorrentUpdate(m.PublicKey, m.Salt)
+	if err != nil {
+		return fmt.Errorf("error resolving torrent update: %w", err)
+	}
+	m.SetInfoHash(infoHash)
+	fmt.Printf("Resolved info hash %s (sequence number %d)\n", m.InfoHashHex, seq)
+	return nil
+}
@@ -2,18 +2,20 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
-	"sync"
 	"time"
 
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
 	"github.com/EshaanAgg/toy-bittorrent/app/types"
 )
 
+// The number of consecutive failures after which a web seed is dropped
+const MAX_WEB_SEED_FAILURES = 3
+
 type pieceToDownload struct {
-	index  uint32
-	length uint32
-	hash   []byte
+	index uint32
 }
 
 type pieceResult struct {
@@ -22,40 +24,135 @@ type pieceResult struct {
 	err   error
 }
 
-// downloadPieces downloads all pieces from peers concurrently. It uses a
-// worker pool to limit the number of concurrent downloads and handles
-// failed downloads by requeuing them for retry.
-func downloadPieces(peers []*types.Peer, fileInfo *types.TorrentFileInfo, outputFile string) {
-	// Create pieces to download
-	var pieces []*pieceToDownload
-	for i := range len(fileInfo.InfoDict.Pieces) {
-		length := getPieceLength(fileInfo, i)
-		pieces = append(pieces, &pieceToDownload{
-			index:  uint32(i),
-			length: length,
-			hash:   fileInfo.InfoDict.Pieces[i],
-		})
+// connectPexPeers connects to the peers discovered by the peer exchange,
+// and sends them to the download once they are ready to send pieces.
+func connectPexPeers(px *types.PeerExchange, fileInfo *types.TorrentFileInfo, newPeers chan<- *types.Peer, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case addr := <-px.Discovered():
+			go func() {
+				peer, err := px.Connect(addr)
+				if err != nil {
+					return
+				}
+				peer.Metadata = fileInfo.InfoBytes
+				err = peer.PrepareToGetPieceData(fileInfo.InfoHash)
+				if err != nil {
+					fmt.Printf("error preparing peer %s from pex: %v\n", addr, err)
+					peer.Close()
+					return
+				}
+
+				select {
+				case newPeers <- peer:
+				case <-done:
+					peer.Close()
+				}
+			}()
+		}
 	}
+}
+
+// getWebSeeds creates the web seeds of the torrent, skipping invalid URLs.
+func getWebSeeds(fileInfo *types.TorrentFileInfo) []*types.WebSeed {
+	webSeeds := make([]*types.WebSeed, 0)
+	for _, u := range fileInfo.WebSeeds {
+		ws, err := types.NewWebSeed(u)
+		if err != nil {
+			fmt.Printf("skipping web seed: %v\n", err)
+			continue
+		}
+		ws.ApplyRateLimits(ratelimit.GLOBAL_LIMITS, rateLimits.torrent)
+		webSeeds = append(webSeeds, ws)
+	}
+	return webSeeds
+}
 
-	// Create a wait group to synchronize the completion of all workers
-	var wg sync.WaitGroup
-	numWorkers := len(peers)
-	pieceQueue := make(chan *pieceToDownload, len(pieces))
-	results := make(chan pieceResult, len(pieces))
+// downloadPieces downloads all pieces from peers and web seeds concurrently.
+// Every peer and web seed gets a worker that takes pieces from a shared queue,
+// and failed downloads are requeued for retry. Web seeds are dropped after
+// MAX_WEB_SEED_FAILURES consecutive failures. If the peer exchange is not
+// nil, the peers that it discovers are connected to and get workers too.
+func downloadPieces(peers []*types.Peer, px *types.PeerExchange, webSeeds []*types.WebSeed, fileInfo *types.TorrentFileInfo, outputFile string) {
+	numPieces := fileInfo.NumPieces()
+
+	// The queue can hold all the pieces, so that requeuing never blocks
+	pieceQueue := make(chan *pieceToDownload, numPieces)
+	results := make(chan pieceResult, numPieces)
+	done := make(chan struct{})
 
 	// Feed the queue with all pieces
-	for _, piece := range pieces {
-		pieceQueue <- piece
+	for i := range numPieces {
+		pieceQueue <- &pieceToDownload{index: uint32(i)}
+	}
+
+	// nextPiece takes the next piece from the queue, or returns nil once all pieces are downloaded
+	nextPiece := func() *pieceToDownload {
+		select {
+		case <-done:
+			return nil
+		case piece := <-pieceQueue:
+			return piece
+		}
+	}
+
+	// nextPieceForPeer takes a piece that the peer can serve right now. Pieces
+	// that the peer doesn't have, or that can't be requested while it chokes
+	// us, are put back into the queue. If there are no such pieces, it waits
+	// for the peer to unchoke us or to announce new pieces.
+	nextPieceForPeer := func(peer *types.Peer) *pieceToDownload {
+		for {
+			piece := nextPiece()
+			if piece == nil || peer.CanDownload(piece.index) {
+				return piece
+			}
+			pieceQueue <- piece
+
+		scan:
+			for range len(pieceQueue) - 1 {
+				select {
+				case piece = <-pieceQueue:
+				default:
+					break scan
+				}
+				if peer.CanDownload(piece.index) {
+					return piece
+				}
+				pieceQueue <- piece
+			}
+
+			err := peer.AwaitPieceUpdate()
+			if err != nil {
+				fmt.Printf("dropping peer %s: %v\n", peer.Addr(), err)
+				return nil
+			}
+		}
 	}
-	close(pieceQueue)
 
-	// Worker function
-	worker := func(peer *types.Peer) {
-		defer wg.Done()
-		for piece := range pieceQueue {
-			sp, err := peer.DownloadPiece(piece.index, piece.length, piece.hash)
+	// Every worker reports when it stops, so that the download can fail
+	// once all of them have given up
+	workerStopped := make(chan struct{})
+
+	// Worker function, a maxFailures of 0 retries indefinitely
+	worker := func(name string, next func() *pieceToDownload, download func(int) ([]byte, error), maxFailures int) {
+		defer func() {
+			select {
+			case workerStopped <- struct{}{}:
+			case <-done:
+			}
+		}()
+		failures := 0
+		for {
+			piece := next()
+			if piece == nil {
+				return
+			}
+
+			data, err := download(int(piece.index))
 			if err != nil {
-				fmt.Printf("error with piece %d: %v, retrying\n", piece.index, err)
+				fmt.Printf("error with piece %d from %s: %v, retrying\n", piece.index, name, err)
 
 				// Requeue the piece
 				go func(p *pieceToDownload) {
@@ -63,26 +160,80 @@ func downloadPieces(peers []*types.Peer, fileInfo *types.TorrentFileInfo, output
 					pieceQueue <- p
 				}(piece)
 
+				// Peers that violate the protocol are not retried, as the connection is closed
+				var protocolErr *types.ProtocolError
+				if errors.As(err, &protocolErr) {
+					fmt.Printf("dropping %s: %v\n", name, protocolErr)
+					return
+				}
+
+				failures++
+				if maxFailures > 0 && failures >= maxFailures {
+					fmt.Printf("dropping %s after %d consecutive failures\n", name, failures)
+					return
+				}
 				continue
 			}
-			results <- pieceResult{index: piece.index, data: sp.GetData()}
+
+			failures = 0
+			results <- pieceResult{index: piece.index, data: data}
 		}
 	}
 
-	// Start workers
-	wg.Add(numWorkers)
+	// Start workers. Peers are closed when their worker stops, so that they
+	// are advertised as dropped with PEX.
+	startPeer := func(peer *types.Peer) {
+		next := func() *pieceToDownload { return nextPieceForPeer(peer) }
+		go func() {
+			defer peer.Close()
+			worker(fmt.Sprintf("peer %s", peer.Addr()), next, func(idx int) ([]byte, error) {
+				sp, err := downloadPiece(peer, fileInfo, idx)
+				if err != nil {
+					return nil, err
+				}
+				return sp.GetData(), nil
+			}, 0)
+		}()
+	}
 	for _, peer := range peers {
-		go worker(peer)
+		startPeer(peer)
+	}
+	for _, ws := range webSeeds {
+		go worker(fmt.Sprintf("web seed %s", ws.URL), nextPiece, func(idx int) ([]byte, error) {
+			return ws.DownloadPiece(fileInfo, idx)
+		}, MAX_WEB_SEED_FAILURES)
 	}
+	workers := len(peers) + len(webSeeds)
 
-	wg.Wait()
-	close(results)
+	// Connect to the peers discovered with PEX while downloading
+	newPeers := make(chan *types.Peer)
+	if px != nil && !fileInfo.InfoDict.Private {
+		go connectPexPeers(px, fileInfo, newPeers, done)
+	}
+
+	// Collect results until all the pieces are downloaded,
+	// or all the workers have given up
+	filePieces := make([][]byte, numPieces)
+	collected := 0
+	for collected < numPieces {
+		select {
+		case res := <-results:
+			filePieces[res.index] = res.data
+			collected++
+		case peer := <-newPeers:
+			startPeer(peer)
+			workers++
+		case <-workerStopped:
+			workers--
+		}
 
-	// Collect results
-	filePieces := make([][]byte, len(pieces))
-	for res := range results {
-		filePieces[res.index] = res.data
+		if workers == 0 && len(results) == 0 && collected < numPieces {
+			close(done)
+			fmt.Printf("error downloading file: %d of %d pieces missing\n", numPieces-collected, numPieces)
+			return
+		}
 	}
+	close(done)
 
 	// Combine all pieces into a single byte slice
 	// and write to the output file
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+)
+
+const TEST_PIECE_LENGTH = 16 * 1024
+
+// newTestWebSeedTorrent creates a single-file torrent in a temporary
+// directory, and returns the directory, the content and the torrent.
+func newTestWebSeedTorrent(t *testing.T) (string, []byte, *types.TorrentFileInfo) {
+	t.Helper()
+
+	root := t.TempDir()
+	content := make([]byte, 4*TEST_PIECE_LENGTH+100)
+	rand.New(rand.NewSource(1)).Read(content)
+	err := os.WriteFile(filepath.Join(root, "file.bin"), content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := types.CreateTorrent(types.TorrentCreateOptions{
+		Path:        filepath.Join(root, "file.bin"),
+		PieceLength: TEST_PIECE_LENGTH,
+	})
+	if err != nil {
+		t.Fatalf("error creating torrent: %v", err)
+	}
+	torrentPath := filepath.Join(root, "file.torrent")
+	err = os.WriteFile(torrentPath, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := types.NewTorrentFileInfo(torrentPath)
+	if err != nil {
+		t.Fatalf("error reading torrent: %v", err)
+	}
+	return root, content, fileInfo
+}
+
+func newTestWebSeed(t *testing.T, rawURL string) *types.WebSeed {
+	t.Helper()
+
+	ws, err := types.NewWebSeed(rawURL)
+	if err != nil {
+		t.Fatalf("error creating web seed: %v", err)
+	}
+	return ws
+}
+
+func TestDownloadPiecesRetriesWebSeed(t *testing.T) {
+	root, content, fileInfo := newTestWebSeedTorrent(t)
+
+	// The first requests fail, but fewer than MAX_WEB_SEED_FAILURES in a row
+	var requests atomic.Int32
+	files := http.FileServer(http.Dir(root))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= MAX_WEB_SEED_FAILURES-1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	outputFile := filepath.Join(t.TempDir(), "output.bin")
+	ws := newTestWebSeed(t, server.URL+"/file.bin")
+	downloadPieces(nil, nil, []*types.WebSeed{ws}, fileInfo, outputFile)
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("error reading the downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("the downloaded file does not match the content")
+	}
+	if n := requests.Load(); n != int32(fileInfo.NumPieces()+MAX_WEB_SEED_FAILURES-1) {
+		t.Errorf("expected %d requests, got %d", fileInfo.NumPieces()+MAX_WEB_SEED_FAILURES-1, n)
+	}
+}
+
+func TestDownloadPiecesDropsFailingWebSeed(t *testing.T) {
+	_, _, fileInfo := newTestWebSeedTorrent(t)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// The download gives up once the only web seed is dropped
+	outputFile := filepath.Join(t.TempDir(), "output.bin")
+	ws := newTestWebSeed(t, server.URL+"/file.bin")
+	downloadPieces(nil, nil, []*types.WebSeed{ws}, fileInfo, outputFile)
+
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("expected no output file, got %v", err)
+	}
+	if n := requests.Load(); n != MAX_WEB_SEED_FAILURES {
+		t.Errorf("expected %d requests before the web seed is dropped, got %d", MAX_WEB_SEED_FAILURES, n)
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/lsd"
+)
+
+// The time to wait for the peers on the local network to announce themselves.
+const LSD_LOOKUP_WAIT = 3 * time.Second
+
+// getLSDPeers announces the info hash on the local network, and returns
+// the peers that announced the same info hash.
+func getLSDPeers(infoHash []byte) ([]string, error) {
+	config := lsd.Config{
+		Port:     options.Port,
+		Loopback: options.LSDLoopback,
+	}
+	if options.LSDInterface != "" {
+		ifi, err := net.InterfaceByName(options.LSDInterface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %s: %w", options.LSDInterface, err)
+		}
+		config.Interface = ifi
+	}
+
+	l, err := lsd.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("error starting LSD: %w", err)
+	}
+	defer l.Close()
+
+	addrs, err := l.Lookup(infoHash, LSD_LOOKUP_WAIT)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up peers: %w", err)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
+)
+
+// rateLimits holds the limits set by the flags of the download and seed commands.
+// The torrent limits are shared by all the peers of the torrent, and every
+// peer starts with the per-peer rates.
+var rateLimits = struct {
+	torrent                  *ratelimit.Limits
+	peerDownload, peerUpload int64
+}{}
+
+// rateLimitFlags are the rate limit flags of the download and seed commands,
+// in bytes per second with an optional K, M or G suffix.
+type rateLimitFlags struct {
+	globalDownload, globalUpload string
+	download, upload             string
+	peerDownload, peerUpload     string
+}
+
+func addRateLimitFlags(fs *flag.FlagSet) *rateLimitFlags {
+	f := &rateLimitFlags{}
+	fs.StringVar(&f.globalDownload, "global-download-limit", "0", "download limit of the client, 0 for unlimited")
+	fs.StringVar(&f.globalUpload, "global-upload-limit", "0", "upload limit of the client, 0 for unlimited")
+	fs.StringVar(&f.download, "download-limit", "0", "download limit of the torrent, 0 for unlimited")
+	fs.StringVar(&f.upload, "upload-limit", "0", "upload limit of the torrent, 0 for unlimited")
+	fs.StringVar(&f.peerDownload, "peer-download-limit", "0", "download limit of every peer, 0 for unlimited")
+	fs.StringVar(&f.peerUpload, "peer-upload-limit", "0", "upload limit of every peer, 0 for unlimited")
+	return f
+}
+
+// apply parses the flags, and sets the global limits and the
+// limits used for the peers of the torrent.
+func (f *rateLimitFlags) apply() error {
+	rates := make([]int64, 6)
+	for i, s := range []string{f.globalDownload, f.globalUpload, f.download, f.upload, f.peerDownload, f.peerUpload} {
+		rate, err := ratelimit.ParseRate(s)
+		if err != nil {
+			return err
+		}
+		rates[i] = rate
+	}
+
+	ratelimit.GLOBAL_LIMITS.Download.SetRate(rates[0])
+	ratelimit.GLOBAL_LIMITS.Upload.SetRate(rates[1])
+	rateLimits.torrent = ratelimit.NewLimits(rates[2], rates[3])
+	rateLimits.peerDownload = rates[4]
+	rateLimits.peerUpload = rates[5]
+	return nil
+}
+
+// parseDownloadArgs parses the arguments of the download commands, which are
+// the rate limit flags, the output file and one positional argument.
+func parseDownloadArgs(name string, args []string) (string, string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("o", "", "file to save the download to")
+	limits := addRateLimitFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return "", "", err
+	}
+	if *output == "" || fs.NArg() != 1 {
+		return "", "", fmt.Errorf("expected an output file and one argument")
+	}
+	if err := limits.apply(); err != nil {
+		return "", "", err
+	}
+	return *output, fs.Arg(0), nil
+}
+
+// parseSeedArgs parses the arguments of the seed command, which are the rate
+// limit flags, the torrent file and the file with the data of the torrent.
+func parseSeedArgs(args []string) (string, string, error) {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	limits := addRateLimitFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return "", "", err
+	}
+	if fs.NArg() != 2 {
+		return "", "", fmt.Errorf("expected a torrent file and a file")
+	}
+	if err := limits.apply(); err != nil {
+		return "", "", err
+	}
+	return fs.Arg(0), fs.Arg(1), nil
+}
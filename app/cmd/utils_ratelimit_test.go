@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDownloadArgs(t *testing.T) {
+	output, arg, err := parseDownloadArgs("download", []string{"-upload-limit", "1M", "-o", "out.bin", "file.torrent"})
+	if err != nil {
+		t.Fatalf("error parsing arguments: %v", err)
+	}
+	if output != "out.bin" || arg != "file.torrent" {
+		t.Errorf("expected out.bin and file.torrent, got %s and %s", output, arg)
+	}
+	if rateLimits.torrent.Upload.Rate() != 1024*1024 {
+		t.Errorf("expected an upload limit of 1M, got %d", rateLimits.torrent.Upload.Rate())
+	}
+
+	tests := []struct {
+		args    []string
+		errText string
+	}{
+		{[]string{"file.torrent"}, "expected an output file and one argument"},
+		{[]string{"-o", "out.bin"}, "expected an output file and one argument"},
+		{[]string{"-download-limit", "fast", "-o", "out.bin", "file.torrent"}, "fast"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseDownloadArgs("download", tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("expected an error containing %q for %q, got %v", tt.errText, tt.args, err)
+		}
+	}
+}
+
+func TestParseSeedArgs(t *testing.T) {
+	torrentFile, contentFile, err := parseSeedArgs([]string{"-peer-upload-limit", "64K", "file.torrent", "file.bin"})
+	if err != nil {
+		t.Fatalf("error parsing arguments: %v", err)
+	}
+	if torrentFile != "file.torrent" || contentFile != "file.bin" {
+		t.Errorf("expected file.torrent and file.bin, got %s and %s", torrentFile, contentFile)
+	}
+	if rateLimits.peerUpload != 64*1024 {
+		t.Errorf("expected a peer upload limit of 64K, got %d", rateLimits.peerUpload)
+	}
+
+	_, _, err = parseSeedArgs([]string{"file.torrent"})
+	if err == nil {
+		t.Error("expected an error without the file")
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/types"
+	"github.com/EshaanAgg/toy-bittorrent/app/utp"
+)
+
+// The transports that can be selected with the "transport" option.
+const TRANSPORT_TCP = "tcp"
+const TRANSPORT_UTP = "utp"
+const TRANSPORT_BOTH = "both"
+
+// All the uTP connections share a single socket, which stays
+// open for the lifetime of the process.
+var utpSocket *utp.Socket
+var utpSocketMu sync.Mutex
+
+func getUTPSocket() (*utp.Socket, error) {
+	utpSocketMu.Lock()
+	defer utpSocketMu.Unlock()
+
+	if utpSocket != nil {
+		return utpSocket, nil
+	}
+	s, err := utp.Listen(options.UTPAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error creating uTP socket: %w", err)
+	}
+	utpSocket = s
+	return s, nil
+}
+
+// peerDialer returns the dialer for the transport selected in the options.
+func peerDialer() (types.Dialer, error) {
+	if options.Transport == TRANSPORT_TCP {
+		return types.DEFAULT_DIALER, nil
+	}
+
+	s, err := getUTPSocket()
+	if err != nil {
+		return nil, err
+	}
+	if options.Transport == TRANSPORT_UTP {
+		return s, nil
+	}
+	return types.FallbackDialer{s, types.DEFAULT_DIALER}, nil
+}
@@ -0,0 +1,47 @@
+package dht
+
+import "time"
+
+const K_BUCKET_SIZE = 8 // Maximum number of nodes in a single bucket
+const LOOKUP_ALPHA = 3  // Number of concurrent queries during an iterative lookup
+
+const QUERY_TIMEOUT = 2 * time.Second
+const NODE_QUESTIONABLE_AFTER = 15 * time.Minute
+const MAX_FAILED_QUERIES = 2
+const TOKEN_ROTATION_INTERVAL = 5 * time.Minute
+const PEER_ANNOUNCE_TTL = 30 * time.Minute
+const ITEM_TTL = 2 * time.Hour
+
+// The maximum number of announced peers, across all the info hashes, and of
+// items that we store for other nodes, so that they can't exhaust our memory.
+const MAX_STORED_PEERS = 10000
+const MAX_STORED_ITEMS = 1000
+
+// The delay before reading again after a read error, which is doubled
+// for every consecutive error.
+const READ_ERROR_BACKOFF = 100 * time.Millisecond
+const MAX_READ_ERROR_BACKOFF = 5 * time.Second
+
+// The maximum size of a KRPC packet that we are willing to read.
+const MAX_PACKET_SIZE = 64 * 1024
+
+// KRPC error codes, as defined in BEP 5.
+const ERROR_GENERIC = 201
+const ERROR_SERVER = 202
+const ERROR_PROTOCOL = 203
+const ERROR_METHOD_UNKNOWN = 204
+
+// KRPC error codes for storing items, as defined in BEP 44.
+const ERROR_MESSAGE_TOO_BIG = 205
+const ERROR_INVALID_SIGNATURE = 206
+const ERROR_SALT_TOO_BIG = 207
+const ERROR_CAS_MISMATCH = 301
+const ERROR_SEQ_TOO_LOW = 302
+
+// DEFAULT_BOOTSTRAP_NODES are the well known routers that are used
+// to join the mainline DHT when no other nodes are configured.
+var DEFAULT_BOOTSTRAP_NODES = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
@@ -0,0 +1,453 @@
+package dht
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+var ErrClosed = errors.New("dht node is closed")
+var ErrQueryTimeout = errors.New("query timed out")
+
+var errBadToken = errors.New("invalid token")
+var errBadPort = errors.New("invalid port")
+
+// Config holds the options used to create a new DHT node.
+type Config struct {
+	Addr           string        // The UDP address to listen on, defaults to ":0"
+	NodeID         NodeID        // The ID of our node, a random ID is used if left empty
+	BootstrapNodes []string      // Nodes used to join the network, defaults to DEFAULT_BOOTSTRAP_NODES
+	QueryTimeout   time.Duration // Defaults to QUERY_TIMEOUT
+
+	// If set, the node ID and the routing table are restored from this
+	// file when the node is created, and saved to it when it is closed.
+	StateFile string
+
+	// ReadOnly nodes (BEP 43) set "ro" in their queries and never answer
+	// the queries of other nodes, so they are not added to routing tables.
+	ReadOnly bool
+
+	// ExternalIP is used to derive a node ID that satisfies the BEP 42
+	// restrictions. If unset, the IP learned in a previous run is used.
+	ExternalIP net.IP
+
+	// By default, nodes whose ID does not satisfy BEP 42 for their IP are
+	// not added to the routing tables. This disables the check.
+	AllowInsecureNodeIDs bool
+}
+
+type pendingQuery struct {
+	addr     *net.UDPAddr
+	response chan *message
+}
+
+// DHT is a node in the mainline DHT (BEP 5). It answers the queries of
+// other nodes, and can be used to find the peers for an info hash
+// without contacting a tracker.
+type DHT struct {
+	config Config
+	id     NodeID
+	conn   *net.UDPConn
+	logger *log.Logger
+
+	// IPv4 and IPv6 nodes are kept in separate routing tables (BEP 32)
+	table4 *RoutingTable
+	table6 *RoutingTable
+
+	tokens     *tokenManager
+	peers      *peerStore
+	items      *itemStore
+	externalIP *ipVoter
+
+	mu            sync.Mutex
+	pending       map[string]*pendingQuery
+	transactionID uint16
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a DHT node and starts listening for packets on the configured address.
+// The node is not part of the network until Bootstrap is called.
+func New(config Config) (*DHT, error) {
+	if config.Addr == "" {
+		config.Addr = ":0"
+	}
+
+	var state *State
+	if config.StateFile != "" {
+		var err error
+		state, err = LoadState(config.StateFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading DHT state: %w", err)
+		}
+	}
+	if config.ExternalIP == nil && state != nil {
+		config.ExternalIP = state.ExternalIP
+	}
+
+	// Reuse the saved ID unless it is not valid for our external IP anymore
+	if config.NodeID.IsZero() && state != nil {
+		if config.ExternalIP == nil || IsNodeIDSecure(state.NodeID, config.ExternalIP) {
+			config.NodeID = state.NodeID
+		}
+	}
+	if config.NodeID.IsZero() && config.ExternalIP != nil {
+		config.NodeID = NodeIDForIP(config.ExternalIP)
+	}
+	if config.NodeID.IsZero() {
+		config.NodeID = RandomNodeID()
+	}
+	if config.BootstrapNodes == nil {
+		config.BootstrapNodes = DEFAULT_BOOTSTRAP_NODES
+	}
+	if config.QueryTimeout == 0 {
+		config.QueryTimeout = QUERY_TIMEOUT
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %w", config.Addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", config.Addr, err)
+	}
+
+	d := &DHT{
+		config: config,
+		id:     config.NodeID,
+		conn:   conn,
+		logger: log.New(log.Writer(), "[DHT] ", 0),
+
+		table4: NewRoutingTable(config.NodeID),
+		table6: NewRoutingTable(config.NodeID),
+
+		tokens:     newTokenManager(),
+		peers:      newPeerStore(),
+		items:      newItemStore(),
+		externalIP: newIPVoter(),
+
+		pending: make(map[string]*pendingQuery),
+		closed:  make(chan struct{}),
+	}
+
+	if state != nil {
+		d.restoreState(state)
+	}
+
+	go d.readLoop()
+	return d, nil
+}
+
+// ID returns the node ID of our node.
+func (d *DHT) ID() NodeID {
+	return d.id
+}
+
+// Addr returns the local UDP address that the node is listening on.
+func (d *DHT) Addr() *net.UDPAddr {
+	return d.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// RoutingTable returns the routing table holding the IPv4 nodes.
+func (d *DHT) RoutingTable() *RoutingTable {
+	return d.table4
+}
+
+// RoutingTable6 returns the routing table holding the IPv6 nodes.
+func (d *DHT) RoutingTable6() *RoutingTable {
+	return d.table6
+}
+
+// ExternalIP returns our external IP as reported by the other nodes,
+// or the configured one if not enough nodes have reported it yet.
+func (d *DHT) ExternalIP() net.IP {
+	if ip := d.externalIP.Get(); ip != nil {
+		return ip
+	}
+	return d.config.ExternalIP
+}
+
+func (d *DHT) tableFor(ipv6 bool) *RoutingTable {
+	if ipv6 {
+		return d.table6
+	}
+	return d.table4
+}
+
+// supportsFamily reports whether the socket can be used to reach
+// nodes of the given address family. Sockets bound to the unspecified
+// address are dual-stack, and support both.
+func (d *DHT) supportsFamily(ipv6 bool) bool {
+	local := d.Addr().IP
+	if local == nil || local.IsUnspecified() {
+		return true
+	}
+	return (local.To4() == nil) == ipv6
+}
+
+// wantList returns the "want" argument for our find_node and get_peers
+// queries, which asks for nodes of all the families that we support.
+func (d *DHT) wantList() *bencode.BencodeData {
+	want := make([]*bencode.BencodeData, 0, 2)
+	if d.supportsFamily(false) {
+		want = append(want, bencode.NewDataString(WANT_IPV4))
+	}
+	if d.supportsFamily(true) {
+		want = append(want, bencode.NewDataString(WANT_IPV6))
+	}
+	return bencode.NewDataList(want)
+}
+
+// insertNode adds the node to the routing table of its address family,
+// unless its ID does not satisfy the BEP 42 restrictions.
+func (d *DHT) insertNode(n *Node) bool {
+	if !d.config.AllowInsecureNodeIDs && !IsNodeIDSecure(n.ID, n.Addr.IP) {
+		return false
+	}
+	return d.tableFor(isIPv6(n.Addr)).Insert(n)
+}
+
+// Close stops the node and closes the underlying socket.
+// If a state file is configured, the state of the node is saved to it.
+func (d *DHT) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		err = d.conn.Close()
+
+		if d.config.StateFile != "" {
+			if saveErr := d.SaveState(d.config.StateFile); saveErr != nil && err == nil {
+				err = saveErr
+			}
+		}
+	})
+	return err
+}
+
+// AddNode pings the node at the address and adds it to the routing table if it responds.
+func (d *DHT) AddNode(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("error resolving node address %s: %w", addr, err)
+	}
+
+	_, err = d.Ping(udpAddr)
+	return err
+}
+
+// Bootstrap joins the network and fills up the routing tables. The nodes
+// restored from a previous run are tried first, and the bootstrap nodes
+// are only contacted if not enough of them are still alive. Finally, a
+// lookup for our own ID is performed to populate the nearby buckets.
+//
+// The bootstrap nodes themselves are often not added to the routing tables
+// as their IDs do not satisfy BEP 42, so the nodes that they return are
+// used to seed the lookup instead.
+func (d *DHT) Bootstrap() error {
+	restored := make([]string, 0)
+	for _, n := range append(d.table4.Nodes(), d.table6.Nodes()...) {
+		restored = append(restored, n.Addr.String())
+	}
+	seeds := d.findNodeFrom(restored)
+
+	if d.table4.GoodLen()+d.table6.GoodLen() < K_BUCKET_SIZE {
+		seeds = append(seeds, d.findNodeFrom(d.config.BootstrapNodes)...)
+	}
+
+	for _, ipv6 := range []bool{false, true} {
+		if d.supportsFamily(ipv6) {
+			d.lookup(d.id, QUERY_FIND_NODE, ipv6, seeds...)
+		}
+	}
+
+	if d.table4.Len()+d.table6.Len() == 0 {
+		return fmt.Errorf("no bootstrap node responded")
+	}
+	d.Log("bootstrapped with %d IPv4 and %d IPv6 nodes in the routing tables", d.table4.Len(), d.table6.Len())
+	return nil
+}
+
+// findNodeFrom concurrently sends a find_node query for our own ID to all
+// the addresses, and returns the nodes that they know about. The nodes that
+// respond are added to the routing tables by query.
+func (d *DHT) findNodeFrom(addrs []string) []*Node {
+	var mu sync.Mutex
+	found := make([]*Node, 0)
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			udpAddr, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				d.Log("error resolving node %s: %v", addr, err)
+				return
+			}
+			nodes, err := d.findNode(udpAddr, d.id)
+			if err != nil {
+				d.Log("error querying node %s: %v", addr, err)
+				return
+			}
+
+			mu.Lock()
+			found = append(found, nodes...)
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+
+	return found
+}
+
+func (d *DHT) Log(s string, vals ...any) {
+	d.logger.Printf(s+"\n", vals...)
+}
+
+func (d *DHT) newTransactionID() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.transactionID++
+	return binary.BigEndian.AppendUint16(nil, d.transactionID)
+}
+
+func (d *DHT) send(addr *net.UDPAddr, m *message) error {
+	_, err := d.conn.WriteToUDP(m.Encode(), addr)
+	if err != nil {
+		return fmt.Errorf("error sending packet to %s: %w", addr, err)
+	}
+	return nil
+}
+
+// query sends a query to the node at addr and waits for its response.
+// Nodes that respond are added to the routing table, and nodes that
+// time out are marked as failed.
+func (d *DHT) query(addr *net.UDPAddr, query string, args *bencode.BencodeDictionary) (*message, error) {
+	args.Add("id", bencode.NewDataString(string(d.id[:])))
+
+	if !d.supportsFamily(isIPv6(addr)) {
+		return nil, fmt.Errorf("cannot reach %s from a socket bound to %s", addr, d.Addr())
+	}
+
+	tid := d.newTransactionID()
+	pq := &pendingQuery{addr: addr, response: make(chan *message, 1)}
+
+	d.mu.Lock()
+	d.pending[string(tid)] = pq
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, string(tid))
+		d.mu.Unlock()
+	}()
+
+	q := newQuery(tid, query, args)
+	q.ReadOnly = d.config.ReadOnly
+	if err := d.send(addr, q); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(d.config.QueryTimeout)
+	defer timer.Stop()
+
+	select {
+	case m := <-pq.response:
+		if m.Type == "e" {
+			return nil, fmt.Errorf("node %s returned error %d: %s", addr, m.ErrorCode, m.ErrorMessage)
+		}
+		id, err := m.senderID()
+		if err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %w", addr, err)
+		}
+		if ip := decodeCompactPeer(m.IP); ip != nil {
+			d.externalIP.Add(addr.IP, ip.IP)
+		}
+		d.insertNode(NewNode(id, addr))
+		return m, nil
+
+	case <-timer.C:
+		d.markFailed(addr)
+		return nil, fmt.Errorf("%s query to %s: %w", query, addr, ErrQueryTimeout)
+
+	case <-d.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (d *DHT) markFailed(addr *net.UDPAddr) {
+	table := d.tableFor(isIPv6(addr))
+	for _, n := range table.Nodes() {
+		if n.Addr.IP.Equal(addr.IP) && n.Addr.Port == addr.Port {
+			table.MarkFailed(n.ID)
+		}
+	}
+}
+
+// readLoop reads the packets until the node is closed. After a read error,
+// it backs off before retrying, so that a persistent error doesn't spin.
+func (d *DHT) readLoop() {
+	buf := make([]byte, MAX_PACKET_SIZE)
+	backoff := READ_ERROR_BACKOFF
+	for {
+		n, addr, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			d.Log("error reading packet: %v, retrying in %v", err, backoff)
+
+			select {
+			case <-d.closed:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, MAX_READ_ERROR_BACKOFF)
+			continue
+		}
+		backoff = READ_ERROR_BACKOFF
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		d.handlePacket(packet, addr)
+	}
+}
+
+func (d *DHT) handlePacket(packet []byte, addr *net.UDPAddr) {
+	m, err := decodeMessage(packet)
+	if err != nil {
+		d.Log("dropping invalid packet from %s: %v", addr, err)
+		return
+	}
+
+	switch m.Type {
+	case "q":
+		// Read-only nodes never respond to queries (BEP 43)
+		if d.config.ReadOnly {
+			return
+		}
+		d.handleQuery(m, addr)
+
+	case "r", "e":
+		d.mu.Lock()
+		pq, ok := d.pending[string(m.TransactionID)]
+		d.mu.Unlock()
+
+		if !ok || !pq.addr.IP.Equal(addr.IP) || pq.addr.Port != addr.Port {
+			return
+		}
+		select {
+		case pq.response <- m:
+		default:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package dht
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+const TEST_QUERY_TIMEOUT = 500 * time.Millisecond
+
+// newTestNode starts a node on the loopback interface, which bootstraps
+// from the passed nodes instead of the public routers.
+func newTestNode(t *testing.T, bootstrap ...*DHT) *DHT {
+	t.Helper()
+
+	addrs := make([]string, 0, len(bootstrap))
+	for _, b := range bootstrap {
+		addrs = append(addrs, b.Addr().String())
+	}
+	d, err := New(Config{
+		Addr:           "127.0.0.1:0",
+		BootstrapNodes: addrs,
+		QueryTimeout:   TEST_QUERY_TIMEOUT,
+	})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// newTestNetwork starts n nodes on the loopback interface, which all join
+// the network through the first node.
+func newTestNetwork(t *testing.T, n int) []*DHT {
+	t.Helper()
+
+	nodes := []*DHT{newTestNode(t)}
+	for range n - 1 {
+		d := newTestNode(t, nodes[0])
+		err := d.Bootstrap()
+		if err != nil {
+			t.Fatalf("error bootstrapping node: %v", err)
+		}
+		nodes = append(nodes, d)
+	}
+	return nodes
+}
+
+func TestPing(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+
+	id, err := a.Ping(b.Addr())
+	if err != nil {
+		t.Fatalf("error pinging node: %v", err)
+	}
+	if id != b.ID() {
+		t.Errorf("expected ID %s, got %s", b.ID(), id)
+	}
+
+	// Both nodes learn about each other from the query
+	if a.RoutingTable().Len() != 1 {
+		t.Errorf("expected the responding node in the routing table, got %d nodes", a.RoutingTable().Len())
+	}
+	if b.RoutingTable().Len() != 1 {
+		t.Errorf("expected the querying node in the routing table, got %d nodes", b.RoutingTable().Len())
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	a := newTestNode(t)
+
+	// Nothing is listening on the address of a closed node
+	b := newTestNode(t)
+	addr := b.Addr()
+	b.Close()
+
+	_, err := a.Ping(addr)
+	if err == nil {
+		t.Fatal("expected an error pinging a closed node")
+	}
+}
+
+func TestFindNode(t *testing.T) {
+	nodes := newTestNetwork(t, 8)
+	target := nodes[3]
+
+	found, err := nodes[7].FindNode(target.ID())
+	if err != nil {
+		t.Fatalf("error finding node: %v", err)
+	}
+	if len(found) == 0 {
+		t.Fatal("expected the lookup to find nodes")
+	}
+	if found[0].ID != target.ID() || found[0].Addr.Port != target.Addr().Port {
+		t.Errorf("expected the target %s as the closest node, got %s", target.ID(), found[0])
+	}
+}
+
+func TestGetPeersAndAnnounce(t *testing.T) {
+	nodes := newTestNetwork(t, 6)
+	infoHash := RandomNodeID()
+
+	peers, err := nodes[1].GetPeers(infoHash[:])
+	if err != nil {
+		t.Fatalf("error getting peers: %v", err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("expected no peers before the announcement, got %v", peers)
+	}
+
+	_, err = nodes[2].Announce(infoHash[:], 6881)
+	if err != nil {
+		t.Fatalf("error announcing: %v", err)
+	}
+
+	peers, err = nodes[5].GetPeers(infoHash[:])
+	if err != nil {
+		t.Fatalf("error getting peers: %v", err)
+	}
+	if !slices.Contains(peers, "127.0.0.1:6881") {
+		t.Errorf("expected the announced peer, got %v", peers)
+	}
+}
+
+func TestAnnouncePeerRequiresToken(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	infoHash := RandomNodeID()
+
+	err := a.announcePeer(b.Addr(), infoHash, 6881, []byte("invalid"))
+	if err == nil {
+		t.Fatal("expected an announcement with an invalid token to be rejected")
+	}
+
+	r, err := a.getPeers(b.Addr(), infoHash)
+	if err != nil {
+		t.Fatalf("error getting peers: %v", err)
+	}
+	err = a.announcePeer(b.Addr(), infoHash, 6881, r.token)
+	if err != nil {
+		t.Fatalf("error announcing with a valid token: %v", err)
+	}
+
+	got := b.peers.Get(infoHash, false)
+	want := encodeCompactPeer(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881})
+	if len(got) != 1 || string(got[0]) != string(want) {
+		t.Errorf("expected the announced peer to be stored, got %x", got)
+	}
+}
@@ -0,0 +1,203 @@
+package dht
+
+import (
+	"errors"
+	"net"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// handleQuery answers a query received from another node.
+func (d *DHT) handleQuery(m *message, addr *net.UDPAddr) {
+	senderID, err := m.senderID()
+	if err != nil {
+		d.send(addr, newError(m.TransactionID, ERROR_PROTOCOL, "invalid id"))
+		return
+	}
+
+	var response *bencode.BencodeDictionary
+	switch m.Query {
+	case QUERY_PING:
+		response = d.handlePing()
+	case QUERY_FIND_NODE:
+		response, err = d.handleFindNode(m.Args, addr)
+	case QUERY_GET_PEERS:
+		response, err = d.handleGetPeers(m.Args, addr)
+	case QUERY_ANNOUNCE_PEER:
+		response, err = d.handleAnnouncePeer(m.Args, addr)
+	case QUERY_GET:
+		response, err = d.handleGet(m.Args, addr)
+	case QUERY_PUT:
+		response, err = d.handlePut(m.Args, addr)
+	default:
+		d.send(addr, newError(m.TransactionID, ERROR_METHOD_UNKNOWN, "method unknown"))
+		return
+	}
+
+	if err != nil {
+		var ke *krpcError
+		if errors.As(err, &ke) {
+			d.send(addr, newError(m.TransactionID, ke.Code, ke.Message))
+		} else {
+			d.send(addr, newError(m.TransactionID, ERROR_PROTOCOL, err.Error()))
+		}
+		return
+	}
+
+	// Nodes that query us are alive, so they can be added to the routing
+	// table, except read-only nodes as they won't answer our queries.
+	if !m.ReadOnly {
+		d.insertNode(NewNode(senderID, addr))
+	}
+
+	// Let the node know its external address (BEP 42)
+	r := newResponse(m.TransactionID, response)
+	r.IP = encodeCompactPeer(addr)
+	d.send(addr, r)
+}
+
+func (d *DHT) newResponseBody() *bencode.BencodeDictionary {
+	r := bencode.NewBencodeDictionary()
+	r.Add("id", bencode.NewDataString(string(d.id[:])))
+	return r
+}
+
+func (d *DHT) handlePing() *bencode.BencodeDictionary {
+	return d.newResponseBody()
+}
+
+// addClosestNodes adds the "nodes" and "nodes6" keys to the response body,
+// depending on the families requested by the querying node (BEP 32).
+func (d *DHT) addClosestNodes(r, args *bencode.BencodeDictionary, target NodeID, addr *net.UDPAddr) {
+	ipv4, ipv6 := wantedFamilies(args, isIPv6(addr))
+	if ipv4 {
+		closest := d.table4.Closest(target, K_BUCKET_SIZE)
+		r.Add("nodes", bencode.NewDataString(string(encodeCompactNodes(closest, false))))
+	}
+	if ipv6 {
+		closest := d.table6.Closest(target, K_BUCKET_SIZE)
+		r.Add("nodes6", bencode.NewDataString(string(encodeCompactNodes(closest, true))))
+	}
+}
+
+func (d *DHT) handleFindNode(args *bencode.BencodeDictionary, addr *net.UDPAddr) (*bencode.BencodeDictionary, error) {
+	target, err := dictNodeID(args, "target")
+	if err != nil {
+		return nil, err
+	}
+
+	r := d.newResponseBody()
+	d.addClosestNodes(r, args, target, addr)
+	return r, nil
+}
+
+func (d *DHT) handleGetPeers(args *bencode.BencodeDictionary, addr *net.UDPAddr) (*bencode.BencodeDictionary, error) {
+	infoHash, err := dictNodeID(args, "info_hash")
+	if err != nil {
+		return nil, err
+	}
+
+	r := d.newResponseBody()
+	r.Add("token", bencode.NewDataString(string(d.tokens.Token(addr.IP))))
+
+	// Return the peers if we know any, otherwise the closest nodes to the info hash.
+	// Only the peers of the address family of the querying node are returned.
+	if peers := d.peers.Get(infoHash, isIPv6(addr)); len(peers) > 0 {
+		values := make([]*bencode.BencodeData, 0, len(peers))
+		for _, p := range peers {
+			values = append(values, bencode.NewDataString(string(p)))
+		}
+		r.Add("values", bencode.NewDataList(values))
+	} else {
+		d.addClosestNodes(r, args, infoHash, addr)
+	}
+
+	return r, nil
+}
+
+func (d *DHT) handleAnnouncePeer(args *bencode.BencodeDictionary, addr *net.UDPAddr) (*bencode.BencodeDictionary, error) {
+	infoHash, err := dictNodeID(args, "info_hash")
+	if err != nil {
+		return nil, err
+	}
+	token, err := dictBytes(args, "token")
+	if err != nil {
+		return nil, err
+	}
+	if !d.tokens.Validate(token, addr.IP) {
+		return nil, errBadToken
+	}
+
+	// If implied_port is set, the source port of the packet is used
+	// as the peer port as the peer is probably behind a NAT.
+	port := addr.Port
+	if impliedPort, err := dictInteger(args, "implied_port"); err != nil || impliedPort == 0 {
+		port, err = dictInteger(args, "port")
+		if err != nil {
+			return nil, err
+		}
+		if port <= 0 || port > 65535 {
+			return nil, errBadPort
+		}
+	}
+
+	if !d.peers.Add(infoHash, &net.UDPAddr{IP: addr.IP, Port: port}) {
+		return nil, newKRPCError(ERROR_SERVER, "peer storage is full")
+	}
+	return d.newResponseBody(), nil
+}
+
+func (d *DHT) handleGet(args *bencode.BencodeDictionary, addr *net.UDPAddr) (*bencode.BencodeDictionary, error) {
+	target, err := dictNodeID(args, "target")
+	if err != nil {
+		return nil, err
+	}
+
+	r := d.newResponseBody()
+	r.Add("token", bencode.NewDataString(string(d.tokens.Token(addr.IP))))
+	d.addClosestNodes(r, args, target, addr)
+
+	item := d.items.Get(target)
+	if item == nil {
+		return r, nil
+	}
+	if !item.IsMutable() {
+		r.Add("v", item.Value)
+		return r, nil
+	}
+
+	// For mutable items, the value is only returned if it is newer than
+	// the one the querying node already has.
+	r.Add("seq", bencode.NewDataInteger(item.Seq))
+	if seq, err := dictInteger(args, "seq"); err == nil && item.Seq <= seq {
+		return r, nil
+	}
+	r.Add("k", bencode.NewDataString(string(item.PublicKey)))
+	r.Add("sig", bencode.NewDataString(string(item.Signature)))
+	r.Add("v", item.Value)
+	return r, nil
+}
+
+func (d *DHT) handlePut(args *bencode.BencodeDictionary, addr *net.UDPAddr) (*bencode.BencodeDictionary, error) {
+	token, err := dictBytes(args, "token")
+	if err != nil {
+		return nil, err
+	}
+	if !d.tokens.Validate(token, addr.IP) {
+		return nil, errBadToken
+	}
+
+	item, err := itemFromDictionary(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := item.Verify(item.Target()); err != nil {
+		return nil, err
+	}
+
+	cas, err := dictInteger(args, "cas")
+	if err := d.items.Put(item, cas, err == nil); err != nil {
+		return nil, err
+	}
+	return d.newResponseBody(), nil
+}
@@ -0,0 +1,115 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+const MAX_ITEM_VALUE_SIZE = 1000 // Maximum size of the bencoded value of an item
+const MAX_ITEM_SALT_SIZE = 64
+
+// Item is a value stored in the DHT (BEP 44). Immutable items are
+// addressed by the SHA-1 hash of their value. Mutable items are signed
+// with an ed25519 key, and are addressed by the SHA-1 hash of the public
+// key and the salt, so that they can be updated by increasing Seq.
+type Item struct {
+	Value *bencode.BencodeData
+
+	// The following are only set for mutable items
+	PublicKey ed25519.PublicKey
+	Salt      []byte
+	Seq       int
+	Signature []byte
+}
+
+// NewImmutableItem creates an immutable item holding the value.
+func NewImmutableItem(v *bencode.BencodeData) (*Item, error) {
+	item := &Item{Value: v}
+	if len(v.Value.Encode()) > MAX_ITEM_VALUE_SIZE {
+		return nil, fmt.Errorf("value is larger than %d bytes", MAX_ITEM_VALUE_SIZE)
+	}
+	return item, nil
+}
+
+// NewMutableItem creates a mutable item holding the value, signed with the private key.
+func NewMutableItem(v *bencode.BencodeData, key ed25519.PrivateKey, salt []byte, seq int) (*Item, error) {
+	if len(v.Value.Encode()) > MAX_ITEM_VALUE_SIZE {
+		return nil, fmt.Errorf("value is larger than %d bytes", MAX_ITEM_VALUE_SIZE)
+	}
+	if len(salt) > MAX_ITEM_SALT_SIZE {
+		return nil, fmt.Errorf("salt is larger than %d bytes", MAX_ITEM_SALT_SIZE)
+	}
+
+	item := &Item{
+		Value:     v,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Salt:      salt,
+		Seq:       seq,
+	}
+	item.Signature = ed25519.Sign(key, item.signedBytes())
+	return item, nil
+}
+
+func (i *Item) IsMutable() bool {
+	return i.PublicKey != nil
+}
+
+// Target returns the key under which the item is stored in the DHT.
+func (i *Item) Target() NodeID {
+	if i.IsMutable() {
+		return MutableItemTarget(i.PublicKey, i.Salt)
+	}
+	return ImmutableItemTarget(i.Value)
+}
+
+// ImmutableItemTarget returns the SHA-1 hash of the bencoded value.
+func ImmutableItemTarget(v *bencode.BencodeData) NodeID {
+	return NodeID(sha1.Sum(v.Value.Encode()))
+}
+
+// MutableItemTarget returns the SHA-1 hash of the public key concatenated with the salt.
+func MutableItemTarget(publicKey ed25519.PublicKey, salt []byte) NodeID {
+	return NodeID(sha1.Sum(append(append([]byte{}, publicKey...), salt...)))
+}
+
+// signedBytes returns the buffer that is signed for mutable items, which
+// is the bencoded salt, seq and v keys without the enclosing dictionary:
+// 4:salt<salt>3:seqi<seq>e1:v<value>
+func (i *Item) signedBytes() []byte {
+	b := make([]byte, 0)
+	if len(i.Salt) > 0 {
+		b = append(b, "4:salt"...)
+		b = append(b, bencode.NewDataString(string(i.Salt)).Value.Encode()...)
+	}
+	b = fmt.Appendf(b, "3:seqi%de1:v", i.Seq)
+	b = append(b, i.Value.Value.Encode()...)
+	return b
+}
+
+// Verify checks that the item is valid for the target: the value must hash
+// to the target for immutable items, and mutable items must be correctly signed.
+func (i *Item) Verify(target NodeID) error {
+	if len(i.Value.Value.Encode()) > MAX_ITEM_VALUE_SIZE {
+		return newKRPCError(ERROR_MESSAGE_TOO_BIG, "message (v field) too big")
+	}
+	if i.Target() != target {
+		return fmt.Errorf("item does not match the target %s", target)
+	}
+	if !i.IsMutable() {
+		return nil
+	}
+
+	if len(i.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key length %d", len(i.PublicKey))
+	}
+	if len(i.Salt) > MAX_ITEM_SALT_SIZE {
+		return newKRPCError(ERROR_SALT_TOO_BIG, "salt (salt field) too big")
+	}
+	if len(i.Signature) != ed25519.SignatureSize || !ed25519.Verify(i.PublicKey, i.signedBytes(), i.Signature) {
+		return newKRPCError(ERROR_INVALID_SIGNATURE, "invalid signature")
+	}
+	return nil
+}
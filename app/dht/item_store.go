@@ -0,0 +1,119 @@
+package dht
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+type storedItem struct {
+	item     *Item
+	storedAt time.Time
+}
+
+// itemStore keeps the items that other nodes stored on our node with put
+// queries, keyed by their target. It holds at most MAX_STORED_ITEMS items.
+type itemStore struct {
+	mu    sync.Mutex
+	items map[NodeID]*storedItem
+}
+
+func newItemStore() *itemStore {
+	return &itemStore{
+		items: make(map[NodeID]*storedItem),
+	}
+}
+
+// Get returns the item stored for the target, or nil if there is no
+// such item or it has expired.
+func (s *itemStore) Get(target NodeID) *Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[target]
+	if !ok {
+		return nil
+	}
+	if time.Since(stored.storedAt) > ITEM_TTL {
+		delete(s.items, target)
+		return nil
+	}
+	return stored.item
+}
+
+// Put stores the item, which must already be verified. For mutable items,
+// the sequence number must not go backwards, and if useCAS is set, the
+// sequence number of the currently stored item must be equal to cas.
+func (s *itemStore) Put(item *Item, cas int, useCAS bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target := item.Target()
+	existing, ok := s.items[target]
+	if ok && time.Since(existing.storedAt) > ITEM_TTL {
+		ok = false
+	}
+
+	if ok && item.IsMutable() {
+		current := existing.item
+		if useCAS && current.Seq != cas {
+			return newKRPCError(ERROR_CAS_MISMATCH, "the CAS hash mismatched, re-read value and try again")
+		}
+		if item.Seq < current.Seq {
+			return newKRPCError(ERROR_SEQ_TOO_LOW, "sequence number less than current")
+		}
+		if item.Seq == current.Seq && !bytes.Equal(item.Value.Value.Encode(), current.Value.Value.Encode()) {
+			return newKRPCError(ERROR_SEQ_TOO_LOW, "sequence number less than current")
+		}
+	}
+
+	if !ok {
+		delete(s.items, target)
+		if len(s.items) >= MAX_STORED_ITEMS {
+			s.expire()
+		}
+		if len(s.items) >= MAX_STORED_ITEMS {
+			return newKRPCError(ERROR_SERVER, "item storage is full")
+		}
+	}
+
+	s.items[target] = &storedItem{item: item, storedAt: time.Now()}
+	return nil
+}
+
+// expire removes the expired items.
+func (s *itemStore) expire() {
+	for target, stored := range s.items {
+		if time.Since(stored.storedAt) > ITEM_TTL {
+			delete(s.items, target)
+		}
+	}
+}
+
+// itemFromDictionary parses an item from the arguments of a put query or the
+// response to a get query. The item is mutable if the "k" key is present.
+func itemFromDictionary(d *bencode.BencodeDictionary) (*Item, error) {
+	v, ok := d.Map["v"]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", "v")
+	}
+	item := &Item{Value: v}
+
+	k, err := dictBytes(d, "k")
+	if err != nil {
+		return item, nil
+	}
+	item.PublicKey = k
+
+	if item.Signature, err = dictBytes(d, "sig"); err != nil {
+		return nil, err
+	}
+	if item.Seq, err = dictInteger(d, "seq"); err != nil {
+		return nil, err
+	}
+	item.Salt, _ = dictBytes(d, "salt")
+	return item, nil
+}
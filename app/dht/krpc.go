@@ -0,0 +1,254 @@
+package dht
+
+import (
+	"fmt"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+const (
+	QUERY_PING          = "ping"
+	QUERY_FIND_NODE     = "find_node"
+	QUERY_GET_PEERS     = "get_peers"
+	QUERY_ANNOUNCE_PEER = "announce_peer"
+	QUERY_GET           = "get"
+	QUERY_PUT           = "put"
+)
+
+// Values of the "want" argument (BEP 32) used to request
+// IPv4 ("nodes") and IPv6 ("nodes6") nodes respectively.
+const (
+	WANT_IPV4 = "n4"
+	WANT_IPV6 = "n6"
+)
+
+// krpcError is an error that is sent back to the querying
+// node with a specific KRPC error code.
+type krpcError struct {
+	Code    int
+	Message string
+}
+
+func newKRPCError(code int, msg string) *krpcError {
+	return &krpcError{Code: code, Message: msg}
+}
+
+func (e *krpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// message is a single KRPC message. Depending on the Type, either the
+// Query and Args, the Response, or the error fields are populated.
+type message struct {
+	TransactionID []byte
+	Type          string // "q" for queries, "r" for responses and "e" for errors
+
+	Query string
+	Args  *bencode.BencodeDictionary
+
+	Response *bencode.BencodeDictionary
+
+	ErrorCode    int
+	ErrorMessage string
+
+	IP       []byte // The compact address of the receiver, as seen by the sender (BEP 42)
+	ReadOnly bool   // Set by nodes that do not respond to queries (BEP 43)
+}
+
+func newQuery(tid []byte, query string, args *bencode.BencodeDictionary) *message {
+	return &message{TransactionID: tid, Type: "q", Query: query, Args: args}
+}
+
+func newResponse(tid []byte, response *bencode.BencodeDictionary) *message {
+	return &message{TransactionID: tid, Type: "r", Response: response}
+}
+
+func newError(tid []byte, code int, msg string) *message {
+	return &message{TransactionID: tid, Type: "e", ErrorCode: code, ErrorMessage: msg}
+}
+
+func (m *message) Encode() []byte {
+	d := bencode.NewBencodeDictionary()
+	d.Add("t", bencode.NewDataString(string(m.TransactionID)))
+	d.Add("y", bencode.NewDataString(m.Type))
+
+	switch m.Type {
+	case "q":
+		d.Add("q", bencode.NewDataString(m.Query))
+		d.Add("a", &bencode.BencodeData{Type: bencode.DictionaryType, Value: m.Args})
+	case "r":
+		d.Add("r", &bencode.BencodeData{Type: bencode.DictionaryType, Value: m.Response})
+	case "e":
+		d.Add("e", bencode.NewDataList([]*bencode.BencodeData{
+			bencode.NewDataInteger(m.ErrorCode),
+			bencode.NewDataString(m.ErrorMessage),
+		}))
+	}
+
+	if m.IP != nil {
+		d.Add("ip", bencode.NewDataString(string(m.IP)))
+	}
+	if m.ReadOnly {
+		d.Add("ro", bencode.NewDataInteger(1))
+	}
+
+	return d.Encode()
+}
+
+// decodeMessage parses a KRPC message from the raw packet bytes. As the
+// packets come from the network, every type is validated before access.
+func decodeMessage(b []byte) (*message, error) {
+	bd, err := bencode.NewBencodeData(b)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding packet: %w", err)
+	}
+	if bd.Type != bencode.DictionaryType {
+		return nil, fmt.Errorf("expected packet to be a dictionary, got %s", bd.Type)
+	}
+	d := bd.GetDictionary()
+
+	m := &message{}
+	if m.TransactionID, err = dictBytes(d, "t"); err != nil {
+		return nil, err
+	}
+	y, err := dictBytes(d, "y")
+	if err != nil {
+		return nil, err
+	}
+	m.Type = string(y)
+
+	switch m.Type {
+	case "q":
+		q, err := dictBytes(d, "q")
+		if err != nil {
+			return nil, err
+		}
+		m.Query = string(q)
+		if m.Args, err = dictDictionary(d, "a"); err != nil {
+			return nil, err
+		}
+
+	case "r":
+		if m.Response, err = dictDictionary(d, "r"); err != nil {
+			return nil, err
+		}
+
+	case "e":
+		e, ok := d.Map["e"]
+		if !ok || e.Type != bencode.ListType || e.GetList().Length < 2 {
+			return nil, fmt.Errorf("invalid error message body")
+		}
+		code, msg := e.GetList().Array[0], e.GetList().Array[1]
+		if code.Type != bencode.IntegerType || msg.Type != bencode.StringType {
+			return nil, fmt.Errorf("invalid error message body")
+		}
+		if m.ErrorCode, err = code.GetInteger().Int(); err != nil {
+			return nil, fmt.Errorf("invalid error code: %w", err)
+		}
+		m.ErrorMessage = string(msg.GetString().Value)
+
+	default:
+		return nil, fmt.Errorf("unknown message type: %q", m.Type)
+	}
+
+	m.IP, _ = dictBytes(d, "ip")
+	if ro, err := dictInteger(d, "ro"); err == nil && ro == 1 {
+		m.ReadOnly = true
+	}
+
+	return m, nil
+}
+
+// senderID returns the "id" key of the query arguments or the response.
+func (m *message) senderID() (NodeID, error) {
+	var body *bencode.BencodeDictionary
+	switch m.Type {
+	case "q":
+		body = m.Args
+	case "r":
+		body = m.Response
+	default:
+		return NodeID{}, fmt.Errorf("message of type %q has no sender ID", m.Type)
+	}
+
+	id, err := dictBytes(body, "id")
+	if err != nil {
+		return NodeID{}, err
+	}
+	return NodeIDFromBytes(id)
+}
+
+// wantedFamilies returns the address families requested using the "want"
+// argument of a query. If it is not present, only the family of the
+// address the query was received from is returned, as defined by BEP 32.
+func wantedFamilies(args *bencode.BencodeDictionary, fromIPv6 bool) (ipv4, ipv6 bool) {
+	want, err := dictList(args, "want")
+	if err != nil {
+		return !fromIPv6, fromIPv6
+	}
+
+	for _, w := range want.Array {
+		if w.Type != bencode.StringType {
+			continue
+		}
+		switch string(w.GetString().Value) {
+		case WANT_IPV4:
+			ipv4 = true
+		case WANT_IPV6:
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6
+}
+
+func dictBytes(d *bencode.BencodeDictionary, key string) ([]byte, error) {
+	v, ok := d.Map[key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+	if v.Type != bencode.StringType {
+		return nil, fmt.Errorf("expected key %q to be a string, got %s", key, v.Type)
+	}
+	return v.GetString().Value, nil
+}
+
+func dictInteger(d *bencode.BencodeDictionary, key string) (int, error) {
+	v, ok := d.Map[key]
+	if !ok {
+		return 0, fmt.Errorf("key %q not found", key)
+	}
+	if v.Type != bencode.IntegerType {
+		return 0, fmt.Errorf("expected key %q to be an integer, got %s", key, v.Type)
+	}
+	return v.GetInteger().Int()
+}
+
+func dictDictionary(d *bencode.BencodeDictionary, key string) (*bencode.BencodeDictionary, error) {
+	v, ok := d.Map[key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+	if v.Type != bencode.DictionaryType {
+		return nil, fmt.Errorf("expected key %q to be a dictionary, got %s", key, v.Type)
+	}
+	return v.GetDictionary(), nil
+}
+
+func dictList(d *bencode.BencodeDictionary, key string) (*bencode.BencodeList, error) {
+	v, ok := d.Map[key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+	if v.Type != bencode.ListType {
+		return nil, fmt.Errorf("expected key %q to be a list, got %s", key, v.Type)
+	}
+	return v.GetList(), nil
+}
+
+func dictNodeID(d *bencode.BencodeDictionary, key string) (NodeID, error) {
+	b, err := dictBytes(d, key)
+	if err != nil {
+		return NodeID{}, err
+	}
+	return NodeIDFromBytes(b)
+}
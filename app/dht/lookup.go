@@ -0,0 +1,377 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// Ping sends a ping query to the node at the address and returns its ID.
+func (d *DHT) Ping(addr *net.UDPAddr) (NodeID, error) {
+	r, err := d.query(addr, QUERY_PING, bencode.NewBencodeDictionary())
+	if err != nil {
+		return NodeID{}, err
+	}
+	return r.senderID()
+}
+
+// findNode asks the node at addr for the nodes closest to the target.
+func (d *DHT) findNode(addr *net.UDPAddr, target NodeID) ([]*Node, error) {
+	args := bencode.NewBencodeDictionary()
+	args.Add("target", bencode.NewDataString(string(target[:])))
+	args.Add("want", d.wantList())
+
+	r, err := d.query(addr, QUERY_FIND_NODE, args)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := responseNodes(r.Response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid find_node response: %w", err)
+	}
+	return nodes, nil
+}
+
+// responseNodes parses both the IPv4 "nodes" and the IPv6 "nodes6" keys of a response.
+func responseNodes(r *bencode.BencodeDictionary) ([]*Node, error) {
+	all := make([]*Node, 0)
+	for key, ipv6 := range map[string]bool{"nodes": false, "nodes6": true} {
+		b, err := dictBytes(r, key)
+		if err != nil {
+			continue
+		}
+		nodes, err := decodeCompactNodes(b, ipv6)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, nodes...)
+	}
+	return all, nil
+}
+
+// getPeersResponse is the parsed response to a get_peers query.
+type getPeersResponse struct {
+	token []byte
+	peers []*net.UDPAddr
+	nodes []*Node
+}
+
+// getPeers asks the node at addr for the peers of the info hash.
+func (d *DHT) getPeers(addr *net.UDPAddr, infoHash NodeID) (*getPeersResponse, error) {
+	args := bencode.NewBencodeDictionary()
+	args.Add("info_hash", bencode.NewDataString(string(infoHash[:])))
+	args.Add("want", d.wantList())
+
+	r, err := d.query(addr, QUERY_GET_PEERS, args)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &getPeersResponse{}
+	res.token, _ = dictBytes(r.Response, "token")
+
+	if values, err := dictList(r.Response, "values"); err == nil {
+		for _, v := range values.Array {
+			if v.Type != bencode.StringType {
+				continue
+			}
+			if peer := decodeCompactPeer(v.GetString().Value); peer != nil {
+				res.peers = append(res.peers, peer)
+			}
+		}
+	}
+	if res.nodes, err = responseNodes(r.Response); err != nil {
+		return nil, fmt.Errorf("invalid get_peers response: %w", err)
+	}
+
+	return res, nil
+}
+
+// announcePeer tells the node at addr that we are downloading the
+// info hash and are accepting peer connections on the given port.
+func (d *DHT) announcePeer(addr *net.UDPAddr, infoHash NodeID, port int, token []byte) error {
+	args := bencode.NewBencodeDictionary()
+	args.Add("info_hash", bencode.NewDataString(string(infoHash[:])))
+	args.Add("port", bencode.NewDataInteger(port))
+	args.Add("token", bencode.NewDataString(string(token)))
+	args.Add("implied_port", bencode.NewDataInteger(0))
+
+	_, err := d.query(addr, QUERY_ANNOUNCE_PEER, args)
+	return err
+}
+
+// getItem asks the node at addr for the item stored under the target.
+// The returned item is nil if the node does not have it, and is not verified.
+func (d *DHT) getItem(addr *net.UDPAddr, target NodeID) (*getPeersResponse, *Item, error) {
+	args := bencode.NewBencodeDictionary()
+	args.Add("target", bencode.NewDataString(string(target[:])))
+	args.Add("want", d.wantList())
+
+	r, err := d.query(addr, QUERY_GET, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := &getPeersResponse{}
+	res.token, _ = dictBytes(r.Response, "token")
+	if res.nodes, err = responseNodes(r.Response); err != nil {
+		return nil, nil, fmt.Errorf("invalid get response: %w", err)
+	}
+
+	item, err := itemFromDictionary(r.Response)
+	if err != nil {
+		return res, nil, nil
+	}
+	return res, item, nil
+}
+
+// putItem stores the item on the node at addr. If useCAS is set, the node
+// only accepts the item if its current sequence number is equal to cas.
+func (d *DHT) putItem(addr *net.UDPAddr, item *Item, token []byte, cas int, useCAS bool) error {
+	args := bencode.NewBencodeDictionary()
+	args.Add("token", bencode.NewDataString(string(token)))
+	args.Add("v", item.Value)
+	if item.IsMutable() {
+		args.Add("k", bencode.NewDataString(string(item.PublicKey)))
+		args.Add("seq", bencode.NewDataInteger(item.Seq))
+		args.Add("sig", bencode.NewDataString(string(item.Signature)))
+		if len(item.Salt) > 0 {
+			args.Add("salt", bencode.NewDataString(string(item.Salt)))
+		}
+		if useCAS {
+			args.Add("cas", bencode.NewDataInteger(cas))
+		}
+	}
+
+	_, err := d.query(addr, QUERY_PUT, args)
+	return err
+}
+
+// lookupResult holds the outcome of an iterative lookup.
+type lookupResult struct {
+	closest []*Node           // The closest nodes that responded, sorted by distance
+	tokens  map[string][]byte // The tokens handed out by the responding nodes, keyed by address
+	peers   []*net.UDPAddr    // The peers found, only for get_peers lookups
+	items   []*Item           // The unverified items found, only for get lookups
+}
+
+// lookup performs an iterative Kademlia lookup for the target. It repeatedly
+// queries the LOOKUP_ALPHA closest nodes that have not been queried yet,
+// until the K_BUCKET_SIZE closest nodes found so far have all been queried.
+// The method is the query sent to the nodes, and must be one of find_node,
+// get_peers or get. As the IPv4 and IPv6 networks are separate, only the
+// nodes of the requested address family take part in the lookup. The seeds
+// are used as initial candidates in addition to the nodes in the routing
+// table.
+func (d *DHT) lookup(target NodeID, method string, ipv6 bool, seeds ...*Node) *lookupResult {
+	res := &lookupResult{tokens: make(map[string][]byte)}
+
+	candidates := make([]*Node, 0)
+	seen := make(map[string]bool)
+	for _, n := range append(d.tableFor(ipv6).Closest(target, K_BUCKET_SIZE), seeds...) {
+		if n.ID == d.id || isIPv6(n.Addr) != ipv6 || seen[n.Addr.String()] {
+			continue
+		}
+		seen[n.Addr.String()] = true
+		candidates = append(candidates, n)
+	}
+	queried := make(map[string]bool)
+	seenPeers := make(map[string]bool)
+
+	var mu sync.Mutex
+	for {
+		// Pick the closest candidates that have not been queried yet
+		sortByDistance(candidates, target)
+		if len(candidates) > K_BUCKET_SIZE {
+			candidates = candidates[:K_BUCKET_SIZE]
+		}
+		batch := make([]*Node, 0, LOOKUP_ALPHA)
+		for _, n := range candidates {
+			if !queried[n.Addr.String()] && len(batch) < LOOKUP_ALPHA {
+				batch = append(batch, n)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		failed := make(map[string]bool)
+		found := make([]*Node, 0)
+
+		var wg sync.WaitGroup
+		for _, n := range batch {
+			queried[n.Addr.String()] = true
+
+			wg.Add(1)
+			go func(n *Node) {
+				defer wg.Done()
+
+				var nodes []*Node
+				var peers []*net.UDPAddr
+				var token []byte
+				var item *Item
+				var err error
+
+				switch method {
+				case QUERY_GET_PEERS:
+					var r *getPeersResponse
+					r, err = d.getPeers(n.Addr, target)
+					if err == nil {
+						nodes, peers, token = r.nodes, r.peers, r.token
+					}
+				case QUERY_GET:
+					var r *getPeersResponse
+					r, item, err = d.getItem(n.Addr, target)
+					if err == nil {
+						nodes, token = r.nodes, r.token
+					}
+				default:
+					nodes, err = d.findNode(n.Addr, target)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if err != nil {
+					failed[n.Addr.String()] = true
+					return
+				}
+
+				res.closest = append(res.closest, n)
+				if token != nil {
+					res.tokens[n.Addr.String()] = token
+				}
+				found = append(found, nodes...)
+				if item != nil {
+					res.items = append(res.items, item)
+				}
+				for _, p := range peers {
+					if !seenPeers[p.String()] {
+						seenPeers[p.String()] = true
+						res.peers = append(res.peers, p)
+					}
+				}
+			}(n)
+		}
+		wg.Wait()
+
+		// Drop the nodes that did not respond, and add the newly discovered ones
+		alive := candidates[:0]
+		for _, n := range candidates {
+			if !failed[n.Addr.String()] {
+				alive = append(alive, n)
+			}
+		}
+		candidates = alive
+		for _, n := range found {
+			if n.ID == d.id || isIPv6(n.Addr) != ipv6 || seen[n.Addr.String()] {
+				continue
+			}
+			seen[n.Addr.String()] = true
+			candidates = append(candidates, n)
+		}
+	}
+
+	sortByDistance(res.closest, target)
+	if len(res.closest) > K_BUCKET_SIZE {
+		res.closest = res.closest[:K_BUCKET_SIZE]
+	}
+	return res
+}
+
+// lookupAll runs the lookup for all the address families that we have
+// nodes for, and merges the results.
+func (d *DHT) lookupAll(target NodeID, method string) (*lookupResult, error) {
+	if d.table4.Len()+d.table6.Len() == 0 {
+		return nil, fmt.Errorf("routing table is empty, bootstrap the node first")
+	}
+
+	res := &lookupResult{tokens: make(map[string][]byte)}
+	for _, ipv6 := range []bool{false, true} {
+		if d.tableFor(ipv6).Len() == 0 {
+			continue
+		}
+
+		r := d.lookup(target, method, ipv6)
+		res.closest = append(res.closest, r.closest...)
+		res.peers = append(res.peers, r.peers...)
+		res.items = append(res.items, r.items...)
+		for addr, token := range r.tokens {
+			res.tokens[addr] = token
+		}
+	}
+	return res, nil
+}
+
+// FindNode performs an iterative lookup and returns the closest
+// nodes to the target that responded to our queries.
+func (d *DHT) FindNode(target NodeID) ([]*Node, error) {
+	res, err := d.lookupAll(target, QUERY_FIND_NODE)
+	if err != nil {
+		return nil, err
+	}
+	return res.closest, nil
+}
+
+// GetPeers searches the DHT for the peers of the info hash,
+// and returns their addresses in the "host:port" format.
+func (d *DHT) GetPeers(infoHash []byte) ([]string, error) {
+	target, err := NodeIDFromBytes(infoHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid info hash: %w", err)
+	}
+
+	res, err := d.lookupAll(target, QUERY_GET_PEERS)
+	if err != nil {
+		return nil, err
+	}
+	return peerAddrs(res.peers), nil
+}
+
+// Announce tells the nodes closest to the info hash that we are accepting
+// peer connections for it on the given port. It returns the peers that
+// were found during the lookup, like GetPeers.
+func (d *DHT) Announce(infoHash []byte, port int) ([]string, error) {
+	target, err := NodeIDFromBytes(infoHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid info hash: %w", err)
+	}
+
+	res, err := d.lookupAll(target, QUERY_GET_PEERS)
+	if err != nil {
+		return nil, err
+	}
+
+	announced := 0
+	for _, n := range res.closest {
+		token, ok := res.tokens[n.Addr.String()]
+		if !ok {
+			continue
+		}
+		if err := d.announcePeer(n.Addr, target, port, token); err != nil {
+			d.Log("error announcing to %s: %v", n, err)
+			continue
+		}
+		announced++
+	}
+
+	if announced == 0 {
+		return peerAddrs(res.peers), fmt.Errorf("no node accepted the announcement")
+	}
+	return peerAddrs(res.peers), nil
+}
+
+func peerAddrs(peers []*net.UDPAddr) []string {
+	seen := make(map[string]bool)
+	addrs := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if !seen[p.String()] {
+			seen[p.String()] = true
+			addrs = append(addrs, p.String())
+		}
+	}
+	return addrs
+}
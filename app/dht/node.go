@@ -0,0 +1,177 @@
+package dht
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"time"
+)
+
+const NODE_ID_LENGTH = 20
+const COMPACT_PEER_INFO_LENGTH = 6       // IPv4 + port
+const COMPACT_PEER_INFO_LENGTH_IPV6 = 18 // IPv6 + port
+const COMPACT_NODE_INFO_LENGTH = NODE_ID_LENGTH + COMPACT_PEER_INFO_LENGTH
+const COMPACT_NODE_INFO_LENGTH_IPV6 = NODE_ID_LENGTH + COMPACT_PEER_INFO_LENGTH_IPV6
+
+// NodeID is the 160-bit identifier of a node in the DHT. Info hashes
+// live in the same key space, so they are represented using NodeID as well.
+type NodeID [NODE_ID_LENGTH]byte
+
+// RandomNodeID returns a uniformly random node ID.
+func RandomNodeID() NodeID {
+	var id NodeID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// NodeIDFromBytes converts a 20 byte slice into a NodeID.
+func NodeIDFromBytes(b []byte) (NodeID, error) {
+	var id NodeID
+	if len(b) != NODE_ID_LENGTH {
+		return id, fmt.Errorf("invalid node ID length: expected %d, got %d", NODE_ID_LENGTH, len(b))
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id NodeID) IsZero() bool {
+	return id == NodeID{}
+}
+
+// Distance returns the XOR distance between two IDs.
+func (id NodeID) Distance(other NodeID) NodeID {
+	var d NodeID
+	for i := range NODE_ID_LENGTH {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// Less reports whether the distance (or ID) id is smaller than other.
+func (id NodeID) Less(other NodeID) bool {
+	for i := range NODE_ID_LENGTH {
+		if id[i] != other[i] {
+			return id[i] < other[i]
+		}
+	}
+	return false
+}
+
+// CommonPrefixLength returns the number of leading bits that
+// the two IDs share. It is 160 for identical IDs.
+func (id NodeID) CommonPrefixLength(other NodeID) int {
+	for i := range NODE_ID_LENGTH {
+		x := id[i] ^ other[i]
+		if x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return NODE_ID_LENGTH * 8
+}
+
+// Node is a remote DHT node that we know about.
+type Node struct {
+	ID   NodeID
+	Addr *net.UDPAddr
+
+	LastSeen      time.Time // The last time we heard from the node
+	FailedQueries int       // Number of consecutive queries that the node did not answer
+}
+
+func NewNode(id NodeID, addr *net.UDPAddr) *Node {
+	return &Node{
+		ID:       id,
+		Addr:     addr,
+		LastSeen: time.Now(),
+	}
+}
+
+// IsGood reports whether the node has been active recently, as defined by BEP 5.
+func (n *Node) IsGood() bool {
+	return n.FailedQueries == 0 && time.Since(n.LastSeen) < NODE_QUESTIONABLE_AFTER
+}
+
+// IsBad reports whether the node has failed to respond to multiple
+// queries in a row, and can be replaced in the routing table.
+func (n *Node) IsBad() bool {
+	return n.FailedQueries >= MAX_FAILED_QUERIES
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("%s@%s", n.ID, n.Addr)
+}
+
+// isIPv6 reports whether the address is an IPv6 address.
+// IPv4-mapped IPv6 addresses are treated as IPv4 addresses.
+func isIPv6(addr *net.UDPAddr) bool {
+	return addr.IP.To4() == nil
+}
+
+// encodeCompactNodes encodes the nodes in the "Compact node info"
+// format: the 20 byte ID followed by the compact peer info. The "nodes"
+// key only holds IPv4 nodes and the "nodes6" key (BEP 32) only IPv6
+// nodes, so the nodes of the other address family are skipped.
+func encodeCompactNodes(nodes []*Node, ipv6 bool) []byte {
+	b := make([]byte, 0)
+	for _, n := range nodes {
+		if isIPv6(n.Addr) != ipv6 {
+			continue
+		}
+		b = append(b, n.ID[:]...)
+		b = append(b, encodeCompactPeer(n.Addr)...)
+	}
+	return b
+}
+
+// decodeCompactNodes parses a string of concatenated compact node infos.
+func decodeCompactNodes(b []byte, ipv6 bool) ([]*Node, error) {
+	entryLength := COMPACT_NODE_INFO_LENGTH
+	if ipv6 {
+		entryLength = COMPACT_NODE_INFO_LENGTH_IPV6
+	}
+	if len(b)%entryLength != 0 {
+		return nil, fmt.Errorf("compact nodes length %d is not a multiple of %d", len(b), entryLength)
+	}
+
+	nodes := make([]*Node, 0, len(b)/entryLength)
+	for i := 0; i < len(b); i += entryLength {
+		id, _ := NodeIDFromBytes(b[i : i+NODE_ID_LENGTH])
+		addr := decodeCompactPeer(b[i+NODE_ID_LENGTH : i+entryLength])
+		nodes = append(nodes, NewNode(id, addr))
+	}
+	return nodes, nil
+}
+
+// encodeCompactPeer encodes the address in the "Compact IP-address/port info"
+// format, which is 6 bytes long for IPv4 addresses and 18 bytes for IPv6.
+func encodeCompactPeer(addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+	b := make([]byte, 0, len(ip)+2)
+	b = append(b, ip...)
+	b = binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+	return b
+}
+
+// decodeCompactPeer parses a 6 or 18 byte compact peer info.
+// Returns nil if the length is invalid.
+func decodeCompactPeer(b []byte) *net.UDPAddr {
+	if len(b) != COMPACT_PEER_INFO_LENGTH && len(b) != COMPACT_PEER_INFO_LENGTH_IPV6 {
+		return nil
+	}
+	ip := make(net.IP, len(b)-2)
+	copy(ip, b)
+	return &net.UDPAddr{
+		IP:   ip,
+		Port: int(binary.BigEndian.Uint16(b[len(b)-2:])),
+	}
+}
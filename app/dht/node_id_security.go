@@ -0,0 +1,143 @@
+package dht
+
+import (
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+)
+
+// The number of networks whose nodes have to agree on our external IP
+// before we consider it to be known, how long their votes are counted for,
+// and the maximum number of networks whose votes are kept.
+const EXTERNAL_IP_MIN_VOTES = 3
+const EXTERNAL_IP_VOTE_TTL = 30 * time.Minute
+const MAX_EXTERNAL_IP_VOTES = 1000
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ipv4Mask = []byte{0x03, 0x0f, 0x3f, 0xff}
+var ipv6Mask = []byte{0x01, 0x03, 0x07, 0x0f, 0x1f, 0x3f, 0x7f, 0xff}
+
+// nodeIDPrefix computes the CRC32-C of the masked IP, with the
+// 3 bit random value r mixed into the top bits, as defined by BEP 42.
+func nodeIDPrefix(ip net.IP, r byte) uint32 {
+	mask := ipv4Mask
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	} else {
+		mask = ipv6Mask
+	}
+
+	masked := make([]byte, len(mask))
+	for i := range mask {
+		masked[i] = ip[i] & mask[i]
+	}
+	masked[0] |= (r & 0x07) << 5
+
+	return crc32.Checksum(masked, castagnoliTable)
+}
+
+// NodeIDForIP generates a node ID that is valid for the
+// external IP according to the BEP 42 node ID restrictions.
+func NodeIDForIP(ip net.IP) NodeID {
+	id := RandomNodeID()
+	r := id[NODE_ID_LENGTH-1] & 0x07
+	crc := nodeIDPrefix(ip, r)
+
+	id[0] = byte(crc >> 24)
+	id[1] = byte(crc >> 16)
+	id[2] = (byte(crc>>8) & 0xf8) | (id[2] & 0x07)
+	return id
+}
+
+// IsNodeIDSecure reports whether the node ID is valid for the IP as
+// per BEP 42: the first 21 bits must match the CRC32-C of the masked IP.
+// Local network addresses are exempt from the check.
+func IsNodeIDSecure(id NodeID, ip net.IP) bool {
+	if isExemptIP(ip) {
+		return true
+	}
+
+	crc := nodeIDPrefix(ip, id[NODE_ID_LENGTH-1]&0x07)
+	return id[0] == byte(crc>>24) &&
+		id[1] == byte(crc>>16) &&
+		id[2]&0xf8 == byte(crc>>8)&0xf8
+}
+
+func isExemptIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// ipVoter learns our external IP from the "ip" key that the
+// other nodes include in their responses (BEP 42). Each network of voters
+// only has one vote, so that a single node, or many nodes run by the same
+// host, can't make us believe a wrong IP. Votes expire after
+// EXTERNAL_IP_VOTE_TTL, so that a change of IP is noticed.
+type ipVoter struct {
+	mu    sync.Mutex
+	votes map[string]ipVote // By the network of the voter, see voterKey
+}
+
+type ipVote struct {
+	ip   string
+	time time.Time
+}
+
+func newIPVoter() *ipVoter {
+	return &ipVoter{votes: make(map[string]ipVote)}
+}
+
+// voterKey returns the network of the voter, the /24 for IPv4
+// addresses and the /64 for IPv6 addresses.
+func voterKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
+
+// Add records the IP that the voter reported for us, replacing the
+// previous vote of its network.
+func (v *ipVoter) Add(voter, ip net.IP) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.expire()
+	key := voterKey(voter)
+	if _, ok := v.votes[key]; !ok && len(v.votes) >= MAX_EXTERNAL_IP_VOTES {
+		return
+	}
+	v.votes[key] = ipVote{ip: ip.String(), time: time.Now()}
+}
+
+// Get returns the IP with the most votes, or nil if
+// no IP has received EXTERNAL_IP_MIN_VOTES yet.
+func (v *ipVoter) Get() net.IP {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.expire()
+	counts := make(map[string]int)
+	for _, vote := range v.votes {
+		counts[vote.ip]++
+	}
+
+	var best string
+	for ip, count := range counts {
+		if count >= EXTERNAL_IP_MIN_VOTES && (best == "" || count > counts[best]) {
+			best = ip
+		}
+	}
+	return net.ParseIP(best)
+}
+
+// expire removes the votes older than EXTERNAL_IP_VOTE_TTL.
+// The caller must hold v.mu.
+func (v *ipVoter) expire() {
+	for key, vote := range v.votes {
+		if time.Since(vote.time) > EXTERNAL_IP_VOTE_TTL {
+			delete(v.votes, key)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package dht
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+func TestIsNodeIDSecure(t *testing.T) {
+	// The test vectors of BEP 42
+	tests := []struct {
+		ip string
+		id string
+	}{
+		{"124.31.75.21", "5fbfbff10c5d6a4ec8a88e4c6ab4c28b95eee401"},
+		{"21.75.31.124", "5a3ce9c14e7a08645677bbd1cfe7d8f956d53256"},
+		{"65.23.51.170", "a5d43220bc8f112a3d426c84764f8c2a1150e616"},
+		{"84.124.73.14", "1b0321dd1bb1fe518101ceef99462b947a01ff41"},
+		{"43.213.53.83", "e56f6cbf5b7c4be0237986d5243b87aa6d51305a"},
+	}
+
+	for _, tt := range tests {
+		b, _ := hex.DecodeString(tt.id)
+		id, err := NodeIDFromBytes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := net.ParseIP(tt.ip)
+
+		if !IsNodeIDSecure(id, ip) {
+			t.Errorf("expected %s to be a secure ID for %s", tt.id, tt.ip)
+		}
+
+		// Only the top 21 bits are derived from the IP
+		id[2] ^= 0x07
+		if !IsNodeIDSecure(id, ip) {
+			t.Errorf("expected the low bits of the third byte of %s to be ignored", tt.id)
+		}
+		id[2] ^= 0x08
+		if IsNodeIDSecure(id, ip) {
+			t.Errorf("expected %s with a changed prefix to be rejected for %s", tt.id, tt.ip)
+		}
+	}
+}
+
+func TestNodeIDForIP(t *testing.T) {
+	for _, s := range []string{"124.31.75.21", "2001:db8::1"} {
+		ip := net.ParseIP(s)
+		id := NodeIDForIP(ip)
+		if !IsNodeIDSecure(id, ip) {
+			t.Errorf("expected the generated ID %s to be secure for %s", id, ip)
+		}
+		if IsNodeIDSecure(id, net.ParseIP("8.8.8.8")) {
+			t.Errorf("expected the generated ID %s to be rejected for another IP", id)
+		}
+	}
+
+	// Local addresses are exempt
+	if !IsNodeIDSecure(RandomNodeID(), net.ParseIP("192.168.1.1")) {
+		t.Error("expected any ID to be accepted for a private IP")
+	}
+}
+
+func TestIPVoterCountsNetworksOnce(t *testing.T) {
+	v := newIPVoter()
+	ours := net.ParseIP("1.2.3.4")
+
+	// A single node, or nodes of the same /24, only count once
+	for i := range 10 {
+		v.Add(net.IPv4(5, 6, 7, byte(i)), ours)
+	}
+	if ip := v.Get(); ip != nil {
+		t.Fatalf("expected no external IP from a single network, got %s", ip)
+	}
+
+	v.Add(net.ParseIP("9.9.9.9"), ours)
+	v.Add(net.ParseIP("2001:db8::1"), ours)
+	if ip := v.Get(); !ip.Equal(ours) {
+		t.Fatalf("expected %s, got %s", ours, ip)
+	}
+
+	// A network changes its vote instead of adding one
+	other := net.ParseIP("4.3.2.1")
+	v.Add(net.ParseIP("9.9.9.9"), other)
+	if ip := v.Get(); ip != nil {
+		t.Errorf("expected no external IP once a network changed its vote, got %s", ip)
+	}
+}
+
+func TestIPVoterExpiresVotes(t *testing.T) {
+	v := newIPVoter()
+	ours := net.ParseIP("1.2.3.4")
+	for i := range EXTERNAL_IP_MIN_VOTES {
+		v.Add(net.IPv4(10, byte(i), 0, 1), ours)
+	}
+	if ip := v.Get(); !ip.Equal(ours) {
+		t.Fatalf("expected %s, got %s", ours, ip)
+	}
+
+	for key, vote := range v.votes {
+		vote.time = time.Now().Add(-EXTERNAL_IP_VOTE_TTL - time.Minute)
+		v.votes[key] = vote
+	}
+	if ip := v.Get(); ip != nil {
+		t.Errorf("expected the expired votes to be ignored, got %s", ip)
+	}
+	if len(v.votes) != 0 {
+		t.Errorf("expected the expired votes to be removed, got %d", len(v.votes))
+	}
+}
+
+func TestFindNodeWant(t *testing.T) {
+	d := newTestNode(t)
+	for i := range 3 {
+		d.table4.Insert(NewNode(RandomNodeID(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 6881}))
+		d.table6.Insert(NewNode(RandomNodeID(), &net.UDPAddr{IP: net.ParseIP(fmt.Sprintf("fd00::%d", i+1)), Port: 6881}))
+	}
+
+	from4 := &net.UDPAddr{IP: net.IPv4(10, 1, 1, 1), Port: 1}
+	from6 := &net.UDPAddr{IP: net.ParseIP("fd00::ff"), Port: 1}
+	tests := []struct {
+		name        string
+		want        []string
+		from        *net.UDPAddr
+		nodes       bool
+		nodes6      bool
+		nodesLength int
+	}{
+		{"no want from IPv4", nil, from4, true, false, 3 * COMPACT_NODE_INFO_LENGTH},
+		{"no want from IPv6", nil, from6, false, true, 3 * COMPACT_NODE_INFO_LENGTH_IPV6},
+		{"n4", []string{WANT_IPV4}, from6, true, false, 3 * COMPACT_NODE_INFO_LENGTH},
+		{"n6", []string{WANT_IPV6}, from4, false, true, 3 * COMPACT_NODE_INFO_LENGTH_IPV6},
+		{"n4 and n6", []string{WANT_IPV4, WANT_IPV6}, from4, true, true, 3 * COMPACT_NODE_INFO_LENGTH},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := bencode.NewBencodeDictionary()
+			target := RandomNodeID()
+			args.Add("target", bencode.NewDataString(string(target[:])))
+			if tt.want != nil {
+				want := make([]*bencode.BencodeData, 0, len(tt.want))
+				for _, w := range tt.want {
+					want = append(want, bencode.NewDataString(w))
+				}
+				args.Add("want", bencode.NewDataList(want))
+			}
+
+			r, err := d.handleFindNode(args, tt.from)
+			if err != nil {
+				t.Fatalf("error handling find_node: %v", err)
+			}
+			nodes, hasNodes := r.Map["nodes"]
+			nodes6, hasNodes6 := r.Map["nodes6"]
+			if hasNodes != tt.nodes || hasNodes6 != tt.nodes6 {
+				t.Fatalf("expected nodes %v and nodes6 %v, got %v and %v", tt.nodes, tt.nodes6, hasNodes, hasNodes6)
+			}
+
+			length := 0
+			if hasNodes {
+				length = len(nodes.GetString().Value)
+			} else {
+				length = len(nodes6.GetString().Value)
+			}
+			if length != tt.nodesLength {
+				t.Errorf("expected %d bytes of nodes, got %d", tt.nodesLength, length)
+			}
+
+			found, err := responseNodes(r)
+			if err != nil {
+				t.Fatalf("error parsing the nodes: %v", err)
+			}
+			for _, n := range found {
+				if isIPv6(n.Addr) && !tt.nodes6 || !isIPv6(n.Addr) && !tt.nodes {
+					t.Errorf("unexpected node %s", n)
+				}
+			}
+		})
+	}
+}
+
+func TestReadOnlyNode(t *testing.T) {
+	a := newTestNode(t)
+	ro, err := New(Config{
+		Addr:         "127.0.0.1:0",
+		QueryTimeout: TEST_QUERY_TIMEOUT,
+		ReadOnly:     true,
+	})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	t.Cleanup(func() { ro.Close() })
+
+	// The queries of a read-only node are answered, but it is not added
+	// to the routing table as it won't answer our queries
+	_, err = ro.Ping(a.Addr())
+	if err != nil {
+		t.Fatalf("error pinging from the read-only node: %v", err)
+	}
+	if n := a.RoutingTable().Len(); n != 0 {
+		t.Errorf("expected the read-only node not to be added, got %d nodes", n)
+	}
+	if n := ro.RoutingTable().Len(); n != 1 {
+		t.Errorf("expected the read-only node to add the responding node, got %d nodes", n)
+	}
+
+	// It doesn't answer queries itself
+	_, err = a.Ping(ro.Addr())
+	if err == nil {
+		t.Error("expected the read-only node not to answer")
+	}
+}
+
+func TestQueryReadOnlyFlag(t *testing.T) {
+	for _, readOnly := range []bool{false, true} {
+		q := newQuery([]byte("aa"), QUERY_PING, bencode.NewBencodeDictionary())
+		q.ReadOnly = readOnly
+
+		m, err := decodeMessage(q.Encode())
+		if err != nil {
+			t.Fatalf("error decoding the query: %v", err)
+		}
+		if m.ReadOnly != readOnly {
+			t.Errorf("expected ro %v, got %v in %q", readOnly, m.ReadOnly, q.Encode())
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dht
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// MAX_PEERS_PER_RESPONSE caps the number of peers we return for a single
+// get_peers query so that the response fits in a single UDP packet.
+const MAX_PEERS_PER_RESPONSE = 50
+
+// peerStore keeps the peers that announced themselves to us
+// using announce_peer, keyed by the info hash. It holds at most
+// MAX_STORED_PEERS peers across all the info hashes.
+type peerStore struct {
+	mu    sync.Mutex
+	peers map[NodeID]map[string]time.Time // info hash -> peer address -> announce time
+	count int                             // The number of peers across all the info hashes
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{
+		peers: make(map[NodeID]map[string]time.Time),
+	}
+}
+
+// Add records the announcement of the peer, and reports whether it was
+// stored. New peers are only stored if the store is not full, once the
+// expired announcements are removed.
+func (ps *peerStore) Add(infoHash NodeID, addr *net.UDPAddr) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	key := addr.String()
+	if _, ok := ps.peers[infoHash][key]; !ok {
+		if ps.count >= MAX_STORED_PEERS {
+			ps.expire()
+		}
+		if ps.count >= MAX_STORED_PEERS {
+			return false
+		}
+		ps.count++
+	}
+
+	if _, ok := ps.peers[infoHash]; !ok {
+		ps.peers[infoHash] = make(map[string]time.Time)
+	}
+	ps.peers[infoHash][key] = time.Now()
+	return true
+}
+
+// expire removes the expired announcements of all the info hashes.
+func (ps *peerStore) expire() {
+	for infoHash := range ps.peers {
+		ps.expireInfoHash(infoHash)
+	}
+}
+
+// expireInfoHash removes the expired announcements of the info hash,
+// and the info hash itself once none are left.
+func (ps *peerStore) expireInfoHash(infoHash NodeID) {
+	for addr, announcedAt := range ps.peers[infoHash] {
+		if time.Since(announcedAt) > PEER_ANNOUNCE_TTL {
+			delete(ps.peers[infoHash], addr)
+			ps.count--
+		}
+	}
+	if len(ps.peers[infoHash]) == 0 {
+		delete(ps.peers, infoHash)
+	}
+}
+
+// Get returns the compact peer infos of the peers of the given address family
+// that announced the info hash recently. Expired announcements are removed.
+func (ps *peerStore) Get(infoHash NodeID, ipv6 bool) [][]byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.expireInfoHash(infoHash)
+
+	values := make([][]byte, 0)
+	for addr := range ps.peers[infoHash] {
+		if len(values) >= MAX_PEERS_PER_RESPONSE {
+			break
+		}
+
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil || isIPv6(udpAddr) != ipv6 {
+			continue
+		}
+		values = append(values, encodeCompactPeer(udpAddr))
+	}
+	return values
+}
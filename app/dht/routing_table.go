@@ -0,0 +1,212 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// bucket holds up to K_BUCKET_SIZE nodes, ordered from the least
+// recently seen to the most recently seen.
+type bucket struct {
+	nodes       []*Node
+	lastChanged time.Time
+}
+
+// RoutingTable is a Kademlia routing table. Bucket i holds the nodes
+// whose IDs share exactly i leading bits with our own ID, so the buckets
+// closer to our ID cover exponentially smaller parts of the key space.
+type RoutingTable struct {
+	self NodeID
+
+	mu      sync.Mutex
+	buckets [NODE_ID_LENGTH * 8]*bucket
+}
+
+func NewRoutingTable(self NodeID) *RoutingTable {
+	rt := &RoutingTable{self: self}
+	for i := range rt.buckets {
+		rt.buckets[i] = &bucket{}
+	}
+	return rt
+}
+
+func (rt *RoutingTable) bucketFor(id NodeID) *bucket {
+	idx := rt.self.CommonPrefixLength(id)
+	if idx >= len(rt.buckets) {
+		idx = len(rt.buckets) - 1
+	}
+	return rt.buckets[idx]
+}
+
+// Insert adds the node to the routing table, or marks it as seen if it is
+// already present. If the bucket is full, a bad node is evicted to make space,
+// otherwise the new node is dropped. Returns whether the node is in the table.
+func (rt *RoutingTable) Insert(n *Node) bool {
+	if n.ID == rt.self {
+		return false
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	b := rt.bucketFor(n.ID)
+	for i, existing := range b.nodes {
+		if existing.ID == n.ID {
+			existing.Addr = n.Addr
+			existing.LastSeen = n.LastSeen
+			existing.FailedQueries = 0
+
+			// Move the node to the end of the bucket as it is the most recently seen
+			b.nodes = append(append(b.nodes[:i:i], b.nodes[i+1:]...), existing)
+			b.lastChanged = time.Now()
+			return true
+		}
+	}
+
+	if len(b.nodes) < K_BUCKET_SIZE {
+		b.nodes = append(b.nodes, n)
+		b.lastChanged = time.Now()
+		return true
+	}
+
+	for i, existing := range b.nodes {
+		if existing.IsBad() {
+			b.nodes = append(append(b.nodes[:i:i], b.nodes[i+1:]...), n)
+			b.lastChanged = time.Now()
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarkFailed records that the node did not respond to a query.
+// Nodes that fail too often are removed once they are replaced.
+func (rt *RoutingTable) MarkFailed(id NodeID) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for _, n := range rt.bucketFor(id).nodes {
+		if n.ID == id {
+			n.FailedQueries++
+			return
+		}
+	}
+}
+
+// Remove deletes the node with the given ID from the routing table.
+func (rt *RoutingTable) Remove(id NodeID) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	b := rt.bucketFor(id)
+	for i, n := range b.nodes {
+		if n.ID == id {
+			b.nodes = append(b.nodes[:i], b.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Closest returns up to count nodes from the table that are the closest
+// to the target, sorted by increasing distance. Bad nodes are skipped.
+func (rt *RoutingTable) Closest(target NodeID, count int) []*Node {
+	nodes := rt.Nodes()
+
+	filtered := nodes[:0]
+	for _, n := range nodes {
+		if !n.IsBad() {
+			filtered = append(filtered, n)
+		}
+	}
+	sortByDistance(filtered, target)
+
+	if len(filtered) > count {
+		filtered = filtered[:count]
+	}
+	return filtered
+}
+
+// Nodes returns a copy of all the nodes present in the routing table.
+func (rt *RoutingTable) Nodes() []*Node {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	nodes := make([]*Node, 0)
+	for _, b := range rt.buckets {
+		for _, n := range b.nodes {
+			c := *n
+			nodes = append(nodes, &c)
+		}
+	}
+	return nodes
+}
+
+// Len returns the number of nodes present in the routing table.
+func (rt *RoutingTable) Len() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	total := 0
+	for _, b := range rt.buckets {
+		total += len(b.nodes)
+	}
+	return total
+}
+
+// GoodLen returns the number of good nodes present in the routing table.
+func (rt *RoutingTable) GoodLen() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	total := 0
+	for _, b := range rt.buckets {
+		for _, n := range b.nodes {
+			if n.IsGood() {
+				total++
+			}
+		}
+	}
+	return total
+}
+
+// BucketStats describes the occupancy of a single bucket.
+type BucketStats struct {
+	Index       int // The number of leading bits shared with our ID
+	Nodes       int
+	GoodNodes   int
+	BadNodes    int
+	LastChanged time.Time
+}
+
+// Stats returns the occupancy of all the non-empty buckets.
+func (rt *RoutingTable) Stats() []BucketStats {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	stats := make([]BucketStats, 0)
+	for i, b := range rt.buckets {
+		if len(b.nodes) == 0 {
+			continue
+		}
+
+		s := BucketStats{Index: i, Nodes: len(b.nodes), LastChanged: b.lastChanged}
+		for _, n := range b.nodes {
+			if n.IsGood() {
+				s.GoodNodes++
+			}
+			if n.IsBad() {
+				s.BadNodes++
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func sortByDistance(nodes []*Node, target NodeID) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID.Distance(target).Less(nodes[j].ID.Distance(target))
+	})
+}
@@ -0,0 +1,148 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+// Nodes that we have not heard from for longer than this are
+// not restored from the state file, as they are likely gone.
+const STATE_NODE_MAX_AGE = 24 * time.Hour
+
+// State is the part of a DHT node that is persisted across runs,
+// so that the node does not have to bootstrap from scratch every time.
+type State struct {
+	NodeID     NodeID
+	ExternalIP net.IP // Our external IP as reported by other nodes, if known
+	Nodes      []*Node
+}
+
+// Encode serializes the state as a bencoded dictionary of the form
+// {"id": <node id>, "ip": <external ip>, "nodes": [{"id": <id>, "addr": <compact addr>, "last_seen": <unix time>}, ...]}
+// The compact addresses can be either IPv4 or IPv6 addresses.
+func (s *State) Encode() []byte {
+	nodes := make([]*bencode.BencodeData, 0, len(s.Nodes))
+	for _, n := range s.Nodes {
+		addr := encodeCompactPeer(n.Addr)
+
+		node := bencode.NewBencodeDictionary()
+		node.Add("id", bencode.NewDataString(string(n.ID[:])))
+		node.Add("addr", bencode.NewDataString(string(addr)))
+		node.Add("last_seen", bencode.NewDataInteger(int(n.LastSeen.Unix())))
+		nodes = append(nodes, &bencode.BencodeData{Type: bencode.DictionaryType, Value: node})
+	}
+
+	d := bencode.NewBencodeDictionary()
+	d.Add("id", bencode.NewDataString(string(s.NodeID[:])))
+	if s.ExternalIP != nil {
+		ip := s.ExternalIP.To4()
+		if ip == nil {
+			ip = s.ExternalIP.To16()
+		}
+		d.Add("ip", bencode.NewDataString(string(ip)))
+	}
+	d.Add("nodes", bencode.NewDataList(nodes))
+	return d.Encode()
+}
+
+// DecodeState parses a state previously serialized with Encode.
+// Nodes older than STATE_NODE_MAX_AGE are aged out, and malformed
+// node entries are skipped.
+func DecodeState(data []byte) (*State, error) {
+	bd, err := bencode.NewBencodeData(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state: %w", err)
+	}
+	if bd.Type != bencode.DictionaryType {
+		return nil, fmt.Errorf("expected state to be a dictionary, got %s", bd.Type)
+	}
+	d := bd.GetDictionary()
+
+	s := &State{}
+	if s.NodeID, err = dictNodeID(d, "id"); err != nil {
+		return nil, fmt.Errorf("invalid node ID in state: %w", err)
+	}
+	if ip, err := dictBytes(d, "ip"); err == nil && (len(ip) == net.IPv4len || len(ip) == net.IPv6len) {
+		s.ExternalIP = net.IP(ip)
+	}
+
+	nodes, err := dictList(d, "nodes")
+	if err != nil {
+		return s, nil
+	}
+	for _, item := range nodes.Array {
+		if item.Type != bencode.DictionaryType {
+			continue
+		}
+		entry := item.GetDictionary()
+
+		id, err := dictNodeID(entry, "id")
+		if err != nil {
+			continue
+		}
+		addr, err := dictBytes(entry, "addr")
+		if err != nil || decodeCompactPeer(addr) == nil {
+			continue
+		}
+		lastSeen, err := dictInteger(entry, "last_seen")
+		if err != nil {
+			continue
+		}
+
+		n := NewNode(id, decodeCompactPeer(addr))
+		n.LastSeen = time.Unix(int64(lastSeen), 0)
+		if time.Since(n.LastSeen) > STATE_NODE_MAX_AGE {
+			continue
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+
+	return s, nil
+}
+
+// LoadState reads the state from the file at path. If the file does
+// not exist, a nil state is returned without an error.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	return DecodeState(data)
+}
+
+// State returns the current state of the node. Only the nodes that have
+// not failed any queries recently are included.
+func (d *DHT) State() *State {
+	s := &State{NodeID: d.id, ExternalIP: d.ExternalIP()}
+	for _, n := range append(d.table4.Nodes(), d.table6.Nodes()...) {
+		if n.FailedQueries == 0 {
+			s.Nodes = append(s.Nodes, n)
+		}
+	}
+	return s
+}
+
+// SaveState writes the current state of the node to the file at path.
+func (d *DHT) SaveState(path string) error {
+	err := utils.MakeFileWithData(path, d.State().Encode())
+	if err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// restoreState adds the nodes from a previously saved state to the routing tables.
+func (d *DHT) restoreState(s *State) {
+	for _, n := range s.Nodes {
+		d.insertNode(n)
+	}
+}
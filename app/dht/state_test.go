@@ -0,0 +1,166 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	for _, externalIP := range []net.IP{nil, net.ParseIP("1.2.3.4").To4(), net.ParseIP("2001:db8::1")} {
+		s := &State{
+			NodeID:     RandomNodeID(),
+			ExternalIP: externalIP,
+			Nodes: []*Node{
+				{ID: RandomNodeID(), Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}, LastSeen: now},
+				{ID: RandomNodeID(), Addr: &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 6882}, LastSeen: now.Add(-time.Hour)},
+			},
+		}
+
+		got, err := DecodeState(s.Encode())
+		if err != nil {
+			t.Fatalf("error decoding the state: %v", err)
+		}
+		if got.NodeID != s.NodeID || !got.ExternalIP.Equal(s.ExternalIP) {
+			t.Errorf("expected ID %s and IP %s, got %s and %s", s.NodeID, s.ExternalIP, got.NodeID, got.ExternalIP)
+		}
+		if len(got.Nodes) != len(s.Nodes) {
+			t.Fatalf("expected %d nodes, got %d", len(s.Nodes), len(got.Nodes))
+		}
+		for i, n := range got.Nodes {
+			want := s.Nodes[i]
+			if n.ID != want.ID || n.Addr.String() != want.Addr.String() || !n.LastSeen.Equal(want.LastSeen) {
+				t.Errorf("expected node %s at %s seen %v, got %s at %s seen %v", want.ID, want.Addr, want.LastSeen, n.ID, n.Addr, n.LastSeen)
+			}
+		}
+	}
+}
+
+func TestDecodeStateSkipsStaleAndMalformedNodes(t *testing.T) {
+	id, fresh, other := RandomNodeID(), RandomNodeID(), RandomNodeID()
+	addr := string(encodeCompactPeer(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}))
+	node := func(id string, addr string, lastSeen int64) string {
+		return fmt.Sprintf("d4:addr%d:%s2:id%d:%s9:last_seeni%dee", len(addr), addr, len(id), id, lastSeen)
+	}
+
+	now := time.Now().Unix()
+	stale := now - int64((STATE_NODE_MAX_AGE + time.Hour).Seconds())
+	nodes := []string{
+		node(string(fresh[:]), addr, now),
+		node(string(other[:]), addr, stale),                              // Too old
+		node("short", addr, now),                                         // Invalid ID
+		node(string(other[:]), "bad", now),                               // Invalid address
+		fmt.Sprintf("d4:addr%d:%s2:id20:%se", len(addr), addr, other[:]), // No last_seen
+		"i1e", // Not a dictionary
+	}
+	data := fmt.Sprintf("d2:id20:%s5:nodesl", string(id[:]))
+	for _, n := range nodes {
+		data += n
+	}
+	data += "ee"
+
+	s, err := DecodeState([]byte(data))
+	if err != nil {
+		t.Fatalf("error decoding the state: %v", err)
+	}
+	if s.NodeID != id || s.ExternalIP != nil {
+		t.Errorf("expected ID %s without an IP, got %s and %s", id, s.NodeID, s.ExternalIP)
+	}
+	if len(s.Nodes) != 1 || s.Nodes[0].ID != fresh {
+		t.Errorf("expected only the fresh node to be kept, got %v", s.Nodes)
+	}
+
+	for _, invalid := range []string{"le", "d2:id3:abce", "d2:id20:" + string(id[:])} {
+		if _, err := DecodeState([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for the state %q", invalid)
+		}
+	}
+}
+
+func TestSaveAndLoadStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dht.state")
+	config := Config{Addr: "127.0.0.1:0", BootstrapNodes: []string{}, QueryTimeout: TEST_QUERY_TIMEOUT, StateFile: path}
+
+	// A missing state file starts a new node
+	d, err := New(config)
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	nodes := make([]*Node, 0)
+	for i := range 4 {
+		n := NewNode(RandomNodeID(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 6881})
+		d.table4.Insert(n)
+		nodes = append(nodes, n)
+	}
+	n6 := NewNode(RandomNodeID(), &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 6881})
+	d.table6.Insert(n6)
+
+	// Nodes that failed queries are not saved, and stale nodes are aged out
+	nodes[2].FailedQueries = 1
+	nodes[3].LastSeen = time.Now().Add(-STATE_NODE_MAX_AGE - time.Hour)
+
+	err = d.Close()
+	if err != nil {
+		t.Fatalf("error closing node: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the state file to be written: %v", err)
+	}
+
+	restored, err := New(config)
+	if err != nil {
+		t.Fatalf("error creating node from the state: %v", err)
+	}
+	defer restored.Close()
+	if restored.ID() != d.ID() {
+		t.Errorf("expected the node ID %s to be restored, got %s", d.ID(), restored.ID())
+	}
+	if n := restored.RoutingTable().Len(); n != 2 {
+		t.Errorf("expected 2 IPv4 nodes to be restored, got %d", n)
+	}
+	if n := restored.RoutingTable6().Len(); n != 1 {
+		t.Errorf("expected the IPv6 node to be restored, got %d nodes", n)
+	}
+	for _, n := range restored.RoutingTable().Nodes() {
+		if n.ID == nodes[2].ID || n.ID == nodes[3].ID {
+			t.Errorf("unexpected node %s", n)
+		}
+	}
+}
+
+func TestLoadStateFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := LoadState(filepath.Join(dir, "missing"))
+	if s != nil || err != nil {
+		t.Errorf("expected no state for a missing file, got %v, %v", s, err)
+	}
+
+	path := filepath.Join(dir, "corrupt")
+	os.WriteFile(path, []byte("d2:id"), 0644)
+	_, err = New(Config{Addr: "127.0.0.1:0", StateFile: path})
+	if err == nil {
+		t.Error("expected an error for a corrupt state file")
+	}
+
+	// A saved ID that is not valid for the external IP is replaced
+	ip := net.ParseIP("124.31.75.21")
+	saved := &State{NodeID: NodeIDForIP(ip), ExternalIP: ip.To4()}
+	saved.NodeID[0] ^= 0xff
+	path = filepath.Join(dir, "state")
+	os.WriteFile(path, saved.Encode(), 0644)
+	d, err := New(Config{Addr: "127.0.0.1:0", BootstrapNodes: []string{}, StateFile: path})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	defer d.Close()
+	if d.ID() == saved.NodeID || !IsNodeIDSecure(d.ID(), ip) {
+		t.Errorf("expected a new ID that is valid for %s, got %s", ip, d.ID())
+	}
+	if !d.ExternalIP().Equal(ip) {
+		t.Errorf("expected the external IP %s to be restored, got %s", ip, d.ExternalIP())
+	}
+}
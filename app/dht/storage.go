@@ -0,0 +1,125 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+var ErrItemNotFound = errors.New("item not found")
+
+// GetImmutable searches the DHT for the immutable item stored under the target.
+func (d *DHT) GetImmutable(target NodeID) (*Item, error) {
+	res, err := d.lookupAll(target, QUERY_GET)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range res.items {
+		if item.IsMutable() {
+			continue
+		}
+		if err := item.Verify(target); err == nil {
+			return item, nil
+		}
+	}
+	return nil, ErrItemNotFound
+}
+
+// GetMutable searches the DHT for the mutable item published with the
+// public key and salt, and returns the valid item with the highest sequence number.
+func (d *DHT) GetMutable(publicKey ed25519.PublicKey, salt []byte) (*Item, error) {
+	target := MutableItemTarget(publicKey, salt)
+	res, err := d.lookupAll(target, QUERY_GET)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Item
+	for _, item := range res.items {
+		if !item.IsMutable() {
+			continue
+		}
+
+		// The salt is not part of the response, so it has to be added back before verifying
+		item.Salt = salt
+		if err := item.Verify(target); err != nil {
+			continue
+		}
+		if latest == nil || item.Seq > latest.Seq {
+			latest = item
+		}
+	}
+
+	if latest == nil {
+		return nil, ErrItemNotFound
+	}
+	return latest, nil
+}
+
+// Put stores the item on the nodes closest to its target, and returns the target.
+func (d *DHT) Put(item *Item) (NodeID, error) {
+	return d.put(item, 0, false)
+}
+
+// PutCAS stores the mutable item like Put, but the nodes only accept it
+// if the sequence number of the item they currently hold is equal to cas.
+// This prevents concurrent writers from overwriting each other's updates.
+func (d *DHT) PutCAS(item *Item, cas int) (NodeID, error) {
+	if !item.IsMutable() {
+		return NodeID{}, fmt.Errorf("compare and swap is only supported for mutable items")
+	}
+	return d.put(item, cas, true)
+}
+
+func (d *DHT) put(item *Item, cas int, useCAS bool) (NodeID, error) {
+	target := item.Target()
+	if err := item.Verify(target); err != nil {
+		return target, fmt.Errorf("invalid item: %w", err)
+	}
+
+	// A get lookup is used to find the closest nodes and collect their write tokens
+	res, err := d.lookupAll(target, QUERY_GET)
+	if err != nil {
+		return target, err
+	}
+
+	// Check the mutable items already present in the network, as nodes that
+	// do not have the current item yet would accept an outdated one
+	if item.IsMutable() {
+		for _, existing := range res.items {
+			existing.Salt = item.Salt
+			if !existing.IsMutable() || existing.Verify(target) != nil {
+				continue
+			}
+			if useCAS && existing.Seq != cas {
+				return target, newKRPCError(ERROR_CAS_MISMATCH, "the CAS hash mismatched, re-read value and try again")
+			}
+			if existing.Seq > item.Seq {
+				return target, newKRPCError(ERROR_SEQ_TOO_LOW, "sequence number less than current")
+			}
+		}
+	}
+
+	stored := 0
+	var lastErr error
+	for _, n := range res.closest {
+		token, ok := res.tokens[n.Addr.String()]
+		if !ok {
+			continue
+		}
+		if err := d.putItem(n.Addr, item, token, cas, useCAS); err != nil {
+			lastErr = err
+			continue
+		}
+		stored++
+	}
+
+	if stored == 0 {
+		if lastErr != nil {
+			return target, fmt.Errorf("no node stored the item: %w", lastErr)
+		}
+		return target, fmt.Errorf("no node stored the item")
+	}
+	return target, nil
+}
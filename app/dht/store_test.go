@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// testPeerAddr returns a distinct address for every i.
+func testPeerAddr(i int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 6881}
+}
+
+// agePeers makes all the announcements of the info hash expired.
+func agePeers(ps *peerStore, infoHash NodeID) {
+	for addr := range ps.peers[infoHash] {
+		ps.peers[infoHash][addr] = time.Now().Add(-PEER_ANNOUNCE_TTL - time.Minute)
+	}
+}
+
+func TestPeerStoreCap(t *testing.T) {
+	ps := newPeerStore()
+	old, recent := RandomNodeID(), RandomNodeID()
+
+	for i := range MAX_STORED_PEERS {
+		infoHash := recent
+		if i%2 == 0 {
+			infoHash = old
+		}
+		if !ps.Add(infoHash, testPeerAddr(i)) {
+			t.Fatalf("expected peer %d to be stored", i)
+		}
+	}
+
+	if ps.Add(RandomNodeID(), testPeerAddr(MAX_STORED_PEERS)) {
+		t.Fatal("expected a new peer to be rejected once the store is full")
+	}
+	if !ps.Add(recent, testPeerAddr(1)) {
+		t.Fatal("expected the announcement of a stored peer to be refreshed")
+	}
+
+	// The expired announcements make room for new ones
+	agePeers(ps, old)
+	if !ps.Add(RandomNodeID(), testPeerAddr(MAX_STORED_PEERS)) {
+		t.Fatal("expected a new peer to be stored once the others expired")
+	}
+	if _, ok := ps.peers[old]; ok {
+		t.Error("expected the info hash without peers to be removed")
+	}
+	if ps.count != MAX_STORED_PEERS/2+1 {
+		t.Errorf("expected %d stored peers, got %d", MAX_STORED_PEERS/2+1, ps.count)
+	}
+}
+
+func TestPeerStoreRemovesExpiredInfoHash(t *testing.T) {
+	ps := newPeerStore()
+	infoHash := RandomNodeID()
+	ps.Add(infoHash, testPeerAddr(1))
+	ps.Add(infoHash, testPeerAddr(2))
+
+	agePeers(ps, infoHash)
+	if peers := ps.Get(infoHash, false); len(peers) != 0 {
+		t.Fatalf("expected the expired peers to be removed, got %d", len(peers))
+	}
+	if len(ps.peers) != 0 || ps.count != 0 {
+		t.Errorf("expected an empty store, got %d info hashes and %d peers", len(ps.peers), ps.count)
+	}
+}
+
+func TestItemStoreCap(t *testing.T) {
+	s := newItemStore()
+	items := make([]*Item, 0, MAX_STORED_ITEMS+1)
+	for i := range MAX_STORED_ITEMS + 1 {
+		v, err := bencode.NewBencodeData([]byte(fmt.Sprintf("i%de", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err := NewImmutableItem(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+
+	for _, item := range items[:MAX_STORED_ITEMS] {
+		if err := s.Put(item, 0, false); err != nil {
+			t.Fatalf("error storing item: %v", err)
+		}
+	}
+	if err := s.Put(items[MAX_STORED_ITEMS], 0, false); err == nil {
+		t.Fatal("expected a new item to be rejected once the store is full")
+	}
+	if err := s.Put(items[0], 0, false); err != nil {
+		t.Fatalf("expected a stored item to be refreshed, got %v", err)
+	}
+
+	// An expired item makes room for a new one
+	s.items[items[1].Target()].storedAt = time.Now().Add(-ITEM_TTL - time.Minute)
+	if err := s.Put(items[MAX_STORED_ITEMS], 0, false); err != nil {
+		t.Fatalf("expected a new item to be stored once another expired, got %v", err)
+	}
+	if s.Get(items[1].Target()) != nil {
+		t.Error("expected the expired item to be removed")
+	}
+	if len(s.items) != MAX_STORED_ITEMS {
+		t.Errorf("expected %d stored items, got %d", MAX_STORED_ITEMS, len(s.items))
+	}
+}
@@ -0,0 +1,77 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"net"
+	"sync"
+	"time"
+)
+
+const TOKEN_SECRET_LENGTH = 16
+const TOKEN_LENGTH = 8
+
+// tokenManager hands out the write tokens returned in get_peers responses.
+// A token is the hash of the querying node's IP and a secret that changes
+// every TOKEN_ROTATION_INTERVAL. Tokens made with the previous secret are
+// still accepted, so a token stays valid for up to two rotation intervals.
+type tokenManager struct {
+	mu             sync.Mutex
+	secret         []byte
+	previousSecret []byte
+	lastRotation   time.Time
+}
+
+func newTokenManager() *tokenManager {
+	tm := &tokenManager{}
+	tm.secret = newTokenSecret()
+	tm.previousSecret = tm.secret
+	tm.lastRotation = time.Now()
+	return tm
+}
+
+func newTokenSecret() []byte {
+	s := make([]byte, TOKEN_SECRET_LENGTH)
+	_, _ = rand.Read(s)
+	return s
+}
+
+func (tm *tokenManager) rotateIfNeeded() {
+	if time.Since(tm.lastRotation) < TOKEN_ROTATION_INTERVAL {
+		return
+	}
+	tm.previousSecret = tm.secret
+	tm.secret = newTokenSecret()
+	tm.lastRotation = time.Now()
+}
+
+func makeToken(secret []byte, ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	h := sha1.New()
+	h.Write(secret)
+	h.Write(ip)
+	return h.Sum(nil)[:TOKEN_LENGTH]
+}
+
+// Token returns a write token for the node with the given IP.
+func (tm *tokenManager) Token(ip net.IP) []byte {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.rotateIfNeeded()
+	return makeToken(tm.secret, ip)
+}
+
+// Validate reports whether the token was handed out to the given IP.
+func (tm *tokenManager) Validate(token []byte, ip net.IP) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.rotateIfNeeded()
+	return bytes.Equal(token, makeToken(tm.secret, ip)) ||
+		bytes.Equal(token, makeToken(tm.previousSecret, ip))
+}
@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// Torrents can be updated by publishing a mutable item whose value
+// is the dictionary {"ih": <info hash>} under a fixed public key (BEP 46).
+// Magnet links of the form magnet:?xs=urn:btpk:<public key> then always
+// resolve to the latest version of the torrent.
+
+// ResolveTorrentUpdate returns the info hash currently published with
+// the public key and salt, along with the sequence number of the item.
+func (d *DHT) ResolveTorrentUpdate(publicKey ed25519.PublicKey, salt []byte) ([]byte, int, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, 0, fmt.Errorf("invalid public key length %d", len(publicKey))
+	}
+
+	item, err := d.GetMutable(publicKey, salt)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting mutable item: %w", err)
+	}
+
+	if item.Value.Type != bencode.DictionaryType {
+		return nil, 0, fmt.Errorf("expected item value to be a dictionary, got %s", item.Value.Type)
+	}
+	infoHash, err := dictBytes(item.Value.GetDictionary(), "ih")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid torrent update: %w", err)
+	}
+	if len(infoHash) != NODE_ID_LENGTH {
+		return nil, 0, fmt.Errorf("invalid info hash length %d", len(infoHash))
+	}
+
+	return infoHash, item.Seq, nil
+}
+
+// PublishTorrentUpdate publishes the info hash as the latest version of the
+// torrent for the key and salt, and returns the sequence number used. The
+// sequence number of the currently published item is incremented, and
+// compare and swap is used so that concurrent updates are not lost.
+func (d *DHT) PublishTorrentUpdate(key ed25519.PrivateKey, salt []byte, infoHash []byte) (int, error) {
+	if len(infoHash) != NODE_ID_LENGTH {
+		return 0, fmt.Errorf("invalid info hash length %d", len(infoHash))
+	}
+
+	v := bencode.NewBencodeDictionary()
+	v.Add("ih", bencode.NewDataString(string(infoHash)))
+	value := &bencode.BencodeData{Type: bencode.DictionaryType, Value: v}
+
+	seq := 0
+	current, err := d.GetMutable(key.Public().(ed25519.PublicKey), salt)
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return 0, fmt.Errorf("error getting the current item: %w", err)
+	}
+	if current != nil {
+		seq = current.Seq + 1
+	}
+
+	item, err := NewMutableItem(value, key, salt, seq)
+	if err != nil {
+		return 0, err
+	}
+
+	if current != nil {
+		_, err = d.PutCAS(item, current.Seq)
+	} else {
+		_, err = d.Put(item)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error storing the item: %w", err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,15 @@
+package lsd
+
+import "time"
+
+// The multicast groups used for local service discovery (BEP 14).
+const IPV4_GROUP_ADDR = "239.192.152.143:6771"
+const IPV6_GROUP_ADDR = "[ff15::efc0:988f]:6771"
+
+// Announces are repeated every ANNOUNCE_INTERVAL, and an info hash must
+// not be announced more than once every MIN_ANNOUNCE_INTERVAL.
+const ANNOUNCE_INTERVAL = 5 * time.Minute
+const MIN_ANNOUNCE_INTERVAL = time.Minute
+
+// The maximum size of an announce that we are willing to read.
+const MAX_PACKET_SIZE = 1400
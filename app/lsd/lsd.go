@@ -0,0 +1,312 @@
+package lsd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("lsd is closed")
+
+// Config holds the options used to create a new LSD instance.
+type Config struct {
+	Port      int            // The port we accept BitTorrent connections on
+	Interface *net.Interface // The interface to send and receive announces on, the system default if nil
+
+	IPv4Group string // Defaults to IPV4_GROUP_ADDR
+	IPv6Group string // Defaults to IPV6_GROUP_ADDR
+
+	// Loopback delivers our announces to the other sockets on this host,
+	// so that multiple clients on a single machine can discover each other.
+	Loopback bool
+}
+
+type groupConn struct {
+	conn  *net.UDPConn
+	group *net.UDPAddr
+}
+
+type torrent struct {
+	infoHash      []byte
+	lastAnnounced time.Time
+	peers         []string
+}
+
+// LSD implements local service discovery (BEP 14). It announces our torrents
+// to the multicast groups on the local network, and collects the peers that
+// announce the same torrents.
+type LSD struct {
+	config Config
+	cookie string
+	conns  []*groupConn
+	logger *log.Logger
+
+	mu       sync.Mutex
+	torrents map[string]*torrent // Keyed by the hex encoded info hash
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New joins the IPv4 and IPv6 multicast groups, and starts listening for
+// announces. Joining the IPv6 group is allowed to fail, as many networks
+// do not support it.
+func New(config Config) (*LSD, error) {
+	if config.IPv4Group == "" {
+		config.IPv4Group = IPV4_GROUP_ADDR
+	}
+	if config.IPv6Group == "" {
+		config.IPv6Group = IPV6_GROUP_ADDR
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", config.Port)
+	}
+
+	cookie := make([]byte, 8)
+	if _, err := rand.Read(cookie); err != nil {
+		return nil, fmt.Errorf("error generating cookie: %w", err)
+	}
+
+	l := &LSD{
+		config:   config,
+		cookie:   hex.EncodeToString(cookie),
+		logger:   log.New(log.Writer(), "[LSD] ", 0),
+		torrents: make(map[string]*torrent),
+		closed:   make(chan struct{}),
+	}
+
+	gc, err := l.joinGroup("udp4", config.IPv4Group)
+	if err != nil {
+		return nil, err
+	}
+	l.conns = append(l.conns, gc)
+
+	gc, err = l.joinGroup("udp6", config.IPv6Group)
+	if err != nil {
+		l.Log("not using IPv6: %v", err)
+	} else {
+		l.conns = append(l.conns, gc)
+	}
+
+	for _, gc := range l.conns {
+		go l.readLoop(gc)
+	}
+	go l.announceLoop()
+
+	return l, nil
+}
+
+func (l *LSD) joinGroup(network, addr string) (*groupConn, error) {
+	group, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast group %s: %w", addr, err)
+	}
+
+	conn, err := net.ListenMulticastUDP(network, l.config.Interface, group)
+	if err != nil {
+		return nil, fmt.Errorf("error joining multicast group %s: %w", addr, err)
+	}
+
+	if l.config.Loopback {
+		err = enableMulticastLoopback(conn, network == "udp6")
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error enabling multicast loopback: %w", err)
+		}
+	}
+
+	return &groupConn{conn: conn, group: group}, nil
+}
+
+func (l *LSD) Log(s string, vals ...any) {
+	l.logger.Printf(s+"\n", vals...)
+}
+
+// Close stops listening for announces and leaves the multicast groups.
+func (l *LSD) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		for _, gc := range l.conns {
+			gc.conn.Close()
+		}
+	})
+	return nil
+}
+
+// Announce adds the info hash to the announced torrents and sends an
+// announce for it, unless it was announced less than MIN_ANNOUNCE_INTERVAL ago.
+// The torrent is then re-announced every ANNOUNCE_INTERVAL until Close is called.
+func (l *LSD) Announce(infoHash []byte) error {
+	if len(infoHash) != 20 {
+		return fmt.Errorf("invalid info hash length %d", len(infoHash))
+	}
+
+	select {
+	case <-l.closed:
+		return ErrClosed
+	default:
+	}
+
+	key := hex.EncodeToString(infoHash)
+	l.mu.Lock()
+	t, ok := l.torrents[key]
+	if !ok {
+		t = &torrent{infoHash: infoHash, peers: make([]string, 0)}
+		l.torrents[key] = t
+	}
+	if time.Since(t.lastAnnounced) < MIN_ANNOUNCE_INTERVAL {
+		l.mu.Unlock()
+		return nil
+	}
+	t.lastAnnounced = time.Now()
+	l.mu.Unlock()
+
+	return l.send([][]byte{infoHash})
+}
+
+// Peers returns the addresses of the peers that announced the info hash.
+// Only the announces for torrents that we announced ourselves are recorded.
+func (l *LSD) Peers(infoHash []byte) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.torrents[hex.EncodeToString(infoHash)]
+	if !ok {
+		return []string{}
+	}
+
+	peers := make([]string, len(t.peers))
+	copy(peers, t.peers)
+	return peers
+}
+
+// Lookup announces the info hash, and returns the peers that
+// announced it within the wait duration.
+func (l *LSD) Lookup(infoHash []byte, wait time.Duration) ([]string, error) {
+	err := l.Announce(infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+	return l.Peers(infoHash), nil
+}
+
+// send sends one announce with all the info hashes to every multicast group.
+// It only fails if the announce could not be sent to any of the groups.
+func (l *LSD) send(infoHashes [][]byte) error {
+	var lastErr error
+	sent := 0
+
+	for _, gc := range l.conns {
+		a := announce{
+			Host:       gc.group.String(),
+			Port:       l.config.Port,
+			InfoHashes: infoHashes,
+			Cookie:     l.cookie,
+		}
+		_, err := gc.conn.WriteToUDP(a.Bytes(), gc.group)
+		if err != nil {
+			lastErr = fmt.Errorf("error sending announce to %s: %w", gc.group, err)
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (l *LSD) announceLoop() {
+	ticker := time.NewTicker(ANNOUNCE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		infoHashes := make([][]byte, 0)
+		for _, t := range l.torrents {
+			if time.Since(t.lastAnnounced) < MIN_ANNOUNCE_INTERVAL {
+				continue
+			}
+			t.lastAnnounced = time.Now()
+			infoHashes = append(infoHashes, t.infoHash)
+		}
+		l.mu.Unlock()
+
+		if len(infoHashes) == 0 {
+			continue
+		}
+		if err := l.send(infoHashes); err != nil {
+			l.Log("%v", err)
+		}
+	}
+}
+
+func (l *LSD) readLoop(gc *groupConn) {
+	buf := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, addr, err := gc.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			l.Log("error reading packet: %v", err)
+			continue
+		}
+
+		a, err := parseAnnounce(buf[:n])
+		if err != nil {
+			l.Log("invalid announce from %s: %v", addr, err)
+			continue
+		}
+		if a.Cookie == l.cookie {
+			continue
+		}
+
+		peer := (&net.UDPAddr{IP: addr.IP, Port: a.Port, Zone: addr.Zone}).String()
+		l.addPeer(a.InfoHashes, peer)
+	}
+}
+
+func (l *LSD) addPeer(infoHashes [][]byte, peer string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, infoHash := range infoHashes {
+		t, ok := l.torrents[hex.EncodeToString(infoHash)]
+		if !ok {
+			continue
+		}
+
+		known := false
+		for _, p := range t.peers {
+			if p == peer {
+				known = true
+				break
+			}
+		}
+		if !known {
+			t.peers = append(t.peers, peer)
+			l.Log("found peer %s for info hash %x", peer, infoHash)
+		}
+	}
+}
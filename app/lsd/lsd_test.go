@@ -0,0 +1,126 @@
+package lsd
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A group on a port other than the one of BEP 14, so that the tests don't
+// exchange announces with the clients running on the host.
+const TEST_GROUP_ADDR = "239.192.152.143:16771"
+const TEST_WAIT = 2 * time.Second
+
+func newTestLSD(t *testing.T, port int) *LSD {
+	t.Helper()
+
+	l, err := New(Config{
+		Port:      port,
+		IPv4Group: TEST_GROUP_ADDR,
+		IPv6Group: "[ff15::efc0:988f]:16771",
+		Loopback:  true,
+	})
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// waitForPeers waits until the instance has recorded the expected number
+// of peers for the info hash, or TEST_WAIT has passed.
+func waitForPeers(l *LSD, infoHash []byte, count int) []string {
+	deadline := time.Now().Add(TEST_WAIT)
+	for time.Now().Before(deadline) {
+		if peers := l.Peers(infoHash); len(peers) >= count {
+			return peers
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return l.Peers(infoHash)
+}
+
+// checkPeerPorts checks that all the peers have the port. A peer can be
+// found on both the IPv4 and the IPv6 group.
+func checkPeerPorts(t *testing.T, peers []string, port int) {
+	t.Helper()
+
+	if len(peers) == 0 {
+		t.Fatalf("expected peers on port %d, got none", port)
+	}
+	for _, peer := range peers {
+		_, p, err := net.SplitHostPort(peer)
+		if err != nil {
+			t.Fatalf("invalid peer address %q: %v", peer, err)
+		}
+		if p != strconv.Itoa(port) {
+			t.Errorf("expected peers on port %d, got %s", port, peer)
+		}
+	}
+}
+
+func TestDiscoverOverLoopback(t *testing.T) {
+	a := newTestLSD(t, 6881)
+	b := newTestLSD(t, 6882)
+	infoHash := []byte("01234567890123456789")
+
+	err := a.Announce(infoHash)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	err = b.Announce(infoHash)
+	if err != nil {
+		t.Fatalf("error announcing: %v", err)
+	}
+
+	checkPeerPorts(t, waitForPeers(a, infoHash, 1), 6882)
+
+	// b only records announces once it announced the torrent itself, which
+	// was after the announce of a, so a announces again without the minimum
+	// interval that Announce enforces
+	err = a.send([][]byte{infoHash})
+	if err != nil {
+		t.Fatalf("error announcing: %v", err)
+	}
+	checkPeerPorts(t, waitForPeers(b, infoHash, 1), 6881)
+
+	// The announces looped back to their sender are ignored with the cookie,
+	// so each instance only knows about the other one
+	time.Sleep(100 * time.Millisecond)
+	checkPeerPorts(t, a.Peers(infoHash), 6882)
+	checkPeerPorts(t, b.Peers(infoHash), 6881)
+}
+
+func TestIgnoresOwnCookie(t *testing.T) {
+	a := newTestLSD(t, 6881)
+	infoHash := []byte("abcdefghijabcdefghij")
+
+	err := a.Announce(infoHash)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	if peers := waitForPeers(a, infoHash, 1); len(peers) != 0 {
+		t.Fatalf("expected our own announce to be ignored, got %v", peers)
+	}
+}
+
+func TestIgnoresUnknownTorrents(t *testing.T) {
+	a := newTestLSD(t, 6881)
+	b := newTestLSD(t, 6882)
+	announced := []byte("01234567890123456789")
+	other := []byte("abcdefghijabcdefghij")
+
+	err := a.Announce(announced)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	err = b.send([][]byte{other})
+	if err != nil {
+		t.Fatalf("error announcing: %v", err)
+	}
+
+	if peers := waitForPeers(a, other, 1); len(peers) != 0 {
+		t.Errorf("expected announces of torrents that we don't have to be ignored, got %v", peers)
+	}
+}
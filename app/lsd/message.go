@@ -0,0 +1,77 @@
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// announce is a BT-SEARCH message, which is an HTTP-like request
+// sent to the multicast group to advertise the torrents we have.
+type announce struct {
+	Host       string   // The multicast group the announce is sent to
+	Port       int      // The port we accept BitTorrent connections on
+	InfoHashes [][]byte // Multiple info hashes can be announced in one message
+	Cookie     string   // Used to ignore our own announces
+}
+
+func (a *announce) Bytes() []byte {
+	var b bytes.Buffer
+
+	b.WriteString("BT-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "Host: %s\r\n", a.Host)
+	fmt.Fprintf(&b, "Port: %d\r\n", a.Port)
+	for _, infoHash := range a.InfoHashes {
+		fmt.Fprintf(&b, "Infohash: %x\r\n", infoHash)
+	}
+	if a.Cookie != "" {
+		fmt.Fprintf(&b, "cookie: %s\r\n", a.Cookie)
+	}
+	b.WriteString("\r\n\r\n")
+
+	return b.Bytes()
+}
+
+func parseAnnounce(data []byte) (*announce, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading request line: %w", err)
+	}
+	if !strings.HasPrefix(line, "BT-SEARCH * HTTP/") {
+		return nil, fmt.Errorf("invalid request line: %q", line)
+	}
+
+	headers, err := r.ReadMIMEHeader()
+	if err != nil && len(headers) == 0 {
+		return nil, fmt.Errorf("error reading headers: %w", err)
+	}
+
+	a := &announce{
+		Host:   headers.Get("Host"),
+		Cookie: headers.Get("Cookie"),
+	}
+
+	a.Port, err = strconv.Atoi(headers.Get("Port"))
+	if err != nil || a.Port <= 0 || a.Port > 65535 {
+		return nil, fmt.Errorf("invalid port: %q", headers.Get("Port"))
+	}
+
+	for _, v := range headers.Values("Infohash") {
+		infoHash, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(infoHash) != 20 {
+			return nil, fmt.Errorf("invalid info hash: %q", v)
+		}
+		a.InfoHashes = append(a.InfoHashes, infoHash)
+	}
+	if len(a.InfoHashes) == 0 {
+		return nil, fmt.Errorf("no info hash in announce")
+	}
+
+	return a, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package lsd
+
+import (
+	"fmt"
+	"net"
+)
+
+func enableMulticastLoopback(conn *net.UDPConn, ipv6 bool) error {
+	return fmt.Errorf("multicast loopback is not supported on this platform")
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package lsd
+
+import (
+	"net"
+	"syscall"
+)
+
+// enableMulticastLoopback makes the announces that we send visible to the
+// other sockets on this host, which net.ListenMulticastUDP disables.
+func enableMulticastLoopback(conn *net.UDPConn, ipv6 bool) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
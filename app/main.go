@@ -11,20 +11,30 @@ type HandlerFn func(args []string)
 
 var handlers = map[string]HandlerFn{
 	"decode":                cmd.HandleDecode,
+	"encode":                cmd.HandleEncode,
 	"info":                  cmd.HandleInfo,
 	"peers":                 cmd.HandlePeers,
 	"handshake":             cmd.HandleHandshake,
 	"download_piece":        cmd.HandleDownloadPiece,
 	"download":              cmd.HandleDownload,
+	"seed":                  cmd.HandleSeed,
 	"magnet_parse":          cmd.HandleMagnetParse,
 	"magnet_handshake":      cmd.HandleMagnetHandshake,
 	"magnet_info":           cmd.HandleMagnetInfo,
 	"magnet_download_piece": cmd.HandleMagnetDownloadPiece,
 	"magnet_download":       cmd.HandleMagnetDownload,
+	"dht":                   cmd.HandleDHT,
+	"create":                cmd.HandleCreate,
+	"lint":                  cmd.HandleLint,
+	"torrent":               cmd.HandleTorrent,
 }
 
 func main() {
-	args := os.Args[1:]
+	args, err := cmd.ParseOptions(os.Args[1:])
+	if err != nil {
+		fmt.Printf("error parsing options: %v\n", err)
+		return
+	}
 
 	if len(args) == 0 {
 		fmt.Printf("no arguments provided")
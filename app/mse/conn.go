@@ -0,0 +1,70 @@
+package mse
+
+import (
+	"bufio"
+	"crypto/rc4"
+	"net"
+	"sync"
+)
+
+// Conn is a connection after the MSE handshake. Depending on the selected
+// crypto method, the data is RC4 encrypted or sent as plaintext.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader // Holds the bytes read past the handshake
+
+	// The initial payload of the other end, which was already decrypted
+	initial []byte
+
+	method   uint32
+	infoHash []byte
+	enc      *rc4.Cipher // nil if the stream is not encrypted
+	dec      *rc4.Cipher
+
+	writeMu sync.Mutex
+}
+
+// Method returns the crypto method used for the stream, CRYPTO_PLAINTEXT
+// for plaintext connections that skipped the MSE handshake.
+func (c *Conn) Method() uint32 {
+	return c.method
+}
+
+// Encrypted reports whether the stream is RC4 encrypted.
+func (c *Conn) Encrypted() bool {
+	return c.method == CRYPTO_RC4
+}
+
+// InfoHash returns the info hash used as the shared key in the handshake,
+// nil for plaintext connections that skipped it.
+func (c *Conn) InfoHash() []byte {
+	return c.infoHash
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.initial) > 0 {
+		n := copy(b, c.initial)
+		c.initial = c.initial[n:]
+		return n, nil
+	}
+
+	n, err := c.r.Read(b)
+	if c.dec != nil {
+		c.dec.XORKeyStream(b[:n], b[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(b)
+	}
+
+	// The keystream must be applied in the order the bytes are sent
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	data := make([]byte, len(b))
+	c.enc.XORKeyStream(data, b)
+	return c.Conn.Write(data)
+}
@@ -0,0 +1,32 @@
+package mse
+
+import (
+	"math/big"
+	"time"
+)
+
+// The Diffie-Hellman parameters of the key exchange. P is a 768 bit prime.
+var P, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+var G = big.NewInt(2)
+
+const KEY_LENGTH = 96         // The length of the public keys and the shared secret, in bytes
+const PRIVATE_KEY_LENGTH = 20 // 160 bits are enough for the private keys
+const MAX_PAD_LENGTH = 512
+
+// The crypto methods that can be provided and selected, as bit flags.
+const CRYPTO_PLAINTEXT uint32 = 0x01
+const CRYPTO_RC4 uint32 = 0x02
+
+// The number of bytes of the RC4 keystream that are discarded, as
+// the first bytes are known to leak information about the key.
+const RC4_DISCARD = 1024
+
+const HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// The verification constant, which is sent encrypted so that the other
+// end can find the start of the encrypted stream after the padding.
+var VC = make([]byte, 8)
+
+// The start of a plaintext BitTorrent handshake, used to tell
+// unencrypted inbound connections apart.
+var PLAINTEXT_PROTOCOL_HEADER = append([]byte{19}, "BitTorrent protocol"...)
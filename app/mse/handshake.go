@@ -0,0 +1,271 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var ErrNoCommonMethod = errors.New("no common crypto method")
+var ErrUnknownInfoHash = errors.New("unknown info hash")
+var ErrPlaintextNotAllowed = errors.New("plaintext connections are not allowed")
+
+// Initiate performs the MSE handshake as the connecting side (A). The info
+// hash of the torrent is used as the shared key, and the initial payload,
+// usually our BitTorrent handshake, is sent in the handshake itself.
+// The policy decides the crypto methods we offer to the other end.
+func Initiate(conn net.Conn, infoHash []byte, initialPayload []byte, policy Policy) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+
+	// 1. A->B: Diffie Hellman Ya, PadA
+	kp, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	pad, err := randomPad()
+	if err != nil {
+		return nil, fmt.Errorf("error generating padding: %w", err)
+	}
+	if _, err := conn.Write(append(kp.public, pad...)); err != nil {
+		return nil, fmt.Errorf("error sending public key: %w", err)
+	}
+
+	// 2. B->A: Diffie Hellman Yb, PadB
+	yb := make([]byte, KEY_LENGTH)
+	if _, err := io.ReadFull(r, yb); err != nil {
+		return nil, fmt.Errorf("error reading public key: %w", err)
+	}
+	secret, err := kp.sharedSecret(yb)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. A->B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S),
+	// ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+	enc := newCipher("keyA", secret, infoHash)
+	dec := newCipher("keyB", secret, infoHash)
+
+	var msg bytes.Buffer
+	msg.Write(hash([]byte("req1"), secret))
+	msg.Write(xor(hash([]byte("req2"), infoHash), hash([]byte("req3"), secret)))
+
+	var plain bytes.Buffer
+	plain.Write(VC)
+	binary.Write(&plain, binary.BigEndian, policy.provide())
+	binary.Write(&plain, binary.BigEndian, uint16(0)) // No PadC
+	binary.Write(&plain, binary.BigEndian, uint16(len(initialPayload)))
+	plain.Write(initialPayload)
+
+	encrypted := make([]byte, plain.Len())
+	enc.XORKeyStream(encrypted, plain.Bytes())
+	msg.Write(encrypted)
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return nil, fmt.Errorf("error sending crypto provide: %w", err)
+	}
+
+	// 4. B->A: ENCRYPT(VC, crypto_select, len(padD), padD)
+	// The encrypted VC marks the end of PadB
+	encryptedVC := make([]byte, len(VC))
+	dec.XORKeyStream(encryptedVC, VC)
+	if err := synchronize(r, encryptedVC, MAX_PAD_LENGTH); err != nil {
+		return nil, fmt.Errorf("error finding verification constant: %w", err)
+	}
+
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading crypto select: %w", err)
+	}
+	dec.XORKeyStream(header, header)
+	method := binary.BigEndian.Uint32(header)
+	padLength := binary.BigEndian.Uint16(header[4:])
+	if method&policy.provide() == 0 || (method != CRYPTO_RC4 && method != CRYPTO_PLAINTEXT) {
+		return nil, fmt.Errorf("%w: selected %d", ErrNoCommonMethod, method)
+	}
+	if padLength > MAX_PAD_LENGTH {
+		return nil, fmt.Errorf("invalid padding length %d", padLength)
+	}
+	padD := make([]byte, padLength)
+	if _, err := io.ReadFull(r, padD); err != nil {
+		return nil, fmt.Errorf("error reading padding: %w", err)
+	}
+	dec.XORKeyStream(padD, padD)
+
+	return newConn(conn, r, method, infoHash, enc, dec, nil), nil
+}
+
+// Receive performs the MSE handshake as the accepting side (B). The info
+// hash chosen by the other end must be one of infoHashes. The policy decides
+// the crypto methods that we accept.
+func Receive(conn net.Conn, infoHashes [][]byte, policy Policy) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+	return receive(conn, bufio.NewReader(conn), infoHashes, policy)
+}
+
+func receive(conn net.Conn, r *bufio.Reader, infoHashes [][]byte, policy Policy) (*Conn, error) {
+	// 1. A->B: Diffie Hellman Ya, PadA
+	ya := make([]byte, KEY_LENGTH)
+	if _, err := io.ReadFull(r, ya); err != nil {
+		return nil, fmt.Errorf("error reading public key: %w", err)
+	}
+	kp, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := kp.sharedSecret(ya)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. B->A: Diffie Hellman Yb, PadB
+	pad, err := randomPad()
+	if err != nil {
+		return nil, fmt.Errorf("error generating padding: %w", err)
+	}
+	if _, err := conn.Write(append(kp.public, pad...)); err != nil {
+		return nil, fmt.Errorf("error sending public key: %w", err)
+	}
+
+	// 3. A->B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S),
+	// ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+	// HASH('req1', S) marks the end of PadA
+	if err := synchronize(r, hash([]byte("req1"), secret), MAX_PAD_LENGTH); err != nil {
+		return nil, fmt.Errorf("error finding request hash: %w", err)
+	}
+
+	skeyHash := make([]byte, 20)
+	if _, err := io.ReadFull(r, skeyHash); err != nil {
+		return nil, fmt.Errorf("error reading info hash: %w", err)
+	}
+	skeyHash = xor(skeyHash, hash([]byte("req3"), secret))
+	var infoHash []byte
+	for _, ih := range infoHashes {
+		if bytes.Equal(hash([]byte("req2"), ih), skeyHash) {
+			infoHash = ih
+			break
+		}
+	}
+	if infoHash == nil {
+		return nil, ErrUnknownInfoHash
+	}
+
+	dec := newCipher("keyA", secret, infoHash)
+	enc := newCipher("keyB", secret, infoHash)
+
+	header := make([]byte, 14)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading crypto provide: %w", err)
+	}
+	dec.XORKeyStream(header, header)
+	if !bytes.Equal(header[:8], VC) {
+		return nil, fmt.Errorf("invalid verification constant")
+	}
+	provided := binary.BigEndian.Uint32(header[8:])
+	padLength := binary.BigEndian.Uint16(header[12:])
+	if padLength > MAX_PAD_LENGTH {
+		return nil, fmt.Errorf("invalid padding length %d", padLength)
+	}
+
+	// PadC is followed by the length of the initial payload
+	rest := make([]byte, int(padLength)+2)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("error reading padding: %w", err)
+	}
+	dec.XORKeyStream(rest, rest)
+	initial := make([]byte, binary.BigEndian.Uint16(rest[padLength:]))
+	if _, err := io.ReadFull(r, initial); err != nil {
+		return nil, fmt.Errorf("error reading initial payload: %w", err)
+	}
+	dec.XORKeyStream(initial, initial)
+
+	method := policy.selectMethod(provided)
+	if method == 0 {
+		return nil, fmt.Errorf("%w: provided %d", ErrNoCommonMethod, provided)
+	}
+
+	// 4. B->A: ENCRYPT(VC, crypto_select, len(padD), padD)
+	var plain bytes.Buffer
+	plain.Write(VC)
+	binary.Write(&plain, binary.BigEndian, method)
+	binary.Write(&plain, binary.BigEndian, uint16(0)) // No PadD
+	encrypted := make([]byte, plain.Len())
+	enc.XORKeyStream(encrypted, plain.Bytes())
+	if _, err := conn.Write(encrypted); err != nil {
+		return nil, fmt.Errorf("error sending crypto select: %w", err)
+	}
+
+	return newConn(conn, r, method, infoHash, enc, dec, initial), nil
+}
+
+// Accept handles an inbound connection that may or may not be encrypted.
+// Plaintext connections start with the BitTorrent handshake, and are
+// accepted as is unless the policy requires encryption.
+func Accept(conn net.Conn, infoHashes [][]byte, policy Policy) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+
+	start, err := r.Peek(len(PLAINTEXT_PROTOCOL_HEADER))
+	if err != nil {
+		return nil, fmt.Errorf("error reading handshake: %w", err)
+	}
+	if bytes.Equal(start, PLAINTEXT_PROTOCOL_HEADER) {
+		if policy == POLICY_REQUIRE {
+			return nil, ErrPlaintextNotAllowed
+		}
+		return newConn(conn, r, CRYPTO_PLAINTEXT, nil, nil, nil, nil), nil
+	}
+
+	if policy == POLICY_DISABLE {
+		return nil, fmt.Errorf("encrypted connections are disabled")
+	}
+	return receive(conn, r, infoHashes, policy)
+}
+
+func newConn(conn net.Conn, r *bufio.Reader, method uint32, infoHash []byte, enc, dec *rc4.Cipher, initial []byte) *Conn {
+	c := &Conn{
+		Conn:     conn,
+		r:        r,
+		initial:  initial,
+		method:   method,
+		infoHash: infoHash,
+	}
+	if method == CRYPTO_RC4 {
+		c.enc = enc
+		c.dec = dec
+	}
+	return c
+}
+
+// synchronize consumes bytes from r up to and including marker, which must
+// appear within the next maxSkip + len(marker) bytes.
+func synchronize(r *bufio.Reader, marker []byte, maxSkip int) error {
+	window := make([]byte, 0, maxSkip+len(marker))
+	for len(window) < cap(window) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, marker) {
+			return nil
+		}
+	}
+	return fmt.Errorf("marker not found within %d bytes", maxSkip)
+}
+
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package mse
+
+import (
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"fmt"
+	"math/big"
+)
+
+type keyPair struct {
+	private *big.Int
+	public  []byte
+}
+
+func newKeyPair() (*keyPair, error) {
+	b := make([]byte, PRIVATE_KEY_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating private key: %w", err)
+	}
+	private := new(big.Int).SetBytes(b)
+	public := new(big.Int).Exp(G, private, P)
+
+	return &keyPair{
+		private: private,
+		public:  public.FillBytes(make([]byte, KEY_LENGTH)),
+	}, nil
+}
+
+// sharedSecret computes the secret S from the public key of the other end.
+func (kp *keyPair) sharedSecret(otherPublic []byte) ([]byte, error) {
+	y := new(big.Int).SetBytes(otherPublic)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(P) >= 0 {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	s := new(big.Int).Exp(y, kp.private, P)
+	return s.FillBytes(make([]byte, KEY_LENGTH)), nil
+}
+
+// hash returns the SHA-1 hash of the concatenation of the parts.
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// newCipher creates the RC4 cipher for one direction of the stream, where
+// name is "keyA" for the initiator's data and "keyB" for the receiver's.
+func newCipher(name string, secret, skey []byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(hash([]byte(name), secret, skey))
+	discard := make([]byte, RC4_DISCARD)
+	c.XORKeyStream(discard, discard)
+	return c
+}
+
+// randomPad returns between 0 and MAX_PAD_LENGTH random bytes.
+func randomPad() ([]byte, error) {
+	n := make([]byte, 2)
+	if _, err := rand.Read(n); err != nil {
+		return nil, err
+	}
+	pad := make([]byte, (int(n[0])<<8|int(n[1]))%(MAX_PAD_LENGTH+1))
+	if _, err := rand.Read(pad); err != nil {
+		return nil, err
+	}
+	return pad, nil
+}
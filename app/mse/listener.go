@@ -0,0 +1,91 @@
+package mse
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// Listener accepts both encrypted and plaintext inbound connections on the
+// same net.Listener. The MSE handshakes are performed concurrently, so a
+// slow peer does not block the others.
+type Listener struct {
+	net.Listener
+	policy     Policy
+	infoHashes func() [][]byte
+	logger     *log.Logger
+
+	conns     chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener starts accepting connections on l. infoHashes returns the
+// info hashes of the torrents that peers may connect for.
+func NewListener(l net.Listener, policy Policy, infoHashes func() [][]byte) *Listener {
+	ml := &Listener{
+		Listener:   l,
+		policy:     policy,
+		infoHashes: infoHashes,
+		logger:     log.New(log.Writer(), "[MSE] ", 0),
+		conns:      make(chan *Conn),
+		closed:     make(chan struct{}),
+	}
+	go ml.acceptLoop()
+	return ml
+}
+
+func (l *Listener) Log(s string, vals ...any) {
+	l.logger.Printf(s+"\n", vals...)
+}
+
+// Accept returns the next connection that completed the handshake.
+// The returned connections are of type *Conn.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.Listener.Close()
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.Log("error accepting connection: %v", err)
+			continue
+		}
+
+		go func() {
+			c, err := Accept(conn, l.infoHashes(), l.policy)
+			if err != nil {
+				l.Log("rejected connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			select {
+			case l.conns <- c:
+			case <-l.closed:
+				conn.Close()
+			}
+		}()
+	}
+}
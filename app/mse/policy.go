@@ -0,0 +1,40 @@
+package mse
+
+import "fmt"
+
+// Policy decides whether connections to peers are encrypted.
+type Policy string
+
+const (
+	POLICY_DISABLE Policy = "disable" // Only plaintext connections are made and accepted
+	POLICY_PREFER  Policy = "prefer"  // Encrypted connections are preferred, plaintext ones are allowed
+	POLICY_REQUIRE Policy = "require" // Only RC4 encrypted connections are made and accepted
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case POLICY_DISABLE, POLICY_PREFER, POLICY_REQUIRE:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid encryption policy %q", s)
+}
+
+// provide returns the crypto methods we offer when initiating a connection.
+func (p Policy) provide() uint32 {
+	if p == POLICY_REQUIRE {
+		return CRYPTO_RC4
+	}
+	return CRYPTO_RC4 | CRYPTO_PLAINTEXT
+}
+
+// selectMethod picks the crypto method for an inbound connection from the ones
+// provided by the peer. It returns 0 if none of them is allowed.
+func (p Policy) selectMethod(provided uint32) uint32 {
+	if provided&CRYPTO_RC4 != 0 {
+		return CRYPTO_RC4
+	}
+	if provided&CRYPTO_PLAINTEXT != 0 && p != POLICY_REQUIRE {
+		return CRYPTO_PLAINTEXT
+	}
+	return 0
+}
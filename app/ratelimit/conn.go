@@ -0,0 +1,67 @@
+package ratelimit
+
+import "net"
+
+// Conn wraps a connection, and limits its reads and writes with every one
+// of its limits, eg. the global, torrent and peer limits. The bytes are
+// counted in the stats of all the limits.
+type Conn struct {
+	net.Conn
+	limits []*Limits
+}
+
+// NewConn wraps the connection with the limits, skipping the nil ones.
+func NewConn(conn net.Conn, limits ...*Limits) *Conn {
+	c := &Conn{Conn: conn, limits: make([]*Limits, 0, len(limits))}
+	for _, l := range limits {
+		if l != nil {
+			c.limits = append(c.limits, l)
+		}
+	}
+	return c
+}
+
+// Read reads at most MAX_CHUNK_SIZE bytes, and then waits until the
+// download limits allow them.
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(b) > MAX_CHUNK_SIZE {
+		b = b[:MAX_CHUNK_SIZE]
+	}
+
+	n, err := c.Conn.Read(b)
+	for _, l := range c.limits {
+		l.Download.WaitN(n)
+		l.Stats.downloaded.Add(int64(n))
+	}
+	return n, err
+}
+
+// Write writes the data in chunks of at most MAX_CHUNK_SIZE bytes,
+// waiting for the upload limits to allow every chunk.
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(written+MAX_CHUNK_SIZE, len(b))]
+		for _, l := range c.limits {
+			l.Upload.WaitN(len(chunk))
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		for _, l := range c.limits {
+			l.Stats.uploaded.Add(int64(n))
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// AddPayload records that n bytes of the data read or written through the
+// connection were payload, to separate it from the protocol overhead.
+func (c *Conn) AddPayload(downloaded, uploaded int) {
+	for _, l := range c.limits {
+		l.Stats.AddPayload(downloaded, uploaded)
+	}
+}
@@ -0,0 +1,9 @@
+package ratelimit
+
+// Reads and writes are split into chunks of at most this size, so that
+// the bandwidth is shared fairly between connections.
+const MAX_CHUNK_SIZE = 16 * 1024
+
+// The smallest burst of a limiter, so that a chunk can always be
+// transferred without going into debt.
+const MIN_BURST = MAX_CHUNK_SIZE
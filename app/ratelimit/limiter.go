@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that limits a transfer rate in bytes per second.
+// The bucket holds up to one second of tokens. Transfers larger than the
+// available tokens put the bucket into debt, which later transfers wait for.
+// It is safe for concurrent use, and the rate can be changed at any time.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64 // 0 for unlimited
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter with the rate in bytes per second, or an
+// unlimited one if the rate is 0.
+func NewLimiter(rate int64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(rate)
+	return l
+}
+
+func (l *Limiter) burst() float64 {
+	return float64(max(l.rate, MIN_BURST))
+}
+
+// SetRate changes the rate of the limiter, 0 for unlimited.
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = max(rate, 0)
+	l.last = time.Now()
+	l.tokens = min(l.tokens, l.burst())
+}
+
+// Rate returns the rate of the limiter in bytes per second, 0 if unlimited.
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// WaitN takes n tokens from the bucket, and blocks until they are available.
+func (l *Limiter) WaitN(n int) {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	rate := float64(l.rate)
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*rate, l.burst())
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// ParseRate parses a rate in bytes per second, with an optional
+// K, M or G suffix for multiples of 1024. "0" means unlimited.
+func ParseRate(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "M"), strings.HasSuffix(s, "m"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "G"), strings.HasSuffix(s, "g"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return v * multiplier, nil
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import "sync/atomic"
+
+// Limits holds the download and upload limiters of one level, eg. of the
+// whole client, of a torrent or of a single peer, and counts the bytes
+// transferred through them.
+type Limits struct {
+	Download *Limiter
+	Upload   *Limiter
+	Stats    Stats
+}
+
+// NewLimits creates limits with the rates in bytes per second, 0 for unlimited.
+func NewLimits(downloadRate, uploadRate int64) *Limits {
+	return &Limits{
+		Download: NewLimiter(downloadRate),
+		Upload:   NewLimiter(uploadRate),
+	}
+}
+
+// GLOBAL_LIMITS are shared by all the peer connections of the client.
+var GLOBAL_LIMITS = NewLimits(0, 0)
+
+// Stats counts the transferred bytes. The payload is the data of the pieces,
+// and the rest of the bytes on the wire are protocol overhead, like the
+// message headers, handshakes and requests.
+type Stats struct {
+	downloaded        atomic.Int64
+	uploaded          atomic.Int64
+	payloadDownloaded atomic.Int64
+	payloadUploaded   atomic.Int64
+}
+
+// AddPayload records that bytes that were already counted as downloaded
+// or uploaded were payload, to separate them from the protocol overhead.
+func (s *Stats) AddPayload(downloaded, uploaded int) {
+	s.payloadDownloaded.Add(int64(downloaded))
+	s.payloadUploaded.Add(int64(uploaded))
+}
+
+// Downloaded returns the total number of bytes read from the wire.
+func (s *Stats) Downloaded() int64 {
+	return s.downloaded.Load()
+}
+
+// Uploaded returns the total number of bytes written to the wire.
+func (s *Stats) Uploaded() int64 {
+	return s.uploaded.Load()
+}
+
+func (s *Stats) PayloadDownloaded() int64 {
+	return s.payloadDownloaded.Load()
+}
+
+func (s *Stats) PayloadUploaded() int64 {
+	return s.payloadUploaded.Load()
+}
+
+func (s *Stats) OverheadDownloaded() int64 {
+	return s.Downloaded() - s.PayloadDownloaded()
+}
+
+func (s *Stats) OverheadUploaded() int64 {
+	return s.Uploaded() - s.PayloadUploaded()
+}
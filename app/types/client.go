@@ -0,0 +1,159 @@
+package types
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ClientInfo identifies the client software that a peer runs.
+type ClientInfo struct {
+	Name    string
+	Version string // Empty if unknown
+}
+
+func (c ClientInfo) String() string {
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Version
+}
+
+// Client codes of Azureus-style peer IDs, eg. "-TR2940-".
+var azureusClients = map[string]string{
+	"AG": "Ares",
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"FW": "FrostWire",
+	"KT": "KTorrent",
+	"LT": "libtorrent (Rasterbar)",
+	"lt": "libTorrent (rakshasa)",
+	"LW": "LimeWire",
+	"PI": "PicoTorrent",
+	"qB": "qBittorrent",
+	"SD": "Thunder",
+	"TL": "Tribler",
+	"TR": "Transmission",
+	"TT": CLIENT_NAME,
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// Client codes of Shadow-style peer IDs, eg. "S58B-----".
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT BitTorrent",
+}
+
+// Client codes of Mainline-style peer IDs, eg. "M4-3-6--".
+var mainlineClients = map[byte]string{
+	'M': "BitTorrent",
+	'Q': "Queen Bee",
+}
+
+var azureusPattern = regexp.MustCompile(`^-([A-Za-z]{2})([0-9A-Za-z]{4})-`)
+var mainlinePattern = regexp.MustCompile(`^([A-Z])(\d+)-(\d+)-(\d+)-`)
+var shadowPattern = regexp.MustCompile(`^([A-Z])([0-9A-Za-z.\-]{5})---`)
+
+// ParsePeerID identifies the client from its peer ID, which can be in the
+// Azureus, Mainline or Shadow style. It returns false if the style or the
+// client code is unknown.
+func ParsePeerID(peerID []byte) (ClientInfo, bool) {
+	id := string(peerID)
+
+	if m := azureusPattern.FindStringSubmatch(id); m != nil {
+		name, ok := azureusClients[m[1]]
+		if !ok {
+			return ClientInfo{}, false
+		}
+		return ClientInfo{Name: name, Version: azureusVersion(m[2])}, true
+	}
+
+	if m := mainlinePattern.FindStringSubmatch(id); m != nil {
+		name, ok := mainlineClients[m[1][0]]
+		if !ok {
+			return ClientInfo{}, false
+		}
+		return ClientInfo{Name: name, Version: m[2] + "." + m[3] + "." + m[4]}, true
+	}
+
+	if m := shadowPattern.FindStringSubmatch(id); m != nil {
+		name, ok := shadowClients[m[1][0]]
+		if !ok {
+			return ClientInfo{}, false
+		}
+		return ClientInfo{Name: name, Version: shadowVersion(m[2])}, true
+	}
+
+	return ClientInfo{}, false
+}
+
+// azureusVersion decodes the four version characters of an Azureus-style
+// peer ID, where every character is a component of the version. Trailing
+// zero components are dropped, so "4520" becomes "4.5.2".
+func azureusVersion(v string) string {
+	parts := make([]string, 0, len(v))
+	for i := range len(v) {
+		parts = append(parts, strconv.Itoa(versionCharValue(v[i])))
+	}
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// shadowVersion decodes the version characters of a Shadow-style peer ID,
+// which end at the first '-'.
+func shadowVersion(v string) string {
+	v, _, _ = strings.Cut(v, "-")
+	parts := make([]string, 0, len(v))
+	for i := range len(v) {
+		parts = append(parts, strconv.Itoa(versionCharValue(v[i])))
+	}
+	return strings.Join(parts, ".")
+}
+
+// versionCharValue returns the value of a version character, where
+// 0-9 are digits, A-Z are 10-35, a-z are 36-61 and '.' is 62.
+func versionCharValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 36
+	case c == '.':
+		return 62
+	}
+	return 0
+}
+
+// ParseClientVersion identifies the client from the "v" field of an extension
+// handshake, which usually has the form "<name> <version>" or "<name>/<version>".
+func ParseClientVersion(v string) ClientInfo {
+	v = strings.TrimSpace(v)
+	if i := strings.LastIndexAny(v, " /"); i > 0 && i < len(v)-1 {
+		version := v[i+1:]
+		if version[0] == 'v' {
+			version = version[1:]
+		}
+		// The part after the separator is only a version if it starts with a digit
+		if len(version) > 0 && version[0] >= '0' && version[0] <= '9' {
+			return ClientInfo{Name: strings.TrimSpace(v[:i]), Version: version}
+		}
+	}
+	return ClientInfo{Name: v}
+}
@@ -0,0 +1,60 @@
+package types
+
+import "testing"
+
+func TestParsePeerID(t *testing.T) {
+	tests := []struct {
+		name   string
+		peerID string
+		want   ClientInfo
+		ok     bool
+	}{
+		{"Azureus", "-TR2940-k8hj0wgej6ch", ClientInfo{"Transmission", "2.9.4"}, true},
+		{"Azureus with letters", "-UT355W-\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c", ClientInfo{"µTorrent", "3.5.5.32"}, true},
+		{"Azureus lowercase code", "-lt0D60-abcdefghijkl", ClientInfo{"libTorrent (rakshasa)", "0.13.6"}, true},
+		{"Azureus trailing zeros", "-AZ5000-abcdefghijkl", ClientInfo{"Vuze", "5.0"}, true},
+		{"Azureus unknown code", "-XX1000-abcdefghijkl", ClientInfo{}, false},
+		{"Mainline", "M4-3-6--abcdefghijkl", ClientInfo{"BitTorrent", "4.3.6"}, true},
+		{"Mainline two digits", "M7-10-2-abcdefghijkl", ClientInfo{"BitTorrent", "7.10.2"}, true},
+		{"Mainline unknown code", "Z1-2-3--abcdefghijkl", ClientInfo{}, false},
+		{"Shadow", "S58B-----abcdefghijk", ClientInfo{"Shadow", "5.8.11"}, true},
+		{"Shadow with dots", "T03.6----abcdefghijk", ClientInfo{"BitTornado", "0.3.62.6"}, true},
+		{"Shadow unknown code", "X58B-----abcdefghijk", ClientInfo{}, false},
+		{"unknown style", "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13", ClientInfo{}, false},
+		{"too short", "-TR29", ClientInfo{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParsePeerID([]byte(tt.peerID))
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("expected %q, %v, got %q, %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestParseClientVersion(t *testing.T) {
+	tests := []struct {
+		v    string
+		want ClientInfo
+	}{
+		{"Transmission 2.94", ClientInfo{"Transmission", "2.94"}},
+		{"qBittorrent/4.5.2", ClientInfo{"qBittorrent", "4.5.2"}},
+		{"µTorrent 3.5.5", ClientInfo{"µTorrent", "3.5.5"}},
+		{"libTorrent v0.13.6", ClientInfo{"libTorrent", "0.13.6"}},
+		{"BitTorrent Web 1.2", ClientInfo{"BitTorrent Web", "1.2"}},
+		{"  KTorrent 5.1  ", ClientInfo{"KTorrent", "5.1"}},
+		{"BitTorrent Web", ClientInfo{"BitTorrent Web", ""}},
+		{"Tixati", ClientInfo{"Tixati", ""}},
+		{"", ClientInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			if got := ParseClientVersion(tt.v); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,29 +1,96 @@
 package types
 
-import "github.com/EshaanAgg/toy-bittorrent/app/utils"
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
 
 const BLOCK_SIZE uint32 = 16384 // 16 KB
 
+// Limits on the sizes in torrent files. Pieces are indexed and sized with
+// 32-bit integers on the wire, and the total length is bounded so that the
+// offsets of the pieces can be computed without overflowing.
+const MAX_PIECE_LENGTH = math.MaxUint32
+const MAX_PIECE_COUNT = math.MaxUint32 + 1
+const MAX_TORRENT_LENGTH = 1 << 62
+
+const PEER_DIAL_TIMEOUT = 5 * time.Second
+const WEB_SEED_TIMEOUT = 30 * time.Second
+
+const CHOKE_MESSAGE_ID = 0
 const UNCHOKE_MESSAGE_ID = 1
 const INTERESTED_MESSAGE_ID = 2
+const NOT_INTERESTED_MESSAGE_ID = 3
+const HAVE_MESSAGE_ID = 4
 const BITFIELD_MESSAGE_ID = 5
 const REQUEST_MESSAGE_ID = 6
 const PIECE_MESSAGE_ID = 7
+const CANCEL_MESSAGE_ID = 8
+const PORT_MESSAGE_ID = 9
+
+// Messages of the fast extension (BEP 6).
+const SUGGEST_PIECE_MESSAGE_ID = 13
+const HAVE_ALL_MESSAGE_ID = 14
+const HAVE_NONE_MESSAGE_ID = 15
+const REJECT_REQUEST_MESSAGE_ID = 16
+const ALLOWED_FAST_MESSAGE_ID = 17
+
+// Messages used to exchange the merkle tree hashes of v2 torrents (BEP 52).
+const HASH_REQUEST_MESSAGE_ID = 21
+const HASHES_MESSAGE_ID = 22
+const HASH_REJECT_MESSAGE_ID = 23
+
+// The maximum number of hashes that can be requested in one hash request.
+const MAX_HASHES_PER_REQUEST = 512
+
+// Limits on the messages that peers send us. Larger messages are a protocol
+// violation, so that a peer can't make us allocate arbitrary amounts of memory.
+const MAX_BITFIELD_SIZE = 1024 * 1024 // Enough for 8M pieces
+const MAX_PROOF_LAYERS = 64
+const MAX_EXTENDED_MESSAGE_SIZE = 1024 * 1024
+const MAX_UNKNOWN_MESSAGE_SIZE = 64 * 1024
+
+// The bytes of messages that a peer can make us hold in memory at once,
+// including the blocks of the piece being downloaded from it. Pieces up to
+// MAX_PIECE_LENGTH are allowed, so the budget is raised for larger pieces.
+const DEFAULT_PEER_MEMORY_BUDGET = 64 * 1024 * 1024
+
+const READ_BUFFER_SIZE = 64 * 1024
 
 const EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID = 20
 const EXTENSION_HANDSHAKE_PAYLOAD_MESSAGE_ID = 0
 
-// We assume that our server always uses the ID 1
-// for the "ut_metadata" extension.
+// The ID that we advertise for the "ut_metadata"
+// extension in DEFAULT_EXTENSIONS.
 const UT_METADATA_EXTENSION_ID = 1
+const METADATA_PIECE_SIZE = 16 * 1024
+
+// The client name and version sent in the extension handshake.
+const CLIENT_NAME = "toy-bittorrent"
+const CLIENT_VERSION = "0.1.0"
 
-var SERVER_PEER_ID = utils.GetRandomPeerID()
+// The Azureus-style prefix of our peer ID, with the client code "TT"
+// and the version 0.1.0.0.
+const PEER_ID_PREFIX = "-TT0100-"
+
+// The number of outstanding block requests that we accept, as
+// advertised in the extension handshake.
+const MAX_REQUEST_QUEUE = 250
+
+var SERVER_PEER_ID = utils.GetRandomPeerID(PEER_ID_PREFIX)
 
 var peerAddressToIDMap = make(map[string]uint32)
+var peerAddressToIDMapMu sync.Mutex
 
 // getPeerID returns a unique ID for the peer based on its address.
 // This makes the logs from the peers more readable and helps in debugging.
 func getPeerID(address string) uint32 {
+	peerAddressToIDMapMu.Lock()
+	defer peerAddressToIDMapMu.Unlock()
+
 	id, ok := peerAddressToIDMap[address]
 	if !ok {
 		id = uint32(len(peerAddressToIDMap)) + 1
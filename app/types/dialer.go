@@ -0,0 +1,45 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Dialer establishes the connections to the peers, so that the peer
+// protocol can run over different transports like TCP or uTP.
+type Dialer interface {
+	Dial(addr string) (net.Conn, error)
+}
+
+// TCPDialer connects to the peers over TCP.
+type TCPDialer struct {
+	Timeout time.Duration
+}
+
+func (d *TCPDialer) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, d.Timeout)
+}
+
+// FallbackDialer tries the dialers in order, and returns the
+// first connection that could be established.
+type FallbackDialer []Dialer
+
+func (fd FallbackDialer) Dial(addr string) (net.Conn, error) {
+	errs := make([]error, 0)
+	for _, d := range fd {
+		conn, err := d.Dial(addr)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no dialers configured")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// DEFAULT_DIALER is used when no dialer is passed to connect to a peer.
+var DEFAULT_DIALER Dialer = &TCPDialer{Timeout: PEER_DIAL_TIMEOUT}
@@ -0,0 +1,141 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrExtensionExists = errors.New("extension already registered")
+var ErrInvalidExtensionID = errors.New("extension ID must be between 1 and 255")
+
+// ExtensionHandler handles an extended message (BEP 10) received from a peer.
+// The payload excludes the message ID and the extended message ID.
+type ExtensionHandler func(p *Peer, payload []byte) error
+
+// Extension is an extension protocol that is advertised to peers in the
+// extension handshake, under its name and with our local message ID.
+type Extension struct {
+	Name    string
+	ID      int              // The ID that peers use to send us messages of the extension
+	Handler ExtensionHandler // Incoming messages are ignored if nil
+
+	// Reports whether the extension should be advertised to the peer,
+	// eg. to not advertise PEX for private torrents. Always if nil.
+	Enabled func(p *Peer) bool
+}
+
+// ExtensionRegistry holds the extensions that we support, and routes the
+// incoming extended messages to them by our local IDs. It is safe for
+// concurrent use.
+type ExtensionRegistry struct {
+	mu     sync.RWMutex
+	byName map[string]*Extension
+	byID   map[int]*Extension
+}
+
+func NewExtensionRegistry() *ExtensionRegistry {
+	return &ExtensionRegistry{
+		byName: make(map[string]*Extension),
+		byID:   make(map[int]*Extension),
+	}
+}
+
+// DEFAULT_EXTENSIONS is used by the peers that don't have their own registry.
+// It contains the "ut_metadata" and "ut_pex" extensions.
+var DEFAULT_EXTENSIONS = newDefaultExtensionRegistry()
+
+func newDefaultExtensionRegistry() *ExtensionRegistry {
+	r := NewExtensionRegistry()
+	r.Register(&Extension{
+		Name:    "ut_metadata",
+		ID:      UT_METADATA_EXTENSION_ID,
+		Handler: handleMetadataMessage,
+	})
+	r.Register(&Extension{
+		Name:    "ut_pex",
+		ID:      UT_PEX_EXTENSION_ID,
+		Handler: handlePexMessage,
+		Enabled: func(p *Peer) bool { return p.pex != nil },
+	})
+	return r
+}
+
+// Register adds an extension to the registry. Both the name
+// and the ID of the extension must not be registered yet.
+func (r *ExtensionRegistry) Register(ext *Extension) error {
+	if ext.ID < 1 || ext.ID > 255 {
+		return ErrInvalidExtensionID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byName[ext.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrExtensionExists, ext.Name)
+	}
+	if other, ok := r.byID[ext.ID]; ok {
+		return fmt.Errorf("%w: ID %d is used by %s", ErrExtensionExists, ext.ID, other.Name)
+	}
+	r.byName[ext.Name] = ext
+	r.byID[ext.ID] = ext
+	return nil
+}
+
+// Unregister removes the extension with the name from the registry.
+func (r *ExtensionRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ext, ok := r.byName[name]; ok {
+		delete(r.byName, name)
+		delete(r.byID, ext.ID)
+	}
+}
+
+// Get returns the extension registered with the name.
+func (r *ExtensionRegistry) Get(name string) (*Extension, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ext, ok := r.byName[name]
+	return ext, ok
+}
+
+// Lookup returns the extension registered with our local ID.
+func (r *ExtensionRegistry) Lookup(id int) (*Extension, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ext, ok := r.byID[id]
+	return ext, ok
+}
+
+// extensionMap returns the "m" dictionary of the extension
+// handshake, with the extensions enabled for the peer.
+func (r *ExtensionRegistry) extensionMap(p *Peer) map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m := make(map[string]int)
+	for name, ext := range r.byName {
+		if ext.Enabled == nil || ext.Enabled(p) {
+			m[name] = ext.ID
+		}
+	}
+	return m
+}
+
+// hasHandlers reports whether any of the extensions enabled
+// for the peer handles incoming messages.
+func (r *ExtensionRegistry) hasHandlers(p *Peer) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, ext := range r.byName {
+		if ext.Handler != nil && (ext.Enabled == nil || ext.Enabled(p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+// The number of pieces in the allowed fast set that we generate for peers,
+// as suggested by BEP 6.
+const ALLOWED_FAST_SET_SIZE = 10
+
+// newPieceIndexMessage creates a message whose payload is a single piece
+// index, which is the case for have, suggest piece and allowed fast messages.
+func newPieceIndexMessage(id byte, pieceIdx uint32) []byte {
+	message := make([]byte, 5)
+	message[0] = id
+	binary.BigEndian.PutUint32(message[1:], pieceIdx)
+	return message
+}
+
+// parsePieceIndexMessage returns the piece index from the payload of
+// a have, suggest piece or allowed fast message.
+func parsePieceIndexMessage(message []byte) (uint32, error) {
+	if len(message) != 5 {
+		return 0, fmt.Errorf("invalid length for message with ID %d, expected 5 bytes, got %d", message[0], len(message))
+	}
+	return binary.BigEndian.Uint32(message[1:]), nil
+}
+
+// RejectRequestMessage tells that a request for a block will not be served.
+// It has the same payload as the request message.
+type RejectRequestMessage struct {
+	PieceIndex uint32
+	Begin      uint32
+	Length     uint32
+}
+
+func NewRejectRequestMessage(data []byte) (*RejectRequestMessage, error) {
+	if len(data) != 13 {
+		return nil, fmt.Errorf("invalid length for reject request message, expected 13 bytes, got %d", len(data))
+	}
+	if data[0] != REJECT_REQUEST_MESSAGE_ID {
+		return nil, fmt.Errorf("invalid message ID for reject request message, expected %d, got %d", REJECT_REQUEST_MESSAGE_ID, data[0])
+	}
+
+	return &RejectRequestMessage{
+		PieceIndex: binary.BigEndian.Uint32(data[1:5]),
+		Begin:      binary.BigEndian.Uint32(data[5:9]),
+		Length:     binary.BigEndian.Uint32(data[9:13]),
+	}, nil
+}
+
+func (m *RejectRequestMessage) Bytes() []byte {
+	message := make([]byte, 13)
+	message[0] = REJECT_REQUEST_MESSAGE_ID
+	binary.BigEndian.PutUint32(message[1:], m.PieceIndex)
+	binary.BigEndian.PutUint32(message[5:], m.Begin)
+	binary.BigEndian.PutUint32(message[9:], m.Length)
+	return message
+}
+
+// GenerateAllowedFastSet returns the pieces that a peer with the passed IP
+// may request while being choked, using the canonical algorithm of BEP 6.
+// Only IPv4 addresses are supported, and at most numPieces are returned.
+func GenerateAllowedFastSet(ip net.IP, infoHash []byte, numPieces int, k int) ([]uint32, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("allowed fast set can only be generated for IPv4 addresses, got %s", ip)
+	}
+	k = min(k, numPieces)
+
+	// The last byte of the IP is masked, so peers in the same /24 get the same set
+	x := make([]byte, 0, 4+len(infoHash))
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash...)
+
+	set := make([]uint32, 0, k)
+	seen := make(map[uint32]bool)
+	for len(set) < k {
+		h, err := utils.SHA1Hash(x)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing: %w", err)
+		}
+		x = h
+
+		for i := 0; i < 5 && len(set) < k; i++ {
+			idx := binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces)
+			if !seen[idx] {
+				seen[idx] = true
+				set = append(set, idx)
+			}
+		}
+	}
+	return set, nil
+}
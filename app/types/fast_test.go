@@ -0,0 +1,142 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAllowedFastSet(t *testing.T) {
+	infoHash := bytes.Repeat([]byte{0xaa}, 20)
+	tests := []struct {
+		name      string
+		ip        string
+		numPieces int
+		k         int
+		want      []uint32
+	}{
+		// The test vectors of BEP 6
+		{"k=7", "80.4.4.200", 1313, 7, []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"k=9", "80.4.4.200", 1313, 9, []uint32{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}},
+		// The last byte of the IP is ignored
+		{"same /24", "80.4.4.1", 1313, 7, []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"k above the number of pieces", "80.4.4.200", 3, 7, []uint32{1, 2, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := GenerateAllowedFastSet(net.ParseIP(tt.ip), infoHash, tt.numPieces, tt.k)
+			if err != nil {
+				t.Fatalf("error generating the set: %v", err)
+			}
+			if !reflect.DeepEqual(set, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, set)
+			}
+		})
+	}
+
+	_, err := GenerateAllowedFastSet(net.ParseIP("2001:db8::1"), infoHash, 1313, 7)
+	if err == nil {
+		t.Error("expected an error for an IPv6 address")
+	}
+}
+
+func TestBlockTillBitFieldFastMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		hasAll  bool
+	}{
+		{"have all", []byte{HAVE_ALL_MESSAGE_ID}, true},
+		{"have none", []byte{HAVE_NONE_MESSAGE_ID}, false},
+		{"bitfield", []byte{BITFIELD_MESSAGE_ID, 0x80}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+
+			peer := NewPeerFromConn(client)
+			peer.SupportsFast = true
+			go func() {
+				// The allowed fast messages sent before are recorded
+				server.Write(frameMessage(newPieceIndexMessage(ALLOWED_FAST_MESSAGE_ID, 7)))
+				server.Write(frameMessage(tt.message))
+			}()
+
+			err := peer.BlockTillBitFieldMessage()
+			if err != nil {
+				t.Fatalf("error waiting for the bitfield: %v", err)
+			}
+			if peer.HasPiece(5) != tt.hasAll {
+				t.Errorf("expected HasPiece to be %v, got %v", tt.hasAll, !tt.hasAll)
+			}
+			if has0 := tt.message[0] == BITFIELD_MESSAGE_ID || tt.hasAll; peer.HasPiece(0) != has0 {
+				t.Errorf("expected HasPiece(0) to be %v", has0)
+			}
+			if !peer.allowedFast[7] {
+				t.Error("expected piece 7 to be allowed fast")
+			}
+		})
+	}
+}
+
+func TestFastMessagesRequireNegotiation(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	peer := NewPeerFromConn(client)
+	go server.Write(frameMessage([]byte{HAVE_ALL_MESSAGE_ID}))
+
+	err := peer.BlockTillBitFieldMessage()
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Errorf("expected a protocol error, got %v", err)
+	}
+}
+
+func TestRejectRequest(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	peer := NewPeerFromConn(client)
+	peer.SupportsFast = true
+	peer.hasAll = true
+	peer.unchoked = true
+
+	go io.Copy(io.Discard, server)
+	go func() {
+		// Rejects of other pieces are ignored
+		other := &RejectRequestMessage{PieceIndex: 2, Begin: 0, Length: BLOCK_SIZE}
+		server.Write(frameMessage(other.Bytes()))
+		reject := &RejectRequestMessage{PieceIndex: 1, Begin: BLOCK_SIZE, Length: BLOCK_SIZE}
+		server.Write(frameMessage(reject.Bytes()))
+	}()
+
+	_, err := peer.DownloadPiece(1, 2*BLOCK_SIZE, make([]byte, 20))
+	if err == nil || !strings.Contains(err.Error(), "offset 16384 of piece 1 was rejected") {
+		t.Errorf("expected the rejected request to fail the piece, got %v", err)
+	}
+	if peer.assignedPiece != nil {
+		t.Error("expected the piece to be unassigned")
+	}
+
+	m, err := NewRejectRequestMessage((&RejectRequestMessage{PieceIndex: 1, Begin: 2, Length: 3}).Bytes())
+	if err != nil || *m != (RejectRequestMessage{PieceIndex: 1, Begin: 2, Length: 3}) {
+		t.Errorf("expected the reject request to round trip, got %+v, %v", m, err)
+	}
+	_, err = NewRejectRequestMessage([]byte{REJECT_REQUEST_MESSAGE_ID, 0})
+	if err == nil {
+		t.Error("expected an error for a truncated reject request")
+	}
+}
+
+// frameMessage prepends the length of the message to it.
+func frameMessage(message []byte) []byte {
+	return append([]byte{0, 0, byte(len(message) >> 8), byte(len(message))}, message...)
+}
@@ -3,12 +3,15 @@ package types
 import (
 	"encoding/binary"
 	"fmt"
+	"net"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
 )
 
 type Handshake struct {
 	SupportsExtensions bool
+	SupportsV2         bool // Whether the peer supports v2 torrents (BEP 52)
+	SupportsFast       bool // Whether the peer supports the fast extension (BEP 6)
 	PeerID             []byte
 	InfoHash           []byte
 }
@@ -28,6 +31,14 @@ func (h *Handshake) Bytes() []byte {
 	if h.SupportsExtensions {
 		b[25] |= 1 << 4
 	}
+	// v2 support is signalled with the 4th most significant bit of the last byte
+	if h.SupportsV2 {
+		b[27] |= 1 << 4
+	}
+	// The fast extension is signalled with the third least significant bit
+	if h.SupportsFast {
+		b[27] |= 1 << 2
+	}
 
 	b = append(b, h.InfoHash...)
 	b = append(b, h.PeerID...)
@@ -41,6 +52,8 @@ func NewHandshakeFromBytes(data []byte) *Handshake {
 
 	h := &Handshake{
 		SupportsExtensions: (data[25] & (1 << 4)) != 0,
+		SupportsV2:         (data[27] & (1 << 4)) != 0,
+		SupportsFast:       (data[27] & (1 << 2)) != 0,
 		InfoHash:           data[28:48],
 		PeerID:             data[48:68],
 	}
@@ -48,31 +61,69 @@ func NewHandshakeFromBytes(data []byte) *Handshake {
 	return h
 }
 
+// ExtensionHandshake is the handshake of the extension protocol (BEP 10).
+// The fields other than the extension map are optional, and left out of
+// the handshake if they have their zero value.
 type ExtensionHandshake struct {
-	ExtensionMap map[string]int
+	ExtensionMap map[string]int // Extension names to message IDs, 0 disables an extension
+
+	Client       string // The name and version of the client ("v")
+	Port         int    // The port the sender accepts connections on ("p")
+	RequestQueue int    // The number of outstanding requests the sender supports ("reqq")
+	YourIP       net.IP // The IP of the receiver, as seen by the sender ("yourip")
+	MetadataSize int    // The size of the info dictionary ("metadata_size")
 }
 
-func NewExtensionHandshake() *ExtensionHandshake {
+// NewExtensionHandshake creates the extension handshake for the peer, which
+// advertises the extensions of its registry that are enabled for it.
+func NewExtensionHandshake(p *Peer) *ExtensionHandshake {
 	ext := &ExtensionHandshake{
-		ExtensionMap: make(map[string]int),
+		ExtensionMap: p.extensions().extensionMap(p),
+		Client:       CLIENT_NAME + " " + CLIENT_VERSION,
+		Port:         p.ListenPort,
+		RequestQueue: MAX_REQUEST_QUEUE,
+		YourIP:       net.ParseIP(p.IP),
+		MetadataSize: p.MetadataSize,
+	}
+	if p.Metadata != nil {
+		ext.MetadataSize = len(p.Metadata)
 	}
-	// Add the "ut_metadata" extension
-	ext.ExtensionMap["ut_metadata"] = UT_METADATA_EXTENSION_ID
 	return ext
 }
 
+// extensionHandshakeFields is the layout of the extension handshake.
+type extensionHandshakeFields struct {
+	ExtensionMap map[string]int `bencode:"m"`
+	Client       string         `bencode:"v,omitempty"`
+	Port         int            `bencode:"p,omitempty"`
+	RequestQueue int            `bencode:"reqq,omitempty"`
+	YourIP       []byte         `bencode:"yourip,omitempty"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"`
+}
+
 func (e *ExtensionHandshake) getDictionaryBytes() []byte {
-	// Create a dictionary with the extension map
-	dict := make(map[string]*bencode.BencodeData)
-	for k, v := range e.ExtensionMap {
-		dict[k] = bencode.NewDataInteger(v)
+	// The extension map is always sent under the key "m"
+	fields := extensionHandshakeFields{
+		ExtensionMap: e.ExtensionMap,
+		Client:       e.Client,
+		Port:         max(e.Port, 0),
+		RequestQueue: max(e.RequestQueue, 0),
+		MetadataSize: max(e.MetadataSize, 0),
+	}
+	if fields.ExtensionMap == nil {
+		fields.ExtensionMap = make(map[string]int)
+	}
+
+	// The IP is sent in its compact form, 4 bytes for IPv4 and 16 for IPv6
+	if ip4 := e.YourIP.To4(); ip4 != nil {
+		fields.YourIP = ip4
+	} else if e.YourIP != nil {
+		fields.YourIP = e.YourIP.To16()
 	}
 
-	// Base dictionary contains the extension map
-	// under the key "m"
-	bd := bencode.NewBencodeDictionary()
-	bd.Add("m", bencode.NewDataDictionary(dict))
-	return bd.Encode()
+	// The fields only have types that can be marshaled
+	b, _ := bencode.Marshal(fields)
+	return b
 }
 
 func (e *ExtensionHandshake) Bytes() []byte {
@@ -105,30 +156,25 @@ func NewExtensionHandshakeFromBytes(data []byte) (*ExtensionHandshake, error) {
 	}
 	data = data[2:]
 
-	// Make a new extension handshake object to store the parsed data
-	handshake := &ExtensionHandshake{
-		ExtensionMap: make(map[string]int),
-	}
-
 	// Parse the dictionary
-	dict, err := bencode.NewBencodeData(data)
+	var fields extensionHandshakeFields
+	err := bencode.Unmarshal(data, &fields)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing dictionary: %w", err)
 	}
-	if dict.Type != bencode.DictionaryType {
-		return nil, fmt.Errorf("expected dictionary type, got %s", dict.Type)
-	}
-	if extensionMap, ok := dict.GetDictionary().Map["m"]; ok {
-		if extensionMap.Type != bencode.DictionaryType {
-			return nil, fmt.Errorf("expected dictionary type for extensions, got %s", extensionMap.Type)
-		}
 
-		for k, v := range extensionMap.GetDictionary().Map {
-			if v.Type != bencode.IntegerType {
-				return nil, fmt.Errorf("expected integer type for extension value, got %s", v.Type)
-			}
-			handshake.ExtensionMap[k] = v.GetInteger().Value
-		}
+	handshake := &ExtensionHandshake{
+		ExtensionMap: fields.ExtensionMap,
+		Client:       fields.Client,
+		Port:         fields.Port,
+		RequestQueue: fields.RequestQueue,
+		MetadataSize: fields.MetadataSize,
+	}
+	if handshake.ExtensionMap == nil {
+		handshake.ExtensionMap = make(map[string]int)
+	}
+	if len(fields.YourIP) == net.IPv4len || len(fields.YourIP) == net.IPv6len {
+		handshake.YourIP = net.IP(fields.YourIP)
 	}
 
 	return handshake, nil
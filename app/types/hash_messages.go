@@ -0,0 +1,183 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HashRequest asks a peer for a range of hashes from one layer of the merkle
+// tree of a file, along with the uncle hashes needed to verify them.
+// The same fields are used by the hashes and hash reject messages.
+type HashRequest struct {
+	PiecesRoot  []byte
+	BaseLayer   uint32 // The layer of the hashes, 0 being the 16 KiB blocks
+	Index       uint32 // The index of the first hash in the layer
+	Length      uint32 // The number of hashes, a power of two
+	ProofLayers uint32 // The number of uncle hashes to include
+}
+
+func (r *HashRequest) Bytes(messageID byte) []byte {
+	b := make([]byte, 0, 49)
+	b = append(b, messageID)
+	b = append(b, r.PiecesRoot...)
+	b = binary.BigEndian.AppendUint32(b, r.BaseLayer)
+	b = binary.BigEndian.AppendUint32(b, r.Index)
+	b = binary.BigEndian.AppendUint32(b, r.Length)
+	b = binary.BigEndian.AppendUint32(b, r.ProofLayers)
+	return b
+}
+
+// NewHashRequestFromBytes parses a hash request, hashes or hash reject message.
+// It returns the request and the remaining bytes of the message.
+func NewHashRequestFromBytes(data []byte) (*HashRequest, []byte, error) {
+	if len(data) < 49 {
+		return nil, nil, fmt.Errorf("hash message too short: %d", len(data))
+	}
+	if data[0] != HASH_REQUEST_MESSAGE_ID && data[0] != HASHES_MESSAGE_ID && data[0] != HASH_REJECT_MESSAGE_ID {
+		return nil, nil, fmt.Errorf("invalid message ID for a hash message: %d", data[0])
+	}
+
+	r := &HashRequest{
+		PiecesRoot:  data[1:33],
+		BaseLayer:   binary.BigEndian.Uint32(data[33:]),
+		Index:       binary.BigEndian.Uint32(data[37:]),
+		Length:      binary.BigEndian.Uint32(data[41:]),
+		ProofLayers: binary.BigEndian.Uint32(data[45:]),
+	}
+	return r, data[49:], nil
+}
+
+// HashesMessage is the response to a hash request. The requested hashes
+// are followed by the uncle hashes, from the bottom of the tree to the top.
+type HashesMessage struct {
+	HashRequest
+	Hashes [][]byte
+}
+
+func (m *HashesMessage) Bytes() []byte {
+	b := m.HashRequest.Bytes(HASHES_MESSAGE_ID)
+	for _, h := range m.Hashes {
+		b = append(b, h...)
+	}
+	return b
+}
+
+func NewHashesMessage(data []byte) (*HashesMessage, error) {
+	r, rest, err := NewHashRequestFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if data[0] != HASHES_MESSAGE_ID {
+		return nil, fmt.Errorf("expected hashes message, got message ID %d", data[0])
+	}
+	if len(rest)%32 != 0 {
+		return nil, fmt.Errorf("invalid length of hashes: %d", len(rest))
+	}
+
+	m := &HashesMessage{HashRequest: *r}
+	for i := 0; i < len(rest); i += 32 {
+		m.Hashes = append(m.Hashes, rest[i:i+32])
+	}
+	return m, nil
+}
+
+// RequestPieceLayer requests the piece layer of the file from the peer in
+// chunks of at most MAX_HASHES_PER_REQUEST hashes. Every chunk is verified
+// with its uncle hashes against the pieces root of the file. This is needed
+// for magnet links, as the piece layers are not part of the info dictionary.
+func (p *Peer) RequestPieceLayer(f *FileV2, pieceLength int) error {
+	if !f.HasPieceLayer(pieceLength) || f.PieceLayer != nil {
+		return nil
+	}
+
+	numPieces := f.NumPieces(pieceLength)
+	layerSize := nextPowerOfTwo(numPieces)
+	chunk := min(layerSize, MAX_HASHES_PER_REQUEST)
+	baseLayer := log2(pieceLength / MERKLE_BLOCK_SIZE)
+
+	layer := make([][]byte, 0, layerSize)
+	for index := 0; index < numPieces; index += chunk {
+		req := &HashRequest{
+			PiecesRoot:  f.PiecesRoot,
+			BaseLayer:   uint32(baseLayer),
+			Index:       uint32(index),
+			Length:      uint32(chunk),
+			ProofLayers: uint32(log2(layerSize / chunk)),
+		}
+		hashes, err := p.requestHashes(req)
+		if err != nil {
+			return err
+		}
+
+		uncles := hashes[chunk:]
+		err = verifyMerkleProof(hashes[:chunk], index, uncles, f.PiecesRoot)
+		if err != nil {
+			return fmt.Errorf("invalid hashes for index %d: %w", index, err)
+		}
+		layer = append(layer, hashes[:chunk]...)
+	}
+
+	return f.SetPieceLayer(layer[:numPieces], pieceLength)
+}
+
+// requestHashes sends the hash request and waits for the hashes, skipping
+// over the other messages. The response must include the uncle hashes.
+func (p *Peer) requestHashes(req *HashRequest) ([][]byte, error) {
+	err := p.SendMessage(req.Bytes(HASH_REQUEST_MESSAGE_ID))
+	if err != nil {
+		return nil, fmt.Errorf("error sending hash request: %w", err)
+	}
+
+	for {
+		msg, err := p.RecieveMessage()
+		if err != nil {
+			return nil, fmt.Errorf("error receiving message: %w", err)
+		}
+
+		switch msg[0] {
+		case HASH_REJECT_MESSAGE_ID:
+			return nil, fmt.Errorf("hash request for index %d was rejected", req.Index)
+
+		case HASHES_MESSAGE_ID:
+			m, err := NewHashesMessage(msg)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing hashes message: %w", err)
+			}
+			if m.Index != req.Index || m.Length != req.Length || m.BaseLayer != req.BaseLayer {
+				p.Log("ignoring hashes for index %d that were not requested", m.Index)
+				continue
+			}
+			if len(m.Hashes) != int(req.Length+req.ProofLayers) {
+				return nil, fmt.Errorf("expected %d hashes, got %d", req.Length+req.ProofLayers, len(m.Hashes))
+			}
+			return m.Hashes, nil
+
+		case HASH_REQUEST_MESSAGE_ID:
+			p.rejectHashRequest(msg)
+
+		default:
+			handled, err := p.handleStateMessage(msg)
+			if err != nil {
+				return nil, err
+			}
+			if !handled {
+				p.Log("Recieved message bytes: %q while waiting for hashes message", msg)
+			}
+		}
+	}
+}
+
+// rejectHashRequest rejects a hash request from the peer, as we don't serve
+// the piece layers of v2 torrents.
+func (p *Peer) rejectHashRequest(msg []byte) {
+	req, _, err := NewHashRequestFromBytes(msg)
+	if err != nil {
+		p.Log("invalid hash request: %v", err)
+		return
+	}
+
+	err = p.SendMessage(req.Bytes(HASH_REJECT_MESSAGE_ID))
+	if err != nil {
+		p.Log("error sending hash reject: %v", err)
+	}
+}
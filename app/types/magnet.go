@@ -13,6 +13,23 @@ type MagnetURI struct {
 
 	InfoHash    []byte
 	InfoHashHex string
+	InfoHashV2  []byte // Set for v2 and hybrid torrents, from the "urn:btmh" exact topic
+
+	WebSeeds []string // HTTP servers that host the files of the torrent (BEP 19)
+
+	// Set for magnet links that point to a mutable torrent (BEP 46). The
+	// info hash has to be resolved from the DHT using the key and salt.
+	PublicKey []byte
+	Salt      []byte
+}
+
+// Trackers returns the URL of the tracker of the magnet link as a list,
+// which is empty if the magnet link has no tracker.
+func (m *MagnetURI) Trackers() []string {
+	if m.TrackerURL == "" {
+		return []string{}
+	}
+	return []string{m.TrackerURL}
 }
 
 func (m *MagnetURI) setValue(key, value string) error {
@@ -24,6 +41,9 @@ func (m *MagnetURI) setValue(key, value string) error {
 		m.TrackerURL = value
 
 	case "xt":
+		if strings.HasPrefix(value, "urn:btmh:") {
+			return m.setInfoHashV2(strings.TrimPrefix(value, "urn:btmh:"))
+		}
 		if !strings.HasPrefix(value, "urn:btih:") {
 			return fmt.Errorf("invalid info hash format: %s", value)
 		}
@@ -39,6 +59,32 @@ func (m *MagnetURI) setValue(key, value string) error {
 		}
 		m.InfoHash = bytes
 
+	case "ws":
+		m.WebSeeds = append(m.WebSeeds, value)
+
+	case "xs":
+		if !strings.HasPrefix(value, "urn:btpk:") {
+			// Other exact sources are not supported, and can be ignored
+			return nil
+		}
+		v := strings.TrimPrefix(value, "urn:btpk:")
+		if len(v) != 64 {
+			return fmt.Errorf("invalid public key length: %s", v)
+		}
+
+		bytes, err := hex.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("failed to decode public key: %v", err)
+		}
+		m.PublicKey = bytes
+
+	case "s":
+		bytes, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("failed to decode salt: %v", err)
+		}
+		m.Salt = bytes
+
 	default:
 		return fmt.Errorf("unknown key: %s", key)
 	}
@@ -46,6 +92,27 @@ func (m *MagnetURI) setValue(key, value string) error {
 	return nil
 }
 
+// setInfoHashV2 parses the v2 info hash, which is a SHA-256 multihash.
+// Unless the magnet link also has a v1 info hash, the truncated v2 info
+// hash is used to find and connect to peers.
+func (m *MagnetURI) setInfoHashV2(v string) error {
+	// 0x12 identifies SHA-256, and 0x20 is the length of the hash
+	if len(v) != 68 || !strings.HasPrefix(v, "1220") {
+		return fmt.Errorf("invalid v2 info hash: %s", v)
+	}
+
+	bytes, err := hex.DecodeString(v[4:])
+	if err != nil {
+		return fmt.Errorf("failed to decode v2 info hash: %v", err)
+	}
+	m.InfoHashV2 = bytes
+
+	if m.InfoHash == nil {
+		m.SetInfoHash(bytes[:20])
+	}
+	return nil
+}
+
 func NewMagnetURI(s string) (*MagnetURI, error) {
 	s, err := url.PathUnescape(s)
 	if err != nil {
@@ -59,22 +126,34 @@ func NewMagnetURI(s string) (*MagnetURI, error) {
 		return nil, fmt.Errorf("expected the URL to start with 'magnet:?'")
 	}
 
-	m := &MagnetURI{}
+	m := &MagnetURI{WebSeeds: make([]string, 0)}
 
 	for !p.isAtEnd() {
-		t := p.get(2)
-		if t == nil {
+		key, err := p.readUntilRequired('=')
+		if err != nil || key == "" {
 			return nil, fmt.Errorf("expected a query parameter, but reached end of string")
 		}
-		if err := p.expect('='); err != nil {
-			return nil, err
-		}
 		val := p.readUntil('&')
 
-		if err := m.setValue(*t, val); err != nil {
+		if err := m.setValue(key, val); err != nil {
 			return nil, err
 		}
 	}
 
+	if m.InfoHash == nil && m.PublicKey == nil {
+		return nil, fmt.Errorf("magnet link has neither an info hash nor a public key")
+	}
 	return m, nil
 }
+
+// IsMutable reports whether the magnet link points to a mutable torrent
+// whose info hash has not been resolved yet.
+func (m *MagnetURI) IsMutable() bool {
+	return m.InfoHash == nil && m.PublicKey != nil
+}
+
+// SetInfoHash sets the info hash resolved for a mutable torrent.
+func (m *MagnetURI) SetInfoHash(infoHash []byte) {
+	m.InfoHash = infoHash
+	m.InfoHashHex = hex.EncodeToString(infoHash)
+}
@@ -0,0 +1,101 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// The leaves of the v2 merkle trees are the SHA-256 hashes of 16 KiB blocks.
+const MERKLE_BLOCK_SIZE = 16384
+
+// merkleParent returns the hash of an inner node of a merkle tree.
+func merkleParent(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// zeroHash returns the root of a merkle subtree of the given height whose
+// leaves are all zero hashes, as used to pad the trees (BEP 52).
+func zeroHash(height int) []byte {
+	h := make([]byte, sha256.Size)
+	for range height {
+		h = merkleParent(h, h)
+	}
+	return h
+}
+
+// merkleRoot returns the root of the merkle tree with the passed nodes as
+// its bottom layer. The layer is padded to numLeaves, which must be a power
+// of two, with the pad hash.
+func merkleRoot(layer [][]byte, numLeaves int, pad []byte) []byte {
+	nodes := make([][]byte, numLeaves)
+	for i := range nodes {
+		if i < len(layer) {
+			nodes[i] = layer[i]
+		} else {
+			nodes[i] = pad
+		}
+	}
+
+	for len(nodes) > 1 {
+		next := make([][]byte, len(nodes)/2)
+		for i := range next {
+			next[i] = merkleParent(nodes[2*i], nodes[2*i+1])
+		}
+		nodes = next
+	}
+	return nodes[0]
+}
+
+// blockHashes returns the leaf hashes of the data, one for every 16 KiB block.
+func blockHashes(data []byte) [][]byte {
+	hashes := make([][]byte, 0, (len(data)+MERKLE_BLOCK_SIZE-1)/MERKLE_BLOCK_SIZE)
+	for start := 0; start < len(data); start += MERKLE_BLOCK_SIZE {
+		end := min(start+MERKLE_BLOCK_SIZE, len(data))
+		h := sha256.Sum256(data[start:end])
+		hashes = append(hashes, h[:])
+	}
+	return hashes
+}
+
+// verifyMerkleProof checks that the hashes, which start at index in their
+// layer, are part of the tree with the given root. The uncles are the
+// siblings of the subtree of the hashes, from the bottom to the top.
+func verifyMerkleProof(hashes [][]byte, index int, uncles [][]byte, root []byte) error {
+	node := merkleRoot(hashes, len(hashes), nil)
+	pos := index / len(hashes)
+	for _, uncle := range uncles {
+		if pos%2 == 0 {
+			node = merkleParent(node, uncle)
+		} else {
+			node = merkleParent(uncle, node)
+		}
+		pos /= 2
+	}
+
+	if !bytes.Equal(node, root) {
+		return fmt.Errorf("merkle root mismatch, expected %x, got %x", root, node)
+	}
+	return nil
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// log2 returns the base 2 logarithm of n, which must be a power of two.
+func log2(n int) int {
+	l := 0
+	for n > 1 {
+		n /= 2
+		l++
+	}
+	return l
+}
@@ -0,0 +1,355 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// The known v2 torrent has two files of the test data, with pieces of 8
+// blocks. The roots were computed independently of the code under test.
+const TEST_V2_PIECE_LENGTH = 8 * MERKLE_BLOCK_SIZE
+
+var testV2Files = []struct {
+	name   string
+	length int
+	root   string
+}{
+	// A single piece of 3 blocks, whose tree is padded to 4 blocks
+	{"a.bin", 40000, "ab671631a9fa97a1fdac651fff6c68773b9acf0735b9c7f6ecdd54cbf1bf5dc2"},
+	// 5 full pieces and a last piece of 2 blocks
+	{"b.bin", 5*TEST_V2_PIECE_LENGTH + 20000, "62020c2a93153f2884d3e3b06ac6628fdd8f1137dc66aa799e2c84cdd66b1953"},
+}
+
+// The hash of the last piece of b.bin, whose tree is padded to 8 blocks.
+const testV2LastPieceHash = "52b7491182a4c48d7cfa791e7ef19a0203285e26782c1e20249539368376a370"
+
+func testV2Data(length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testPieceLayer returns the piece hashes of the data.
+func testPieceLayer(data []byte) [][]byte {
+	layer := make([][]byte, 0)
+	for start := 0; start < len(data); start += TEST_V2_PIECE_LENGTH {
+		piece := data[start:min(start+TEST_V2_PIECE_LENGTH, len(data))]
+		layer = append(layer, merkleRoot(blockHashes(piece), TEST_V2_PIECE_LENGTH/MERKLE_BLOCK_SIZE, make([]byte, 32)))
+	}
+	return layer
+}
+
+// writeTestV2Torrent writes the known v2 torrent, with the piece layers if
+// withLayers is set, and reads it back.
+func writeTestV2Torrent(t *testing.T, withLayers bool) *TorrentFileInfo {
+	t.Helper()
+
+	tree := map[string]any{}
+	layers := map[string]any{}
+	for _, f := range testV2Files {
+		root := mustDecodeHex(t, f.root)
+		tree[f.name] = map[string]any{"": map[string]any{"length": f.length, "pieces root": root}}
+		if f.length > TEST_V2_PIECE_LENGTH {
+			layers[string(root)] = bytes.Join(testPieceLayer(testV2Data(f.length)), nil)
+		}
+	}
+	m := map[string]any{
+		"info": map[string]any{
+			"file tree":    tree,
+			"meta version": 2,
+			"name":         "test",
+			"piece length": TEST_V2_PIECE_LENGTH,
+		},
+	}
+	if withLayers {
+		m["piece layers"] = layers
+	}
+
+	data, err := bencode.Marshal(m)
+	if err != nil {
+		t.Fatalf("error encoding torrent: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "v2.torrent")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := NewTorrentFileInfo(path)
+	if err != nil {
+		t.Fatalf("error reading torrent: %v", err)
+	}
+	return fileInfo
+}
+
+func TestVerifyPiecesOfKnownV2Torrent(t *testing.T) {
+	fileInfo := writeTestV2Torrent(t, true)
+	if n := fileInfo.NumPieces(); n != 7 {
+		t.Fatalf("expected 7 pieces, got %d", n)
+	}
+	b := fileInfo.InfoDict.Files[1]
+	if got := hex.EncodeToString(b.PieceLayer[5]); got != testV2LastPieceHash {
+		t.Errorf("expected the hash of the last piece to be %s, got %s", testV2LastPieceHash, got)
+	}
+
+	pieceIdx := 0
+	for _, f := range testV2Files {
+		data := testV2Data(f.length)
+		for start := 0; start < len(data); start += TEST_V2_PIECE_LENGTH {
+			piece := data[start:min(start+TEST_V2_PIECE_LENGTH, len(data))]
+			err := fileInfo.VerifyPiece(pieceIdx, piece)
+			if err != nil {
+				t.Errorf("error verifying piece %d of %s: %v", pieceIdx, f.name, err)
+			}
+
+			tampered := bytes.Clone(piece)
+			tampered[len(tampered)-1] ^= 1
+			if fileInfo.VerifyPiece(pieceIdx, tampered) == nil {
+				t.Errorf("expected tampered piece %d of %s to be rejected", pieceIdx, f.name)
+			}
+			pieceIdx++
+		}
+	}
+}
+
+func TestSetPieceLayer(t *testing.T) {
+	fileInfo := writeTestV2Torrent(t, false)
+	a, b := fileInfo.InfoDict.Files[0], fileInfo.InfoDict.Files[1]
+	if a.HasPieceLayer(TEST_V2_PIECE_LENGTH) {
+		t.Error("expected a single-piece file not to have a piece layer")
+	}
+	if b.PieceLayer != nil {
+		t.Fatal("expected no piece layer without the piece layers of the torrent")
+	}
+	if fileInfo.VerifyPiece(1, testV2Data(TEST_V2_PIECE_LENGTH)) == nil {
+		t.Error("expected pieces not to be verified without their piece layer")
+	}
+
+	layer := testPieceLayer(testV2Data(testV2Files[1].length))
+	tampered := bytes.Clone(layer[5])
+	tampered[0] ^= 1
+	for name, l := range map[string][][]byte{
+		"missing hash":  layer[:5],
+		"extra hash":    append(append([][]byte{}, layer...), layer[0]),
+		"tampered hash": append(append([][]byte{}, layer[:5]...), tampered),
+	} {
+		if err := b.SetPieceLayer(l, TEST_V2_PIECE_LENGTH); err == nil {
+			t.Errorf("expected an error for a layer with a %s", name)
+		}
+		if b.PieceLayer != nil {
+			t.Errorf("expected the layer with a %s not to be stored", name)
+		}
+	}
+
+	err := b.SetPieceLayer(layer, TEST_V2_PIECE_LENGTH)
+	if err != nil {
+		t.Fatalf("error setting the piece layer: %v", err)
+	}
+	if err := fileInfo.VerifyPiece(6, testV2Data(testV2Files[1].length)[5*TEST_V2_PIECE_LENGTH:]); err != nil {
+		t.Errorf("error verifying the last piece: %v", err)
+	}
+}
+
+// testMerkleLevels returns the layers of the tree above the hashes, which
+// are padded to a power of two with the pad hash, from the bottom up.
+func testMerkleLevels(hashes [][]byte, pad []byte) [][][]byte {
+	level := make([][]byte, nextPowerOfTwo(len(hashes)))
+	for i := range level {
+		if i < len(hashes) {
+			level[i] = hashes[i]
+		} else {
+			level[i] = pad
+		}
+	}
+
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			h := sha256.Sum256(append(bytes.Clone(level[2*i]), level[2*i+1]...))
+			next[i] = h[:]
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// testUncles returns the uncles of the subtree of the hashes at index.
+func testUncles(levels [][][]byte, index, length, proofLayers int) [][]byte {
+	uncles := make([][]byte, 0, proofLayers)
+	pos := index / length
+	for l := log2(length); l < log2(length)+proofLayers; l++ {
+		uncles = append(uncles, levels[l][pos^1])
+		pos /= 2
+	}
+	return uncles
+}
+
+func TestVerifyMerkleProof(t *testing.T) {
+	hashes := make([][]byte, 13)
+	for i := range hashes {
+		h := sha256.Sum256([]byte{byte(i)})
+		hashes[i] = h[:]
+	}
+	levels := testMerkleLevels(hashes, make([]byte, 32))
+	root := levels[len(levels)-1][0]
+	layer := levels[0]
+
+	tests := []struct {
+		name   string
+		index  int
+		length int
+	}{
+		{"single hash", 5, 1},
+		{"pair", 6, 2},
+		{"half of the layer", 8, 8},
+		{"whole layer", 0, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proofLayers := log2(len(layer) / tt.length)
+			uncles := testUncles(levels, tt.index, tt.length, proofLayers)
+			chunk := layer[tt.index : tt.index+tt.length]
+			err := verifyMerkleProof(chunk, tt.index, uncles, root)
+			if err != nil {
+				t.Fatalf("error verifying proof: %v", err)
+			}
+
+			// The hashes are only valid at their own index
+			if tt.length < len(layer) && verifyMerkleProof(chunk, tt.index+tt.length, uncles, root) == nil {
+				t.Error("expected the hashes to be rejected at another index")
+			}
+
+			tampered := make([][]byte, len(chunk))
+			copy(tampered, chunk)
+			tampered[0] = bytes.Clone(chunk[0])
+			tampered[0][31] ^= 1
+			if verifyMerkleProof(tampered, tt.index, uncles, root) == nil {
+				t.Error("expected a tampered hash to be rejected")
+			}
+			if len(uncles) > 0 {
+				uncles[len(uncles)-1] = bytes.Clone(uncles[len(uncles)-1])
+				uncles[len(uncles)-1][0] ^= 1
+				if verifyMerkleProof(chunk, tt.index, uncles, root) == nil {
+					t.Error("expected a tampered uncle to be rejected")
+				}
+			}
+		})
+	}
+}
+
+// serveHashes answers the hash requests read from the connection with the
+// hashes of the levels and their uncles, after passing them to tamper.
+func serveHashes(conn net.Conn, levels [][][]byte, tamper func(m *HashesMessage)) {
+	for {
+		lengthPrefix := make([]byte, 4)
+		if _, err := io.ReadFull(conn, lengthPrefix); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(lengthPrefix))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		req, _, err := NewHashRequestFromBytes(msg)
+		if err != nil {
+			return
+		}
+
+		index, length := int(req.Index), int(req.Length)
+		m := &HashesMessage{HashRequest: *req}
+		m.Hashes = append(m.Hashes, levels[0][index:index+length]...)
+		m.Hashes = append(m.Hashes, testUncles(levels, index, length, int(req.ProofLayers))...)
+		if tamper != nil {
+			tamper(m)
+		}
+
+		b := m.Bytes()
+		data := binary.BigEndian.AppendUint32(nil, uint32(len(b)))
+		if _, err := conn.Write(append(data, b...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestRequestPieceLayer(t *testing.T) {
+	// The layer spans several requests, so every chunk comes with uncles
+	const numPieces = 2*MAX_HASHES_PER_REQUEST + 100
+	layer := make([][]byte, numPieces)
+	for i := range layer {
+		h := sha256.Sum256(binary.BigEndian.AppendUint32(nil, uint32(i)))
+		layer[i] = h[:]
+	}
+	pad := sha256.Sum256(make([]byte, 2*sha256.Size)) // The root of 2 zero blocks
+	levels := testMerkleLevels(layer, pad[:])
+	root := levels[len(levels)-1][0]
+
+	tests := []struct {
+		name   string
+		tamper func(m *HashesMessage)
+	}{
+		{"valid", nil},
+		{"tampered hash", func(m *HashesMessage) {
+			if m.Index == MAX_HASHES_PER_REQUEST {
+				m.Hashes[3] = bytes.Clone(m.Hashes[3])
+				m.Hashes[3][0] ^= 1
+			}
+		}},
+		{"tampered uncle", func(m *HashesMessage) {
+			if m.Index == 2*MAX_HASHES_PER_REQUEST {
+				last := len(m.Hashes) - 1
+				m.Hashes[last] = bytes.Clone(m.Hashes[last])
+				m.Hashes[last][0] ^= 1
+			}
+		}},
+		{"missing uncle", func(m *HashesMessage) {
+			m.Hashes = m.Hashes[:len(m.Hashes)-1]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			go serveHashes(server, levels, tt.tamper)
+
+			f := &FileV2{Path: []string{"file"}, Length: numPieces * 2 * MERKLE_BLOCK_SIZE, PiecesRoot: root}
+			err := NewPeerFromConn(client).RequestPieceLayer(f, 2*MERKLE_BLOCK_SIZE)
+			if tt.tamper != nil {
+				if err == nil || f.PieceLayer != nil {
+					t.Errorf("expected the piece layer to be rejected, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error requesting the piece layer: %v", err)
+			}
+			if len(f.PieceLayer) != numPieces {
+				t.Fatalf("expected %d piece hashes, got %d", numPieces, len(f.PieceLayer))
+			}
+			for i := range layer {
+				if !bytes.Equal(f.PieceLayer[i], layer[i]) {
+					t.Fatalf("unexpected hash of piece %d", i)
+				}
+			}
+		})
+	}
+}
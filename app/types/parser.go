@@ -30,6 +30,21 @@ func (p *parser) readUntil(c byte) string {
 	return result
 }
 
+// readUntilRequired works like readUntil, but returns an
+// error if the character is not present in the rest of the string.
+func (p *parser) readUntilRequired(c byte) (string, error) {
+	start := p.i
+	for !p.isAtEnd() && p.s[p.i] != c {
+		p.i++
+	}
+	if p.isAtEnd() {
+		return "", fmt.Errorf("expected '%c' after index %d", c, start)
+	}
+	result := p.s[start:p.i]
+	p.i++
+	return result, nil
+}
+
 func (p *parser) expect(c byte) error {
 	if p.isAtEnd() || p.s[p.i] != c {
 		return fmt.Errorf("expected '%c' but got '%c'", c, p.s[p.i])
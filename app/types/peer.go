@@ -1,12 +1,17 @@
 package types
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"strconv"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
 )
 
 // Peer represents a remote peer in the network.
@@ -14,15 +19,48 @@ type Peer struct {
 	IP   string
 	Port int
 
-	ExtensionMessageID int // Would be -1 if the peer doesn't support extension messages
+	ExtensionMessageID int                // Would be -1 if the peer doesn't support extension messages
+	Encryption         mse.Policy         // Whether to encrypt the connection, plaintext if empty
+	Extensions         *ExtensionRegistry // The extensions we support, DEFAULT_EXTENSIONS if nil
+	ListenPort         int                // Our port advertised in the extension handshake, if set
+	MetadataSize       int                // The size of the info dictionary, advertised if known
+	Metadata           []byte             // The info dictionary, served with "ut_metadata" if set
+	Limits             *ratelimit.Limits  // The rate limits of this peer, adjustable at any time
+	MemoryBudget       int                // DEFAULT_PEER_MEMORY_BUDGET if 0, raised to fit the assigned piece
+	SupportsV2         bool               // Whether the peer supports v2 torrents, known after the handshake
+	SupportsFast       bool               // Whether the fast extension was negotiated, known after the handshake
+	PeerID             []byte             // Known after the handshake, or from non-compact tracker responses
+	ClientVersion      string             // The "v" field of the extension handshake of the peer, if sent
 
 	conn          net.Conn
+	reader        *bufio.Reader
+	memoryUsed    int             // Bytes of the blocks of the assigned piece that are held in memory
+	rateConn      *ratelimit.Conn // Set if the connection is rate limited
+	sharedLimits  []*ratelimit.Limits
+	dialer        Dialer
 	logger        *log.Logger
 	assignedPiece *StoredPiece
+
+	unchoked    bool
+	hasAll      bool
+	pieces      []byte          // The bitfield of the pieces that the peer has
+	allowedFast map[uint32]bool // Pieces that can be requested while choked
+
+	peerExtensions         map[string]int // The extension IDs of the peer, from its extension handshake
+	pex                    *PeerExchange
+	pexSent                map[string]bool // Peers that were advertised to this peer
+	pexLastSent            time.Time
+	extensionHandshakeSent bool
 }
 
 // NewPeerFromAddr initializes a Peer and establishes a TCP connection to it.
 func NewPeerFromAddr(addr string) (*Peer, error) {
+	return NewPeerFromAddrWithDialer(addr, DEFAULT_DIALER)
+}
+
+// NewPeerFromAddrWithDialer initializes a Peer and establishes
+// a connection to it using the passed dialer.
+func NewPeerFromAddrWithDialer(addr string, dialer Dialer) (*Peer, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid address format: %w", err)
@@ -33,7 +71,7 @@ func NewPeerFromAddr(addr string) (*Peer, error) {
 	}
 
 	// Create a new connection to the peer
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", portInt)))
+	conn, err := dialer.Dial(net.JoinHostPort(host, fmt.Sprintf("%d", portInt)))
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to address %s: %w", addr, err)
 	}
@@ -41,14 +79,65 @@ func NewPeerFromAddr(addr string) (*Peer, error) {
 	logger := log.New(conn, fmt.Sprintf("[Peer %d] ", getPeerID(addr)), 0)
 	logger.SetOutput(log.Writer())
 
-	return &Peer{
+	p := &Peer{
 		IP:                 host,
 		Port:               portInt,
 		ExtensionMessageID: -1,
 
-		conn:   conn,
+		Limits: ratelimit.NewLimits(0, 0),
+
+		dialer: dialer,
 		logger: logger,
-	}, nil
+	}
+	p.setConn(conn)
+	return p, nil
+}
+
+// Client identifies the client software of the peer, preferring the
+// "v" field of its extension handshake over its peer ID.
+func (p *Peer) Client() (ClientInfo, bool) {
+	if p.ClientVersion != "" {
+		return ParseClientVersion(p.ClientVersion), true
+	}
+	if p.PeerID != nil {
+		return ParsePeerID(p.PeerID)
+	}
+	return ClientInfo{}, false
+}
+
+// ApplyRateLimits limits the connection with the limits of the peer, and with
+// the shared limits, eg. the global and torrent limits. It must be called
+// before the handshake, so that all the bytes on the wire are counted.
+func (p *Peer) ApplyRateLimits(shared ...*ratelimit.Limits) {
+	if p.Limits == nil {
+		p.Limits = ratelimit.NewLimits(0, 0)
+	}
+	p.sharedLimits = shared
+	limits := append(append([]*ratelimit.Limits{}, shared...), p.Limits)
+	p.rateConn = ratelimit.NewConn(p.conn, limits...)
+	p.setConn(p.rateConn)
+}
+
+// SetDeadline sets the read and write deadline of the connection to the peer.
+func (p *Peer) SetDeadline(t time.Time) error {
+	return p.conn.SetDeadline(t)
+}
+
+// Close closes the connection to the peer. The peer is removed from the
+// peer exchange, so that it is advertised as dropped to the other peers.
+func (p *Peer) Close() error {
+	if p.pex != nil {
+		p.pex.RemoveConnected(p)
+	}
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.Close()
+}
+
+// Addr returns the address of the peer in the "host:port" format.
+func (p *Peer) Addr() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
 }
 
 // SendMessage sends a message to the peer with a 4-byte length prefix.
@@ -70,31 +159,61 @@ func (p *Peer) SendMessage(messageBytes []byte) error {
 
 func (p *Peer) readExactBytes(n uint32) ([]byte, error) {
 	data := make([]byte, n)
-	_, err := io.ReadFull(p.conn, data)
+	_, err := io.ReadFull(p.reader, data)
 	if err != nil {
 		return nil, fmt.Errorf("error reading %d bytes: %w", n, err)
 	}
 	return data, nil
 }
 
-// RecieveMessage reads a message from the peer.
-// It first reads the 4-byte length prefix, then reads the message of that length.
-func (p *Peer) RecieveMessage() ([]byte, error) {
-	lengthPrefix := make([]byte, 4)
-	_, err := p.conn.Read(lengthPrefix)
-	if err != nil {
-		return nil, fmt.Errorf("error reading message length: %w", err)
+// memoryBudget returns the memory budget of the peer. While a piece is
+// assigned, it is raised if needed to hold the whole piece and the message
+// being read, so that the pieces of any valid torrent can be downloaded.
+func (p *Peer) memoryBudget() int64 {
+	budget := int64(DEFAULT_PEER_MEMORY_BUDGET)
+	if p.MemoryBudget > 0 {
+		budget = int64(p.MemoryBudget)
 	}
-	length := binary.BigEndian.Uint32(lengthPrefix)
-
-	// Keep-alive message, so recursively call itself
-	if length == 0 {
-		return p.RecieveMessage()
+	if p.assignedPiece != nil {
+		budget = max(budget, int64(p.assignedPiece.Length)+int64(maxMessageLength(PIECE_MESSAGE_ID)))
 	}
+	return budget
+}
 
-	message, err := p.readExactBytes(length)
-	if err != nil {
-		return nil, fmt.Errorf("error reading message: %w", err)
+// RecieveMessage reads a message from the peer.
+// It first reads the 4-byte length prefix, then reads the message of that length.
+// Messages that are longer than allowed for their ID, or that would exceed the
+// memory budget of the peer, are a protocol violation.
+func (p *Peer) RecieveMessage() ([]byte, error) {
+	for {
+		lengthPrefix := make([]byte, 4)
+		_, err := io.ReadFull(p.reader, lengthPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("error reading message length: %w", err)
+		}
+		length := binary.BigEndian.Uint32(lengthPrefix)
+
+		// Keep-alive message, so wait for the next one
+		if length == 0 {
+			continue
+		}
+
+		// Peek at the ID to check the length before allocating the message
+		id, err := p.reader.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("error reading message ID: %w", err)
+		}
+		if maxLength := maxMessageLength(id[0]); length > maxLength {
+			return nil, p.protocolError("message with ID %d has length %d, the maximum is %d", id[0], length, maxLength)
+		}
+		if int64(p.memoryUsed)+int64(length) > p.memoryBudget() {
+			return nil, p.protocolError("message of length %d exceeds the memory budget of %d bytes", length, p.memoryBudget())
+		}
+
+		message, err := p.readExactBytes(length)
+		if err != nil {
+			return nil, fmt.Errorf("error reading message: %w", err)
+		}
+		return message, nil
 	}
-	return message, nil
 }
@@ -0,0 +1,83 @@
+package types
+
+import "fmt"
+
+// extensions returns the registry of the extensions that we support.
+func (p *Peer) extensions() *ExtensionRegistry {
+	if p.Extensions != nil {
+		return p.Extensions
+	}
+	return DEFAULT_EXTENSIONS
+}
+
+// PeerExtensionID returns the ID that the peer uses for the extension,
+// or 0 if the peer doesn't support it.
+func (p *Peer) PeerExtensionID(name string) int {
+	return p.peerExtensions[name]
+}
+
+// setPeerExtensions records the extension IDs and the client version from an
+// extension handshake of the peer. Handshakes can be sent more than once, and
+// only update the extensions that they contain, where an ID of 0 disables the
+// extension.
+func (p *Peer) setPeerExtensions(handshake *ExtensionHandshake) {
+	if p.peerExtensions == nil {
+		p.peerExtensions = make(map[string]int)
+	}
+	if handshake.Client != "" {
+		p.ClientVersion = handshake.Client
+	}
+	for name, id := range handshake.ExtensionMap {
+		if id <= 0 || id > 255 {
+			delete(p.peerExtensions, name)
+			continue
+		}
+		p.peerExtensions[name] = id
+	}
+}
+
+// SendExtendedMessage sends a message of the extension to the peer,
+// using the ID that the peer advertised for it.
+func (p *Peer) SendExtendedMessage(name string, payload []byte) error {
+	id := p.PeerExtensionID(name)
+	if id == 0 {
+		return fmt.Errorf("peer doesn't support the %s extension", name)
+	}
+
+	body := make([]byte, 0, len(payload)+2)
+	body = append(body, EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID)
+	body = append(body, byte(id))
+	body = append(body, payload...)
+	return p.SendMessage(body)
+}
+
+// handleExtendedMessage processes an extended message (ID = 20) received from
+// the peer. Handshakes update the extension IDs of the peer, and the other
+// messages are routed to the extension that we advertised the ID for.
+func (p *Peer) handleExtendedMessage(message []byte) error {
+	if len(message) < 2 {
+		return fmt.Errorf("extended message too short: %d", len(message))
+	}
+
+	if message[1] == EXTENSION_HANDSHAKE_PAYLOAD_MESSAGE_ID {
+		handshake, err := NewExtensionHandshakeFromBytes(message)
+		if err != nil {
+			return fmt.Errorf("error parsing extension handshake: %w", err)
+		}
+		p.setPeerExtensions(handshake)
+		return nil
+	}
+
+	ext, ok := p.extensions().Lookup(int(message[1]))
+	if !ok {
+		return fmt.Errorf("received extended message with unknown ID %d", message[1])
+	}
+	if ext.Handler == nil {
+		return nil
+	}
+	err := ext.Handler(p, message[2:])
+	if err != nil {
+		return fmt.Errorf("error handling %s message: %w", ext.Name, err)
+	}
+	return nil
+}
@@ -1,11 +1,28 @@
 package types
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
 )
 
+// The types of "ut_metadata" messages (BEP 9).
+const (
+	METADATA_REQUEST = 0
+	METADATA_DATA    = 1
+	METADATA_REJECT  = 2
+)
+
+// metadataMessage is the dictionary of a "ut_metadata" message. Data
+// messages are followed by the bytes of the metadata piece.
+type metadataMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
 func (p *Peer) GetInfoFile(m *MagnetURI) (*TorrentFileInfo, error) {
 	// Send the magnet request message
 	err := p.SendMagnetRequestMessage(0)
@@ -28,13 +45,14 @@ func (p *Peer) SendMagnetRequestMessage(pieceIndex int) error {
 	body = append(body, EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID)
 	body = append(body, byte(p.ExtensionMessageID))
 
-	payload := bencode.NewBencodeDictionary()
-	payload.Add("msg_type", bencode.NewDataInteger(0))
-	payload.Add("piece", bencode.NewDataInteger(pieceIndex))
-	body = append(body, payload.Encode()...)
+	payload, err := bencode.Marshal(metadataMessage{MsgType: METADATA_REQUEST, Piece: pieceIndex})
+	if err != nil {
+		return fmt.Errorf("error encoding message: %w", err)
+	}
+	body = append(body, payload...)
 
 	// Send the message
-	err := p.SendMessage(body)
+	err = p.SendMessage(body)
 	if err != nil {
 		return fmt.Errorf("error sending message: %w", err)
 	}
@@ -42,12 +60,39 @@ func (p *Peer) SendMagnetRequestMessage(pieceIndex int) error {
 }
 
 func (p *Peer) GetMagnetDataMessage(m *MagnetURI, pieceIndex int) (*TorrentFileInfo, error) {
-	msg, err := p.RecieveMessage()
-	if err != nil {
-		return nil, fmt.Errorf("error receiving message: %w", err)
+	metadataID := UT_METADATA_EXTENSION_ID
+	if ext, ok := p.extensions().Get("ut_metadata"); ok {
+		metadataID = ext.ID
 	}
 
-	infoDict, err := parseDataMessage(pieceIndex, msg)
+	// Skip the messages that update the state of the peer, like allowed fast
+	var msg []byte
+	for {
+		var err error
+		msg, err = p.RecieveMessage()
+		if err != nil {
+			return nil, fmt.Errorf("error receiving message: %w", err)
+		}
+		handled, err := p.handleStateMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		if handled {
+			continue
+		}
+
+		// Route the messages of the other extensions to their handlers
+		if len(msg) >= 2 && msg[0] == EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID && int(msg[1]) != metadataID {
+			err = p.handleExtendedMessage(msg)
+			if err != nil {
+				p.Log("%v", err)
+			}
+			continue
+		}
+		break
+	}
+
+	infoDict, err := parseDataMessage(pieceIndex, msg, metadataID)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing data message: %w", err)
 	}
@@ -55,7 +100,9 @@ func (p *Peer) GetMagnetDataMessage(m *MagnetURI, pieceIndex int) (*TorrentFileI
 	return NewTorrentFileInfoFromMagnet(m, infoDict)
 }
 
-func parseDataMessage(pieceIdx int, msg []byte) (*bencode.BencodeDictionary, error) {
+// parseDataMessage parses a "ut_metadata" data message, which the peer sends
+// with the extension ID that we advertised for "ut_metadata".
+func parseDataMessage(pieceIdx int, msg []byte, metadataID int) (*bencode.BencodeData, error) {
 	if len(msg) < 2 {
 		return nil, fmt.Errorf("message too short: %d", len(msg))
 	}
@@ -64,39 +111,90 @@ func parseDataMessage(pieceIdx int, msg []byte) (*bencode.BencodeDictionary, err
 		return nil, fmt.Errorf("invalid message ID: expected %d, got %d", EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID, msg[0])
 	}
 
-	if msg[1] != UT_METADATA_EXTENSION_ID {
-		return nil, fmt.Errorf("invalid extension ID: expected %d, got %d", UT_METADATA_EXTENSION_ID, msg[1])
+	if int(msg[1]) != metadataID {
+		return nil, fmt.Errorf("invalid extension ID: expected %d, got %d", metadataID, msg[1])
 	}
 
 	return getDataMessageDict(pieceIdx, msg[2:])
 }
 
-func getDataMessageDict(pieceIdx int, data []byte) (*bencode.BencodeDictionary, error) {
-	d, leftData, err := bencode.NewPartialBencodeData(data)
+func getDataMessageDict(pieceIdx int, data []byte) (*bencode.BencodeData, error) {
+	// The dictionary is followed by the raw bytes of the metadata piece
+	dec := bencode.NewDecoder(bytes.NewReader(data))
+	d, err := dec.Decode()
 	if err != nil {
 		return nil, fmt.Errorf("error parsing dictionary: %w", err)
 	}
 
-	if d.Type != bencode.DictionaryType {
-		return nil, fmt.Errorf("expected dictionary type, got %s", d.Type)
+	var m metadataMessage
+	err = bencode.UnmarshalData(d, &m)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing dictionary: %w", err)
 	}
-	dict := d.GetDictionary()
 
 	// Valid the different keys
-	if msg_type, err := dict.GetInteger("msg_type"); err != nil || msg_type != 1 {
-		return nil, fmt.Errorf("invalid message type: %w", err)
+	if m.MsgType != METADATA_DATA {
+		return nil, fmt.Errorf("invalid message type: %d", m.MsgType)
 	}
-	if piece_index, err := dict.GetInteger("piece"); err != nil || piece_index != pieceIdx {
-		return nil, fmt.Errorf("invalid piece index: %w", err)
+	if m.Piece != pieceIdx {
+		return nil, fmt.Errorf("invalid piece index: %d", m.Piece)
 	}
-	if total_size, err := dict.GetInteger("total_size"); err != nil || total_size != len(leftData) {
-		return nil, fmt.Errorf("invalid total size: %w", err)
+	leftData, err := io.ReadAll(dec.Remaining())
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata piece: %w", err)
+	}
+	if m.TotalSize != len(leftData) {
+		return nil, fmt.Errorf("invalid total size: %d", m.TotalSize)
 	}
 
 	// Decode the remaining data
 	decodedData, err := bencode.NewBencodeData(leftData)
-	if decodedData.Type != bencode.DictionaryType {
-		return nil, fmt.Errorf("expected dictionary type, got %s", decodedData.Type)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding metadata: %w", err)
+	}
+	if _, err := decodedData.AsDictionary(); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	return decodedData, nil
+}
+
+// handleMetadataMessage serves the pieces of the info dictionary that are
+// requested by the peer, and rejects the requests if it is not known.
+func handleMetadataMessage(p *Peer, payload []byte) error {
+	// Data messages are followed by the bytes of the piece
+	d, err := bencode.NewDecoder(bytes.NewReader(payload)).Decode()
+	if err != nil {
+		return fmt.Errorf("error parsing metadata message: %w", err)
+	}
+	var m metadataMessage
+	err = bencode.UnmarshalData(d, &m)
+	if err != nil {
+		return fmt.Errorf("error parsing metadata message: %w", err)
+	}
+	if m.MsgType != METADATA_REQUEST {
+		return nil
+	}
+
+	// The piece index is checked before it is multiplied, as a huge index
+	// from the peer would overflow the offset of the piece
+	numPieces := (len(p.Metadata) + METADATA_PIECE_SIZE - 1) / METADATA_PIECE_SIZE
+	if p.Metadata == nil || m.Piece < 0 || m.Piece >= numPieces {
+		reply, err := bencode.Marshal(metadataMessage{MsgType: METADATA_REJECT, Piece: m.Piece})
+		if err != nil {
+			return err
+		}
+		return p.SendExtendedMessage("ut_metadata", reply)
+	}
+
+	reply, err := bencode.Marshal(metadataMessage{
+		MsgType:   METADATA_DATA,
+		Piece:     m.Piece,
+		TotalSize: len(p.Metadata),
+	})
+	if err != nil {
+		return err
 	}
-	return decodedData.GetDictionary(), nil
+	start := m.Piece * METADATA_PIECE_SIZE
+	reply = append(reply, p.Metadata[start:min(start+METADATA_PIECE_SIZE, len(p.Metadata))]...)
+	return p.SendExtendedMessage("ut_metadata", reply)
 }
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGetDataMessageDict(t *testing.T) {
+	// The metadata is long enough to be split across the reads of the decoder
+	info := "d6:lengthi5e4:name4:file4:pad_40000:" + strings.Repeat("e", 40000) + "12:piece lengthi16384e6:pieces20:" + strings.Repeat("\xff", 20) + "e"
+	header := func(piece, size int) string {
+		return fmt.Sprintf("d8:msg_typei%de5:piecei%de10:total_sizei%dee", METADATA_DATA, piece, size)
+	}
+
+	d, err := getDataMessageDict(0, []byte(header(0, len(info))+info))
+	if err != nil {
+		t.Fatalf("error parsing the data message: %v", err)
+	}
+	if got := string(d.Value.Encode()); got != info {
+		t.Errorf("expected the metadata after the header, got %d bytes", len(got))
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"wrong piece", header(1, len(info)) + info},
+		{"wrong total size", header(0, len(info)+1) + info},
+		{"truncated metadata", header(0, len(info)-1) + info[:len(info)-1]},
+		{"metadata not a dictionary", header(0, 3) + "i1e"},
+		{"truncated header", header(0, len(info))[:20]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := getDataMessageDict(0, []byte(tt.data))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -18,17 +18,18 @@ func (p *Peer) PerformMagnetHandshake(m *MagnetURI, logIDs bool) (*Handshake, er
 	}
 
 	if handshake.SupportsExtensions {
-		extHandshake, err := p.PerformExtensionHandshake()
+		_, err = p.PerformExtensionHandshake()
 		if err != nil {
 			return nil, fmt.Errorf("error performing extension handshake: %w", err)
 		}
-		if mtExtensionId, ok := extHandshake.ExtensionMap["ut_metadata"]; ok {
+		if mtExtensionId := p.PeerExtensionID("ut_metadata"); mtExtensionId > 0 {
 			p.ExtensionMessageID = mtExtensionId
 			if logIDs {
 				fmt.Printf("Peer Metadata Extension ID: %d\n", mtExtensionId)
 			}
 		}
 	}
+	p.setupPex()
 
 	return handshake, nil
 }
@@ -45,6 +46,17 @@ func (p *Peer) MagnetHandshakeAndInfoFile(m *MagnetURI) (*TorrentFileInfo, error
 		return nil, fmt.Errorf("error getting info file: %w", err)
 	}
 
+	// Serve the info dictionary to the peer as well, now that we have it
+	p.Metadata = infoFile.InfoBytes
+
+	// Peers of private torrents must not be shared with other peers
+	if infoFile.InfoDict.Private {
+		err = p.DisablePex()
+		if err != nil {
+			return nil, fmt.Errorf("error disabling pex: %w", err)
+		}
+	}
+
 	return infoFile, nil
 }
 
@@ -56,6 +68,17 @@ func (p *Peer) PrepareToGetPieceData_Magnet(m *MagnetURI) (*TorrentFileInfo, err
 		return nil, fmt.Errorf("error getting info file: %w", err)
 	}
 
+	// The piece layers of v2 only torrents are not part of the info
+	// dictionary, so they have to be requested from the peer
+	if infoFile.InfoDict.Pieces == nil {
+		for _, f := range infoFile.InfoDict.Files {
+			err = p.RequestPieceLayer(f, infoFile.InfoDict.PieceLength)
+			if err != nil {
+				return nil, fmt.Errorf("error requesting piece layer: %w", err)
+			}
+		}
+	}
+
 	err = p.SendInterested()
 	if err != nil {
 		return nil, fmt.Errorf("error while sending interested message: %w", err)
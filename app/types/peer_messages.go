@@ -1,6 +1,10 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+)
 
 // PerformHandshake sends a handshake to the peer and waits for a response.
 // Returns the received handshake or an error.
@@ -9,9 +13,11 @@ func (p *Peer) PerformHandshake(infoHash []byte) (*Handshake, error) {
 		PeerID:             SERVER_PEER_ID,
 		InfoHash:           infoHash,
 		SupportsExtensions: true,
+		SupportsV2:         true,
+		SupportsFast:       true,
 	}
 
-	_, err := p.conn.Write(handshake.Bytes())
+	err := p.sendHandshake(infoHash, handshake.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("error sending handshake: %w", err)
 	}
@@ -20,40 +26,111 @@ func (p *Peer) PerformHandshake(infoHash []byte) (*Handshake, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading handshake response: %w", err)
 	}
+	err = p.validateHandshake(response, infoHash)
+	if err != nil {
+		return nil, err
+	}
 	recievedHandshake := NewHandshakeFromBytes(response)
+	p.SupportsV2 = recievedHandshake.SupportsV2
+	p.SupportsFast = recievedHandshake.SupportsFast
+	p.PeerID = recievedHandshake.PeerID
+
+	// With the fast extension, we must announce the pieces that we have,
+	// and we don't have any
+	if p.SupportsFast {
+		err = p.SendMessage([]byte{HAVE_NONE_MESSAGE_ID})
+		if err != nil {
+			return nil, fmt.Errorf("error sending have none message: %w", err)
+		}
+	}
 
 	return recievedHandshake, nil
 }
 
+// sendHandshake sends our handshake to the peer. If encryption is enabled,
+// it is sent as the initial payload of the MSE handshake, and the connection
+// is replaced by the encrypted one. With POLICY_PREFER, we reconnect and
+// send it in plaintext if the peer does not support encryption.
+func (p *Peer) sendHandshake(infoHash []byte, data []byte) error {
+	if p.Encryption != mse.POLICY_PREFER && p.Encryption != mse.POLICY_REQUIRE {
+		_, err := p.conn.Write(data)
+		return err
+	}
+
+	conn, err := mse.Initiate(p.conn, infoHash, data, p.Encryption)
+	if err == nil {
+		p.setConn(conn)
+		p.Log("encryption handshake completed, encrypted: %v", conn.Encrypted())
+		return nil
+	}
+	if p.Encryption == mse.POLICY_REQUIRE || p.dialer == nil {
+		return fmt.Errorf("error performing encryption handshake: %w", err)
+	}
+
+	p.Log("encryption handshake failed, retrying in plaintext: %v", err)
+	p.conn.Close()
+	conn2, err := p.dialer.Dial(p.Addr())
+	if err != nil {
+		return fmt.Errorf("error reconnecting to peer: %w", err)
+	}
+	p.setConn(conn2)
+	if p.rateConn != nil {
+		p.ApplyRateLimits(p.sharedLimits...)
+	}
+	_, err = p.conn.Write(data)
+	return err
+}
+
+// PerformExtensionHandshake sends our extension handshake to the peer, and
+// waits for the handshake of the peer. The extension IDs of the peer are
+// recorded, so that extended messages can be sent to it.
 func (p *Peer) PerformExtensionHandshake() (*ExtensionHandshake, error) {
 	// Send handshake message
-	handshake := NewExtensionHandshake()
+	handshake := NewExtensionHandshake(p)
 	_, err := p.conn.Write(handshake.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("error sending extension handshake: %w", err)
 	}
+	p.extensionHandshakeSent = true
 
-	// Recieve handshake response
-	data, err := p.RecieveMessage()
-	if err != nil {
-		return nil, fmt.Errorf("error receiving extension handshake response: %w", err)
+	// Recieve handshake response, skipping the messages that update the state of the peer
+	var data []byte
+	for {
+		data, err = p.RecieveMessage()
+		if err != nil {
+			return nil, fmt.Errorf("error receiving extension handshake response: %w", err)
+		}
+		handled, err := p.handleStateMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		if !handled {
+			break
+		}
 	}
+
 	response, err := NewExtensionHandshakeFromBytes(data)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing extension handshake response: %w", err)
 	}
+	p.setPeerExtensions(response)
 	return response, nil
 }
 
 // SendInterested sends an "interested" message to the peer indicating that
 // we want to download data from them. It also waits for the unchoke message
-// indicating that the peer is ready to send us data.
+// indicating that the peer is ready to send us data. With the fast extension,
+// the peer can allow us to download some pieces while choked, so the unchoke
+// message is waited for when downloading a piece instead.
 func (p *Peer) SendInterested() error {
 	interested := []byte{INTERESTED_MESSAGE_ID}
 	err := p.SendMessage(interested)
 	if err != nil {
 		return fmt.Errorf("error sending interested message: %w", err)
 	}
+	if p.SupportsFast {
+		return nil
+	}
 
 	err = p.blockTillUnchokeMessage()
 	if err != nil {
@@ -62,8 +139,9 @@ func (p *Peer) SendInterested() error {
 	return nil
 }
 
-// blockTillBitFieldMessage blocks the peer until a bitfield message (ID = 5) is received.
-// It skips over other messages and discards their payloads.
+// blockTillBitFieldMessage blocks the peer until a bitfield message (ID = 5) is received,
+// or a have all or have none message if the fast extension is supported.
+// It records the state from other messages like allowed fast, and skips over the rest.
 func (p *Peer) BlockTillBitFieldMessage() error {
 	for {
 		msg, err := p.RecieveMessage()
@@ -71,18 +149,23 @@ func (p *Peer) BlockTillBitFieldMessage() error {
 			return fmt.Errorf("error receiving message: %w", err)
 		}
 
-		if msg[0] == BITFIELD_MESSAGE_ID {
-			// TODO: Parse the response to get all the pieces present
+		handled, err := p.handleStateMessage(msg)
+		if err != nil {
+			return err
+		}
+		switch msg[0] {
+		case BITFIELD_MESSAGE_ID, HAVE_ALL_MESSAGE_ID, HAVE_NONE_MESSAGE_ID:
 			return nil
-
 		}
 
-		p.Log("Recieved message bytes: %q while waiting for bitfield message", msg)
+		if !handled {
+			p.Log("Recieved message bytes: %q while waiting for bitfield message", msg)
+		}
 	}
 }
 
 // blockTillUnchokeMessage blocks the peer until an unchoke message (ID = 1) is received.
-// It skips over other messages and discards their payloads.
+// It records the state from other messages like have, and skips over the rest.
 func (p *Peer) blockTillUnchokeMessage() error {
 	for {
 		msg, err := p.RecieveMessage()
@@ -90,10 +173,16 @@ func (p *Peer) blockTillUnchokeMessage() error {
 			return fmt.Errorf("error receiving message: %w", err)
 		}
 
+		handled, err := p.handleStateMessage(msg)
+		if err != nil {
+			return err
+		}
 		if msg[0] == UNCHOKE_MESSAGE_ID {
 			return nil
 		}
 
-		p.Log("Recieved message bytes: %q while waiting for unchoke message", msg)
+		if !handled {
+			p.Log("Recieved message bytes: %q while waiting for unchoke message", msg)
+		}
 	}
 }
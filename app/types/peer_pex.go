@@ -0,0 +1,82 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// EnablePex attaches the peer exchange of the torrent to the peer. It must
+// be called before the extension handshake, so that "ut_pex" is advertised.
+func (p *Peer) EnablePex(px *PeerExchange) {
+	p.pex = px
+	p.pexSent = make(map[string]bool)
+}
+
+// DisablePex stops exchanging peers with the peer, eg. when the torrent
+// turns out to be private. If the extension handshake was already sent,
+// the peer is notified by sending a new one that disables "ut_pex".
+func (p *Peer) DisablePex() error {
+	if p.pex == nil {
+		return nil
+	}
+	p.pex.RemoveConnected(p)
+	p.pex = nil
+
+	if !p.extensionHandshakeSent {
+		return nil
+	}
+	handshake := NewExtensionHandshake(p)
+	handshake.ExtensionMap["ut_pex"] = 0
+	_, err := p.conn.Write(handshake.Bytes())
+	if err != nil {
+		return fmt.Errorf("error sending extension handshake: %w", err)
+	}
+	return nil
+}
+
+// setupPex registers the peer as connected with the peer exchange.
+func (p *Peer) setupPex() {
+	if p.pex == nil {
+		return
+	}
+	// We always connect to the peers ourselves, so they are reachable
+	p.pex.AddConnected(p, PEX_FLAG_OUTGOING)
+}
+
+// maybeSendPex sends the peers that were added and dropped since the last PEX
+// message to the peer, if the peer supports PEX and PEX_INTERVAL has passed.
+func (p *Peer) maybeSendPex() error {
+	if p.pex == nil || p.PeerExtensionID("ut_pex") == 0 {
+		return nil
+	}
+	if !p.pexLastSent.IsZero() && time.Since(p.pexLastSent) < PEX_INTERVAL {
+		return nil
+	}
+
+	m := p.pex.nextMessage(p, p.pexSent)
+	if len(m.Added) == 0 && len(m.Dropped) == 0 {
+		return nil
+	}
+
+	err := p.SendExtendedMessage("ut_pex", m.Bytes())
+	if err != nil {
+		return fmt.Errorf("error sending pex message: %w", err)
+	}
+	p.pexLastSent = time.Now()
+	p.Log("sent pex message with %d added and %d dropped peers", len(m.Added), len(m.Dropped))
+	return nil
+}
+
+// handlePexMessage handles a "ut_pex" message received while downloading.
+func handlePexMessage(p *Peer, payload []byte) error {
+	if p.pex == nil {
+		return nil
+	}
+
+	added, err := p.pex.HandleMessage(payload)
+	if err != nil {
+		return err
+	}
+	p.Log("received pex message with %d new peers", added)
+	return nil
+}
@@ -8,7 +8,7 @@ import (
 
 // PrepareToGetPieceData is to be used for stages without magnet links.
 func (p *Peer) PrepareToGetPieceData(infoHash []byte) error {
-	_, err := p.PerformHandshake(infoHash)
+	handshake, err := p.PerformHandshake(infoHash)
 	if err != nil {
 		return fmt.Errorf("error performing handshake: %w", err)
 	}
@@ -19,6 +19,16 @@ func (p *Peer) PrepareToGetPieceData(infoHash []byte) error {
 		return fmt.Errorf("error waiting for bitfield message: %w", err)
 	}
 
+	// The extension handshake is only needed for the extensions
+	// that handle messages while downloading, like "ut_pex"
+	if handshake.SupportsExtensions && p.extensions().hasHandlers(p) {
+		_, err = p.PerformExtensionHandshake()
+		if err != nil {
+			return fmt.Errorf("error performing extension handshake: %w", err)
+		}
+	}
+	p.setupPex()
+
 	err = p.SendInterested()
 	if err != nil {
 		return fmt.Errorf("error while sending interested message: %w", err)
@@ -41,6 +51,11 @@ func (p *Peer) getCompletePiece() error {
 	piece := p.assignedPiece
 
 	for {
+		err := p.maybeSendPex()
+		if err != nil {
+			p.Log("%v", err)
+		}
+
 		message, err := p.RecieveMessage()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
@@ -49,6 +64,42 @@ func (p *Peer) getCompletePiece() error {
 			return fmt.Errorf("error receiving message: %w", err)
 		}
 
+		if message[0] == EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID {
+			err = p.handleExtendedMessage(message)
+			if err != nil {
+				p.Log("%v", err)
+			}
+			continue
+		}
+
+		if message[0] == HASH_REQUEST_MESSAGE_ID {
+			p.rejectHashRequest(message)
+			continue
+		}
+
+		if message[0] == REJECT_REQUEST_MESSAGE_ID && p.SupportsFast {
+			m, err := NewRejectRequestMessage(message)
+			if err != nil {
+				return fmt.Errorf("error creating RejectRequestMessage: %w", err)
+			}
+			if m.PieceIndex == piece.Index {
+				return fmt.Errorf("request for block at offset %d of piece %d was rejected", m.Begin, m.PieceIndex)
+			}
+			continue
+		}
+
+		handled, err := p.handleStateMessage(message)
+		if err != nil {
+			return err
+		}
+		if handled {
+			// Without the fast extension, choking discards all pending requests
+			if message[0] == CHOKE_MESSAGE_ID && !p.SupportsFast {
+				return fmt.Errorf("peer choked us while downloading piece %d", piece.Index)
+			}
+			continue
+		}
+
 		if message[0] != PIECE_MESSAGE_ID {
 			return fmt.Errorf("recieved message with ID = %d, expected PIECE_MESSAGE_ID = %d", message[0], PIECE_MESSAGE_ID)
 		}
@@ -58,10 +109,20 @@ func (p *Peer) getCompletePiece() error {
 			return fmt.Errorf("error creating PieceMessage: %w", err)
 		}
 
+		if m.PieceIndex != piece.Index {
+			p.Log("ignoring block of piece %d, which is not assigned", m.PieceIndex)
+			continue
+		}
+
+		if p.rateConn != nil {
+			p.rateConn.AddPayload(len(m.Block), 0)
+		}
+
 		err = piece.HandlePieceMessage(m)
 		if err != nil {
 			return fmt.Errorf("error handling piece message: %w", err)
 		}
+		p.memoryUsed += len(m.Block)
 
 		if piece.IsComplete() {
 			p.Log("piece %d completed", piece.Index)
@@ -0,0 +1,173 @@
+package types
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
+)
+
+// PeerSource identifies the subsystem that discovered a peer.
+type PeerSource string
+
+const (
+	PEER_SOURCE_TRACKER PeerSource = "tracker"
+	PEER_SOURCE_DHT     PeerSource = "dht"
+	PEER_SOURCE_PEX     PeerSource = "pex"
+	PEER_SOURCE_LSD     PeerSource = "lsd"
+)
+
+// PeerPool collects the addresses of the peers discovered for a torrent
+// from all the peer sources, without duplicates. It is safe for concurrent use.
+type PeerPool struct {
+	Dialer     Dialer     // Used to connect to the peers, DEFAULT_DIALER if nil
+	Encryption mse.Policy // Set on the connected peers, plaintext if empty
+	ListenPort int        // Set on the connected peers, advertised in the extension handshake
+
+	// The connected peers are limited by the global limits, the limits
+	// of the torrent, and their own limits with the initial rates below.
+	TorrentLimits     *ratelimit.Limits // Unlimited if nil
+	PeerDownloadLimit int64             // In bytes per second, 0 for unlimited
+	PeerUploadLimit   int64
+
+	mu      sync.Mutex
+	addrs   []string
+	sources map[string]PeerSource // Address to the source that first reported it
+	peerIDs map[string][]byte     // Address to the peer ID, if reported by the source
+}
+
+func NewPeerPool() *PeerPool {
+	return &PeerPool{
+		addrs:   make([]string, 0),
+		sources: make(map[string]PeerSource),
+		peerIDs: make(map[string][]byte),
+	}
+}
+
+// Add adds the address of a peer to the pool.
+// Returns false if the address is invalid or was already present.
+func (pp *PeerPool) Add(addr string, source PeerSource) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return false
+	}
+	addr = net.JoinHostPort(host, port)
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	if _, ok := pp.sources[addr]; ok {
+		return false
+	}
+	pp.sources[addr] = source
+	pp.addrs = append(pp.addrs, addr)
+	return true
+}
+
+// AddPeers adds the addresses of the passed peers to the pool,
+// along with their peer IDs if they are known.
+func (pp *PeerPool) AddPeers(peers []*Peer, source PeerSource) {
+	for _, p := range peers {
+		if pp.Add(p.Addr(), source) && p.PeerID != nil {
+			pp.mu.Lock()
+			pp.peerIDs[p.Addr()] = p.PeerID
+			pp.mu.Unlock()
+		}
+	}
+}
+
+// Addrs returns the addresses in the pool, in the order they were added.
+func (pp *PeerPool) Addrs() []string {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	addrs := make([]string, len(pp.addrs))
+	copy(addrs, pp.addrs)
+	return addrs
+}
+
+// Source returns the source that reported the address.
+func (pp *PeerPool) Source(addr string) PeerSource {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	return pp.sources[addr]
+}
+
+func (pp *PeerPool) Len() int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	return len(pp.addrs)
+}
+
+func (pp *PeerPool) peerID(addr string) []byte {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	return pp.peerIDs[addr]
+}
+
+// Peers returns unconnected Peer objects for all the addresses in the pool.
+func (pp *PeerPool) Peers() []*Peer {
+	peers := make([]*Peer, 0)
+	for _, addr := range pp.Addrs() {
+		host, port, _ := net.SplitHostPort(addr)
+		portInt, _ := strconv.Atoi(port)
+		peers = append(peers, &Peer{IP: host, Port: portInt, PeerID: pp.peerID(addr)})
+	}
+	return peers
+}
+
+// Dial connects to the peer at the address, with the settings of the pool.
+func (pp *PeerPool) Dial(addr string) (*Peer, error) {
+	dialer := pp.Dialer
+	if dialer == nil {
+		dialer = DEFAULT_DIALER
+	}
+
+	p, err := NewPeerFromAddrWithDialer(addr, dialer)
+	if err != nil {
+		return nil, err
+	}
+	p.Encryption = pp.Encryption
+	p.ListenPort = pp.ListenPort
+	p.Limits = ratelimit.NewLimits(pp.PeerDownloadLimit, pp.PeerUploadLimit)
+	p.ApplyRateLimits(ratelimit.GLOBAL_LIMITS, pp.TorrentLimits)
+	return p, nil
+}
+
+// Connect establishes a connection to every peer in the pool. The peers
+// that cannot be reached are skipped, so the returned slice only contains
+// the connected peers.
+func (pp *PeerPool) Connect() []*Peer {
+	addrs := pp.Addrs()
+	connected := make([]*Peer, len(addrs))
+
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			p, err := pp.Dial(addr)
+			if err != nil {
+				return
+			}
+			connected[i] = p
+		}(i, addr)
+	}
+	wg.Wait()
+
+	peers := make([]*Peer, 0)
+	for _, p := range connected {
+		if p != nil {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,218 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
+)
+
+// NewPeerFromConn initializes a Peer for a connection that the peer made to
+// us, eg. one returned by an mse.Listener, which already completed the
+// encryption handshake if the peer asked for it.
+func NewPeerFromConn(conn net.Conn) *Peer {
+	addr := conn.RemoteAddr().String()
+	host, port, _ := net.SplitHostPort(addr)
+	portInt, _ := strconv.Atoi(port)
+
+	p := &Peer{
+		IP:                 host,
+		Port:               portInt,
+		ExtensionMessageID: -1,
+
+		Limits: ratelimit.NewLimits(0, 0),
+
+		logger: log.New(log.Writer(), fmt.Sprintf("[Peer %d] ", getPeerID(addr)), 0),
+	}
+	p.setConn(conn)
+	return p
+}
+
+// ReceiveHandshake waits for the handshake of a peer that connected to us,
+// which must be for one of the info hashes, and replies with our handshake.
+func (p *Peer) ReceiveHandshake(infoHashes ...[]byte) (*Handshake, error) {
+	data, err := p.readExactBytes(68)
+	if err != nil {
+		return nil, fmt.Errorf("error reading handshake: %w", err)
+	}
+	received := NewHandshakeFromBytes(data)
+
+	var infoHash []byte
+	for _, h := range infoHashes {
+		if bytes.Equal(received.InfoHash, h) {
+			infoHash = h
+		}
+	}
+	if infoHash == nil {
+		return nil, p.protocolError("handshake for unknown info hash %x", received.InfoHash)
+	}
+	err = p.validateHandshake(data, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	handshake := Handshake{
+		PeerID:             SERVER_PEER_ID,
+		InfoHash:           infoHash,
+		SupportsExtensions: true,
+		SupportsV2:         true,
+		SupportsFast:       true,
+	}
+	_, err = p.conn.Write(handshake.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error sending handshake: %w", err)
+	}
+
+	p.SupportsV2 = received.SupportsV2
+	p.SupportsFast = received.SupportsFast
+	p.PeerID = received.PeerID
+	return received, nil
+}
+
+// Seed serves the pieces of the torrent to a peer that connected to us, until
+// the peer closes the connection. The content holds the data of the torrent,
+// as saved by downloading it, and all of its pieces are announced. The peer
+// is unchoked as soon as it is interested.
+func (p *Peer) Seed(fileInfo *TorrentFileInfo, content io.ReaderAt) error {
+	infoHashes := [][]byte{fileInfo.InfoHash}
+	if fileInfo.IsHybrid() {
+		infoHashes = append(infoHashes, fileInfo.InfoHashV2[:20])
+	}
+	handshake, err := p.ReceiveHandshake(infoHashes...)
+	if err != nil {
+		return fmt.Errorf("error receiving handshake: %w", err)
+	}
+
+	err = p.sendHavePieces(fileInfo.NumPieces())
+	if err != nil {
+		return err
+	}
+	if handshake.SupportsExtensions {
+		_, err = p.conn.Write(NewExtensionHandshake(p).Bytes())
+		if err != nil {
+			return fmt.Errorf("error sending extension handshake: %w", err)
+		}
+		p.extensionHandshakeSent = true
+	}
+
+	for {
+		msg, err := p.RecieveMessage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("error receiving message: %w", err)
+		}
+
+		switch msg[0] {
+		case INTERESTED_MESSAGE_ID:
+			err = p.SendMessage([]byte{UNCHOKE_MESSAGE_ID})
+			if err != nil {
+				return fmt.Errorf("error sending unchoke message: %w", err)
+			}
+
+		case NOT_INTERESTED_MESSAGE_ID, CANCEL_MESSAGE_ID:
+			// Requests are served as soon as they are received, so
+			// there is nothing to cancel
+
+		case REQUEST_MESSAGE_ID:
+			err = p.serveRequest(fileInfo, content, msg)
+			if err != nil {
+				return err
+			}
+
+		case EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID:
+			err = p.handleExtendedMessage(msg)
+			if err != nil {
+				p.Log("%v", err)
+			}
+
+		case HASH_REQUEST_MESSAGE_ID:
+			p.rejectHashRequest(msg)
+
+		default:
+			handled, err := p.handleStateMessage(msg)
+			if err != nil {
+				return err
+			}
+			if !handled {
+				p.Log("Recieved message bytes: %q while seeding", msg)
+			}
+		}
+	}
+}
+
+// sendHavePieces announces that we have all the pieces, with a have all
+// message if the fast extension was negotiated, or a full bitfield otherwise.
+func (p *Peer) sendHavePieces(numPieces int) error {
+	if p.SupportsFast {
+		err := p.SendMessage([]byte{HAVE_ALL_MESSAGE_ID})
+		if err != nil {
+			return fmt.Errorf("error sending have all message: %w", err)
+		}
+		return nil
+	}
+
+	bitfield := make([]byte, 1+(numPieces+7)/8)
+	bitfield[0] = BITFIELD_MESSAGE_ID
+	for i := range numPieces {
+		bitfield[1+i/8] |= 0x80 >> (i % 8)
+	}
+	err := p.SendMessage(bitfield)
+	if err != nil {
+		return fmt.Errorf("error sending bitfield message: %w", err)
+	}
+	return nil
+}
+
+// serveRequest sends the requested block to the peer. Requests for blocks
+// outside of the pieces of the torrent are rejected if the fast extension
+// was negotiated, and are a protocol violation otherwise.
+func (p *Peer) serveRequest(fileInfo *TorrentFileInfo, content io.ReaderAt, msg []byte) error {
+	if len(msg) != 13 {
+		return p.protocolError("request message has length %d, expected 13", len(msg))
+	}
+	index := binary.BigEndian.Uint32(msg[1:5])
+	begin := binary.BigEndian.Uint32(msg[5:9])
+	length := binary.BigEndian.Uint32(msg[9:13])
+
+	pieceSize := fileInfo.PieceSize(int(index))
+	if length == 0 || length > BLOCK_SIZE || uint64(begin)+uint64(length) > uint64(pieceSize) {
+		if !p.SupportsFast {
+			return p.protocolError("invalid request for %d bytes at offset %d of piece %d", length, begin, index)
+		}
+		reject := RejectRequestMessage{PieceIndex: index, Begin: begin, Length: length}
+		err := p.SendMessage(reject.Bytes())
+		if err != nil {
+			return fmt.Errorf("error sending reject request message: %w", err)
+		}
+		return nil
+	}
+
+	offset, err := fileInfo.PieceOffset(int(index))
+	if err != nil {
+		return err
+	}
+	message := make([]byte, 9+length)
+	message[0] = PIECE_MESSAGE_ID
+	copy(message[1:9], msg[1:9])
+	_, err = content.ReadAt(message[9:], offset+int64(begin))
+	if err != nil {
+		return fmt.Errorf("error reading block of piece %d: %w", index, err)
+	}
+
+	err = p.SendMessage(message)
+	if err != nil {
+		return fmt.Errorf("error sending piece message: %w", err)
+	}
+	if p.rateConn != nil {
+		p.rateConn.AddPayload(0, int(length))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package types
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
+)
+
+// startTestSeeder seeds the content to the peers that connect to the
+// returned address, accepting the connections allowed by the policy.
+// The peers are limited by the shared limits, if any are passed.
+func startTestSeeder(t *testing.T, fileInfo *TorrentFileInfo, content []byte, policy mse.Policy, shared ...*ratelimit.Limits) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := mse.NewListener(l, policy, func() [][]byte { return [][]byte{fileInfo.InfoHash} })
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				peer := NewPeerFromConn(conn)
+				if len(shared) > 0 {
+					peer.ApplyRateLimits(shared...)
+				}
+				defer peer.Close()
+				peer.Seed(fileInfo, bytes.NewReader(content))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// downloadFromSeeder downloads all the pieces of the torrent from the seeder.
+func downloadFromSeeder(addr string, fileInfo *TorrentFileInfo, policy mse.Policy) ([]byte, error) {
+	peer, err := NewPeerFromAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer peer.Close()
+	peer.Encryption = policy
+
+	err = peer.PrepareToGetPieceData(fileInfo.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for i := range fileInfo.NumPieces() {
+		sp, err := peer.DownloadPiece(uint32(i), fileInfo.PieceSize(i), fileInfo.InfoDict.Pieces[i])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sp.GetData()...)
+	}
+	return data, nil
+}
+
+func TestSeedAcceptsEncryptedAndPlaintext(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), 3*TEST_PIECE_LENGTH+100)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+	addr := startTestSeeder(t, fileInfo, content, mse.POLICY_PREFER)
+
+	for _, policy := range []mse.Policy{mse.POLICY_DISABLE, mse.POLICY_REQUIRE} {
+		data, err := downloadFromSeeder(addr, fileInfo, policy)
+		if err != nil {
+			t.Fatalf("error downloading with policy %s: %v", policy, err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("the data downloaded with policy %s does not match the file", policy)
+		}
+	}
+}
+
+func TestSeedRequiresEncryption(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), TEST_PIECE_LENGTH)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+	addr := startTestSeeder(t, fileInfo, content, mse.POLICY_REQUIRE)
+
+	_, err := downloadFromSeeder(addr, fileInfo, mse.POLICY_DISABLE)
+	if err == nil {
+		t.Fatal("expected a plaintext connection to be rejected")
+	}
+
+	data, err := downloadFromSeeder(addr, fileInfo, mse.POLICY_REQUIRE)
+	if err != nil {
+		t.Fatalf("error downloading with encryption: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("the downloaded data does not match the file")
+	}
+}
+
+func TestSeedRejectsUnknownInfoHash(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), TEST_PIECE_LENGTH)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+	addr := startTestSeeder(t, fileInfo, content, mse.POLICY_PREFER)
+
+	peer, err := NewPeerFromAddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	_, err = peer.PerformHandshake(bytes.Repeat([]byte{1}, 20))
+	if err == nil {
+		t.Fatal("expected the handshake for another torrent to fail")
+	}
+}
+
+func TestSeedCountsUploadedPayload(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), 3*TEST_PIECE_LENGTH+100)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+	limits := ratelimit.NewLimits(0, 0)
+	addr := startTestSeeder(t, fileInfo, content, mse.POLICY_PREFER, limits)
+
+	_, err := downloadFromSeeder(addr, fileInfo, mse.POLICY_DISABLE)
+	if err != nil {
+		t.Fatalf("error downloading: %v", err)
+	}
+
+	// The payload is counted after the last block is sent
+	deadline := time.Now().Add(time.Second)
+	for limits.Stats.PayloadUploaded() < int64(len(content)) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := limits.Stats.PayloadUploaded(); n != int64(len(content)) {
+		t.Errorf("expected %d bytes of uploaded payload, got %d", len(content), n)
+	}
+	if limits.Stats.OverheadUploaded() <= 0 {
+		t.Error("expected the handshakes and message headers to be counted as overhead")
+	}
+}
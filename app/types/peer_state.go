@@ -0,0 +1,117 @@
+package types
+
+import "fmt"
+
+// IsChoked reports whether the peer is choking us.
+func (p *Peer) IsChoked() bool {
+	return !p.unchoked
+}
+
+// HasPiece reports whether the peer has announced that it has the piece.
+func (p *Peer) HasPiece(pieceIdx uint32) bool {
+	if p.hasAll {
+		return true
+	}
+	byteIdx := pieceIdx / 8
+	return int(byteIdx) < len(p.pieces) && p.pieces[byteIdx]&(0x80>>(pieceIdx%8)) != 0
+}
+
+// CanDownload reports whether the piece can be requested from the peer right
+// now. While we are choked, only the pieces in the allowed fast set can be
+// requested, if the fast extension is supported.
+func (p *Peer) CanDownload(pieceIdx uint32) bool {
+	if !p.HasPiece(pieceIdx) {
+		return false
+	}
+	return p.unchoked || p.allowedFast[pieceIdx]
+}
+
+func (p *Peer) setHave(pieceIdx uint32) {
+	byteIdx := int(pieceIdx / 8)
+	for len(p.pieces) <= byteIdx {
+		p.pieces = append(p.pieces, 0)
+	}
+	p.pieces[byteIdx] |= 0x80 >> (pieceIdx % 8)
+}
+
+// handleStateMessage updates the state of the peer from messages that change
+// its choke state or the pieces that it has. It reports whether the message
+// was such a message. The messages of the fast extension are only accepted
+// if it was negotiated in the handshake.
+func (p *Peer) handleStateMessage(msg []byte) (bool, error) {
+	switch msg[0] {
+	case CHOKE_MESSAGE_ID:
+		p.unchoked = false
+	case UNCHOKE_MESSAGE_ID:
+		p.unchoked = true
+	case BITFIELD_MESSAGE_ID:
+		p.pieces = append([]byte{}, msg[1:]...)
+		p.hasAll = false
+	case HAVE_MESSAGE_ID:
+		idx, err := parsePieceIndexMessage(msg)
+		if err != nil {
+			return true, err
+		}
+		p.setHave(idx)
+
+	case HAVE_ALL_MESSAGE_ID, HAVE_NONE_MESSAGE_ID, SUGGEST_PIECE_MESSAGE_ID, ALLOWED_FAST_MESSAGE_ID:
+		if !p.SupportsFast {
+			return true, p.protocolError("received fast extension message with ID %d, but the extension was not negotiated", msg[0])
+		}
+		return true, p.handleFastMessage(msg)
+
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (p *Peer) handleFastMessage(msg []byte) error {
+	switch msg[0] {
+	case HAVE_ALL_MESSAGE_ID:
+		p.hasAll = true
+	case HAVE_NONE_MESSAGE_ID:
+		p.hasAll = false
+		p.pieces = nil
+
+	case SUGGEST_PIECE_MESSAGE_ID:
+		idx, err := parsePieceIndexMessage(msg)
+		if err != nil {
+			return err
+		}
+		p.Log("peer suggested piece %d", idx)
+
+	case ALLOWED_FAST_MESSAGE_ID:
+		idx, err := parsePieceIndexMessage(msg)
+		if err != nil {
+			return err
+		}
+		if p.allowedFast == nil {
+			p.allowedFast = make(map[uint32]bool)
+		}
+		p.allowedFast[idx] = true
+	}
+	return nil
+}
+
+// AwaitPieceUpdate blocks until the peer sends a message that can change
+// which pieces can be downloaded from it, like an unchoke or a have message.
+func (p *Peer) AwaitPieceUpdate() error {
+	for {
+		msg, err := p.RecieveMessage()
+		if err != nil {
+			return fmt.Errorf("error receiving message: %w", err)
+		}
+
+		handled, err := p.handleStateMessage(msg)
+		if err != nil {
+			return err
+		}
+		if handled && msg[0] != CHOKE_MESSAGE_ID && msg[0] != SUGGEST_PIECE_MESSAGE_ID {
+			return nil
+		}
+		if !handled {
+			p.Log("Recieved message bytes: %q while waiting for piece updates", msg)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// The ID that we advertise for the "ut_pex"
+// extension in DEFAULT_EXTENSIONS.
+const UT_PEX_EXTENSION_ID = 2
+
+// PEX messages must not be sent more often than once a minute, and
+// should contain at most 50 added and 50 dropped peers (BEP 11).
+const PEX_INTERVAL = time.Minute
+const PEX_MAX_PEERS = 50
+
+// The number of peers received with PEX that can wait to be connected to.
+// Peers received while the queue is full are only kept in the peer pool.
+const PEX_DISCOVERED_QUEUE_SIZE = 4 * PEX_MAX_PEERS
+
+// Flags describing a peer in the "added.f" and "added6.f" keys.
+const (
+	PEX_FLAG_ENCRYPTION = 0x01 // Prefers encryption
+	PEX_FLAG_SEED       = 0x02 // Is a seed or partial seed
+	PEX_FLAG_UTP        = 0x04 // Supports uTP
+	PEX_FLAG_HOLEPUNCH  = 0x08 // Supports the ut_holepunch extension
+	PEX_FLAG_OUTGOING   = 0x10 // Is reachable, as the connection was made by the sender
+)
+
+// PexPeer is a single peer in a PEX message.
+type PexPeer struct {
+	Addr  string // In the "host:port" format
+	Flags byte
+}
+
+// PexMessage is the payload of a ut_pex extended message.
+type PexMessage struct {
+	Added   []PexPeer
+	Dropped []string
+}
+
+// Bytes encodes the message as a bencoded dictionary. IPv4 and IPv6
+// peers are stored in separate keys as compact peer lists.
+func (m *PexMessage) Bytes() []byte {
+	var added, addedFlags, added6, added6Flags, dropped, dropped6 []byte
+
+	for _, p := range m.Added {
+		compact, ipv6 := encodeCompactAddr(p.Addr)
+		if compact == nil {
+			continue
+		}
+		if ipv6 {
+			added6 = append(added6, compact...)
+			added6Flags = append(added6Flags, p.Flags)
+		} else {
+			added = append(added, compact...)
+			addedFlags = append(addedFlags, p.Flags)
+		}
+	}
+	for _, addr := range m.Dropped {
+		compact, ipv6 := encodeCompactAddr(addr)
+		if compact == nil {
+			continue
+		}
+		if ipv6 {
+			dropped6 = append(dropped6, compact...)
+		} else {
+			dropped = append(dropped, compact...)
+		}
+	}
+
+	d := bencode.NewBencodeDictionary()
+	d.Add("added", bencode.NewDataString(string(added)))
+	d.Add("added.f", bencode.NewDataString(string(addedFlags)))
+	d.Add("added6", bencode.NewDataString(string(added6)))
+	d.Add("added6.f", bencode.NewDataString(string(added6Flags)))
+	d.Add("dropped", bencode.NewDataString(string(dropped)))
+	d.Add("dropped6", bencode.NewDataString(string(dropped6)))
+	return d.Encode()
+}
+
+// NewPexMessageFromBytes parses the payload of a ut_pex extended message.
+func NewPexMessageFromBytes(data []byte) (*PexMessage, error) {
+	bd, err := bencode.NewBencodeData(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding pex message: %w", err)
+	}
+	if _, err := bd.AsDictionary(); err != nil {
+		return nil, fmt.Errorf("invalid pex message: %w", err)
+	}
+
+	// Missing keys and keys of other types are treated as empty. The keys
+	// are quoted in the path, as "added.f" contains a '.'.
+	getBytes := func(key string) []byte {
+		b, _ := bd.LookupBytes(fmt.Sprintf("[%q]", key))
+		return b
+	}
+
+	m := &PexMessage{}
+	for _, ipv6 := range []bool{false, true} {
+		suffix, size := "", 6
+		if ipv6 {
+			suffix, size = "6", 18
+		}
+
+		added := decodeCompactAddrs(getBytes("added"+suffix), size)
+		flags := getBytes("added" + suffix + ".f")
+		for i, addr := range added {
+			p := PexPeer{Addr: addr}
+			if i < len(flags) {
+				p.Flags = flags[i]
+			}
+			m.Added = append(m.Added, p)
+		}
+		m.Dropped = append(m.Dropped, decodeCompactAddrs(getBytes("dropped"+suffix), size)...)
+	}
+
+	return m, nil
+}
+
+// encodeCompactAddr encodes a "host:port" address as a compact peer info.
+// Returns nil if the address is invalid.
+func encodeCompactAddr(addr string) (compact []byte, ipv6 bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, false
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return binary.BigEndian.AppendUint16(v4, uint16(portInt)), false
+	}
+	return binary.BigEndian.AppendUint16(ip.To16(), uint16(portInt)), true
+}
+
+// decodeCompactAddrs splits a compact peer list into "host:port" addresses.
+// The size is 6 for IPv4 lists, and 18 for IPv6 lists.
+func decodeCompactAddrs(b []byte, size int) []string {
+	addrs := make([]string, 0)
+	for i := 0; i+size <= len(b); i += size {
+		ip := net.IP(b[i : i+size-2])
+		port := binary.BigEndian.Uint16(b[i+size-2 : i+size])
+		addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return addrs
+}
+
+// PeerExchange implements the ut_pex extension (BEP 11) for a torrent. It
+// tracks the peers that we are connected to, so that they can be advertised
+// to the other peers, and adds the peers advertised to us to the peer pool.
+// The new peers are also queued, so that they can be connected to while
+// downloading.
+type PeerExchange struct {
+	pool       *PeerPool
+	discovered chan string
+
+	mu        sync.Mutex
+	connected map[string]byte // Address of the connected peers to their flags
+}
+
+func NewPeerExchange(pool *PeerPool) *PeerExchange {
+	return &PeerExchange{
+		pool:       pool,
+		discovered: make(chan string, PEX_DISCOVERED_QUEUE_SIZE),
+		connected:  make(map[string]byte),
+	}
+}
+
+// Discovered returns the queue of the addresses of the peers that were
+// added to the pool by PEX messages.
+func (px *PeerExchange) Discovered() <-chan string {
+	return px.discovered
+}
+
+// Connect connects to the peer at the address with the settings of the
+// pool, and attaches the peer exchange to it.
+func (px *PeerExchange) Connect(addr string) (*Peer, error) {
+	p, err := px.pool.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	p.EnablePex(px)
+	return p, nil
+}
+
+// AddConnected registers a peer that we have completed the handshake with.
+func (px *PeerExchange) AddConnected(p *Peer, flags byte) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	px.connected[p.Addr()] = flags
+}
+
+// RemoveConnected removes a peer that we have disconnected from.
+func (px *PeerExchange) RemoveConnected(p *Peer) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	delete(px.connected, p.Addr())
+}
+
+// HandleMessage adds the peers advertised by a ut_pex message to the peer
+// pool, and queues the new ones in Discovered. Only the first PEX_MAX_PEERS
+// added peers of a message are used, as no more are allowed.
+func (px *PeerExchange) HandleMessage(data []byte) (int, error) {
+	m, err := NewPexMessageFromBytes(data)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, p := range m.Added[:min(len(m.Added), PEX_MAX_PEERS)] {
+		if !px.pool.Add(p.Addr, PEER_SOURCE_PEX) {
+			continue
+		}
+		added++
+
+		select {
+		case px.discovered <- p.Addr:
+		default:
+		}
+	}
+	return added, nil
+}
+
+// nextMessage returns the PEX message to be sent to a peer, given the set of
+// peers that were advertised to it previously. The sent set is updated in place.
+func (px *PeerExchange) nextMessage(to *Peer, sent map[string]bool) *PexMessage {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	m := &PexMessage{}
+	for addr, flags := range px.connected {
+		if addr == to.Addr() || sent[addr] || len(m.Added) >= PEX_MAX_PEERS {
+			continue
+		}
+		m.Added = append(m.Added, PexPeer{Addr: addr, Flags: flags})
+		sent[addr] = true
+	}
+	for addr := range sent {
+		if _, ok := px.connected[addr]; ok || len(m.Dropped) >= PEX_MAX_PEERS {
+			continue
+		}
+		m.Dropped = append(m.Dropped, addr)
+		delete(sent, addr)
+	}
+	return m
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -54,7 +55,11 @@ func (pb *pieceBlock) makeRequest(p *Peer, pieceIdx uint32) error {
 type StoredPiece struct {
 	Index  uint32
 	Length uint32 // The total length of the piece, in bytes
-	Hash   []byte
+	Hash   []byte // The SHA-1 hash for v1 pieces, or the merkle root for v2 pieces
+
+	// The number of leaves of the merkle tree of a v2 piece, which can be more
+	// than the number of blocks for the last piece of a file. 0 for v1 pieces.
+	MerkleLeaves uint32
 
 	NumberOfBlocks     uint32 // The number of blocks in the piece
 	Blocks             []*pieceBlock
@@ -64,25 +69,61 @@ type StoredPiece struct {
 }
 
 func (p *Peer) DownloadPiece(index, length uint32, hash []byte) (*StoredPiece, error) {
-	if p.assignedPiece != nil {
-		return nil, fmt.Errorf("peer already has an assigned piece")
+	return p.downloadPiece(newStoredPiece(p, index, length, hash))
+}
+
+// DownloadPieceV2 downloads a piece of a v2 torrent, which is verified with
+// the merkle root of the piece instead of a SHA-1 hash.
+func (p *Peer) DownloadPieceV2(index, length uint32, root []byte, merkleLeaves uint32) (*StoredPiece, error) {
+	sp := newStoredPiece(p, index, length, root)
+	sp.MerkleLeaves = merkleLeaves
+	return p.downloadPiece(sp)
+}
+
+// numberOfBlocks returns the number of blocks of a piece, without
+// overflowing for pieces close to the maximum length.
+func numberOfBlocks(length uint32) uint32 {
+	n := length / BLOCK_SIZE
+	if length%BLOCK_SIZE != 0 {
+		n++
 	}
+	return n
+}
 
-	sp := &StoredPiece{
+func newStoredPiece(p *Peer, index, length uint32, hash []byte) *StoredPiece {
+	return &StoredPiece{
 		Index:  index,
 		Length: length,
 		Hash:   hash,
 
-		NumberOfBlocks: (length + BLOCK_SIZE - 1) / BLOCK_SIZE,
+		NumberOfBlocks: numberOfBlocks(length),
 		Blocks:         make([]*pieceBlock, 0),
 		peerConn:       p.conn,
 	}
+}
 
+func (p *Peer) downloadPiece(sp *StoredPiece) (*StoredPiece, error) {
+	if p.assignedPiece != nil {
+		return nil, fmt.Errorf("peer already has an assigned piece")
+	}
+	if !p.HasPiece(sp.Index) {
+		return nil, fmt.Errorf("peer doesn't have piece %d", sp.Index)
+	}
+
+	// Wait until the piece can be requested, eg. for the peer to unchoke us
+	for !p.CanDownload(sp.Index) {
+		err := p.AwaitPieceUpdate()
+		if err != nil {
+			return nil, fmt.Errorf("error waiting for piece %d to be available: %w", sp.Index, err)
+		}
+	}
+
+	length := sp.Length
 	currentOffset := uint32(0)
 
 	for range sp.NumberOfBlocks {
 		blockLength := BLOCK_SIZE
-		if currentOffset+BLOCK_SIZE > length {
+		if length-currentOffset < BLOCK_SIZE {
 			blockLength = length - currentOffset
 		}
 
@@ -96,15 +137,19 @@ func (p *Peer) DownloadPiece(index, length uint32, hash []byte) (*StoredPiece, e
 	p.assignedPiece = sp
 	p.Log("assigned piece %d", sp.Index)
 
+	// Reset the assigned piece to nil so that the peer can download another piece,
+	// even if this one fails
+	defer func() {
+		p.assignedPiece = nil
+		p.memoryUsed = 0
+	}()
+
 	sp.makeInitialDownloadRequests(p)
 	err := p.getCompletePiece()
 	if err != nil {
 		return nil, fmt.Errorf("error getting complete piece: %w", err)
 	}
 
-	// Reset the assigned piece to nil so that the peer can download another piece
-	p.assignedPiece = nil
-
 	return sp, nil
 }
 
@@ -155,13 +200,28 @@ func (sp *StoredPiece) GetData() []byte {
 }
 
 func (sp *StoredPiece) VerifyHash() error {
-	hash, err := utils.SHA1Hash(sp.GetData())
+	return verifyPieceHash(sp.GetData(), sp.Hash, sp.MerkleLeaves)
+}
+
+// verifyPieceHash checks the data of a piece against its SHA-1 hash, or
+// against its merkle root if the number of merkle leaves is set.
+func verifyPieceHash(data []byte, hash []byte, merkleLeaves uint32) error {
+	if merkleLeaves > 0 {
+		pad := make([]byte, sha256.Size)
+		root := merkleRoot(blockHashes(data), int(merkleLeaves), pad)
+		if !bytes.Equal(root, hash) {
+			return fmt.Errorf("piece merkle root verification failed, expected %x, got %x", hash, root)
+		}
+		return nil
+	}
+
+	h, err := utils.SHA1Hash(data)
 	if err != nil {
 		return fmt.Errorf("error hashing piece data: %w", err)
 	}
 
-	if !bytes.Equal(hash, sp.Hash) {
-		return fmt.Errorf("piece hash verification failed, expected %x, got %x", sp.Hash, hash)
+	if !bytes.Equal(h, hash) {
+		return fmt.Errorf("piece hash verification failed, expected %x, got %x", hash, h)
 	}
 
 	return nil
@@ -0,0 +1,188 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+	"github.com/EshaanAgg/toy-bittorrent/app/utils"
+)
+
+// The piece length used when creating torrents, if none is passed.
+const DEFAULT_PIECE_LENGTH = 256 * 1024
+
+// TorrentCreateOptions configures the creation of a v1 torrent file.
+type TorrentCreateOptions struct {
+	Path        string // A file, or a directory for a multi-file torrent
+	TrackerURL  string
+	PieceLength int      // DEFAULT_PIECE_LENGTH if 0, must be a power of two
+	Private     bool     // Sets the "private" flag (BEP 27), which changes the info hash
+	WebSeeds    []string // Stored in the "url-list" key (BEP 19)
+}
+
+// CreateTorrent creates a torrent file for the file or directory, and
+// returns its bencoded bytes. The files of a directory are added in
+// lexical order of their paths.
+func CreateTorrent(opts TorrentCreateOptions) ([]byte, error) {
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = DEFAULT_PIECE_LENGTH
+	}
+	if pieceLength < int(BLOCK_SIZE) || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two of at least %d bytes, got %d", BLOCK_SIZE, pieceLength)
+	}
+
+	stat, err := os.Stat(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading path: %w", err)
+	}
+
+	// Collect the files with their paths relative to the directory
+	var paths [][]string
+	if stat.IsDir() {
+		paths, err = listFiles(opts.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("directory %s has no files", opts.Path)
+		}
+	}
+
+	h := newPieceHasher(pieceLength)
+	info := infoDictFields{
+		Name:        filepath.Base(filepath.Clean(opts.Path)),
+		PieceLength: pieceLength,
+	}
+	if stat.IsDir() {
+		info.Files = make([]fileV1Fields, 0, len(paths))
+		for _, path := range paths {
+			n, err := hashFile(h, filepath.Join(append([]string{opts.Path}, path...)...))
+			if err != nil {
+				return nil, err
+			}
+			info.Files = append(info.Files, fileV1Fields{Length: n, Path: path})
+		}
+	} else {
+		n, err := hashFile(h, opts.Path)
+		if err != nil {
+			return nil, err
+		}
+		info.Length = n
+	}
+
+	info.Pieces, err = h.finish()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+
+	infoData, err := bencode.MarshalData(info)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the info dictionary: %w", err)
+	}
+	return bencode.Marshal(metainfo{
+		Announce: opts.TrackerURL,
+		URLList:  opts.WebSeeds,
+		Info:     infoData,
+	})
+}
+
+// listFiles returns the paths of the regular files in the directory,
+// split into their components.
+func listFiles(dir string) ([][]string, error) {
+	paths := make([][]string, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, strings.Split(filepath.ToSlash(rel), "/"))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing files of %s: %w", dir, err)
+	}
+	return paths, nil
+}
+
+// pieceHasher computes the SHA-1 hashes of the pieces of the files
+// written to it, which are laid out one after the other.
+type pieceHasher struct {
+	pieceLength int
+	buf         []byte
+	pieces      []byte
+}
+
+func newPieceHasher(pieceLength int) *pieceHasher {
+	return &pieceHasher{
+		pieceLength: pieceLength,
+		buf:         make([]byte, 0, pieceLength),
+		pieces:      make([]byte, 0),
+	}
+}
+
+func (h *pieceHasher) Write(data []byte) (int, error) {
+	n := len(data)
+	for len(data) > 0 {
+		take := min(h.pieceLength-len(h.buf), len(data))
+		h.buf = append(h.buf, data[:take]...)
+		data = data[take:]
+
+		if len(h.buf) == h.pieceLength {
+			if err := h.hashPiece(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (h *pieceHasher) hashPiece() error {
+	hash, err := utils.SHA1Hash(h.buf)
+	if err != nil {
+		return fmt.Errorf("error hashing piece: %w", err)
+	}
+	h.pieces = append(h.pieces, hash...)
+	h.buf = h.buf[:0]
+	return nil
+}
+
+// finish hashes the last, possibly shorter, piece and returns all the hashes.
+func (h *pieceHasher) finish() ([]byte, error) {
+	if len(h.buf) > 0 {
+		if err := h.hashPiece(); err != nil {
+			return nil, err
+		}
+	}
+	return h.pieces, nil
+}
+
+// hashFile writes the contents of the file to the hasher,
+// and returns the length of the file.
+func hashFile(h *pieceHasher, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening file: %w", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, fmt.Errorf("error reading file %s: %w", path, err)
+	}
+	return int(n), nil
+}
@@ -1,24 +1,57 @@
 package types
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"strings"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
 	"github.com/EshaanAgg/toy-bittorrent/app/utils"
 )
 
+// ErrInfoHashMismatch is returned when the metadata received from a peer
+// does not match the info hash of the magnet link.
+var ErrInfoHashMismatch = errors.New("info hash mismatch")
+
 type InfoDict struct {
 	Length      int
 	Name        string
 	PieceLength int
-	Pieces      [][]byte // Hashes of each piece
+	Pieces      [][]byte // SHA-1 hashes of each piece, nil for v2 only torrents
+	Private     bool     // Peers must only be obtained from the tracker (BEP 27)
+
+	MetaVersion int       // 2 for v2 and hybrid torrents (BEP 52), 1 otherwise
+	Files       []*FileV2 // The files from the v2 file tree
+	FilesV1     []*FileV1 // The files of v1 multi-file torrents, nil for single-file torrents
+}
+
+// FileV1 is a file from the "files" list of a v1 multi-file torrent.
+// The files are laid out one after the other in the piece space.
+type FileV1 struct {
+	Path    []string
+	Length  int
+	Padding bool // Padding files only contain zeros, and are never downloaded
 }
 
 type TorrentFileInfo struct {
-	TrackerURL string
-	InfoDict   *InfoDict
+	TrackerURL   string
+	AnnounceList [][]string // Tiers of trackers (BEP 12), which replace TrackerURL if present
+	InfoDict     *InfoDict
+
+	// The info hash used in handshakes and peer discovery. This is the
+	// SHA-1 info hash for v1 and hybrid torrents, and the v2 info hash
+	// truncated to 20 bytes for v2 only torrents.
 	InfoHash   []byte
+	InfoHashV2 []byte // The SHA-256 info hash, nil for v1 torrents
+
+	// The bencoded info dictionary, as it was in the torrent file. It is
+	// hashed for the info hashes, and served to peers with "ut_metadata".
+	InfoBytes []byte
+
+	WebSeeds []string // HTTP servers that host the files of the torrent (BEP 19)
 }
 
 func piecesFromString(pieces []byte) [][]byte {
@@ -36,54 +69,537 @@ func piecesFromString(pieces []byte) [][]byte {
 	return pieceHashes
 }
 
+// metainfo is the layout of a torrent file. The info dictionary is kept
+// decoded, as the info hash is computed from it.
+type metainfo struct {
+	Announce     string               `bencode:"announce,omitempty"`
+	AnnounceList announceList         `bencode:"announce-list,omitempty"`
+	URLList      urlList              `bencode:"url-list,omitempty"`
+	Info         *bencode.BencodeData `bencode:"info"`
+	PieceLayers  map[string][]byte    `bencode:"piece layers,omitempty"`
+}
+
+// infoDictFields is the layout of the info dictionary. Torrents have either
+// the "length" or the "files" key, and v2 torrents have the "file tree".
+type infoDictFields struct {
+	Name        string               `bencode:"name"`
+	PieceLength int                  `bencode:"piece length"`
+	Pieces      []byte               `bencode:"pieces,omitempty"`
+	Length      int                  `bencode:"length,omitempty"`
+	Files       []fileV1Fields       `bencode:"files,omitempty"`
+	Private     int                  `bencode:"private,omitempty"`
+	MetaVersion int                  `bencode:"meta version,omitempty"`
+	FileTree    *bencode.BencodeData `bencode:"file tree,omitempty"`
+}
+
+type fileV1Fields struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	Attr   string   `bencode:"attr,omitempty"`
+}
+
+// announceList is the "announce-list" of a torrent, with tiers of tracker
+// URLs (BEP 12). Invalid entries and empty tiers are skipped.
+type announceList [][]string
+
+func (l *announceList) UnmarshalBencode(data []byte) error {
+	var tiers []any
+	err := bencode.Unmarshal(data, &tiers)
+	if err != nil {
+		return err
+	}
+
+	*l = make(announceList, 0)
+	for _, t := range tiers {
+		urls, ok := t.([]any)
+		if !ok {
+			continue
+		}
+		tier := make([]string, 0)
+		for _, u := range urls {
+			if s, ok := u.(string); ok && s != "" {
+				tier = append(tier, s)
+			}
+		}
+		if len(tier) > 0 {
+			*l = append(*l, tier)
+		}
+	}
+	return nil
+}
+
+// urlList is the "url-list" of a torrent, which is either a single URL
+// or a list of URLs.
+type urlList []string
+
+func (l *urlList) UnmarshalBencode(data []byte) error {
+	var v any
+	err := bencode.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	urls := make([]string, 0)
+	switch v := v.(type) {
+	case string:
+		urls = append(urls, v)
+	case []any:
+		for _, u := range v {
+			if s, ok := u.(string); ok {
+				urls = append(urls, s)
+			}
+		}
+	}
+
+	// Empty URLs are used by some tools to mean there are no web seeds
+	*l = make(urlList, 0, len(urls))
+	for _, u := range urls {
+		if u != "" {
+			*l = append(*l, u)
+		}
+	}
+	return nil
+}
+
+// parseFilesV1 parses the "files" list of a v1 multi-file torrent.
+func parseFilesV1(files []fileV1Fields) ([]*FileV1, error) {
+	parsed := make([]*FileV1, 0, len(files))
+	for _, f := range files {
+		if f.Length < 0 {
+			return nil, fmt.Errorf("invalid file length: %d", f.Length)
+		}
+		if f.Path == nil {
+			return nil, fmt.Errorf("invalid file path")
+		}
+		parsed = append(parsed, &FileV1{
+			Path:    f.Path,
+			Length:  f.Length,
+			Padding: strings.Contains(f.Attr, "p"),
+		})
+	}
+	return parsed, nil
+}
+
+// addLength adds the length of a file to the total length of a torrent, and
+// returns an error if the total is negative or larger than MAX_TORRENT_LENGTH.
+func addLength(total int, length int) (int, error) {
+	limit := int64(min(MAX_TORRENT_LENGTH, math.MaxInt))
+	if length < 0 || int64(length) > limit-int64(total) {
+		return 0, fmt.Errorf("invalid length %d: the total length must be between 0 and %d bytes", length, limit)
+	}
+	return total + length, nil
+}
+
+func newInfoDict(info *bencode.BencodeData) (*InfoDict, error) {
+	var fields infoDictFields
+	err := bencode.UnmarshalData(info, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the info dictionary: %w", err)
+	}
+	if fields.PieceLength <= 0 || int64(fields.PieceLength) > MAX_PIECE_LENGTH {
+		return nil, fmt.Errorf("invalid piece length: %d", fields.PieceLength)
+	}
+
+	d := &InfoDict{
+		Name:        fields.Name,
+		PieceLength: fields.PieceLength,
+		Private:     fields.Private == 1,
+		MetaVersion: 1,
+	}
+	if fields.MetaVersion != 0 {
+		d.MetaVersion = fields.MetaVersion
+	}
+
+	// v1 and hybrid torrents have the SHA-1 piece hashes
+	if fields.Pieces != nil {
+		if len(fields.Pieces)%20 != 0 {
+			return nil, fmt.Errorf("invalid length of the piece hashes: %d is not a multiple of 20", len(fields.Pieces))
+		}
+		d.Pieces = piecesFromString(fields.Pieces)
+		if int64(len(d.Pieces)) > MAX_PIECE_COUNT {
+			return nil, fmt.Errorf("too many pieces: %d", len(d.Pieces))
+		}
+		d.Length, err = addLength(0, fields.Length)
+		if err != nil {
+			return nil, err
+		}
+		if fields.Files != nil {
+			filesV1, err := parseFilesV1(fields.Files)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing the files: %w", err)
+			}
+			d.FilesV1 = filesV1
+			for _, f := range filesV1 {
+				d.Length, err = addLength(d.Length, f.Length)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// Every piece must be part of the torrent, else the size of the
+		// extra pieces would wrap around when it is computed
+		numPieces := (int64(d.Length) + int64(d.PieceLength) - 1) / int64(d.PieceLength)
+		if int64(len(d.Pieces)) != numPieces {
+			return nil, fmt.Errorf("invalid number of piece hashes: expected %d for a length of %d, got %d", numPieces, d.Length, len(d.Pieces))
+		}
+	}
+
+	if d.MetaVersion == META_VERSION_2 {
+		files, err := parseFilesV2(fields.FileTree, d.PieceLength)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the file tree: %w", err)
+		}
+		d.Files = files
+
+		if d.Pieces == nil {
+			for _, f := range files {
+				d.Length, err = addLength(d.Length, f.Length)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if d.Pieces == nil && d.Files == nil {
+		return nil, fmt.Errorf("info dictionary has neither pieces nor a file tree")
+	}
+	return d, nil
+}
+
+// infoBytes returns the bytes of the info dictionary as they were in the
+// torrent file, as re-encoding it would change the info hash of torrents
+// with unsorted or duplicate keys.
+func infoBytes(info *bencode.BencodeData) []byte {
+	if info.Raw != nil {
+		return info.Raw
+	}
+	return info.Value.Encode()
+}
+
+// infoHashes returns the info hash used for the torrent, and the v2 info
+// hash for v2 and hybrid torrents, from the bytes of the info dictionary.
+func infoHashes(encoded []byte, parsed *InfoDict) ([]byte, []byte, error) {
+
+	var infoHashV2 []byte
+	if parsed.MetaVersion == META_VERSION_2 {
+		var err error
+		infoHashV2, err = utils.SHA256Hash(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error hashing the info dictionary: %w", err)
+		}
+		if parsed.Pieces == nil {
+			return infoHashV2[:20], infoHashV2, nil
+		}
+	}
+
+	infoHash, err := utils.SHA1Hash(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error hashing the info dictionary: %w", err)
+	}
+	return infoHash, infoHashV2, nil
+}
+
 // NewTorrentFileInfo creates a new TorrentFileInfo struct from the given torrent file path.
 func NewTorrentFileInfo(torrentFilePath string) (*TorrentFileInfo, error) {
-	fileContent, err := os.ReadFile(torrentFilePath)
+	f, err := os.Open(torrentFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("error reading file: %w", err)
 	}
+	defer f.Close()
 
-	bd, err := bencode.NewBencodeData(fileContent)
+	// The info dictionary is hashed as it was in the file
+	dec := bencode.NewDecoder(f)
+	dec.KeepRaw("info")
+	bd, err := dec.Decode()
 	if err != nil {
 		return nil, fmt.Errorf("error decoding the file: %w", err)
 	}
 
-	// Access the nested elements directly as we can be assured
-	// that the file passed is a valid torrent file
-	d := bd.GetDictionary()
-	infoDict := d.Map["info"].GetDictionary()
+	var m metainfo
+	err = bencode.UnmarshalData(bd, &m)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the file: %w", err)
+	}
+	if _, err := bd.LookupDictionary("info"); err != nil {
+		return nil, fmt.Errorf("invalid info dictionary: %w", err)
+	}
+	info := infoBytes(m.Info)
 
-	// Parse the info dictionary to get the info hash
-	infoHash, err := utils.SHA1Hash(infoDict.Encode())
+	parsed, err := newInfoDict(m.Info)
+	if err != nil {
+		return nil, err
+	}
+	infoHash, infoHashV2, err := infoHashes(info, parsed)
 	if err != nil {
-		return nil, fmt.Errorf("error hashing the info dictionary: %w", err)
+		return nil, err
 	}
 
-	return &TorrentFileInfo{
-		TrackerURL: string(d.Map["announce"].GetString().Value),
-		InfoHash:   infoHash,
-		InfoDict: &InfoDict{
-			Length:      infoDict.Map["length"].GetInteger().Value,
-			Name:        string(infoDict.Map["name"].GetString().Value),
-			PieceLength: infoDict.Map["piece length"].GetInteger().Value,
-			Pieces:      piecesFromString(infoDict.Map["pieces"].GetString().Value),
-		},
-	}, nil
+	// The piece layers of v2 torrents are stored outside of the info dictionary
+	if m.PieceLayers != nil && parsed.Files != nil {
+		err = setPieceLayers(m.PieceLayers, parsed.Files, parsed.PieceLength)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := &TorrentFileInfo{
+		TrackerURL:   m.Announce,
+		AnnounceList: m.AnnounceList,
+		InfoHash:     infoHash,
+		InfoHashV2:   infoHashV2,
+		InfoDict:     parsed,
+		InfoBytes:    info,
+		WebSeeds:     make([]string, 0),
+	}
+	if m.URLList != nil {
+		t.WebSeeds = m.URLList
+	}
+	return t, nil
 }
 
-func NewTorrentFileInfoFromMagnet(magnet *MagnetURI, infoDict *bencode.BencodeDictionary) (*TorrentFileInfo, error) {
+// Trackers returns the URLs of all the trackers of the torrent, tier by tier.
+// If the announce list is present, the "announce" key is ignored (BEP 12).
+func (t *TorrentFileInfo) Trackers() []string {
+	trackers := make([]string, 0)
+	if len(t.AnnounceList) == 0 {
+		if t.TrackerURL != "" {
+			trackers = append(trackers, t.TrackerURL)
+		}
+		return trackers
+	}
+
+	for _, tier := range t.AnnounceList {
+		trackers = append(trackers, tier...)
+	}
+	return trackers
+}
+
+// NewTorrentFileInfoFromMagnet creates the TorrentFileInfo from the info
+// dictionary that was received from a peer with "ut_metadata".
+func NewTorrentFileInfoFromMagnet(magnet *MagnetURI, info *bencode.BencodeData) (*TorrentFileInfo, error) {
+	// The metadata comes from an untrusted peer, so it must be checked
+	// before it is parsed, used or served to other peers
+	err := verifyInfoHash(magnet, infoBytes(info))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := newInfoDict(info)
+	if err != nil {
+		return nil, err
+	}
+	_, infoHashV2, err := infoHashes(infoBytes(info), parsed)
+	if err != nil {
+		return nil, err
+	}
+
 	return &TorrentFileInfo{
 		TrackerURL: magnet.TrackerURL,
 		InfoHash:   magnet.InfoHash,
-		InfoDict: &InfoDict{
-			Length:      infoDict.Map["length"].GetInteger().Value,
-			Name:        string(infoDict.Map["name"].GetString().Value),
-			PieceLength: infoDict.Map["piece length"].GetInteger().Value,
-			Pieces:      piecesFromString(infoDict.Map["pieces"].GetString().Value),
-		},
+		InfoHashV2: infoHashV2,
+		InfoDict:   parsed,
+		InfoBytes:  infoBytes(info),
+		WebSeeds:   magnet.WebSeeds,
 	}, nil
 }
 
+// verifyInfoHash checks that the info dictionary matches the info hashes of
+// the magnet link. The info hash of a v2 only magnet link is the truncated
+// v2 info hash.
+func verifyInfoHash(magnet *MagnetURI, encoded []byte) error {
+	infoHashV2, err := utils.SHA256Hash(encoded)
+	if err != nil {
+		return fmt.Errorf("error hashing the info dictionary: %w", err)
+	}
+	if magnet.InfoHashV2 != nil && !bytes.Equal(infoHashV2, magnet.InfoHashV2) {
+		return fmt.Errorf("%w: expected v2 info hash %x, got %x", ErrInfoHashMismatch, magnet.InfoHashV2, infoHashV2)
+	}
+
+	infoHash, err := utils.SHA1Hash(encoded)
+	if err != nil {
+		return fmt.Errorf("error hashing the info dictionary: %w", err)
+	}
+	if !bytes.Equal(infoHash, magnet.InfoHash) && !bytes.Equal(infoHashV2[:20], magnet.InfoHash) {
+		return fmt.Errorf("%w: expected info hash %x, got %x", ErrInfoHashMismatch, magnet.InfoHash, infoHash)
+	}
+	return nil
+}
+
+// IsV2 reports whether the torrent has v2 metadata, which is also
+// the case for hybrid torrents.
+func (t *TorrentFileInfo) IsV2() bool {
+	return t.InfoDict.MetaVersion == META_VERSION_2
+}
+
+// IsHybrid reports whether the torrent can be downloaded by both v1 and v2 peers.
+// The pieces of hybrid torrents are laid out the same way in both versions.
+func (t *TorrentFileInfo) IsHybrid() bool {
+	return t.IsV2() && t.InfoDict.Pieces != nil
+}
+
+// NumPieces returns the number of pieces of the torrent.
+func (t *TorrentFileInfo) NumPieces() int {
+	if t.InfoDict.Pieces != nil {
+		return len(t.InfoDict.Pieces)
+	}
+
+	n := 0
+	for _, f := range t.InfoDict.Files {
+		n += f.NumPieces(t.InfoDict.PieceLength)
+	}
+	return n
+}
+
+// fileForPiece returns the v2 file that contains the piece, and the
+// index of the piece within the file.
+func (t *TorrentFileInfo) fileForPiece(pieceIdx int) (*FileV2, int, error) {
+	for _, f := range t.InfoDict.Files {
+		n := f.NumPieces(t.InfoDict.PieceLength)
+		if pieceIdx >= f.FirstPiece && pieceIdx < f.FirstPiece+n {
+			return f, pieceIdx - f.FirstPiece, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("piece index out of range: %d", pieceIdx)
+}
+
+// PieceSize returns the length of the piece in bytes, or 0 if the index is
+// out of range. Pieces of v2 only torrents never span multiple files, so the
+// last piece of every file can be shorter than the others.
+func (t *TorrentFileInfo) PieceSize(pieceIdx int) uint32 {
+	pieceLength := t.InfoDict.PieceLength
+	if t.InfoDict.Pieces != nil {
+		if pieceIdx < 0 || pieceIdx >= len(t.InfoDict.Pieces) {
+			return 0
+		}
+		// The offsets are computed in 64 bits, as the end of the last piece
+		// can be past the largest int on 32-bit platforms
+		end := int64(pieceIdx+1) * int64(pieceLength)
+		if end > int64(t.InfoDict.Length) {
+			return uint32(int64(t.InfoDict.Length) - int64(pieceIdx)*int64(pieceLength))
+		}
+		return uint32(pieceLength)
+	}
+
+	f, i, err := t.fileForPiece(pieceIdx)
+	if err != nil {
+		return 0
+	}
+	return uint32(min(pieceLength, f.Length-i*pieceLength))
+}
+
+// PieceOffset returns the offset of the piece in the data of the torrent, as
+// it is saved by downloading all of its pieces in order. The files of v2 only
+// torrents are not padded to the piece length, so the offset of their pieces
+// depends on the lengths of the files before them.
+func (t *TorrentFileInfo) PieceOffset(pieceIdx int) (int64, error) {
+	pieceLength := int64(t.InfoDict.PieceLength)
+	if t.InfoDict.Pieces != nil {
+		if pieceIdx < 0 || pieceIdx >= len(t.InfoDict.Pieces) {
+			return 0, fmt.Errorf("piece index out of range: %d", pieceIdx)
+		}
+		return int64(pieceIdx) * pieceLength, nil
+	}
+
+	offset := int64(0)
+	for _, f := range t.InfoDict.Files {
+		n := f.NumPieces(t.InfoDict.PieceLength)
+		if pieceIdx >= f.FirstPiece && pieceIdx < f.FirstPiece+n {
+			return offset + int64(pieceIdx-f.FirstPiece)*pieceLength, nil
+		}
+		offset += int64(f.Length)
+	}
+	return 0, fmt.Errorf("piece index out of range: %d", pieceIdx)
+}
+
+// PieceHashV2 returns the merkle root that the v2 piece must hash to,
+// and the number of 16 KiB leaves of its merkle tree.
+func (t *TorrentFileInfo) PieceHashV2(pieceIdx int) ([]byte, uint32, error) {
+	f, i, err := t.fileForPiece(pieceIdx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f.pieceHash(i, t.InfoDict.PieceLength)
+}
+
+// VerifyPiece checks the data of a piece against its SHA-1 hash, or its
+// merkle root for v2 only torrents.
+func (t *TorrentFileInfo) VerifyPiece(pieceIdx int, data []byte) error {
+	if t.InfoDict.Pieces != nil {
+		if pieceIdx < 0 || pieceIdx >= len(t.InfoDict.Pieces) {
+			return fmt.Errorf("piece index out of range: %d", pieceIdx)
+		}
+		return verifyPieceHash(data, t.InfoDict.Pieces[pieceIdx], 0)
+	}
+
+	root, leaves, err := t.PieceHashV2(pieceIdx)
+	if err != nil {
+		return err
+	}
+	return verifyPieceHash(data, root, leaves)
+}
+
 func (t *TorrentFileInfo) GetHexInfoHash() string {
 	return fmt.Sprintf("%x", t.InfoHash)
 }
+
+func (t *TorrentFileInfo) GetHexInfoHashV2() string {
+	return fmt.Sprintf("%x", t.InfoHashV2)
+}
+
+// fileSegment is the part of a file that is covered by a piece.
+type fileSegment struct {
+	Path    []string // Relative to the name of the torrent, nil for single-file torrents
+	Offset  int64    // The offset of the segment in the file
+	Length  int
+	Padding bool
+}
+
+// pieceSegments maps the piece onto the files of the torrent. Pieces of v1
+// and hybrid torrents can span multiple files, while the pieces of v2 only
+// torrents are always contained in a single file.
+func (t *TorrentFileInfo) pieceSegments(pieceIdx int) ([]fileSegment, error) {
+	if pieceIdx < 0 || pieceIdx >= t.NumPieces() {
+		return nil, fmt.Errorf("piece index out of range: %d", pieceIdx)
+	}
+	pieceLength := int64(t.InfoDict.PieceLength)
+	length := int(t.PieceSize(pieceIdx))
+
+	if t.InfoDict.Pieces == nil {
+		f, i, err := t.fileForPiece(pieceIdx)
+		if err != nil {
+			return nil, err
+		}
+		seg := fileSegment{Path: f.Path, Offset: int64(i) * pieceLength, Length: length}
+		// A lone file named after the torrent is a single-file torrent
+		if len(t.InfoDict.Files) == 1 && len(f.Path) == 1 && f.Path[0] == t.InfoDict.Name {
+			seg.Path = nil
+		}
+		return []fileSegment{seg}, nil
+	}
+
+	start := int64(pieceIdx) * pieceLength
+	if t.InfoDict.FilesV1 == nil {
+		return []fileSegment{{Offset: start, Length: length}}, nil
+	}
+
+	segments := make([]fileSegment, 0)
+	end := start + int64(length)
+	fileStart := int64(0)
+	for _, f := range t.InfoDict.FilesV1 {
+		fileEnd := fileStart + int64(f.Length)
+		if fileEnd > start && fileStart < end {
+			segStart := max(start, fileStart)
+			segEnd := min(end, fileEnd)
+			segments = append(segments, fileSegment{
+				Path:    f.Path,
+				Offset:  segStart - fileStart,
+				Length:  int(segEnd - segStart),
+				Padding: f.Padding,
+			})
+		}
+		fileStart = fileEnd
+	}
+	return segments, nil
+}
@@ -0,0 +1,280 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// LintIssue is a problem found in a torrent file. Warnings are for issues
+// that clients can work around, and errors are for torrents that are not
+// valid, or that can't be downloaded correctly.
+type LintIssue struct {
+	Path    string // The path of the value in the torrent, eg. "info.files[2].path"
+	Message string
+	Warning bool
+}
+
+func (i LintIssue) String() string {
+	severity := "error"
+	if i.Warning {
+		severity = "warning"
+	}
+	if i.Path == "" {
+		return fmt.Sprintf("%s: %s", severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", severity, i.Path, i.Message)
+}
+
+// linter collects the issues of a torrent file.
+type linter struct {
+	root   *bencode.BencodeData
+	issues []LintIssue
+}
+
+func (l *linter) errorf(path string, format string, vals ...any) {
+	l.issues = append(l.issues, LintIssue{Path: path, Message: fmt.Sprintf(format, vals...)})
+}
+
+func (l *linter) warnf(path string, format string, vals ...any) {
+	l.issues = append(l.issues, LintIssue{Path: path, Message: fmt.Sprintf(format, vals...), Warning: true})
+}
+
+// lookupErr records the error of a lookup, and reports if the lookup failed.
+// Missing values are only recorded if they are required.
+func (l *linter) lookupErr(err error, required bool) bool {
+	if err == nil {
+		return false
+	}
+	var accessErr *bencode.AccessError
+	if !errors.As(err, &accessErr) {
+		l.errorf("", "%v", err)
+		return true
+	}
+	if errors.Is(err, bencode.ErrNotFound) {
+		if required {
+			l.errorf(accessErr.Path, "required key is missing")
+		}
+		return true
+	}
+	l.errorf(accessErr.Path, "expected a %s, got %s", accessErr.Expected, accessErr.Actual)
+	return true
+}
+
+// LintTorrent checks the bencoded torrent file for issues. The encoding is
+// checked to be canonical, as clients that re-encode the info dictionary
+// compute a different info hash otherwise. The info dictionary is checked
+// for the required keys, the number of piece hashes, the piece length and
+// the file paths, which must not escape the download directory.
+func LintTorrent(data []byte) []LintIssue {
+	l := &linter{}
+
+	violations, err := bencode.Validate(data)
+	if err != nil {
+		l.errorf("", "invalid bencode: %v", err)
+		return l.issues
+	}
+	for _, v := range violations {
+		l.errorf("", "not canonical bencode at %v", v)
+	}
+
+	l.root, err = bencode.NewBencodeData(data)
+	if err != nil {
+		l.errorf("", "invalid bencode: %v", err)
+		return l.issues
+	}
+	if _, err := l.root.AsDictionary(); err != nil {
+		l.lookupErr(err, true)
+		return l.issues
+	}
+
+	l.lintTrackers()
+	if _, err := l.root.LookupDictionary("info"); l.lookupErr(err, true) {
+		return l.issues
+	}
+	l.lintInfo()
+	return l.issues
+}
+
+func (l *linter) lintTrackers() {
+	_, err := l.root.LookupString("announce")
+	l.lookupErr(err, false)
+	tiers, listErr := l.root.LookupList("announce-list")
+	l.lookupErr(listErr, false)
+
+	if errors.Is(err, bencode.ErrNotFound) && (listErr != nil || len(tiers) == 0) {
+		l.warnf("announce", "the torrent has no trackers, so peers can only be found with the DHT or PEX")
+	}
+}
+
+func (l *linter) lintInfo() {
+	name, err := l.root.LookupString("info.name")
+	if !l.lookupErr(err, true) {
+		l.lintPathComponent("info.name", name)
+	}
+
+	pieceLength, err := l.root.LookupInteger("info.piece length")
+	if !l.lookupErr(err, true) {
+		if pieceLength <= 0 {
+			l.errorf("info.piece length", "must be positive, got %d", pieceLength)
+		} else if pieceLength&(pieceLength-1) != 0 {
+			l.errorf("info.piece length", "must be a power of two, got %d", pieceLength)
+		} else if pieceLength < MERKLE_BLOCK_SIZE {
+			l.warnf("info.piece length", "is smaller than the block size of %d bytes", MERKLE_BLOCK_SIZE)
+		}
+	}
+
+	metaVersion, err := l.root.LookupInteger("info.meta version")
+	l.lookupErr(err, false)
+	isV2 := metaVersion == META_VERSION_2
+	if isV2 {
+		if _, err := l.root.LookupDictionary("info.file tree"); !l.lookupErr(err, true) {
+			l.lintFileTree()
+		}
+	}
+
+	pieces, err := l.root.LookupBytes("info.pieces")
+	if l.lookupErr(err, !isV2) {
+		return
+	}
+	if len(pieces)%20 != 0 {
+		l.errorf("info.pieces", "length %d is not a multiple of 20", len(pieces))
+	}
+
+	totalLength, ok := l.lintFilesV1()
+	if ok && pieceLength > 0 {
+		expected := (totalLength + pieceLength - 1) / pieceLength
+		if len(pieces)/20 != expected {
+			l.errorf("info.pieces", "has %d piece hashes, but the total length of %d bytes needs %d pieces", len(pieces)/20, totalLength, expected)
+		}
+	}
+}
+
+// lintFilesV1 checks the "length" or the "files" of a v1 info dictionary,
+// and returns the total length of the torrent if it is valid.
+func (l *linter) lintFilesV1() (int, bool) {
+	length, lengthErr := l.root.LookupInteger("info.length")
+	files, filesErr := l.root.LookupList("info.files")
+
+	hasLength := !errors.Is(lengthErr, bencode.ErrNotFound)
+	hasFiles := !errors.Is(filesErr, bencode.ErrNotFound)
+	if hasLength && hasFiles {
+		l.errorf("info", "has both the \"length\" and the \"files\" keys")
+		return 0, false
+	}
+	if !hasLength && !hasFiles {
+		l.errorf("info", "has neither the \"length\" nor the \"files\" key")
+		return 0, false
+	}
+
+	if hasLength {
+		if l.lookupErr(lengthErr, true) {
+			return 0, false
+		}
+		if length < 0 {
+			l.errorf("info.length", "must not be negative, got %d", length)
+			return 0, false
+		}
+		return length, true
+	}
+
+	if l.lookupErr(filesErr, true) {
+		return 0, false
+	}
+	if len(files) == 0 {
+		l.errorf("info.files", "is empty")
+	}
+
+	valid := true
+	total := 0
+	for i := range files {
+		path := fmt.Sprintf("info.files[%d]", i)
+		length, err := l.root.LookupInteger(path + ".length")
+		if l.lookupErr(err, true) {
+			valid = false
+		} else if length < 0 {
+			l.errorf(path+".length", "must not be negative, got %d", length)
+			valid = false
+		} else {
+			total += length
+		}
+
+		components, err := l.root.LookupList(path + ".path")
+		if l.lookupErr(err, true) {
+			continue
+		}
+		if len(components) == 0 {
+			l.errorf(path+".path", "is empty")
+		}
+		for j := range components {
+			componentPath := fmt.Sprintf("%s.path[%d]", path, j)
+			component, err := l.root.LookupString(componentPath)
+			if !l.lookupErr(err, true) {
+				l.lintPathComponent(componentPath, component)
+			}
+		}
+	}
+	return total, valid
+}
+
+// lintFileTree checks the names and the entries of the files in the v2 file tree.
+func (l *linter) lintFileTree() {
+	tree, _ := l.root.Lookup("info.file tree")
+	l.lintFileTreeNode(tree, "info.file tree")
+}
+
+func (l *linter) lintFileTreeNode(node *bencode.BencodeData, path string) {
+	d, err := node.AsDictionary()
+	if err != nil {
+		l.errorf(path, "expected a %s, got %s", bencode.DictionaryType, node.Type)
+		return
+	}
+
+	if entry, ok := d.Map[""]; ok {
+		length, err := entry.LookupInteger("length")
+		if err != nil {
+			l.errorf(path, "invalid file entry: %v", err)
+		} else if length < 0 {
+			l.errorf(path, "file length must not be negative, got %d", length)
+		} else if root, err := entry.LookupBytes("pieces root"); length > 0 && (err != nil || len(root) != 32) {
+			l.errorf(path, "non-empty files must have a 32 byte pieces root")
+		}
+		if len(d.Map) > 1 {
+			l.errorf(path, "a file entry can't have other keys")
+		}
+		return
+	}
+
+	if len(d.Map) == 0 {
+		l.errorf(path, "is an empty directory")
+	}
+	names := make([]string, 0, len(d.Map))
+	for name := range d.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := fmt.Sprintf("%s[%q]", path, name)
+		l.lintPathComponent(childPath, name)
+		l.lintFileTreeNode(d.Map[name], childPath)
+	}
+}
+
+// lintPathComponent checks that the name of a file or a directory can't
+// refer to a location outside of the download directory.
+func (l *linter) lintPathComponent(path string, name string) {
+	switch {
+	case name == "":
+		l.errorf(path, "path component is empty")
+	case name == "." || name == "..":
+		l.errorf(path, "path component %q refers to a directory", name)
+	case strings.ContainsAny(name, "/\\"):
+		l.errorf(path, "path component %q contains a path separator", name)
+	case strings.ContainsRune(name, 0):
+		l.errorf(path, "path component %q contains a NUL byte", name)
+	}
+}
@@ -0,0 +1,121 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+// testLintTorrent returns a valid single-file torrent of 40000 bytes in
+// pieces of 16 KiB, with the changes applied to its info dictionary.
+func testLintTorrent(t *testing.T, change func(info map[string]any)) []byte {
+	t.Helper()
+
+	info := map[string]any{
+		"length":       40000,
+		"name":         "file.bin",
+		"piece length": 16384,
+		"pieces":       strings.Repeat("x", 3*20),
+	}
+	if change != nil {
+		change(info)
+	}
+	data, err := bencode.Marshal(map[string]any{"announce": "http://tracker/announce", "info": info})
+	if err != nil {
+		t.Fatalf("error encoding torrent: %v", err)
+	}
+	return data
+}
+
+func TestLintTorrent(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(info map[string]any)
+		issues []string
+	}{
+		{"valid", nil, nil},
+
+		{"too few pieces", func(info map[string]any) { info["pieces"] = strings.Repeat("x", 2*20) },
+			[]string{"error: info.pieces: has 2 piece hashes, but the total length of 40000 bytes needs 3 pieces"}},
+		{"too many pieces", func(info map[string]any) { info["pieces"] = strings.Repeat("x", 4*20) },
+			[]string{"error: info.pieces: has 4 piece hashes, but the total length of 40000 bytes needs 3 pieces"}},
+		{"partial piece hash", func(info map[string]any) { info["pieces"] = strings.Repeat("x", 3*20+1) },
+			[]string{"error: info.pieces: length 61 is not a multiple of 20"}},
+		{"missing pieces", func(info map[string]any) { delete(info, "pieces") },
+			[]string{"error: info.pieces: required key is missing"}},
+
+		{"piece length not a power of two", func(info map[string]any) {
+			info["piece length"] = 10000
+			info["pieces"] = strings.Repeat("x", 4*20)
+		}, []string{"error: info.piece length: must be a power of two, got 10000"}},
+		{"piece length zero", func(info map[string]any) { info["piece length"] = 0 },
+			[]string{"error: info.piece length: must be positive, got 0"}},
+		{"piece length below a block", func(info map[string]any) {
+			info["piece length"] = 8192
+			info["pieces"] = strings.Repeat("x", 5*20)
+		}, []string{"warning: info.piece length: is smaller than the block size of 16384 bytes"}},
+		{"piece length of the wrong type", func(info map[string]any) { info["piece length"] = "16384" },
+			[]string{"error: info.piece length: expected a Integer, got String"}},
+
+		{"name with separator", func(info map[string]any) { info["name"] = "../file.bin" },
+			[]string{`error: info.name: path component "../file.bin" contains a path separator`}},
+		{"parent directory name", func(info map[string]any) { info["name"] = ".." },
+			[]string{`error: info.name: path component ".." refers to a directory`}},
+		{"empty name", func(info map[string]any) { info["name"] = "" },
+			[]string{"error: info.name: path component is empty"}},
+		{"files with unsafe paths", func(info map[string]any) {
+			delete(info, "length")
+			info["files"] = []any{
+				map[string]any{"length": 20000, "path": []any{"dir", "a.bin"}},
+				map[string]any{"length": 10000, "path": []any{"..", "b.bin"}},
+				map[string]any{"length": 10000, "path": []any{"c\\d.bin"}},
+				map[string]any{"length": 0, "path": []any{"nul\x00"}},
+			}
+		}, []string{
+			`error: info.files[1].path[0]: path component ".." refers to a directory`,
+			`error: info.files[2].path[0]: path component "c\\d.bin" contains a path separator`,
+			`error: info.files[3].path[0]: path component "nul\x00" contains a NUL byte`,
+		}},
+		{"length and files", func(info map[string]any) {
+			info["files"] = []any{map[string]any{"length": 40000, "path": []any{"a"}}}
+		}, []string{`error: info: has both the "length" and the "files" keys`}},
+		{"v2 file tree with unsafe name", func(info map[string]any) {
+			info["meta version"] = 2
+			info["file tree"] = map[string]any{
+				"..": map[string]any{"": map[string]any{"length": 40000, "pieces root": strings.Repeat("r", 32)}},
+			}
+		}, []string{`error: info.file tree[".."]: path component ".." refers to a directory`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, issue := range LintTorrent(testLintTorrent(t, tt.change)) {
+				got = append(got, issue.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.issues, "\n") {
+				t.Errorf("expected the issues %q, got %q", tt.issues, got)
+			}
+		})
+	}
+}
+
+func TestLintTorrentEncoding(t *testing.T) {
+	// The keys of the info dictionary are not sorted
+	data := "d8:announce3:url4:infod4:name1:a6:lengthi1e12:piece lengthi16384e6:pieces20:" + strings.Repeat("x", 20) + "ee"
+	issues := LintTorrent([]byte(data))
+	if len(issues) != 1 || issues[0].String() != `error: not canonical bencode at offset 32: dictionary key "length" is not sorted after "name"` {
+		t.Errorf("expected the unsorted keys to be reported, got %v", issues)
+	}
+
+	issues = LintTorrent([]byte("d4:infoi1e"))
+	if len(issues) != 1 || !strings.HasPrefix(issues[0].String(), "error: invalid bencode") {
+		t.Errorf("expected invalid bencode to be reported, got %v", issues)
+	}
+
+	issues = LintTorrent([]byte("d4:infod4:name1:a6:lengthi1e12:piece lengthi16384e6:pieces20:" + strings.Repeat("x", 20) + "ee"))
+	if len(issues) < 1 || issues[len(issues)-1].String() != "warning: announce: the torrent has no trackers, so peers can only be found with the DHT or PEX" {
+		t.Errorf("expected a warning for the missing trackers, got %v", issues)
+	}
+}
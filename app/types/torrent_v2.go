@@ -0,0 +1,180 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
+)
+
+const META_VERSION_2 = 2
+
+// FileV2 is a file from the "file tree" of a v2 torrent (BEP 52). Every
+// file starts at a piece boundary, and its pieces are verified with the
+// merkle tree of the file.
+type FileV2 struct {
+	Path       []string
+	Length     int
+	PiecesRoot []byte // The root of the merkle tree of the file, nil for empty files
+	FirstPiece int    // The index of the first piece of the file in the torrent
+
+	// The hashes of the pieces of the file, from the "piece layers" of the
+	// torrent. Files that fit in a single piece don't have a piece layer,
+	// and the layer is nil until it is received for magnet links.
+	PieceLayer [][]byte
+}
+
+// NumPieces returns the number of pieces the file spans.
+func (f *FileV2) NumPieces(pieceLength int) int {
+	return (f.Length + pieceLength - 1) / pieceLength
+}
+
+// HasPieceLayer reports whether the file needs a piece layer to be verified.
+func (f *FileV2) HasPieceLayer(pieceLength int) bool {
+	return f.NumPieces(pieceLength) > 1
+}
+
+// SetPieceLayer verifies the piece hashes against the root of the file,
+// and stores them to verify the pieces of the file with.
+func (f *FileV2) SetPieceLayer(layer [][]byte, pieceLength int) error {
+	numPieces := f.NumPieces(pieceLength)
+	if len(layer) != numPieces {
+		return fmt.Errorf("expected %d piece hashes, got %d", numPieces, len(layer))
+	}
+
+	pad := zeroHash(log2(pieceLength / MERKLE_BLOCK_SIZE))
+	root := merkleRoot(layer, nextPowerOfTwo(numPieces), pad)
+	if !bytes.Equal(root, f.PiecesRoot) {
+		return fmt.Errorf("piece layer does not match the pieces root %x", f.PiecesRoot)
+	}
+
+	f.PieceLayer = layer
+	return nil
+}
+
+// pieceHash returns the merkle root that the piece at index in the file must
+// hash to, and the number of leaves of its tree.
+func (f *FileV2) pieceHash(index int, pieceLength int) ([]byte, uint32, error) {
+	if !f.HasPieceLayer(pieceLength) {
+		blocks := (f.Length + MERKLE_BLOCK_SIZE - 1) / MERKLE_BLOCK_SIZE
+		return f.PiecesRoot, uint32(nextPowerOfTwo(blocks)), nil
+	}
+	if f.PieceLayer == nil {
+		return nil, 0, fmt.Errorf("piece layer of %v is not known", f.Path)
+	}
+	return f.PieceLayer[index], uint32(pieceLength / MERKLE_BLOCK_SIZE), nil
+}
+
+// fileTreeEntry is the dictionary of a file in the "file tree".
+type fileTreeEntry struct {
+	Length     int    `bencode:"length"`
+	PiecesRoot []byte `bencode:"pieces root,omitempty"`
+}
+
+// parseFileTree collects the files in the "file tree" of a v2 info dictionary.
+// Files are dictionaries with an empty key, holding their length and root.
+func parseFileTree(node *bencode.BencodeDictionary, path []string, files []*FileV2) ([]*FileV2, error) {
+	if entry, ok := node.Map[""]; ok {
+		var e fileTreeEntry
+		err := bencode.UnmarshalData(entry, &e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file entry for %v: %w", path, err)
+		}
+		if e.Length < 0 {
+			return nil, fmt.Errorf("invalid length for %v: %d", path, e.Length)
+		}
+		f := &FileV2{Path: path, Length: e.Length}
+		if e.Length > 0 {
+			if len(e.PiecesRoot) != 32 {
+				return nil, fmt.Errorf("invalid pieces root for %v", path)
+			}
+			f.PiecesRoot = e.PiecesRoot
+		}
+		return append(files, f), nil
+	}
+
+	// The keys are visited in sorted order, which is the order of the files
+	names := make([]string, 0, len(node.Map))
+	for name := range node.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := append(append([]string{}, path...), name)
+		child, err := node.Map[name].AsDictionary()
+		if err != nil {
+			return nil, fmt.Errorf("invalid file tree entry %v: %w", childPath, err)
+		}
+
+		files, err = parseFileTree(child, childPath, files)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// parseFilesV2 parses the file tree of a v2 info dictionary, and lays the
+// files out in the piece space.
+func parseFilesV2(tree *bencode.BencodeData, pieceLength int) ([]*FileV2, error) {
+	if tree == nil {
+		return nil, fmt.Errorf("missing file tree")
+	}
+	root, err := tree.AsDictionary()
+	if err != nil {
+		return nil, fmt.Errorf("invalid file tree: %w", err)
+	}
+	if pieceLength < MERKLE_BLOCK_SIZE || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("invalid piece length %d for a v2 torrent", pieceLength)
+	}
+
+	files, err := parseFileTree(root, []string{}, make([]*FileV2, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	// The lengths are bounded first, so that the piece counts can't overflow
+	piece, total := 0, 0
+	for _, f := range files {
+		total, err = addLength(total, f.Length)
+		if err != nil {
+			return nil, err
+		}
+		f.FirstPiece = piece
+		piece += f.NumPieces(pieceLength)
+	}
+	if int64(piece) > MAX_PIECE_COUNT {
+		return nil, fmt.Errorf("too many pieces: %d", piece)
+	}
+	return files, nil
+}
+
+// setPieceLayers verifies and stores the "piece layers" of a v2 torrent,
+// which map the pieces root of each file to its concatenated piece hashes.
+func setPieceLayers(layers map[string][]byte, files []*FileV2, pieceLength int) error {
+	for _, f := range files {
+		if !f.HasPieceLayer(pieceLength) {
+			continue
+		}
+
+		data, ok := layers[string(f.PiecesRoot)]
+		if !ok {
+			return fmt.Errorf("missing piece layer for %v", f.Path)
+		}
+		if len(data)%32 != 0 {
+			return fmt.Errorf("invalid piece layer length %d for %v", len(data), f.Path)
+		}
+
+		layer := make([][]byte, len(data)/32)
+		for i := range layer {
+			layer[i] = data[i*32 : (i+1)*32]
+		}
+		err := f.SetPieceLayer(layer, pieceLength)
+		if err != nil {
+			return fmt.Errorf("invalid piece layer for %v: %w", f.Path, err)
+		}
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package types
 
 import (
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 )
@@ -43,12 +42,7 @@ func (r *TrackerGetRequest) MakeRequest(connectToPeers bool) (*TrackerGetRespons
 	}
 
 	defer resp.Body.Close()
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-
-	response, err := NewTrackerGetResponse(data, connectToPeers)
+	response, err := NewTrackerGetResponse(resp.Body, connectToPeers)
 	if err != nil {
 		return nil, fmt.Errorf("error decoding tracker response: %w", err)
 	}
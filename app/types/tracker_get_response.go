@@ -2,10 +2,26 @@ package types
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"strconv"
 
 	"github.com/EshaanAgg/toy-bittorrent/app/bencode"
 )
 
+// trackerResponseFields is the layout of the response of the tracker. The
+// peers are either a compact string, or a list of dictionaries.
+type trackerResponseFields struct {
+	Interval int                  `bencode:"interval"`
+	Peers    *bencode.BencodeData `bencode:"peers"`
+}
+
+type trackerPeerFields struct {
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+	PeerID []byte `bencode:"peer id,omitempty"`
+}
+
 // TrackerResponse is a struct that holds the response from
 // the tracker.
 type TrackerGetResponse struct {
@@ -13,34 +29,42 @@ type TrackerGetResponse struct {
 	Peers    []*Peer
 }
 
-func NewTrackerGetResponse(data []byte, connectToPeers bool) (*TrackerGetResponse, error) {
-	d, err := bencode.NewBencodeData(data)
+// NewTrackerGetResponse decodes the response of the tracker as it is read.
+func NewTrackerGetResponse(r io.Reader, connectToPeers bool) (*TrackerGetResponse, error) {
+	d, err := bencode.NewDecoder(r).Decode()
 	if err != nil {
 		return nil, fmt.Errorf("error decoding bencode data: %w", err)
 	}
 
-	dict := d.GetDictionary()
-	interval := dict.Map["interval"].GetInteger().Value
+	var fields trackerResponseFields
+	err = bencode.UnmarshalData(d, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing tracker response: %w", err)
+	}
+	interval := fields.Interval
 
-	peerString := dict.Map["peers"].GetString().Value
-	if len(peerString)%6 != 0 {
-		return nil, fmt.Errorf("peers length is not a multiple of 6")
+	addrs, peerIDs, err := parseTrackerPeers(fields.Peers)
+	if err != nil {
+		return nil, err
 	}
+
 	peers := make([]*Peer, 0)
-	for i := 0; i < len(peerString); i += 6 {
-		ip := fmt.Sprintf("%d.%d.%d.%d", peerString[i], peerString[i+1], peerString[i+2], peerString[i+3])
-		port := int(peerString[i+4])<<8 + int(peerString[i+5])
+	for i, addr := range addrs {
+		host, portStr, _ := net.SplitHostPort(addr)
+		port, _ := strconv.Atoi(portStr)
 
 		if connectToPeers {
-			p, err := NewPeerFromAddr(fmt.Sprintf("%s:%d", ip, port))
+			p, err := NewPeerFromAddr(addr)
 			if err != nil {
 				return nil, fmt.Errorf("error creating peer from address: %w", err)
 			}
+			p.PeerID = peerIDs[i]
 			peers = append(peers, p)
 		} else {
 			peers = append(peers, &Peer{
-				IP:   ip,
-				Port: port,
+				IP:     host,
+				Port:   port,
+				PeerID: peerIDs[i],
 			})
 		}
 	}
@@ -50,3 +74,47 @@ func NewTrackerGetResponse(data []byte, connectToPeers bool) (*TrackerGetRespons
 		Peers:    peers,
 	}, nil
 }
+
+// parseTrackerPeers returns the addresses of the peers from the "peers" key,
+// which is either a compact string of 6 bytes per peer, or a list of
+// dictionaries that also contain the peer IDs. The peer IDs are nil for
+// compact responses.
+func parseTrackerPeers(peersData *bencode.BencodeData) ([]string, [][]byte, error) {
+	addrs := make([]string, 0)
+	peerIDs := make([][]byte, 0)
+
+	if peersData == nil {
+		return nil, nil, fmt.Errorf("tracker response has no peers")
+	}
+	if peersData.Type == bencode.ListType {
+		var peers []trackerPeerFields
+		err := bencode.UnmarshalData(peersData, &peers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid peer list: %w", err)
+		}
+		for _, p := range peers {
+			if p.IP == "" || p.Port == 0 {
+				return nil, nil, fmt.Errorf("peer dictionary is missing the ip or port")
+			}
+			addrs = append(addrs, net.JoinHostPort(p.IP, strconv.Itoa(p.Port)))
+			peerIDs = append(peerIDs, p.PeerID)
+		}
+		return addrs, peerIDs, nil
+	}
+
+	compact, err := peersData.AsString()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid peers: %w", err)
+	}
+	peerString := compact.Value
+	if len(peerString)%6 != 0 {
+		return nil, nil, fmt.Errorf("peers length is not a multiple of 6")
+	}
+	for i := 0; i < len(peerString); i += 6 {
+		ip := fmt.Sprintf("%d.%d.%d.%d", peerString[i], peerString[i+1], peerString[i+2], peerString[i+3])
+		port := int(peerString[i+4])<<8 + int(peerString[i+5])
+		addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(port)))
+		peerIDs = append(peerIDs, nil)
+	}
+	return addrs, peerIDs, nil
+}
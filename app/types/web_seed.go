@@ -0,0 +1,153 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/ratelimit"
+)
+
+// WebSeed downloads pieces from an HTTP server that hosts the files of the
+// torrent (BEP 19). Pieces are mapped onto the files, and fetched with
+// HTTP range requests.
+type WebSeed struct {
+	URL string
+
+	client *http.Client
+	logger *log.Logger
+	limits []*ratelimit.Limits
+}
+
+func NewWebSeed(rawURL string) (*WebSeed, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid web seed URL %s: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported web seed URL scheme: %s", u.Scheme)
+	}
+
+	return &WebSeed{
+		URL:    rawURL,
+		client: &http.Client{Timeout: WEB_SEED_TIMEOUT},
+		logger: log.New(log.Writer(), fmt.Sprintf("[WebSeed %s] ", rawURL), 0),
+	}, nil
+}
+
+// ApplyRateLimits limits the HTTP connections of the web seed with the limits,
+// eg. the global and torrent limits, like the connections to peers.
+func (ws *WebSeed) ApplyRateLimits(limits ...*ratelimit.Limits) {
+	dialer := &net.Dialer{Timeout: WEB_SEED_TIMEOUT}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return ratelimit.NewConn(conn, limits...), nil
+	}
+	ws.client.Transport = transport
+	ws.limits = limits
+}
+
+func (ws *WebSeed) Log(s string, vals ...any) {
+	ws.logger.Printf(s+"\n", vals...)
+}
+
+// fileURL returns the URL of a file of the torrent. The URL of single-file
+// torrents points to the file itself, unless it ends with a slash. For
+// multi-file torrents, the name of the torrent and the path of the file are
+// appended to the URL.
+func (ws *WebSeed) fileURL(fileInfo *TorrentFileInfo, path []string) string {
+	if path == nil && !strings.HasSuffix(ws.URL, "/") {
+		return ws.URL
+	}
+
+	u := ws.URL
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	u += url.PathEscape(fileInfo.InfoDict.Name)
+	for _, p := range path {
+		u += "/" + url.PathEscape(p)
+	}
+	return u
+}
+
+// DownloadPiece fetches the data of the piece from the server,
+// and verifies it against the hash of the piece.
+func (ws *WebSeed) DownloadPiece(fileInfo *TorrentFileInfo, pieceIdx int) ([]byte, error) {
+	segments, err := fileInfo.pieceSegments(pieceIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, fileInfo.PieceSize(pieceIdx))
+	fetched := 0 // Padding is not fetched, so it is not payload
+	for _, seg := range segments {
+		if seg.Padding {
+			data = append(data, make([]byte, seg.Length)...)
+			continue
+		}
+
+		d, err := ws.fetchRange(ws.fileURL(fileInfo, seg.Path), seg.Offset, seg.Length)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, d...)
+		fetched += len(d)
+	}
+
+	err = fileInfo.VerifyPiece(pieceIdx, data)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying piece %d: %w", pieceIdx, err)
+	}
+	for _, l := range ws.limits {
+		if l != nil {
+			l.Stats.AddPayload(fetched, 0)
+		}
+	}
+	ws.Log("piece %d downloaded and verified", pieceIdx)
+	return data, nil
+}
+
+// fetchRange requests length bytes starting at offset from the URL. Servers
+// that ignore the range header and send the whole file are also supported.
+func (ws *WebSeed) fetchRange(u string, offset int64, length int) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Skip to the start of the range in the full file
+		_, err = io.CopyN(io.Discard, resp.Body, int64(offset))
+		if err != nil {
+			return nil, fmt.Errorf("error skipping to offset %d of %s: %w", offset, u, err)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, u)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %w", u, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,276 @@
+package types
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const TEST_PIECE_LENGTH = 16 * 1024
+
+// writeTestFile writes size random bytes to the file, and returns them.
+func writeTestFile(t *testing.T, path string, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newTestTorrent creates the torrent of the file or directory at the path.
+func newTestTorrent(t *testing.T, path string) *TorrentFileInfo {
+	t.Helper()
+
+	data, err := CreateTorrent(TorrentCreateOptions{Path: path, PieceLength: TEST_PIECE_LENGTH})
+	if err != nil {
+		t.Fatalf("error creating torrent: %v", err)
+	}
+	torrentPath := filepath.Join(t.TempDir(), "test.torrent")
+	err = os.WriteFile(torrentPath, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := NewTorrentFileInfo(torrentPath)
+	if err != nil {
+		t.Fatalf("error reading torrent: %v", err)
+	}
+	return fileInfo
+}
+
+// rangeRecorder records the paths and the ranges requested from the handler.
+type rangeRecorder struct {
+	handler http.Handler
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (r *rangeRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.URL.Path+" "+req.Header.Get("Range"))
+	r.mu.Unlock()
+	r.handler.ServeHTTP(w, req)
+}
+
+func newTestWebSeed(t *testing.T, rawURL string) *WebSeed {
+	t.Helper()
+
+	ws, err := NewWebSeed(rawURL)
+	if err != nil {
+		t.Fatalf("error creating web seed: %v", err)
+	}
+	return ws
+}
+
+// downloadAll downloads all the pieces of the torrent from the web seed.
+func downloadAll(t *testing.T, ws *WebSeed, fileInfo *TorrentFileInfo) []byte {
+	t.Helper()
+
+	var data []byte
+	for i := range fileInfo.NumPieces() {
+		piece, err := ws.DownloadPiece(fileInfo, i)
+		if err != nil {
+			t.Fatalf("error downloading piece %d: %v", i, err)
+		}
+		data = append(data, piece...)
+	}
+	return data
+}
+
+func TestWebSeedSingleFile(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), 3*TEST_PIECE_LENGTH+100)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+
+	recorder := &rangeRecorder{handler: http.FileServer(http.Dir(root))}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	ws := newTestWebSeed(t, server.URL+"/file.bin")
+	if data := downloadAll(t, ws, fileInfo); !bytes.Equal(data, content) {
+		t.Fatal("the downloaded data does not match the file")
+	}
+
+	expected := []string{
+		"/file.bin bytes=0-16383",
+		"/file.bin bytes=16384-32767",
+		"/file.bin bytes=32768-49151",
+		"/file.bin bytes=49152-49251",
+	}
+	if strings.Join(recorder.requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the requests %q, got %q", expected, recorder.requests)
+	}
+}
+
+func TestWebSeedMultiFile(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "content")
+	a := writeTestFile(t, filepath.Join(dir, "a.bin"), 2*TEST_PIECE_LENGTH+1000)
+	b := writeTestFile(t, filepath.Join(dir, "sub dir", "b.bin"), TEST_PIECE_LENGTH)
+	fileInfo := newTestTorrent(t, dir)
+
+	recorder := &rangeRecorder{handler: http.FileServer(http.Dir(root))}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	// The name of the torrent and the paths of the files are appended to the URL
+	ws := newTestWebSeed(t, server.URL+"/")
+	if data := downloadAll(t, ws, fileInfo); !bytes.Equal(data, append(a, b...)) {
+		t.Fatal("the downloaded data does not match the files")
+	}
+
+	// The third piece spans the end of the first file and the second file
+	expected := []string{
+		"/content/a.bin bytes=0-16383",
+		"/content/a.bin bytes=16384-32767",
+		"/content/a.bin bytes=32768-33767",
+		"/content/sub dir/b.bin bytes=0-15383",
+		"/content/sub dir/b.bin bytes=15384-16383",
+	}
+	if strings.Join(recorder.requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the requests %q, got %q", expected, recorder.requests)
+	}
+}
+
+func TestWebSeedIgnoredRange(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), 2*TEST_PIECE_LENGTH+100)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+
+	// The server sends the whole file with a 200 response
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	ws := newTestWebSeed(t, server.URL+"/file.bin")
+	if data := downloadAll(t, ws, fileInfo); !bytes.Equal(data, content) {
+		t.Fatal("the downloaded data does not match the file")
+	}
+}
+
+func TestWebSeedErrors(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), 2*TEST_PIECE_LENGTH)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		errText string
+	}{
+		{
+			name: "missing file",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			errText: "unexpected status code 404",
+		},
+		{
+			name: "short response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[:100])
+			},
+			errText: "error reading response",
+		},
+		{
+			name: "corrupted data",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(make([]byte, TEST_PIECE_LENGTH))
+			},
+			errText: "error verifying piece 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			ws := newTestWebSeed(t, server.URL+"/file.bin")
+			_, err := ws.DownloadPiece(fileInfo, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("expected an error containing %q, got %v", tt.errText, err)
+			}
+		})
+	}
+
+	ws := newTestWebSeed(t, "http://127.0.0.1:1/file.bin")
+	if _, err := ws.DownloadPiece(fileInfo, fileInfo.NumPieces()); err == nil {
+		t.Error("expected an error for a piece index out of range")
+	}
+	if _, err := NewWebSeed("ftp://example.com/file.bin"); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestPieceSegmentsPastFourGiB(t *testing.T) {
+	// The lengths of the files fit in 32 bits, but the offsets of the
+	// pieces in the torrent don't
+	const pieceLength = 4 * 1024 * 1024
+	const fileLength = 1536*1024*1024 + 1000
+	if 3*fileLength > math.MaxInt {
+		t.Skip("the length of the torrent doesn't fit in an int")
+	}
+	length := int64(3 * fileLength)
+	fileInfo := &TorrentFileInfo{InfoDict: &InfoDict{
+		Length:      int(length),
+		PieceLength: pieceLength,
+		Pieces:      make([][]byte, 1153),
+		FilesV1: []*FileV1{
+			{Path: []string{"a"}, Length: fileLength},
+			{Path: []string{"b"}, Length: fileLength},
+			{Path: []string{"c"}, Length: fileLength},
+		},
+	}}
+
+	tests := []struct {
+		piece int
+		want  []fileSegment
+	}{
+		{768, []fileSegment{
+			{Path: []string{"b"}, Offset: 768*pieceLength - fileLength, Length: 2000},
+			{Path: []string{"c"}, Offset: 0, Length: pieceLength - 2000},
+		}},
+		{1100, []fileSegment{
+			{Path: []string{"c"}, Offset: 1100*pieceLength - 2*fileLength, Length: pieceLength},
+		}},
+	}
+	for _, tt := range tests {
+		segments, err := fileInfo.pieceSegments(tt.piece)
+		if err != nil {
+			t.Fatalf("error mapping piece %d: %v", tt.piece, err)
+		}
+		if !reflect.DeepEqual(segments, tt.want) {
+			t.Errorf("expected %+v for piece %d, got %+v", tt.want, tt.piece, segments)
+		}
+	}
+
+	// Without files, the offset is the offset of the piece in the torrent
+	fileInfo.InfoDict.FilesV1 = nil
+	segments, err := fileInfo.pieceSegments(1100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(1100 * pieceLength); segments[0].Offset != want {
+		t.Errorf("expected offset %d, got %d", want, segments[0].Offset)
+	}
+}
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// ProtocolError is returned when a peer violates the protocol, eg. by sending
+// an invalid handshake or an oversized message. The connection to the peer
+// is closed when it is returned.
+type ProtocolError struct {
+	Addr   string
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol violation by peer %s: %s", e.Addr, e.Reason)
+}
+
+// protocolError closes the connection to the peer,
+// and returns a ProtocolError with the reason.
+func (p *Peer) protocolError(format string, vals ...any) error {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	return &ProtocolError{Addr: p.Addr(), Reason: fmt.Sprintf(format, vals...)}
+}
+
+// setConn replaces the connection to the peer, eg. with an encrypted or
+// rate limited one, along with the buffered reader for it.
+func (p *Peer) setConn(conn net.Conn) {
+	p.conn = conn
+	p.reader = bufio.NewReaderSize(conn, READ_BUFFER_SIZE)
+}
+
+// maxMessageLength returns the maximum length of a message with the ID,
+// including the ID. Messages with unknown IDs are also bounded.
+func maxMessageLength(id byte) uint32 {
+	switch id {
+	case CHOKE_MESSAGE_ID, UNCHOKE_MESSAGE_ID, INTERESTED_MESSAGE_ID, NOT_INTERESTED_MESSAGE_ID,
+		HAVE_ALL_MESSAGE_ID, HAVE_NONE_MESSAGE_ID:
+		return 1
+	case HAVE_MESSAGE_ID, SUGGEST_PIECE_MESSAGE_ID, ALLOWED_FAST_MESSAGE_ID:
+		return 5
+	case PORT_MESSAGE_ID:
+		return 3
+	case REQUEST_MESSAGE_ID, CANCEL_MESSAGE_ID, REJECT_REQUEST_MESSAGE_ID:
+		return 13
+	case BITFIELD_MESSAGE_ID:
+		return 1 + MAX_BITFIELD_SIZE
+	case PIECE_MESSAGE_ID:
+		return 9 + BLOCK_SIZE
+	case HASH_REQUEST_MESSAGE_ID, HASH_REJECT_MESSAGE_ID:
+		return 49
+	case HASHES_MESSAGE_ID:
+		return 49 + 32*(MAX_HASHES_PER_REQUEST+MAX_PROOF_LAYERS)
+	case EXTENSION_HANDSHAKE_HEADER_MESSAGE_ID:
+		return MAX_EXTENDED_MESSAGE_SIZE
+	}
+	return MAX_UNKNOWN_MESSAGE_SIZE
+}
+
+// validateHandshake checks that the handshake of the peer is for the
+// BitTorrent protocol, and for the info hash that we asked for.
+func (p *Peer) validateHandshake(data []byte, infoHash []byte) error {
+	if data[0] != 19 || string(data[1:20]) != "BitTorrent protocol" {
+		return p.protocolError("invalid protocol in handshake: %q", data[1:min(1+int(data[0]), len(data))])
+	}
+	if !bytes.Equal(data[28:48], infoHash) {
+		return p.protocolError("handshake for info hash %x, expected %x", data[28:48], infoHash)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/EshaanAgg/toy-bittorrent/app/mse"
+)
+
+func TestMemoryBudgetFitsAssignedPiece(t *testing.T) {
+	root := t.TempDir()
+	content := writeTestFile(t, filepath.Join(root, "file.bin"), 2*TEST_PIECE_LENGTH)
+	fileInfo := newTestTorrent(t, filepath.Join(root, "file.bin"))
+	addr := startTestSeeder(t, fileInfo, content, mse.POLICY_DISABLE)
+
+	peer, err := NewPeerFromAddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// The budget is smaller than a single block, but the pieces are
+	// still downloaded as their blocks were requested
+	peer.MemoryBudget = 1024
+	err = peer.PrepareToGetPieceData(fileInfo.InfoHash)
+	if err != nil {
+		t.Fatalf("error preparing peer: %v", err)
+	}
+	for i := range fileInfo.NumPieces() {
+		sp, err := peer.DownloadPiece(uint32(i), fileInfo.PieceSize(i), fileInfo.InfoDict.Pieces[i])
+		if err != nil {
+			t.Fatalf("error downloading piece %d: %v", i, err)
+		}
+		if !bytes.Equal(sp.GetData(), content[i*TEST_PIECE_LENGTH:(i+1)*TEST_PIECE_LENGTH]) {
+			t.Errorf("the data of piece %d does not match the file", i)
+		}
+	}
+}
+
+func TestMemoryBudgetWithoutAssignedPiece(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	peer := NewPeerFromConn(client)
+	peer.MemoryBudget = 1024
+	go server.Write(append([]byte{0, 0, 8, 1, PIECE_MESSAGE_ID}, make([]byte, 2048)...))
+
+	// Blocks that we didn't ask for only get the configured budget
+	_, err := peer.RecieveMessage()
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("expected a protocol error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 )
 
@@ -13,3 +14,12 @@ func SHA1Hash(d []byte) ([]byte, error) {
 	}
 	return h.Sum(nil), nil
 }
+
+func SHA256Hash(d []byte) ([]byte, error) {
+	h := sha256.New()
+	_, err := h.Write(d)
+	if err != nil {
+		return nil, fmt.Errorf("error writing to hash: %w", err)
+	}
+	return h.Sum(nil), nil
+}
@@ -2,11 +2,16 @@ package utils
 
 import "math/rand"
 
-func GetRandomPeerID() []byte {
+const peerIDChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// GetRandomPeerID returns a 20 byte peer ID that starts with the prefix,
+// eg. an Azureus-style client prefix like "-TT0100-", followed by random
+// alphanumeric characters. Prefixes longer than 20 bytes are truncated.
+func GetRandomPeerID(prefix string) []byte {
 	clientID := make([]byte, 20)
-	for i := range 20 {
-		rnd := rand.Intn(26)
-		clientID[i] = byte('a' + rnd)
+	n := copy(clientID, prefix)
+	for i := n; i < 20; i++ {
+		clientID[i] = peerIDChars[rand.Intn(len(peerIDChars))]
 	}
 	return clientID
 }
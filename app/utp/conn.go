@@ -0,0 +1,720 @@
+package utp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrConnReset = errors.New("connection reset by peer")
+var ErrTimeout = errors.New("connection timed out")
+
+type connState int
+
+const (
+	stateSynSent connState = iota
+	stateConnected
+	stateFinSent
+	stateClosed
+)
+
+// outPacket is a packet that was sent but not acknowledged yet.
+type outPacket struct {
+	packet        *packet
+	sentAt        time.Time
+	transmissions int
+	lost          bool // Waiting to be resent, and not counted as in flight
+}
+
+// Conn is a reliable, ordered uTP connection (BEP 29). It implements net.Conn,
+// so it can be used in place of a TCP connection to a peer.
+type Conn struct {
+	socket *Socket
+	remote *net.UDPAddr
+	recvID uint16 // The connection ID of the packets we receive
+	sendID uint16 // The connection ID of the packets we send
+
+	mu    sync.Mutex
+	state connState
+	err   error // The reason the connection was closed
+
+	// Sending side
+	seqNr      uint16 // The sequence number of the next packet we send
+	outbuf     []*outPacket
+	inFlight   int    // Bytes sent but not acknowledged, nor considered lost
+	peerWindow int    // The receive window advertised by the peer
+	replyMicro uint32 // The delay of the last packet we received, echoed back to the peer
+	cc         *ledbat
+	rtt        time.Duration
+	rttVar     time.Duration
+	rto        time.Duration
+	timeouts   int // Timeouts in a row without any packet being acknowledged
+
+	lastAckNr   uint16 // To count the duplicate ACKs
+	dupAcks     int
+	inRecovery  bool      // Whether we are resending lost packets
+	lastAcked   time.Time // When the most recently sent of the acknowledged packets was sent
+	recoverySeq uint16    // The first packet sent after the loss that started the recovery
+
+	tokens    float64 // Bytes that may be sent right away, see pace
+	lastPaced time.Time
+
+	// Receiving side
+	ackNr    uint16 // The sequence number of the last packet received in order
+	readBuf  bytes.Buffer
+	reorder  map[uint16]*packet // Packets received ahead of ackNr + 1
+	finSeqNr uint16
+	gotFin   bool
+	eof      bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// Signalled without blocking whenever the state changes,
+	// so that blocked readers and writers check it again
+	readable chan struct{}
+	writable chan struct{}
+
+	connected chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(s *Socket, remote *net.UDPAddr, recvID, sendID, seqNr uint16) *Conn {
+	return &Conn{
+		socket:     s,
+		remote:     remote,
+		recvID:     recvID,
+		sendID:     sendID,
+		state:      stateSynSent,
+		seqNr:      seqNr,
+		outbuf:     make([]*outPacket, 0),
+		peerWindow: MAX_PAYLOAD_SIZE,
+		cc:         newLedbat(),
+		rto:        INITIAL_TIMEOUT,
+		reorder:    make(map[uint16]*packet),
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+		connected:  make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.socket.Addr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Read reads the data received in order from the peer. It returns io.EOF
+// once the peer has closed the connection and all its data was read.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.readBuf.Len() > 0 {
+			wasFull := c.receiveWindow() < MAX_PAYLOAD_SIZE
+			n, _ := c.readBuf.Read(b)
+			// The peer stopped sending when our window filled up,
+			// so tell it that there is room again
+			if wasFull && c.receiveWindow() >= MAX_PAYLOAD_SIZE && c.state != stateClosed {
+				c.sendState()
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.state == stateClosed {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		if err := wait(c.readable, c.closed, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write splits the data into packets and sends them as the congestion
+// window and the receive window of the peer allow, after the packets that
+// must be resent. The packets are paced over the round trip time. It returns
+// once all the data was sent, not when it was acknowledged.
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		c.mu.Lock()
+		if c.state != stateConnected {
+			err := c.err
+			if err == nil {
+				err = net.ErrClosed
+			}
+			c.mu.Unlock()
+			return written, err
+		}
+
+		size := min(len(b)-written, MAX_PAYLOAD_SIZE)
+		if !c.resendLost() || !c.canSend(size) {
+			deadline := c.writeDeadline
+			c.mu.Unlock()
+			if err := wait(c.writable, c.closed, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		if d := c.pace(size); d > 0 {
+			c.mu.Unlock()
+			if err := sleep(d, c.closed); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		payload := make([]byte, size)
+		copy(payload, b[written:])
+		c.sendPacket(ST_DATA, payload)
+		c.mu.Unlock()
+
+		written += size
+	}
+	return written, nil
+}
+
+// Close sends a FIN packet after the data that was written, and waits for
+// the peer to acknowledge it for at most CLOSE_TIMEOUT.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.state != stateConnected {
+		c.mu.Unlock()
+		c.reset(net.ErrClosed)
+		return nil
+	}
+	c.state = stateFinSent
+	c.sendPacket(ST_FIN, nil)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(CLOSE_TIMEOUT)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		done := len(c.outbuf) == 0
+		c.mu.Unlock()
+		if done {
+			break
+		}
+
+		select {
+		case <-c.writable:
+			continue
+		case <-c.closed:
+		case <-timer.C:
+		}
+		break
+	}
+
+	c.destroy(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	signal(c.readable)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	signal(c.writable)
+	return nil
+}
+
+// window returns the number of bytes that may be in flight.
+// The caller must hold c.mu.
+func (c *Conn) window() int {
+	return min(c.cc.Window(), c.peerWindow)
+}
+
+// canSend reports whether a packet of the given size fits in the window.
+// A single packet may always be in flight, so that the peer tells us when
+// its receive window opens again.
+// The caller must hold c.mu.
+func (c *Conn) canSend(size int) bool {
+	return c.inFlight == 0 || c.inFlight+size <= c.window()
+}
+
+// pace returns how long to wait before sending size bytes, so that the
+// window is sent evenly over a round trip instead of in a single burst.
+// The caller must hold c.mu.
+func (c *Conn) pace(size int) time.Duration {
+	now := time.Now()
+	if c.rtt == 0 {
+		// Nothing to spread the window over yet
+		c.lastPaced = now
+		return 0
+	}
+
+	rate := float64(c.window()) / c.rtt.Seconds() // In bytes per second
+	c.tokens = min(c.tokens+rate*now.Sub(c.lastPaced).Seconds(), PACING_BURST)
+	c.lastPaced = now
+	if c.tokens >= float64(size) {
+		c.tokens -= float64(size)
+		return 0
+	}
+	return time.Duration((float64(size) - c.tokens) / rate * float64(time.Second))
+}
+
+// receiveWindow returns the number of bytes we are still willing to buffer.
+// The caller must hold c.mu.
+func (c *Conn) receiveWindow() uint32 {
+	buffered := c.readBuf.Len()
+	for _, p := range c.reorder {
+		buffered += len(p.Payload)
+	}
+	if buffered >= RECEIVE_BUFFER_SIZE {
+		return 0
+	}
+	return uint32(RECEIVE_BUFFER_SIZE - buffered)
+}
+
+// sendSyn starts the connection. The SYN is the only packet
+// that is sent on the receive connection ID.
+// The caller must hold c.mu.
+func (c *Conn) sendSyn() {
+	c.sendPacket(ST_SYN, nil)
+}
+
+// sendPacket sends a packet that consumes a sequence number, and keeps it
+// until it is acknowledged so that it can be retransmitted.
+// The caller must hold c.mu.
+func (c *Conn) sendPacket(typ uint8, payload []byte) {
+	p := &packet{
+		Type:    typ,
+		ConnID:  c.sendID,
+		SeqNr:   c.seqNr,
+		Payload: payload,
+	}
+	if typ == ST_SYN {
+		p.ConnID = c.recvID
+	}
+	c.seqNr++
+
+	op := &outPacket{packet: p}
+	c.outbuf = append(c.outbuf, op)
+	c.inFlight += len(payload)
+	c.transmit(op)
+}
+
+// resendLost resends the packets considered lost, oldest first, as far as
+// the window allows. It reports whether all of them were resent.
+// The caller must hold c.mu.
+func (c *Conn) resendLost() bool {
+	for _, op := range c.outbuf {
+		if !op.lost {
+			continue
+		}
+		size := len(op.packet.Payload)
+		if !c.canSend(size) {
+			return false
+		}
+		op.lost = false
+		c.inFlight += size
+		c.transmit(op)
+	}
+	return true
+}
+
+// markLost stops counting a packet as in flight, so that it is resent.
+// The window is only reduced once for the packets lost in the same window.
+// The caller must hold c.mu.
+func (c *Conn) markLost(op *outPacket) {
+	if op.lost {
+		return
+	}
+	op.lost = true
+	c.inFlight -= len(op.packet.Payload)
+
+	if !c.inRecovery {
+		c.inRecovery = true
+		c.recoverySeq = c.seqNr
+		c.cc.OnLoss()
+	}
+}
+
+// transmit (re)sends a packet with up to date acknowledgement and timing fields.
+// The caller must hold c.mu.
+func (c *Conn) transmit(op *outPacket) {
+	p := op.packet
+	p.AckNr = c.ackNr
+	p.WndSize = c.receiveWindow()
+	p.TimestampDiff = c.replyMicro
+	p.Timestamp = nowMicro()
+
+	op.sentAt = time.Now()
+	op.transmissions++
+	c.socket.writeTo(p, c.remote)
+}
+
+// sendState acknowledges the packets we received. The packets received
+// out of order are acknowledged with a selective ACK.
+// The caller must hold c.mu.
+func (c *Conn) sendState() {
+	p := &packet{
+		Type:          ST_STATE,
+		ConnID:        c.sendID,
+		Timestamp:     nowMicro(),
+		TimestampDiff: c.replyMicro,
+		WndSize:       c.receiveWindow(),
+		SeqNr:         c.seqNr,
+		AckNr:         c.ackNr,
+	}
+
+	if len(c.reorder) > 0 {
+		// The mask length must be a multiple of 4 bytes
+		mask := make([]byte, 4)
+		for seq := range c.reorder {
+			i := int(seq - c.ackNr - 2)
+			if i < 0 || i >= 32*8 {
+				continue
+			}
+			for i/8 >= len(mask) {
+				mask = append(mask, 0, 0, 0, 0)
+			}
+			mask[i/8] |= 1 << (i % 8)
+		}
+		p.SelectiveAck = mask
+	}
+
+	c.socket.writeTo(p, c.remote)
+}
+
+func (c *Conn) markConnected() {
+	c.state = stateConnected
+	close(c.connected)
+}
+
+// handlePacket processes a packet received from the peer.
+func (c *Conn) handlePacket(p *packet) {
+	if p.Type == ST_RESET {
+		c.destroy(ErrConnReset)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == stateClosed {
+		return
+	}
+
+	c.replyMicro = nowMicro() - p.Timestamp
+	c.peerWindow = int(p.WndSize)
+
+	if p.Type == ST_SYN {
+		// Either the SYN that created the connection, or a retransmission
+		// because our acknowledgement was lost
+		c.sendState()
+		return
+	}
+
+	if c.state == stateSynSent {
+		if p.Type != ST_STATE {
+			return
+		}
+		// The acceptor sends its initial sequence number without consuming it
+		c.ackNr = p.SeqNr - 1
+		c.markConnected()
+	}
+
+	c.processAck(p)
+
+	if p.Type == ST_DATA || p.Type == ST_FIN {
+		c.processData(p)
+		c.sendState()
+	}
+}
+
+// processAck removes the packets acknowledged by the peer from the
+// outgoing buffer, resends the ones it considers lost, and updates the
+// congestion window and the timeout.
+// The caller must hold c.mu.
+func (c *Conn) processAck(p *packet) {
+	// A packet is considered lost if enough of the packets after it arrived.
+	// It is only resent again once it had the time to arrive itself.
+	if p.SelectiveAck != nil {
+		received := 0
+		for i := len(c.outbuf) - 1; i >= 0; i-- {
+			op := c.outbuf[i]
+			if p.isSelectivelyAcked(op.packet.SeqNr) {
+				received++
+				continue
+			}
+			if received >= FAST_RETRANSMIT_THRESHOLD && seqLess(p.AckNr, op.packet.SeqNr) && time.Since(op.sentAt) > c.rtt {
+				c.markLost(op)
+			}
+		}
+	}
+
+	acked := 0
+	remaining := make([]*outPacket, 0, len(c.outbuf))
+	for _, op := range c.outbuf {
+		seq := op.packet.SeqNr
+		if !seqLess(p.AckNr, seq) || p.isSelectivelyAcked(seq) {
+			acked += len(op.packet.Payload)
+			if !op.lost {
+				c.inFlight -= len(op.packet.Payload)
+			}
+			// Retransmitted packets would give an ambiguous round trip time
+			if op.transmissions == 1 {
+				c.updateRTT(time.Since(op.sentAt))
+			}
+			if op.sentAt.After(c.lastAcked) {
+				c.lastAcked = op.sentAt
+			}
+			continue
+		}
+		remaining = append(remaining, op)
+	}
+
+	if len(remaining) != len(c.outbuf) {
+		c.outbuf = remaining
+		c.cc.OnAck(acked, p.TimestampDiff)
+		c.dupAcks = 0
+		// Undo the backoff of earlier timeouts, as the peer is reachable again
+		c.timeouts = 0
+		if c.rtt > 0 {
+			c.rto = max(c.rtt+4*c.rttVar, MIN_TIMEOUT)
+		}
+		signal(c.writable)
+	} else if p.Type == ST_STATE && p.AckNr == c.lastAckNr && len(c.outbuf) > 0 {
+		// Without selective ACKs, the peer acknowledges the same packet
+		// again for every packet it receives past a lost one
+		c.dupAcks++
+		head := c.outbuf[0]
+		if c.dupAcks >= FAST_RETRANSMIT_THRESHOLD && time.Since(head.sentAt) > c.rtt {
+			c.dupAcks = 0
+			c.markLost(head)
+		}
+	}
+	c.lastAckNr = p.AckNr
+
+	// A packet sent before one that was acknowledged is also considered lost
+	// once it had a round trip to arrive, which detects resent packets
+	// that were lost again
+	for _, op := range c.outbuf {
+		if op.sentAt.Before(c.lastAcked) && time.Since(op.sentAt) > c.rtt+c.rtt/4 {
+			c.markLost(op)
+		}
+	}
+
+	// The recovery ends once all the packets sent before it are acknowledged
+	if c.inRecovery && (len(c.outbuf) == 0 || !seqLess(c.outbuf[0].packet.SeqNr, c.recoverySeq)) {
+		c.inRecovery = false
+	}
+	if c.resendLost() {
+		signal(c.writable)
+	}
+}
+
+// updateRTT updates the round trip time estimate and the retransmission
+// timeout with a new sample, as described in BEP 29.
+// The caller must hold c.mu.
+func (c *Conn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = max(c.rtt+4*c.rttVar, MIN_TIMEOUT)
+}
+
+// processData delivers the data and FIN packets to the read buffer in order.
+// Packets that don't fit in our receive window are dropped, so that the
+// data buffered for the connection stays bounded.
+// The caller must hold c.mu.
+func (c *Conn) processData(p *packet) {
+	if !seqLess(c.ackNr, p.SeqNr) {
+		return // Duplicate
+	}
+	if p.SeqNr-c.ackNr > MAX_REORDER_DISTANCE {
+		return
+	}
+	if _, ok := c.reorder[p.SeqNr]; ok {
+		return // Duplicate
+	}
+	// The next packet only has to fit in the read buffer, as it frees the
+	// packets that are waiting for it. Otherwise a lost packet could never
+	// be delivered once the packets after it fill the window.
+	free := int(c.receiveWindow())
+	if p.SeqNr == c.ackNr+1 {
+		free = RECEIVE_BUFFER_SIZE - c.readBuf.Len()
+	}
+	if len(p.Payload) > free {
+		return
+	}
+	if p.Type == ST_FIN {
+		c.gotFin = true
+		c.finSeqNr = p.SeqNr
+	}
+	if c.gotFin && seqLess(c.finSeqNr, p.SeqNr) {
+		return // Past the end of the stream
+	}
+	c.reorder[p.SeqNr] = p
+
+	for {
+		next, ok := c.reorder[c.ackNr+1]
+		if !ok {
+			break
+		}
+		delete(c.reorder, c.ackNr+1)
+		c.ackNr++
+		c.readBuf.Write(next.Payload)
+		if next.Type == ST_FIN {
+			c.eof = true
+		}
+	}
+	signal(c.readable)
+}
+
+// tick resends the packets in flight if the oldest one timed out, and gives
+// up on the connection after too many timeouts in a row.
+func (c *Conn) tick() {
+	c.mu.Lock()
+	if c.state == stateClosed || len(c.outbuf) == 0 {
+		c.mu.Unlock()
+		return
+	}
+
+	oldest := c.outbuf[0]
+	if time.Since(oldest.sentAt) < c.rto {
+		c.mu.Unlock()
+		return
+	}
+
+	limit := MAX_RETRANSMISSIONS
+	if c.state == stateSynSent {
+		limit = MAX_SYN_RETRANSMISSIONS
+	}
+	if c.timeouts >= limit {
+		c.mu.Unlock()
+		c.destroy(ErrTimeout)
+		return
+	}
+	c.timeouts++
+	c.rto = min(2*c.rto, MAX_TIMEOUT)
+
+	// Nothing was acknowledged for a whole timeout, so all the packets in
+	// flight are considered lost, and resent as the reduced window allows
+	c.cc.OnTimeout()
+	c.inRecovery = true
+	c.recoverySeq = c.seqNr
+	for _, op := range c.outbuf {
+		c.markLost(op)
+	}
+	c.resendLost()
+	c.mu.Unlock()
+}
+
+// reset tells the peer that the connection is gone, and closes it.
+func (c *Conn) reset(err error) {
+	c.mu.Lock()
+	if c.state != stateClosed {
+		c.socket.writeTo(&packet{
+			Type:      ST_RESET,
+			ConnID:    c.sendID,
+			Timestamp: nowMicro(),
+			SeqNr:     c.seqNr,
+			AckNr:     c.ackNr,
+		}, c.remote)
+	}
+	c.mu.Unlock()
+	c.destroy(err)
+}
+
+// destroy closes the connection without notifying the peer,
+// and removes it from the socket.
+func (c *Conn) destroy(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.state = stateClosed
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.closed)
+		c.socket.remove(c)
+	})
+}
+
+func (c *Conn) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// signal wakes up a goroutine waiting on ch, if any.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// sleep blocks for d, or until the connection is closed.
+func sleep(d time.Duration, closed chan struct{}) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-closed:
+		return net.ErrClosed
+	}
+}
+
+// wait blocks until ch is signalled, the connection is closed, or the deadline passes.
+func wait(ch, closed chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ch:
+	case <-closed:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package utp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn drops every dropEvery-th packet it sends, and delays every
+// reorderEvery-th packet until after the next one.
+type lossyConn struct {
+	net.PacketConn
+	dropEvery    int
+	reorderEvery int
+
+	mu     sync.Mutex
+	sent   int
+	held   []byte
+	heldTo net.Addr
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent++
+	if c.dropEvery > 0 && c.sent%c.dropEvery == 0 {
+		return len(b), nil
+	}
+	if c.reorderEvery > 0 && c.sent%c.reorderEvery == 0 && c.held == nil {
+		c.held = append([]byte(nil), b...)
+		c.heldTo = addr
+		return len(b), nil
+	}
+
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if c.held != nil {
+		c.PacketConn.WriteTo(c.held, c.heldTo)
+		c.held = nil
+	}
+	return n, err
+}
+
+// newTestSocket creates a socket on the loopback interface. The packets it
+// sends go through wrap, if it isn't nil.
+func newTestSocket(t *testing.T, wrap func(net.PacketConn) net.PacketConn) *Socket {
+	t.Helper()
+
+	var conn net.PacketConn
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap != nil {
+		conn = wrap(conn)
+	}
+	s := newSocket(conn)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestPair connects a client socket to a server socket, and returns the
+// connections of both ends.
+func newTestPair(t *testing.T, client, server *Socket) (*Conn, *Conn) {
+	t.Helper()
+
+	dialed, err := client.DialTimeout(server.Addr().String(), DIAL_TIMEOUT)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	accepted, err := server.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	return dialed, accepted.(*Conn)
+}
+
+// transfer writes random data of the given size on one end and closes it,
+// and checks that the other end reads the same data followed by io.EOF.
+func transfer(t *testing.T, from, to *Conn, size int) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	if err := <-startTransfer(from, to, size); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+// startTransfer is like transfer, but reports the result on the returned
+// channel instead of failing the test.
+func startTransfer(from, to *Conn, size int) <-chan error {
+	data := make([]byte, size)
+	rand.Read(data)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := from.Write(data)
+		if err == nil {
+			err = from.Close()
+		}
+		written <- err
+	}()
+
+	result := make(chan error, 1)
+	go func() {
+		to.SetReadDeadline(time.Now().Add(30 * time.Second))
+		received, err := io.ReadAll(to)
+		if err != nil {
+			result <- fmt.Errorf("error reading after %d bytes: %w", len(received), err)
+			return
+		}
+		if err := <-written; err != nil {
+			result <- fmt.Errorf("error writing: %w", err)
+			return
+		}
+		if !bytes.Equal(received, data) {
+			result <- fmt.Errorf("received %d bytes that don't match the %d bytes sent", len(received), len(data))
+			return
+		}
+		result <- nil
+	}()
+	return result
+}
+
+func TestTransferInOrder(t *testing.T) {
+	client, server := newTestSocket(t, nil), newTestSocket(t, nil)
+	dialed, accepted := newTestPair(t, client, server)
+
+	transfer(t, dialed, accepted, 256*1024)
+}
+
+func TestTransferBothWays(t *testing.T) {
+	client, server := newTestSocket(t, nil), newTestSocket(t, nil)
+	dialed, accepted := newTestPair(t, client, server)
+
+	// Each end only closes once it has written, and the data of the
+	// other end is still read after that
+	up := startTransfer(dialed, accepted, 128*1024)
+	down := startTransfer(accepted, dialed, 128*1024)
+	for _, result := range []<-chan error{up, down} {
+		if err := <-result; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTransferWithLossAndReorder(t *testing.T) {
+	lossy := func(conn net.PacketConn) net.PacketConn {
+		return &lossyConn{PacketConn: conn, dropEvery: 20, reorderEvery: 7}
+	}
+	client, server := newTestSocket(t, lossy), newTestSocket(t, lossy)
+	dialed, accepted := newTestPair(t, client, server)
+
+	transfer(t, dialed, accepted, 1024*1024)
+}
+
+func TestCloseSendsFin(t *testing.T) {
+	client, server := newTestSocket(t, nil), newTestSocket(t, nil)
+	dialed, accepted := newTestPair(t, client, server)
+
+	_, err := dialed.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	err = dialed.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+
+	// The data written before the FIN is read before io.EOF
+	accepted.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := io.ReadAll(accepted)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", data)
+	}
+
+	_, err = dialed.Write([]byte("more"))
+	if err == nil {
+		t.Error("expected writing to a closed connection to fail")
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping throughput test in short mode")
+	}
+
+	client, server := newTestSocket(t, nil), newTestSocket(t, nil)
+	dialed, accepted := newTestPair(t, client, server)
+
+	const size = 8 * 1024 * 1024
+	elapsed := transfer(t, dialed, accepted, size)
+	// Far below what loopback allows, so that it holds on slow machines
+	// and with the race detector, but well above a connection that stalls
+	// on timeouts
+	if rate := float64(size) / elapsed.Seconds(); rate < 4*1024*1024 {
+		t.Errorf("transferred %d bytes in %v, expected at least 4 MiB/s", size, elapsed)
+	}
+}
+
+func TestReceiveWindowIsBounded(t *testing.T) {
+	client, server := newTestSocket(t, nil), newTestSocket(t, nil)
+	_, accepted := newTestPair(t, client, server)
+
+	// Send packets ahead of the next expected one, that are never delivered
+	// as the application doesn't read them, until the window is full
+	accepted.mu.Lock()
+	defer accepted.mu.Unlock()
+	for i := range 2 * RECEIVE_BUFFER_SIZE / MAX_PAYLOAD_SIZE {
+		accepted.processData(&packet{
+			Type:    ST_DATA,
+			SeqNr:   accepted.ackNr + 2 + uint16(i),
+			Payload: make([]byte, MAX_PAYLOAD_SIZE),
+		})
+	}
+	if w := accepted.receiveWindow(); w >= MAX_PAYLOAD_SIZE {
+		t.Errorf("expected the window to be full, got %d bytes", w)
+	}
+
+	buffered := 0
+	for _, p := range accepted.reorder {
+		buffered += len(p.Payload)
+	}
+	if buffered > RECEIVE_BUFFER_SIZE {
+		t.Errorf("buffered %d bytes, more than the receive buffer", buffered)
+	}
+
+	// The next packet is still accepted, as it frees the packets after it
+	next := accepted.ackNr + 1
+	accepted.processData(&packet{Type: ST_DATA, SeqNr: next, Payload: []byte("next")})
+	if !seqLess(next, accepted.ackNr) {
+		t.Errorf("expected the packets after %d to be delivered, got ack %d", next, accepted.ackNr)
+	}
+
+	// Packets past the reorder distance are dropped even if they are empty
+	accepted.processData(&packet{Type: ST_DATA, SeqNr: accepted.ackNr + MAX_REORDER_DISTANCE + 1})
+	if _, ok := accepted.reorder[accepted.ackNr+MAX_REORDER_DISTANCE+1]; ok {
+		t.Error("expected a packet past the reorder distance to be dropped")
+	}
+}
@@ -0,0 +1,61 @@
+package utp
+
+import "time"
+
+// The packet types, as defined in BEP 29.
+const ST_DATA = 0
+const ST_FIN = 1
+const ST_STATE = 2
+const ST_RESET = 3
+const ST_SYN = 4
+
+const PROTOCOL_VERSION = 1
+const HEADER_SIZE = 20
+
+// The extension used to acknowledge packets received out of order.
+const EXTENSION_SELECTIVE_ACK = 1
+
+// The maximum size of a packet that we send, chosen to fit in the MTU
+// of most links, and the maximum size of a packet we are willing to read.
+const MAX_PACKET_SIZE = 1400
+const MAX_PAYLOAD_SIZE = MAX_PACKET_SIZE - HEADER_SIZE
+const MAX_READ_SIZE = 64 * 1024
+
+// The number of bytes we are willing to buffer for a connection before
+// the application reads them, advertised as our receive window.
+const RECEIVE_BUFFER_SIZE = 1024 * 1024
+
+// Packets further than this past the last packet received in order are
+// dropped, so that packets without payload can't fill the reorder buffer.
+const MAX_REORDER_DISTANCE = 1024
+
+// The size requested for the buffers of the UDP socket.
+const SOCKET_BUFFER_SIZE = 4 * 1024 * 1024
+
+// LEDBAT parameters. The congestion window grows while the queuing delay
+// is below TARGET_DELAY, and shrinks when it is above it.
+const TARGET_DELAY = 100 * time.Millisecond
+const MAX_CWND_INCREASE_PER_RTT = 3000 // In bytes
+const MIN_WINDOW = MAX_PAYLOAD_SIZE
+const MAX_WINDOW = RECEIVE_BUFFER_SIZE
+const BASE_DELAY_HISTORY = 10 // Number of minutes the base delay is remembered for
+
+const INITIAL_TIMEOUT = time.Second
+const MIN_TIMEOUT = 500 * time.Millisecond
+const MAX_TIMEOUT = 30 * time.Second
+const MAX_RETRANSMISSIONS = 5
+const MAX_SYN_RETRANSMISSIONS = 2
+
+// Packets past the oldest unacknowledged packet that must be selectively
+// acknowledged before it is considered lost and resent, and the number of
+// duplicate ACKs that have the same meaning.
+const FAST_RETRANSMIT_THRESHOLD = 3
+
+// The number of bytes that may be sent in a burst. The rest of the window
+// is spread over the round trip time, so that the queues along the path
+// don't overflow.
+const PACING_BURST = 16 * MAX_PACKET_SIZE
+
+const TICK_INTERVAL = 50 * time.Millisecond
+const DIAL_TIMEOUT = 5 * time.Second
+const CLOSE_TIMEOUT = 5 * time.Second
@@ -0,0 +1,104 @@
+package utp
+
+import "time"
+
+// ledbat is the delay based congestion controller used by uTP (RFC 6817).
+// It keeps the queuing delay that our packets add on the path close to
+// TARGET_DELAY, so that uTP connections yield to the other traffic on the link
+// instead of filling up the buffers of the modem.
+type ledbat struct {
+	cwnd float64 // The congestion window, in bytes
+
+	// The minimum delay observed in each of the last BASE_DELAY_HISTORY
+	// minutes. The smallest of them is assumed to be the delay of the path
+	// without any queuing.
+	baseDelays   []uint32
+	lastRollover time.Time
+}
+
+func newLedbat() *ledbat {
+	return &ledbat{
+		cwnd:         2 * MIN_WINDOW,
+		baseDelays:   make([]uint32, 0, BASE_DELAY_HISTORY),
+		lastRollover: time.Now(),
+	}
+}
+
+// Window returns the number of bytes that may be in flight.
+func (l *ledbat) Window() int {
+	return int(l.cwnd)
+}
+
+// OnAck updates the window after bytesAcked bytes were acknowledged.
+// delay is the one-way delay of our packets as measured by the other end,
+// 0 if it is not known yet.
+func (l *ledbat) OnAck(bytesAcked int, delay uint32) {
+	if bytesAcked <= 0 || delay == 0 {
+		return
+	}
+
+	l.updateBaseDelay(delay)
+	queuing := delay - l.baseDelay()
+	// The clocks of the two ends are not synchronized, so a delay
+	// below the base delay wraps around and means no queuing
+	if int32(queuing) < 0 {
+		queuing = 0
+	}
+
+	target := float64(TARGET_DELAY.Microseconds())
+	offTarget := (target - float64(queuing)) / target
+	l.cwnd += MAX_CWND_INCREASE_PER_RTT * offTarget * float64(bytesAcked) / l.cwnd
+	l.clamp()
+}
+
+// OnLoss halves the window when a packet was lost, like TCP does.
+func (l *ledbat) OnLoss() {
+	l.cwnd /= 2
+	l.clamp()
+}
+
+// OnTimeout resets the window, as nothing was acknowledged for a whole
+// timeout and the path is probably congested.
+func (l *ledbat) OnTimeout() {
+	l.cwnd = MIN_WINDOW
+}
+
+func (l *ledbat) clamp() {
+	if l.cwnd < MIN_WINDOW {
+		l.cwnd = MIN_WINDOW
+	}
+	if l.cwnd > MAX_WINDOW {
+		l.cwnd = MAX_WINDOW
+	}
+}
+
+func (l *ledbat) updateBaseDelay(delay uint32) {
+	if len(l.baseDelays) == 0 {
+		l.baseDelays = append(l.baseDelays, delay)
+		return
+	}
+
+	if time.Since(l.lastRollover) >= time.Minute {
+		l.lastRollover = time.Now()
+		if len(l.baseDelays) == BASE_DELAY_HISTORY {
+			l.baseDelays = l.baseDelays[1:]
+		}
+		l.baseDelays = append(l.baseDelays, delay)
+		return
+	}
+
+	last := len(l.baseDelays) - 1
+	if int32(delay-l.baseDelays[last]) < 0 {
+		l.baseDelays[last] = delay
+	}
+}
+
+func (l *ledbat) baseDelay() uint32 {
+	base := l.baseDelays[0]
+	for _, d := range l.baseDelays[1:] {
+		if int32(d-base) < 0 {
+			base = d
+		}
+	}
+	return base
+}
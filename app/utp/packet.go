@@ -0,0 +1,104 @@
+package utp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// packet is a single uTP packet. All the fields of the header are in
+// network byte order, and the header is followed by the extensions.
+type packet struct {
+	Type          uint8
+	ConnID        uint16
+	Timestamp     uint32 // The time the packet was sent at, in microseconds
+	TimestampDiff uint32 // The one-way delay of the last packet received from the other end
+	WndSize       uint32 // The number of bytes the sender is willing to receive
+	SeqNr         uint16
+	AckNr         uint16
+	SelectiveAck  []byte // Bitmask of the packets received after AckNr + 1, nil if absent
+	Payload       []byte
+}
+
+func (p *packet) Bytes() []byte {
+	b := make([]byte, HEADER_SIZE, HEADER_SIZE+len(p.Payload))
+
+	b[0] = p.Type<<4 | PROTOCOL_VERSION
+	if p.SelectiveAck != nil {
+		b[1] = EXTENSION_SELECTIVE_ACK
+	}
+	binary.BigEndian.PutUint16(b[2:], p.ConnID)
+	binary.BigEndian.PutUint32(b[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(b[8:], p.TimestampDiff)
+	binary.BigEndian.PutUint32(b[12:], p.WndSize)
+	binary.BigEndian.PutUint16(b[16:], p.SeqNr)
+	binary.BigEndian.PutUint16(b[18:], p.AckNr)
+
+	if p.SelectiveAck != nil {
+		b = append(b, 0, byte(len(p.SelectiveAck)))
+		b = append(b, p.SelectiveAck...)
+	}
+	return append(b, p.Payload...)
+}
+
+func parsePacket(data []byte) (*packet, error) {
+	if len(data) < HEADER_SIZE {
+		return nil, fmt.Errorf("packet too short: %d", len(data))
+	}
+	if data[0]&0x0f != PROTOCOL_VERSION {
+		return nil, fmt.Errorf("unsupported version %d", data[0]&0x0f)
+	}
+
+	p := &packet{
+		Type:          data[0] >> 4,
+		ConnID:        binary.BigEndian.Uint16(data[2:]),
+		Timestamp:     binary.BigEndian.Uint32(data[4:]),
+		TimestampDiff: binary.BigEndian.Uint32(data[8:]),
+		WndSize:       binary.BigEndian.Uint32(data[12:]),
+		SeqNr:         binary.BigEndian.Uint16(data[16:]),
+		AckNr:         binary.BigEndian.Uint16(data[18:]),
+	}
+	if p.Type > ST_SYN {
+		return nil, fmt.Errorf("invalid packet type %d", p.Type)
+	}
+
+	// Every extension starts with the type of the next extension and its length
+	ext := data[1]
+	data = data[HEADER_SIZE:]
+	for ext != 0 {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, fmt.Errorf("truncated extension %d", ext)
+		}
+		next, length := data[0], int(data[1])
+		if ext == EXTENSION_SELECTIVE_ACK {
+			p.SelectiveAck = data[2 : 2+length]
+		}
+		ext = next
+		data = data[2+length:]
+	}
+	p.Payload = data
+
+	return p, nil
+}
+
+// isSelectivelyAcked reports whether the selective ACK of the packet
+// acknowledges seq. The first bit of the mask stands for AckNr + 2, as
+// AckNr + 1 is known to be missing.
+func (p *packet) isSelectivelyAcked(seq uint16) bool {
+	i := int(seq - p.AckNr - 2)
+	if i < 0 || i >= len(p.SelectiveAck)*8 {
+		return false
+	}
+	return p.SelectiveAck[i/8]&(1<<(i%8)) != 0
+}
+
+// seqLess compares sequence numbers, taking the wrap around into account.
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// nowMicro returns the current time in microseconds, truncated to 32 bits
+// as is used for the timestamps in the packet headers.
+func nowMicro() uint32 {
+	return uint32(time.Now().UnixMicro())
+}
@@ -0,0 +1,281 @@
+package utp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("utp socket is closed")
+var ErrDialTimeout = errors.New("timed out connecting")
+
+// connKey identifies a connection on the socket. The connection IDs are
+// only unique per remote address.
+type connKey struct {
+	addr   string
+	recvID uint16
+}
+
+// Socket multiplexes all the uTP connections over a single UDP socket, both
+// the ones we dial and the ones we accept. It implements net.Listener.
+type Socket struct {
+	conn   net.PacketConn
+	logger *log.Logger
+
+	mu    sync.Mutex
+	conns map[connKey]*Conn
+
+	accepted  chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen creates a uTP socket on the UDP address, eg. ":6881".
+func Listen(addr string) (*Socket, error) {
+	if addr == "" {
+		addr = ":0"
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	return newSocket(conn), nil
+}
+
+// newSocket creates a uTP socket on top of a packet connection,
+// which must send and receive from UDP addresses.
+func newSocket(conn net.PacketConn) *Socket {
+	// Bursts of packets are dropped by the kernel if the buffers are too
+	// small. The sizes are only hints, and are capped by the system limits.
+	if udpConn, ok := conn.(*net.UDPConn); ok {
+		udpConn.SetReadBuffer(SOCKET_BUFFER_SIZE)
+		udpConn.SetWriteBuffer(SOCKET_BUFFER_SIZE)
+	}
+
+	s := &Socket{
+		conn:     conn,
+		logger:   log.New(log.Writer(), "[uTP] ", 0),
+		conns:    make(map[connKey]*Conn),
+		accepted: make(chan *Conn, 16),
+		closed:   make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.tickLoop()
+	return s
+}
+
+func (s *Socket) Log(str string, vals ...any) {
+	s.logger.Printf(str+"\n", vals...)
+}
+
+// Addr returns the local address of the UDP socket.
+func (s *Socket) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close resets all the connections and closes the UDP socket.
+func (s *Socket) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		s.mu.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+
+		for _, c := range conns {
+			c.reset(ErrClosed)
+		}
+		s.conn.Close()
+	})
+	return nil
+}
+
+// Accept waits for the next connection initiated by a remote peer.
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.accepted:
+		return c, nil
+	case <-s.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Dial connects to the uTP peer at addr, waiting for at most DIAL_TIMEOUT.
+// It allows the socket to be used as the dialer for peer connections.
+func (s *Socket) Dial(addr string) (net.Conn, error) {
+	return s.DialTimeout(addr, DIAL_TIMEOUT)
+}
+
+// DialTimeout connects to the uTP peer at addr. The connection is
+// established once the peer acknowledges our SYN packet.
+func (s *Socket) DialTimeout(addr string, timeout time.Duration) (*Conn, error) {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+
+	// We receive on recvID, and the peer receives on recvID + 1
+	s.mu.Lock()
+	var recvID uint16
+	for {
+		recvID = uint16(rand.IntN(1 << 16))
+		if _, ok := s.conns[connKey{remote.String(), recvID}]; !ok {
+			break
+		}
+	}
+	c := newConn(s, remote, recvID, recvID+1, 1)
+	s.conns[connKey{remote.String(), recvID}] = c
+	s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	c.mu.Lock()
+	c.sendSyn()
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.closed:
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, c.closeErr())
+	case <-timer.C:
+		c.reset(ErrDialTimeout)
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, ErrDialTimeout)
+	}
+}
+
+func (s *Socket) writeTo(p *packet, addr *net.UDPAddr) {
+	_, err := s.conn.WriteTo(p.Bytes(), addr)
+	if err != nil {
+		select {
+		case <-s.closed:
+		default:
+			s.Log("error sending packet to %s: %v", addr, err)
+		}
+	}
+}
+
+func (s *Socket) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := connKey{c.remote.String(), c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+func (s *Socket) readLoop() {
+	buf := make([]byte, MAX_READ_SIZE)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+			s.Log("error reading packet: %v", err)
+			continue
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		// The payload is kept by the connection, so it must not share the buffer
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		p, err := parsePacket(data)
+		if err != nil {
+			continue
+		}
+		s.handlePacket(p, addr)
+	}
+}
+
+func (s *Socket) handlePacket(p *packet, addr *net.UDPAddr) {
+	s.mu.Lock()
+	c, ok := s.conns[connKey{addr.String(), p.ConnID}]
+	if !ok && p.Type == ST_SYN {
+		// A retransmitted SYN is routed to the connection it already created
+		c, ok = s.conns[connKey{addr.String(), p.ConnID + 1}]
+	}
+	if ok {
+		s.mu.Unlock()
+		c.handlePacket(p)
+		return
+	}
+
+	if p.Type != ST_SYN {
+		s.mu.Unlock()
+		if p.Type != ST_RESET {
+			s.writeTo(&packet{
+				Type:      ST_RESET,
+				ConnID:    p.ConnID,
+				Timestamp: nowMicro(),
+				AckNr:     p.SeqNr,
+			}, addr)
+		}
+		return
+	}
+
+	// The peer sends on the connection ID of the SYN, and receives on the one after it
+	c = newConn(s, addr, p.ConnID+1, p.ConnID, uint16(rand.IntN(1<<16)))
+	c.ackNr = p.SeqNr
+	c.markConnected()
+	s.conns[connKey{addr.String(), c.recvID}] = c
+	s.mu.Unlock()
+
+	c.handlePacket(p)
+
+	select {
+	case s.accepted <- c:
+	default:
+		s.Log("dropping connection from %s, too many pending connections", addr)
+		c.reset(ErrClosed)
+	}
+}
+
+func (s *Socket) tickLoop() {
+	ticker := time.NewTicker(TICK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+
+		for _, c := range conns {
+			c.tick()
+		}
+	}
+}